@@ -1,22 +1,74 @@
 package ast
 
 import (
+	"bytes"
 	"errors"
-	"regexp"
+	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/zkry/go-sed/lexer"
 )
 
 type Program struct {
-	Statements []statement
-	Labels     map[string]int
-	Tokens     []lexer.Item
+	Statements    []statement
+	SourceLines   []int // The script line each statement starts on.
+	SourceColumns []int // The byte column, starting at 1, each statement starts at.
+	SourceOffsets []int // The byte offset in the script each statement starts at.
+	Labels        map[string]int
+	Comments      []Comment
+	Tokens        []lexer.Item
+
+	code         []instruction  // The statements of the program and its blocks, as run.
+	bc           *bytecode      // The code lowered for Run.
+	labels       map[string]int // The position in code of every label.
+	labelLines   map[string]int // The script line every label is defined on.
+	labelColumns map[string]int // The byte column the : of every label is at.
+	labelOffsets map[string]int // The byte offset in the script of the : of every label.
+	blankLines   []int          // The empty lines of the script, for Format.
+	dialect      Dialect        // The dialect the script was parsed in.
+}
+
+// Comment is a # comment in a script, kept so that the script can be
+// printed back with its comments by Format.
+type Comment struct {
+	Pos      int    // The index of the statement the comment comes before.
+	Line     int    // The script line the comment is on.
+	Text     string // The comment without its #.
+	Trailing bool   // Whether the comment follows a command on its line.
+}
+
+// ErrSandbox is returned by CheckSandbox when a program reads or writes
+// files or executes commands.
+var ErrSandbox = errors.New("e/r/w commands disabled in sandbox mode")
+
+// CheckSandbox reports whether the program, including any blocks, only
+// operates on its input and output streams.
+func (p *Program) CheckSandbox() error {
+	for _, stmt := range p.Statements {
+		switch s := stmt.(type) {
+		case *eStmt, *rStmt, *r2Stmt, *wStmt, *w2Stmt:
+			return ErrSandbox
+		case *sStmt:
+			if s.Flags.WFile != "" {
+				return ErrSandbox
+			}
+		case *blockStmt:
+			if err := s.Code.CheckSandbox(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
 type addresser interface {
 	Address(r *runtime) bool
+	// address returns the addresser itself. Statements embed their
+	// addresser, so this gives access to the address of any statement.
+	address() addresser
 }
 
 type statement interface {
@@ -27,10 +79,21 @@ type statement interface {
 type aStmt struct {
 	addresser
 	AppendLine string
+	HasText    bool // False when the script ends right after a\.
 }
 
 func (s *aStmt) Run(r *runtime) {
-	r.appendSpace += s.AppendLine + "\n"
+	r.appendSpace += textLine(s.AppendLine, s.HasText)
+}
+
+// textLine returns what an a, i or c command prints: its text and a
+// newline, or nothing if the script ends right after the backslash and so
+// gives the command no text at all. Empty text prints an empty line.
+func textLine(text string, hasText bool) string {
+	if !hasText {
+		return ""
+	}
+	return text + "\n"
 }
 
 type bStmt struct {
@@ -39,39 +102,27 @@ type bStmt struct {
 }
 
 func (s *bStmt) Run(r *runtime) {
-	r.directives.jumpTo = s.BranchIdent
+	r.directives.branch = true
 }
 
+func (s *bStmt) label() string { return s.BranchIdent }
+
 type cStmt struct {
 	addresser
 	ChangeLine string
-	prevResult bool
+	HasText    bool // False when the script ends right after c\.
 }
 
+// Run deletes the pattern space and prints the text. For a range the text
+// is printed once, in place of the range's last line; a range that is
+// still open when the input ends prints nothing, as in GNU sed. A negated
+// address prints the text for every line it matches.
 func (s *cStmt) Run(r *runtime) {
-	// TODO: To be implemented
-	switch s.addresser.(type) {
-	case *rangeAddress:
-		match := s.Address(r)
-		if !match {
-			if s.prevResult {
-				// output the ChangeLine
-				r.directives.deleteCmd = true
-				r.output += s.ChangeLine + "\n"
-			}
-			s.prevResult = false
-			return
-		}
-		r.directives.deleteCmd = true
-		s.prevResult = true
-	default:
-		match := s.Address(r)
-		if !match {
-			return
-		}
-		r.directives.deleteCmd = true
-		r.output += s.ChangeLine + "\n"
+	r.directives.deleteCmd = true
+	if a, ok := s.addresser.(*rangeAddress); ok && r.rangeState(a).on {
+		return
 	}
+	r.write(textLine(s.ChangeLine, s.HasText))
 }
 
 // SFlags represents the various options that can be passed to the s command.
@@ -81,6 +132,9 @@ type sFlags struct {
 	GFlag bool   // g - Make the substitution for all non-overlapping matches
 	PFlag bool   // p - Write the pattern space to stdout
 	WFile string // w file  - append pattern space to file if a replacement made.
+	IFlag bool   // i, I - Match the regexp without regard to case (GNU)
+	MFlag bool   // m, M - Match the regexp in multi-line mode (GNU)
+	EFlag bool   // e - Execute the pattern space as a command (GNU)
 }
 
 type sStmt struct {
@@ -88,41 +142,44 @@ type sStmt struct {
 	FindAddr    string
 	ReplaceAddr string
 	Flags       sFlags
+	regexp      pattern // nil for an empty regexp, which reuses the last one.
+	replacement replacement
 }
 
 func (s *sStmt) Run(r *runtime) {
-	var rgxp *regexp.Regexp
-	var err error
-	if s.Flags.GFlag {
-		// Replace for all occurences.
-		rgxp, err = regexp.Compile(s.FindAddr)
-		if err != nil {
-			return
-		}
-		r.subMade = true
-		r.patternSpace = rgxp.ReplaceAllString(r.patternSpace, s.ReplaceAddr)
-	} else {
-		matchIdx := 0
-		if s.Flags.NFlag != 0 {
-			// Replace for nth occurence.
-			matchIdx = s.Flags.NFlag - 1
-		}
-
-		// } else {
-		// Replace for first occurence.
-		rgxp, err = regexp.Compile(s.FindAddr)
-		if err != nil {
-			return
-		}
-		loc := rgxp.FindAllStringIndex(r.patternSpace, -1)
-		if loc == nil {
-			return
-		}
-		r.subMade = true
-		r.patternSpace = r.patternSpace[0:loc[matchIdx][0]] + rgxp.ReplaceAllString(r.patternSpace[loc[matchIdx][0]:loc[matchIdx][1]], s.ReplaceAddr) + r.patternSpace[loc[matchIdx][1]:len(r.patternSpace)]
+	re := r.regexp(s.regexp)
+	if re == nil {
+		return
+	}
+	n := 1
+	if s.Flags.NFlag != 0 {
+		// Replace from the nth occurence.
+		n = s.Flags.NFlag
+	}
+	matches := r.findAll(re)
+	if len(matches) > 0 {
+		r.traceMatch(matches[0])
 	}
+	if len(matches) < n {
+		return
+	}
+	matches = matches[n-1:]
+	if !s.Flags.GFlag {
+		matches = matches[:1]
+	}
+	ps := []byte(r.patternSpace)
+	var out []byte
+	last := 0
+	for _, m := range matches {
+		out = append(out, ps[last:m[0]]...)
+		out = s.replacement.expand(out, ps, m)
+		last = m[1]
+	}
+	out = append(out, ps[last:]...)
+	r.subMade = true
+	r.patternSpace = string(out)
 	if s.Flags.PFlag {
-		r.output += r.patternSpace + "\n"
+		r.write(r.patternSpace + r.lineDelim)
 	}
 }
 
@@ -191,17 +248,101 @@ func (s *h2Stmt) Run(r *runtime) {
 type iStmt struct {
 	addresser
 	InsertLine string
+	HasText    bool // False when the script ends right after i\.
 }
 
 func (s *iStmt) Run(r *runtime) {
-	r.output += s.InsertLine + "\n"
+	r.write(textLine(s.InsertLine, s.HasText))
 }
 
 type lStmt struct {
 	addresser
+	Width int // The line length given to the command (GNU), or -1.
 }
 
 func (s *lStmt) Run(r *runtime) {
+	width := r.lineLength
+	if s.Width >= 0 {
+		width = s.Width
+	}
+	if r.dialect == BSD {
+		r.write(listBSD(r.patternSpace, width) + r.lineDelim)
+		return
+	}
+	r.write(listGNU(r.patternSpace, width) + r.lineDelim)
+}
+
+// listGNU returns the pattern space in the unambiguous form GNU sed's l
+// command prints: byte by byte, with backslashes and non-printable bytes
+// escaped, lines wrapped with a backslash to at most width characters and
+// a $ marking the end. A width of 0 never wraps.
+func listGNU(ps string, width int) string {
+	var buff bytes.Buffer
+	col := 0
+	for i := 0; i < len(ps); i++ {
+		c := ps[i]
+		var o string
+		switch {
+		case c == '\\':
+			o = `\\`
+		case c >= ' ' && c < 0x7f:
+			o = string(c)
+		case strings.IndexByte("\a\b\f\n\r\t\v", c) != -1:
+			o = `\` + string("abfnrtv"[strings.IndexByte("\a\b\f\n\r\t\v", c)])
+		default:
+			o = fmt.Sprintf("\\%03o", c)
+		}
+		if width > 0 && col+len(o) > width-1 {
+			buff.WriteString("\\\n")
+			col = 0
+		}
+		buff.WriteString(o)
+		col += len(o)
+	}
+	buff.WriteString("$")
+	return buff.String()
+}
+
+// listBSD returns the pattern space in the form BSD sed's l command
+// prints. Unlike GNU sed it works on characters rather than bytes, leaves
+// backslashes alone and shows embedded newlines as the end of a line. A
+// width of 0 never wraps.
+func listBSD(ps string, width int) string {
+	var buff bytes.Buffer
+	col := 0
+	wrap := func(w int) {
+		if width > 0 && col+w >= width {
+			buff.WriteString("\\\n")
+			col = 0
+		}
+	}
+	for len(ps) > 0 {
+		r, n := utf8.DecodeRuneInString(ps)
+		switch {
+		case r == '\n':
+			wrap(1)
+			buff.WriteString("$\n")
+			col = 0
+		case r != utf8.RuneError && unicode.IsPrint(r):
+			wrap(1)
+			buff.WriteString(ps[:n])
+			col++
+		case strings.IndexByte("\a\b\f\r\t\v", ps[0]) != -1 && n == 1:
+			wrap(2)
+			buff.WriteString(`\` + string("abfrtv"[strings.IndexByte("\a\b\f\r\t\v", ps[0])]))
+			col += 2
+		default:
+			wrap(4 * n)
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(&buff, "\\%03o", ps[i])
+			}
+			col += 4 * n
+		}
+		ps = ps[n:]
+	}
+	wrap(1)
+	buff.WriteString("$")
+	return buff.String()
 }
 
 type nStmt struct {
@@ -216,12 +357,15 @@ type n2Stmt struct {
 	addresser
 }
 
+// Run appends the next line to the pattern space. Without a next line
+// GNU sed ends as if the script had finished, printing the pattern space;
+// POSIX and BSD sed end without printing it.
 func (s *n2Stmt) Run(r *runtime) {
-	r.lineNo++
-	if r.lineNo >= len(r.lines) {
-		r.directives.quitNoPattern = true
+	if r.lineNo+1 >= len(r.lines) {
+		r.directives.lastLine = true
 		return
 	}
+	r.lineNo++
 	r.patternSpace += "\n" + r.lines[r.lineNo]
 }
 
@@ -230,7 +374,7 @@ type pStmt struct {
 }
 
 func (s *pStmt) Run(r *runtime) {
-	r.output += r.patternSpace + "\n"
+	r.write(r.patternSpace + r.lineDelim)
 }
 
 type p2Stmt struct {
@@ -240,20 +384,42 @@ type p2Stmt struct {
 func (s *p2Stmt) Run(r *runtime) {
 	idx := strings.IndexRune(r.patternSpace, '\n')
 	if idx == -1 {
-		r.output += r.patternSpace
+		r.write(r.patternSpace + r.lineDelim)
 		return
 	}
-	r.output += r.patternSpace[:idx]
+	r.write(r.patternSpace[:idx] + r.lineDelim)
 }
 
 type qStmt struct {
 	addresser
+	ExitCode int
 }
 
 func (s *qStmt) Run(r *runtime) {
+	r.exitCode = s.ExitCode
 	r.directives.quitCmd = true
 }
 
+// q2Stmt is the GNU Q command: quit without printing the pattern space.
+type q2Stmt struct {
+	addresser
+	ExitCode int
+}
+
+func (s *q2Stmt) Run(r *runtime) {
+	r.exitCode = s.ExitCode
+	r.directives.quitNoPattern = true
+}
+
+// fStmt is the GNU F command, which prints the name of the input file.
+type fStmt struct {
+	addresser
+}
+
+func (s *fStmt) Run(r *runtime) {
+	r.write(fileName(r.files, r.lineNo+1) + "\n")
+}
+
 type rStmt struct {
 	addresser
 	FileName string
@@ -279,19 +445,26 @@ type tStmt struct {
 func (s *tStmt) Run(r *runtime) {
 	if r.subMade {
 		r.subMade = false
-		r.directives.jumpTo = s.BranchIdent
+		r.directives.branch = true
 	}
 }
 
+func (s *tStmt) label() string { return s.BranchIdent }
+
 type t2Stmt struct {
 	addresser
-	FileName string
+	BranchIdent string
 }
 
 func (s *t2Stmt) Run(r *runtime) {
-	// To be implemented
+	if !r.subMade {
+		r.directives.branch = true
+	}
+	r.subMade = false
 }
 
+func (s *t2Stmt) label() string { return s.BranchIdent }
+
 type wStmt struct {
 	addresser
 	FileName string
@@ -320,19 +493,37 @@ func (s *xStmt) Run(r *runtime) {
 
 type yStmt struct {
 	addresser
+	Find    string
+	Replace string
 	charMap map[rune]rune
+	table   *[256]byte // The map as a table of bytes, if it only maps ASCII.
 }
 
 func (s *yStmt) Run(r *runtime) {
-	var newPS string
-	for _, r := range r.patternSpace {
-		if nr, ok := s.charMap[r]; ok {
-			newPS += string(nr)
+	if s.table != nil {
+		b := []byte(r.patternSpace)
+		for i, c := range b {
+			b[i] = s.table[c]
+		}
+		r.patternSpace = string(b)
+		return
+	}
+	r.patternSpace = string(s.translate(nil, []byte(r.patternSpace)))
+}
+
+// translate appends src to dst with each character mapped. Bytes that
+// are not valid UTF-8 are copied as they are.
+func (s *yStmt) translate(dst, src []byte) []byte {
+	for len(src) > 0 {
+		r, n := utf8.DecodeRune(src)
+		if nr, ok := s.charMap[r]; ok && !(r == utf8.RuneError && n == 1) {
+			dst = append(dst, string(nr)...)
 		} else {
-			newPS += string(r)
+			dst = append(dst, src[:n]...)
 		}
+		src = src[n:]
 	}
-	r.patternSpace = newPS
+	return dst
 }
 
 func newYStmt(find, replace string, addr addresser) (*yStmt, error) {
@@ -350,11 +541,29 @@ func newYStmt(find, replace string, addr addresser) (*yStmt, error) {
 	}
 
 	cm := make(map[rune]rune)
+	ascii := true
 	for i := range fRunes {
 		cm[fRunes[i]] = rRunes[i]
+		ascii = ascii && fRunes[i] < utf8.RuneSelf && rRunes[i] < utf8.RuneSelf
 	}
 
-	return &yStmt{charMap: cm}, nil
+	s := &yStmt{
+		addresser: addr,
+		Find:      find,
+		Replace:   replace,
+		charMap:   cm,
+	}
+	if ascii {
+		// Bytes of multi-byte characters are never mapped.
+		s.table = new([256]byte)
+		for i := range s.table {
+			s.table[i] = byte(i)
+		}
+		for f, r := range cm {
+			s.table[f] = byte(r)
+		}
+	}
+	return s, nil
 }
 
 type zStmt struct {
@@ -362,7 +571,7 @@ type zStmt struct {
 }
 
 func (s *zStmt) Run(r *runtime) {
-	// To be implemented
+	r.patternSpace = ""
 }
 
 type equStmt struct {
@@ -370,7 +579,7 @@ type equStmt struct {
 }
 
 func (s *equStmt) Run(r *runtime) {
-	r.output += strconv.Itoa(r.lineNo+1) + "\n"
+	r.write(strconv.Itoa(r.lineNo+1) + "\n")
 }
 
 type blockStmt struct {
@@ -378,18 +587,22 @@ type blockStmt struct {
 	addresser
 }
 
-func (s *blockStmt) Run(r *runtime) {
-	r.directives.runBlock = s.Code
-}
+// Run does nothing: the machine enters or skips the block itself.
+func (s *blockStmt) Run(r *runtime) {}
 
 type regexpAddr struct {
-	Regexp *regexp.Regexp
+	Regexp pattern // nil for an empty regexp, which reuses the last one.
+	Source string  // The regexp as written in the script.
+	Flags  regexFlags
 }
 
 func (a *regexpAddr) Address(r *runtime) bool {
-	return len(a.Regexp.FindIndex([]byte(r.patternSpace))) > 0
+	re := r.regexp(a.Regexp)
+	return re != nil && r.match(re)
 }
 
+func (a *regexpAddr) address() addresser { return a }
+
 type lineNoAddr struct {
 	LineNo int
 }
@@ -398,42 +611,123 @@ func (a *lineNoAddr) Address(r *runtime) bool {
 	return r.lineNo+1 == a.LineNo
 }
 
+func (a *lineNoAddr) address() addresser { return a }
+
 type eofAddr struct{}
 
 func (a *eofAddr) Address(r *runtime) bool {
 	return r.lineNo == len(r.lines)-1
 }
 
+func (a *eofAddr) address() addresser { return a }
+
 type notAddr struct {
 	Addr addresser
 }
 
 func (a *notAddr) Address(r *runtime) bool {
-	return !a.Address(r)
+	return !a.Addr.Address(r)
+}
+
+func (a *notAddr) address() addresser { return a }
+
+// stepAddr is the GNU first~step address, which matches every step'th
+// line starting with line first.
+type stepAddr struct {
+	First int
+	Step  int
+}
+
+func (a *stepAddr) Address(r *runtime) bool {
+	line := r.lineNo + 1
+	if a.Step <= 0 {
+		return line == a.First
+	}
+	return line >= a.First && (line-a.First)%a.Step == 0
+}
+
+func (a *stepAddr) address() addresser { return a }
+
+// relLineAddr ends a range N lines after the line that started it, as in
+// the GNU addr1,+N address.
+type relLineAddr struct {
+	N int
+}
+
+func (a *relLineAddr) Address(r *runtime) bool { return false }
+
+func (a *relLineAddr) address() addresser { return a }
+
+// multipleAddr ends a range at the next line whose number is a multiple of
+// N, as in the GNU addr1,~N address.
+type multipleAddr struct {
+	N int
 }
 
+func (a *multipleAddr) Address(r *runtime) bool { return false }
+
+func (a *multipleAddr) address() addresser { return a }
+
 type rangeAddress struct {
 	Addr1 addresser
 	Addr2 addresser
-	on    bool
+}
+
+// rangeState is the progress of a range address through the input. It is
+// kept by the runtime so that a program can be run more than once.
+type rangeState struct {
+	on  bool
+	end int // The last line of a range ending at a line number.
 }
 
 func (a *rangeAddress) Address(r *runtime) bool {
-	if a.on {
-		if a.Addr2.Address(r) {
-			a.on = false
+	state := r.rangeState(a)
+	line := r.lineNo + 1
+	if state.on {
+		if state.end > 0 {
+			state.on = line < state.end
+		} else if a.Addr2.Address(r) {
+			state.on = false
 		}
 		return true
 	}
-	if a.Addr1.Address(r) {
-		a.on = true
+	if !a.Addr1.Address(r) {
+		return false
+	}
+	// When the range ends at a line number that is not past the first
+	// line, only the first line matches.
+	switch addr2 := a.Addr2.(type) {
+	case *lineNoAddr:
+		state.end = addr2.LineNo
+	case *relLineAddr:
+		state.end = line + addr2.N
+	case *multipleAddr:
+		state.end = line
+		if addr2.N > 0 && line%addr2.N != 0 {
+			state.end = line + addr2.N - line%addr2.N
+		}
+	default:
+		state.on = true
 		return true
 	}
-	return false
+	state.on = line < state.end
+	return true
+}
+
+// startsBeforeInput reports whether the range is a GNU 0,/regexp/ range,
+// which is already active when the first line is read so that the regexp
+// may end it on that line.
+func (a *rangeAddress) startsBeforeInput() bool {
+	l, ok := a.Addr1.(*lineNoAddr)
+	return ok && l.LineNo == 0
 }
 
+func (a *rangeAddress) address() addresser { return a }
+
 type blankAddress struct{}
 
 func (a *blankAddress) Address(r *runtime) bool {
 	return true
 }
+
+func (a *blankAddress) address() addresser { return a }
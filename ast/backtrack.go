@@ -0,0 +1,370 @@
+package ast
+
+import (
+	"context"
+	"errors"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// pattern is a compiled regular expression. Most are Go regexps; those
+// with back-references, which Go's regexp package cannot express, are
+// matched by a backtracker.
+type pattern interface {
+	MatchString(s string) bool
+	Match(b []byte) bool
+	FindAllStringSubmatchIndex(s string, n int) [][]int
+	FindAllSubmatchIndex(b []byte, n int) [][]int
+	NumSubexp() int
+	String() string
+}
+
+// backrefBase is the first of the private use runes that stand for the
+// back-references \1 to \9 in a translated regexp.
+const backrefBase = 0xF0000
+
+// backtracker matches a regexp containing back-references by trying the
+// alternatives of the expression in turn. Like GNU sed, it finds the
+// leftmost-longest match: at the leftmost position where the regexp
+// matches, it tries every way of matching and keeps the longest. Where
+// several ways match the same text it keeps the first one tried, in which
+// a group prefers its earlier alternatives and a repetition more repeats.
+// POSIX instead gives each group in turn the longest text it can have,
+// which differs for a repeated group that can match empty text: a
+// repetition here never matches its group to empty text, so in
+// b\(a*\)*\1 the back-reference has the last non-empty text the group
+// matched, or fails if there is none, where GNU can repeat the group
+// once more on empty text. TestBacktracker has examples.
+//
+// Backtracking takes exponential time on some regexps, such as
+// \(a*\)*b\1, so a search gives up with errBacktrackLimit after
+// maxBacktrackSteps steps, or once it recurses maxBacktrackDepth levels
+// deep, which long lines can reach as each repeat of a group recurses.
+// It also gives up once the context of the run is done.
+type backtracker struct {
+	expr string
+	re   *syntax.Regexp
+	ncap int
+}
+
+// maxBacktrackSteps and maxBacktrackDepth bound the work and the stack of
+// one search by a backtracker.
+const (
+	maxBacktrackSteps = 1 << 22
+	maxBacktrackDepth = 1 << 16
+)
+
+// errBacktrackLimit is the error a backtracker gives up with.
+var errBacktrackLimit = errors.New("regexp with back-references took too long to match")
+
+func newBacktracker(expr string) (*backtracker, error) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	return &backtracker{expr: expr, re: re.Simplify(), ncap: re.MaxCap()}, nil
+}
+
+func (b *backtracker) String() string { return b.expr }
+
+func (b *backtracker) NumSubexp() int { return b.ncap }
+
+func (b *backtracker) MatchString(s string) bool {
+	matches, _ := b.findAll(nil, s, 1)
+	return len(matches) > 0
+}
+
+// Match matches a copy of s as a string; patterns with back-references
+// are rare enough not to need their own matcher for bytes.
+func (b *backtracker) Match(s []byte) bool {
+	return b.MatchString(string(s))
+}
+
+func (b *backtracker) FindAllSubmatchIndex(s []byte, n int) [][]int {
+	return b.FindAllStringSubmatchIndex(string(s), n)
+}
+
+// FindAllStringSubmatchIndex returns up to n matches as a Go regexp does,
+// leaving out those past where the search gave up; findAll reports that.
+func (b *backtracker) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	matches, _ := b.findAll(nil, s, n)
+	return matches
+}
+
+// findAll returns up to n successive matches in s, or all of them if n
+// is negative. If the search gives up it returns the matches so far with
+// errBacktrackLimit, or with the error of ctx, which may be nil, once it
+// is done.
+func (b *backtracker) findAll(ctx context.Context, s string, n int) ([][]int, error) {
+	var matches [][]int
+	m := &btMatch{s: s, ctx: ctx}
+	prevEnd := -1
+	for pos := 0; pos <= len(s) && (n < 0 || len(matches) < n); {
+		caps := b.find(m, pos)
+		if m.err != nil {
+			return matches, m.err
+		}
+		if caps == nil {
+			break
+		}
+		if caps[1] == caps[0] && caps[0] == prevEnd {
+			// Skip an empty match right after the previous match.
+			if caps[0] >= len(s) {
+				break
+			}
+			_, w := utf8.DecodeRuneInString(s[caps[0]:])
+			pos = caps[0] + w
+			continue
+		}
+		matches = append(matches, caps)
+		prevEnd = caps[1]
+		pos = caps[1]
+		if caps[1] == caps[0] {
+			if caps[0] >= len(s) {
+				break
+			}
+			_, w := utf8.DecodeRuneInString(s[caps[0]:])
+			pos = caps[0] + w
+		}
+	}
+	return matches, nil
+}
+
+// find returns the group offsets of the leftmost-longest match starting
+// at or after start, or nil.
+func (b *backtracker) find(m *btMatch, start int) []int {
+	s := m.s
+	m.caps = make([]int, 2*(b.ncap+1))
+	var best []int
+	for pos := start; pos <= len(s) && best == nil; pos++ {
+		if pos < len(s) && !utf8.RuneStart(s[pos]) {
+			continue
+		}
+		for i := range m.caps {
+			m.caps[i] = -1
+		}
+		m.match(b.re, pos, func(end int) bool {
+			if best == nil || end > best[1] {
+				best = append(best[:0], m.caps...)
+				best[0], best[1] = pos, end
+			}
+			// No other way of matching can end later.
+			return end == len(s)
+		})
+		if m.err != nil {
+			return nil
+		}
+	}
+	return best
+}
+
+// btMatch is the state of a search by a backtracker.
+type btMatch struct {
+	s     string
+	caps  []int
+	ctx   context.Context
+	steps int   // Calls of match so far.
+	depth int   // Calls of match on the stack.
+	err   error // Why the search gave up.
+}
+
+// match reports whether re matches at pos followed by whatever k accepts,
+// counting the step against the search's limits.
+func (m *btMatch) match(re *syntax.Regexp, pos int, k func(int) bool) bool {
+	if m.err != nil {
+		return false
+	}
+	m.steps++
+	if m.steps > maxBacktrackSteps || m.depth >= maxBacktrackDepth {
+		m.err = errBacktrackLimit
+		return false
+	}
+	if m.ctx != nil && m.steps%1024 == 0 {
+		select {
+		case <-m.ctx.Done():
+			m.err = m.ctx.Err()
+			return false
+		default:
+		}
+	}
+	m.depth++
+	ok := m.matchOp(re, pos, k)
+	m.depth--
+	return ok
+}
+
+// matchOp matches the operator at the top of re as match does.
+func (m *btMatch) matchOp(re *syntax.Regexp, pos int, k func(int) bool) bool {
+	s := m.s
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return false
+	case syntax.OpEmptyMatch:
+		return k(pos)
+	case syntax.OpLiteral:
+		return m.literal(re.Rune, re.Flags&syntax.FoldCase != 0, pos, k)
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		if pos >= len(s) {
+			return false
+		}
+		r, w := utf8.DecodeRuneInString(s[pos:])
+		switch re.Op {
+		case syntax.OpAnyCharNotNL:
+			if r == '\n' {
+				return false
+			}
+		case syntax.OpCharClass:
+			if !inClass(r, re.Rune) {
+				return false
+			}
+		}
+		return k(pos + w)
+	case syntax.OpBeginLine:
+		return (pos == 0 || s[pos-1] == '\n') && k(pos)
+	case syntax.OpEndLine:
+		return (pos == len(s) || s[pos] == '\n') && k(pos)
+	case syntax.OpBeginText:
+		return pos == 0 && k(pos)
+	case syntax.OpEndText:
+		return pos == len(s) && k(pos)
+	case syntax.OpWordBoundary:
+		return m.atWordBoundary(pos) && k(pos)
+	case syntax.OpNoWordBoundary:
+		return !m.atWordBoundary(pos) && k(pos)
+	case syntax.OpCapture:
+		i := 2 * re.Cap
+		oldStart, oldEnd := m.caps[i], m.caps[i+1]
+		if m.match(re.Sub[0], pos, func(end int) bool {
+			prevStart, prevEnd := m.caps[i], m.caps[i+1]
+			m.caps[i], m.caps[i+1] = pos, end
+			if k(end) {
+				return true
+			}
+			m.caps[i], m.caps[i+1] = prevStart, prevEnd
+			return false
+		}) {
+			return true
+		}
+		m.caps[i], m.caps[i+1] = oldStart, oldEnd
+		return false
+	case syntax.OpConcat:
+		return m.concat(re.Sub, pos, k)
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if m.match(sub, pos, k) {
+				return true
+			}
+		}
+		return false
+	case syntax.OpStar:
+		return m.repeat(re.Sub[0], 0, -1, re.Flags&syntax.NonGreedy == 0, pos, k)
+	case syntax.OpPlus:
+		return m.repeat(re.Sub[0], 1, -1, re.Flags&syntax.NonGreedy == 0, pos, k)
+	case syntax.OpQuest:
+		return m.repeat(re.Sub[0], 0, 1, re.Flags&syntax.NonGreedy == 0, pos, k)
+	case syntax.OpRepeat:
+		return m.repeat(re.Sub[0], re.Min, re.Max, re.Flags&syntax.NonGreedy == 0, pos, k)
+	}
+	return false
+}
+
+// literal matches the runes of a literal, where any
+// back-reference placeholders match the text of their group.
+func (m *btMatch) literal(runes []rune, fold bool, pos int, k func(int) bool) bool {
+	for _, want := range runes {
+		if want > backrefBase && want <= backrefBase+9 {
+			n := int(want - backrefBase)
+			start, end := m.caps[2*n], m.caps[2*n+1]
+			if start < 0 {
+				return false
+			}
+			group := m.s[start:end]
+			if len(m.s)-pos < len(group) {
+				return false
+			}
+			got := m.s[pos : pos+len(group)]
+			if got != group && !(fold && strings.EqualFold(got, group)) {
+				return false
+			}
+			pos += len(group)
+			continue
+		}
+		if pos >= len(m.s) {
+			return false
+		}
+		r, w := utf8.DecodeRuneInString(m.s[pos:])
+		if r != want && !(fold && equalFold(r, want)) {
+			return false
+		}
+		pos += w
+	}
+	return k(pos)
+}
+
+func (m *btMatch) concat(subs []*syntax.Regexp, pos int, k func(int) bool) bool {
+	if len(subs) == 0 {
+		return k(pos)
+	}
+	return m.match(subs[0], pos, func(next int) bool {
+		return m.concat(subs[1:], next, k)
+	})
+}
+
+// repeat matches re between min and max times (max < 0 for no limit).
+// Repetitions that match the empty string end the loop.
+func (m *btMatch) repeat(re *syntax.Regexp, min, max int, greedy bool, pos int, k func(int) bool) bool {
+	more := func() bool {
+		if max == 0 {
+			return false
+		}
+		return m.match(re, pos, func(next int) bool {
+			if next == pos && min <= 0 {
+				return false
+			}
+			nextMax := max - 1
+			if max < 0 {
+				nextMax = -1
+			}
+			return m.repeat(re, min-1, nextMax, greedy, next, k)
+		})
+	}
+	if min > 0 {
+		return more()
+	}
+	if greedy {
+		return more() || k(pos)
+	}
+	return k(pos) || more()
+}
+
+func (m *btMatch) atWordBoundary(pos int) bool {
+	before := pos > 0 && isWordByte(m.s[pos-1])
+	after := pos < len(m.s) && isWordByte(m.s[pos])
+	return before != after
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// inClass reports whether r falls in one of the [lo, hi] pairs of a
+// character class.
+func inClass(r rune, class []rune) bool {
+	for i := 0; i+1 < len(class); i += 2 {
+		if class[i] <= r && r <= class[i+1] {
+			return true
+		}
+	}
+	return false
+}
+
+func equalFold(a, b rune) bool {
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,227 @@
+package ast
+
+// opcode is the operation of an op.
+type opcode uint8
+
+const (
+	// Tests jump to the op's jump when its address does not match, or
+	// when it does if the op's not is set.
+	opLine     opcode = iota // The line is n.
+	opLast                   // The line is the last.
+	opMatch                  // re, or the last regexp for the empty regexp, matches.
+	opStep                   // The GNU n~m address.
+	opNever                  // Never matches.
+	opRelative               // Never matches; ends a range n lines after its start.
+	opMultiple               // Never matches; ends a range at a multiple of n.
+	opRange                  // The range in slot n.
+
+	opAppend       // a: queue text.
+	opBranch       // b: jump.
+	opChange       // c: print text, unless range slot n is still on, and delete.
+	opSubst        // s
+	opDelete       // d
+	opDeleteFirst  // D
+	opGet          // g
+	opGetAppend    // G
+	opHold         // h
+	opHoldAppend   // H
+	opInsert       // i: print text.
+	opList         // l with width n, or the default if negative.
+	opNext         // n
+	opNextAppend   // N
+	opPrint        // p
+	opPrintFirst   // P
+	opQuit         // q with exit code n.
+	opQuitSilent   // Q with exit code n.
+	opFile         // F
+	opBranchSub    // t
+	opBranchNotSub // T
+	opExchange     // x
+	opTranslate    // y
+	opZap          // z
+	opLineNo       // =
+	opNop          // The commands that touch files or run commands, which do nothing.
+)
+
+// op is one operation of a program lowered to bytecode by lower. Each
+// command becomes a test for each part of its address followed by the
+// op that runs it, and a block becomes the tests of its address, which
+// jump past the block.
+type op struct {
+	code opcode
+	not  bool
+	n, m int
+	jump int // The op a test or branch jumps to, or -1.
+	re   pattern
+	text string
+	// cmd is the instruction that starts at this op, for limits and
+	// errors, or -1.
+	cmd   int
+	subst *sStmt
+	y     *yStmt
+}
+
+// rangeOps are the tests of the first and last addresses of a range.
+type rangeOps struct {
+	first, last op
+	startsOn    bool
+}
+
+// bytecode is a program lowered for the VM.
+type bytecode struct {
+	ops    []op
+	ranges []rangeOps
+}
+
+// lower lowers p.code to bytecode.
+func (p *Program) lower() *bytecode {
+	bc := &bytecode{}
+	slots := make(map[*rangeAddress]int)
+	start := make([]int, len(p.code)+1) // The first op of each instruction.
+	for i, in := range p.code {
+		start[i] = len(bc.ops)
+		if in.close {
+			continue
+		}
+		first := len(bc.ops)
+		bc.test(in.stmt.address(), false, slots)
+		// Until every instruction has its ops, jumps are to instructions.
+		next := i + 1
+		if _, ok := in.stmt.(*blockStmt); ok {
+			next = in.end
+		} else {
+			bc.ops = append(bc.ops, bc.command(in, slots))
+		}
+		for j := first; j < len(bc.ops); j++ {
+			if bc.ops[j].code <= opRange {
+				bc.ops[j].jump = next
+			}
+		}
+		if first == len(bc.ops) {
+			// A block with no address still counts as a command.
+			bc.ops = append(bc.ops, op{code: opNop, jump: -1})
+		}
+		bc.ops[first].cmd = i
+	}
+	start[len(p.code)] = len(bc.ops)
+	for i := range bc.ops {
+		if bc.ops[i].jump >= 0 {
+			bc.ops[i].jump = start[bc.ops[i].jump]
+		}
+	}
+	return bc
+}
+
+// test appends the tests for the address a, which match when it does or,
+// if not is set, when it doesn't. A blank address needs no test.
+func (bc *bytecode) test(a addresser, not bool, slots map[*rangeAddress]int) {
+	switch a := a.(type) {
+	case *blankAddress:
+		if not {
+			bc.ops = append(bc.ops, op{code: opNever, cmd: -1})
+		}
+		return
+	case *notAddr:
+		bc.test(a.Addr, !not, slots)
+		return
+	case *rangeAddress:
+		slots[a] = len(bc.ranges)
+		bc.ranges = append(bc.ranges, rangeOps{
+			first:    simpleTest(a.Addr1),
+			last:     simpleTest(a.Addr2),
+			startsOn: a.startsBeforeInput(),
+		})
+		bc.ops = append(bc.ops, op{code: opRange, n: slots[a], not: not, cmd: -1})
+		return
+	}
+	o := simpleTest(a)
+	o.not = not
+	bc.ops = append(bc.ops, o)
+}
+
+// simpleTest returns the test for an address that is not a range or
+// negated.
+func simpleTest(a addresser) op {
+	o := op{cmd: -1, jump: -1}
+	switch a := a.(type) {
+	case *lineNoAddr:
+		o.code, o.n = opLine, a.LineNo
+	case *eofAddr:
+		o.code = opLast
+	case *regexpAddr:
+		o.code, o.re = opMatch, a.Regexp
+	case *stepAddr:
+		o.code, o.n, o.m = opStep, a.First, a.Step
+	case *relLineAddr:
+		o.code, o.n = opRelative, a.N
+	case *multipleAddr:
+		o.code, o.n = opMultiple, a.N
+	default:
+		o.code = opNever
+	}
+	return o
+}
+
+// command returns the op that runs the command of in. Branches are given
+// the instruction they jump to, for lower to resolve.
+func (bc *bytecode) command(in instruction, slots map[*rangeAddress]int) op {
+	o := op{cmd: -1, n: -1, jump: -1}
+	switch s := in.stmt.(type) {
+	case *aStmt:
+		o.code, o.text = opAppend, textLine(s.AppendLine, s.HasText)
+	case *bStmt:
+		o.code, o.jump = opBranch, in.target
+	case *cStmt:
+		o.code, o.text = opChange, textLine(s.ChangeLine, s.HasText)
+		if a, ok := s.addresser.(*rangeAddress); ok {
+			o.n = slots[a]
+		}
+	case *sStmt:
+		o.code, o.re, o.subst = opSubst, s.regexp, s
+	case *dStmt:
+		o.code = opDelete
+	case *d2Stmt:
+		o.code = opDeleteFirst
+	case *gStmt:
+		o.code = opGet
+	case *g2Stmt:
+		o.code = opGetAppend
+	case *hStmt:
+		o.code = opHold
+	case *h2Stmt:
+		o.code = opHoldAppend
+	case *iStmt:
+		o.code, o.text = opInsert, textLine(s.InsertLine, s.HasText)
+	case *lStmt:
+		o.code, o.n = opList, s.Width
+	case *nStmt:
+		o.code = opNext
+	case *n2Stmt:
+		o.code = opNextAppend
+	case *pStmt:
+		o.code = opPrint
+	case *p2Stmt:
+		o.code = opPrintFirst
+	case *qStmt:
+		o.code, o.n = opQuit, s.ExitCode
+	case *q2Stmt:
+		o.code, o.n = opQuitSilent, s.ExitCode
+	case *fStmt:
+		o.code = opFile
+	case *tStmt:
+		o.code, o.jump = opBranchSub, in.target
+	case *t2Stmt:
+		o.code, o.jump = opBranchNotSub, in.target
+	case *xStmt:
+		o.code = opExchange
+	case *yStmt:
+		o.code, o.y = opTranslate, s
+	case *zStmt:
+		o.code = opZap
+	case *equStmt:
+		o.code = opLineNo
+	default:
+		o.code = opNop
+	}
+	return o
+}
@@ -0,0 +1,47 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLower(t *testing.T) {
+	type lowered struct {
+		code opcode
+		not  bool
+		jump int
+		cmd  int
+	}
+	tests := []struct {
+		script   string
+		expected []lowered
+	}{
+		{
+			script: "/a/!{p;b end\n};:end\n=",
+			expected: []lowered{
+				{opMatch, true, 3, 0}, {opPrint, false, -1, 1}, {opBranch, false, 3, 2}, {opLineNo, false, -1, 4},
+			},
+		},
+		{
+			script: "{1,/x/!d};2!{ta\n$s/a/b/};:a",
+			expected: []lowered{
+				{opNop, false, -1, 0}, {opRange, true, 3, 1}, {opDelete, false, -1, -1},
+				{opLine, true, 7, 3}, {opBranchSub, false, 7, 4}, {opLast, false, 7, 5}, {opSubst, false, -1, -1},
+			},
+		},
+	}
+	for i, test := range tests {
+		p := New(test.script)
+		prg := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("Lower [%d] script errors: %v", i, p.Errors())
+		}
+		var got []lowered
+		for _, o := range prg.bc.ops {
+			got = append(got, lowered{o.code, o.not, o.jump, o.cmd})
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Lower [%d] incorrect.\n  Got: %v\n  Expected: %v", i, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package ast
+
+import "fmt"
+
+// instruction is one step of a program compiled into a flat list. A block
+// becomes the instruction for its opening brace, which skips past the
+// block when its address does not match, then the block's commands and
+// an instruction for its closing brace. With every command in one list,
+// labels are global and branches may cross block boundaries.
+type instruction struct {
+	stmt  statement // The command, or the block for both of its braces.
+	line  int       // The script line the command starts on.
+	depth int       // The block nesting level of the command.
+	end   int       // For an opening brace, the instruction after the block.
+	close bool      // Whether this is the closing brace of a block.
+	// target is the instruction a branch jumps to. A branch to the end
+	// of the script targets len(code), just past the last instruction.
+	target int
+}
+
+// brancher is a command that jumps to a label, or to the end of the
+// script when the label is "".
+type brancher interface {
+	label() string
+}
+
+// compile flattens the statements of the program and its blocks into
+// p.code, resolves every label to an index into it and lowers it to
+// bytecode. It returns an error for each branch to a label that does not
+// exist.
+func (p *Program) compile() []string {
+	p.code = make([]instruction, 0, len(p.Statements))
+	p.labels = make(map[string]int)
+	p.compileBlock(p, 0)
+
+	var errs []string
+	for i := range p.code {
+		in := &p.code[i]
+		b, ok := in.stmt.(brancher)
+		if !ok {
+			continue
+		}
+		in.target = len(p.code)
+		if b.label() == "" {
+			continue
+		}
+		target, ok := p.labels[b.label()]
+		if !ok {
+			errs = append(errs, fmt.Sprintf("line %d: can't find label for jump to `%s'", in.line, b.label()))
+			continue
+		}
+		in.target = target
+	}
+	p.bc = p.lower()
+	return errs
+}
+
+func (p *Program) compileBlock(block *Program, depth int) {
+	labelsAt := make(map[int][]string)
+	for l, pos := range block.Labels {
+		labelsAt[pos] = append(labelsAt[pos], l)
+	}
+	for i, stmt := range block.Statements {
+		for _, l := range labelsAt[i] {
+			p.labels[l] = len(p.code)
+		}
+		line := 0
+		if i < len(block.SourceLines) {
+			line = block.SourceLines[i]
+		}
+		b, ok := stmt.(*blockStmt)
+		if !ok {
+			p.code = append(p.code, instruction{stmt: stmt, line: line, depth: depth})
+			continue
+		}
+		open := len(p.code)
+		p.code = append(p.code, instruction{stmt: stmt, line: line, depth: depth})
+		p.compileBlock(b.Code, depth+1)
+		p.code = append(p.code, instruction{stmt: stmt, line: line, depth: depth, close: true})
+		p.code[open].end = len(p.code)
+	}
+	for _, l := range labelsAt[len(block.Statements)] {
+		p.labels[l] = len(p.code)
+	}
+}
@@ -0,0 +1,75 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Dialect selects which variant of the sed language a script is written in.
+type Dialect int
+
+const (
+	// GNU accepts everything GNU sed does. It is the default.
+	GNU Dialect = iota
+	// POSIX accepts only what POSIX specifies, like GNU sed's --posix. Any
+	// GNU extension is a compile error.
+	POSIX
+	// BSD follows FreeBSD and macOS sed: POSIX with a few extensions of
+	// its own, and its own rules for the text of a, i and c.
+	BSD
+)
+
+func (d Dialect) String() string {
+	switch d {
+	case GNU:
+		return "GNU"
+	case POSIX:
+		return "POSIX"
+	case BSD:
+		return "BSD"
+	}
+	return fmt.Sprintf("Dialect(%d)", int(d))
+}
+
+// ParseOptions control how a script is parsed.
+type ParseOptions struct {
+	Dialect        Dialect
+	ExtendedRegexp bool // Regexps are POSIX extended regexps, as with sed -E.
+}
+
+// ParseDialect returns the dialect named name, ignoring case.
+func ParseDialect(name string) (Dialect, error) {
+	for _, d := range []Dialect{GNU, POSIX, BSD} {
+		if strings.EqualFold(name, d.String()) {
+			return d, nil
+		}
+	}
+	return GNU, fmt.Errorf("unknown sed dialect %q", name)
+}
+
+// extensionError reports a construct that dialect does not support.
+func extensionError(construct string, dialect Dialect) error {
+	if dialect == BSD {
+		return fmt.Errorf("%s is not supported by BSD sed", construct)
+	}
+	return fmt.Errorf("%s is a GNU extension, not allowed in POSIX mode", construct)
+}
+
+// gnuExtension records an error naming construct unless the script is
+// parsed as GNU sed. It reports whether the construct is allowed.
+func (p *Parser) gnuExtension(construct string) bool {
+	return p.extension(construct, GNU)
+}
+
+// extension records an error naming construct unless the script is parsed
+// in one of the allowed dialects. It reports whether the construct is
+// allowed.
+func (p *Parser) extension(construct string, allowed ...Dialect) bool {
+	for _, d := range allowed {
+		if p.opts.Dialect == d {
+			return true
+		}
+	}
+	p.errorf("%v", extensionError(construct, p.opts.Dialect))
+	return false
+}
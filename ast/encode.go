@@ -0,0 +1,618 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// EncodingVersion is the version of the format Encode writes. It changes
+// whenever the format does, and Decode only reads its own version, so a
+// program encoded by another version of the package must be compiled from
+// its script again.
+const EncodingVersion = 2
+
+// encodingMagic starts every encoded program.
+const encodingMagic = "gosed"
+
+var (
+	// ErrEncoding is returned by Decode for data that is not an encoded
+	// program, or that is cut short or corrupt.
+	ErrEncoding = errors.New("not an encoded sed program")
+	// ErrVersion is returned by Decode for a program encoded with another
+	// EncodingVersion.
+	ErrVersion = errors.New("encoded sed program has another version")
+)
+
+// The tags of the addresses in an encoded program. Commands are tagged
+// by their command character, and blocks by {.
+const (
+	addrBlank byte = iota
+	addrLine
+	addrLast
+	addrRegexp
+	addrNot
+	addrStep
+	addrRelative
+	addrMultiple
+	addrRange
+)
+
+// The kinds of compiled regexps in an encoded program.
+const (
+	noPattern byte = iota
+	goPattern
+	backtrackPattern
+)
+
+// Encode returns the program in a compact binary form that Decode reads
+// back without parsing the script, for embedding compiled programs in a
+// binary. opts are the options the program was parsed with, which Decode
+// returns. Regexps are stored as the Go expressions they were translated
+// to, so only the Go regexp package compiles them again.
+func (p *Program) Encode(opts ParseOptions) []byte {
+	e := &encoder{}
+	e.buff.WriteString(encodingMagic)
+	e.uint(EncodingVersion)
+	e.int(int(opts.Dialect))
+	e.bool(opts.ExtendedRegexp)
+	e.program(p)
+
+	labels := sortedKeys(p.labelLines)
+	e.uint(len(labels))
+	for _, l := range labels {
+		e.string(l)
+		e.int(p.labelLines[l])
+		e.int(p.labelColumns[l])
+		e.int(p.labelOffsets[l])
+	}
+	e.uint(len(p.Comments))
+	for _, c := range p.Comments {
+		e.int(c.Pos)
+		e.int(c.Line)
+		e.string(c.Text)
+		e.bool(c.Trailing)
+	}
+	e.ints(p.blankLines)
+	return e.buff.Bytes()
+}
+
+// Decode returns the program encoded in data by Encode and the options it
+// was parsed with. It returns an error wrapping ErrEncoding if data is not
+// an encoded program and one wrapping ErrVersion if it was encoded with
+// another EncodingVersion. The tokens of the script are not encoded, so
+// the program's Tokens are empty.
+func Decode(data []byte) (*Program, ParseOptions, error) {
+	var opts ParseOptions
+	if !bytes.HasPrefix(data, []byte(encodingMagic)) {
+		return nil, opts, ErrEncoding
+	}
+	d := &decoder{data: data[len(encodingMagic):]}
+	if v := d.uint(); d.err == nil && v != EncodingVersion {
+		return nil, opts, fmt.Errorf("%w: version %d, expected %d", ErrVersion, v, EncodingVersion)
+	}
+	opts.Dialect = Dialect(d.int())
+	opts.ExtendedRegexp = d.bool()
+	p := d.program()
+	p.dialect = opts.Dialect
+
+	p.labelLines = make(map[string]int)
+	p.labelColumns = make(map[string]int)
+	p.labelOffsets = make(map[string]int)
+	for n := d.count(); n > 0; n-- {
+		l := d.string()
+		p.labelLines[l] = d.int()
+		p.labelColumns[l] = d.int()
+		p.labelOffsets[l] = d.int()
+	}
+	for n := d.count(); n > 0; n-- {
+		p.Comments = append(p.Comments, Comment{Pos: d.int(), Line: d.int(), Text: d.string(), Trailing: d.bool()})
+	}
+	p.blankLines = d.ints()
+	if d.err == nil && len(d.data) > 0 {
+		d.fail("%d bytes after the program", len(d.data))
+	}
+	if d.err != nil {
+		return nil, opts, d.err
+	}
+	if errs := p.compile(); len(errs) > 0 {
+		return nil, opts, fmt.Errorf("%w: %s", ErrEncoding, errs[0])
+	}
+	return p, opts, nil
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// encoder writes the parts of a program as varints and length-prefixed
+// strings.
+type encoder struct {
+	buff bytes.Buffer
+}
+
+func (e *encoder) uint(n int) {
+	var b [binary.MaxVarintLen64]byte
+	e.buff.Write(b[:binary.PutUvarint(b[:], uint64(n))])
+}
+
+func (e *encoder) int(n int) {
+	var b [binary.MaxVarintLen64]byte
+	e.buff.Write(b[:binary.PutVarint(b[:], int64(n))])
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.buff.WriteByte(1)
+		return
+	}
+	e.buff.WriteByte(0)
+}
+
+func (e *encoder) string(s string) {
+	e.uint(len(s))
+	e.buff.WriteString(s)
+}
+
+func (e *encoder) ints(ns []int) {
+	e.uint(len(ns))
+	for _, n := range ns {
+		e.int(n)
+	}
+}
+
+func (e *encoder) program(p *Program) {
+	e.uint(len(p.Statements))
+	for _, stmt := range p.Statements {
+		e.statement(stmt)
+	}
+	e.ints(p.SourceLines)
+	e.ints(p.SourceColumns)
+	e.ints(p.SourceOffsets)
+	labels := sortedKeys(p.Labels)
+	e.uint(len(labels))
+	for _, l := range labels {
+		e.string(l)
+		e.int(p.Labels[l])
+	}
+}
+
+func (e *encoder) statement(stmt statement) {
+	e.address(stmt.address())
+	switch s := stmt.(type) {
+	case *aStmt:
+		e.buff.WriteByte('a')
+		e.string(s.AppendLine)
+		e.bool(s.HasText)
+	case *bStmt:
+		e.buff.WriteByte('b')
+		e.string(s.BranchIdent)
+	case *cStmt:
+		e.buff.WriteByte('c')
+		e.string(s.ChangeLine)
+		e.bool(s.HasText)
+	case *sStmt:
+		e.buff.WriteByte('s')
+		e.string(s.FindAddr)
+		e.string(s.ReplaceAddr)
+		e.int(s.Flags.NFlag)
+		e.bool(s.Flags.GFlag)
+		e.bool(s.Flags.PFlag)
+		e.string(s.Flags.WFile)
+		e.bool(s.Flags.IFlag)
+		e.bool(s.Flags.MFlag)
+		e.bool(s.Flags.EFlag)
+		e.pattern(s.regexp)
+		e.uint(len(s.replacement))
+		for _, part := range s.replacement {
+			e.string(part.literal)
+			e.int(part.group)
+			e.buff.WriteByte(part.caseOp)
+		}
+	case *dStmt:
+		e.buff.WriteByte('d')
+	case *d2Stmt:
+		e.buff.WriteByte('D')
+	case *eStmt:
+		e.buff.WriteByte('e')
+		e.string(s.Command)
+	case *gStmt:
+		e.buff.WriteByte('g')
+	case *g2Stmt:
+		e.buff.WriteByte('G')
+	case *hStmt:
+		e.buff.WriteByte('h')
+	case *h2Stmt:
+		e.buff.WriteByte('H')
+	case *iStmt:
+		e.buff.WriteByte('i')
+		e.string(s.InsertLine)
+		e.bool(s.HasText)
+	case *lStmt:
+		e.buff.WriteByte('l')
+		e.int(s.Width)
+	case *nStmt:
+		e.buff.WriteByte('n')
+	case *n2Stmt:
+		e.buff.WriteByte('N')
+	case *pStmt:
+		e.buff.WriteByte('p')
+	case *p2Stmt:
+		e.buff.WriteByte('P')
+	case *qStmt:
+		e.buff.WriteByte('q')
+		e.int(s.ExitCode)
+	case *q2Stmt:
+		e.buff.WriteByte('Q')
+		e.int(s.ExitCode)
+	case *fStmt:
+		e.buff.WriteByte('F')
+	case *rStmt:
+		e.buff.WriteByte('r')
+		e.string(s.FileName)
+	case *r2Stmt:
+		e.buff.WriteByte('R')
+		e.string(s.FileName)
+	case *tStmt:
+		e.buff.WriteByte('t')
+		e.string(s.BranchIdent)
+	case *t2Stmt:
+		e.buff.WriteByte('T')
+		e.string(s.BranchIdent)
+	case *wStmt:
+		e.buff.WriteByte('w')
+		e.string(s.FileName)
+	case *w2Stmt:
+		e.buff.WriteByte('W')
+		e.string(s.FileName)
+	case *xStmt:
+		e.buff.WriteByte('x')
+	case *yStmt:
+		e.buff.WriteByte('y')
+		e.string(s.Find)
+		e.string(s.Replace)
+	case *zStmt:
+		e.buff.WriteByte('z')
+	case *equStmt:
+		e.buff.WriteByte('=')
+	case *blockStmt:
+		e.buff.WriteByte('{')
+		e.program(s.Code)
+	default:
+		panic(fmt.Sprintf("can't encode %T", stmt))
+	}
+}
+
+func (e *encoder) address(a addresser) {
+	switch a := a.(type) {
+	case *blankAddress:
+		e.buff.WriteByte(addrBlank)
+	case *lineNoAddr:
+		e.buff.WriteByte(addrLine)
+		e.int(a.LineNo)
+	case *eofAddr:
+		e.buff.WriteByte(addrLast)
+	case *regexpAddr:
+		e.buff.WriteByte(addrRegexp)
+		e.string(a.Source)
+		e.bool(a.Flags.icase)
+		e.bool(a.Flags.multiline)
+		e.pattern(a.Regexp)
+	case *notAddr:
+		e.buff.WriteByte(addrNot)
+		e.address(a.Addr)
+	case *stepAddr:
+		e.buff.WriteByte(addrStep)
+		e.int(a.First)
+		e.int(a.Step)
+	case *relLineAddr:
+		e.buff.WriteByte(addrRelative)
+		e.int(a.N)
+	case *multipleAddr:
+		e.buff.WriteByte(addrMultiple)
+		e.int(a.N)
+	case *rangeAddress:
+		e.buff.WriteByte(addrRange)
+		e.address(a.Addr1)
+		e.address(a.Addr2)
+	default:
+		panic(fmt.Sprintf("can't encode %T", a))
+	}
+}
+
+func (e *encoder) pattern(re pattern) {
+	switch re.(type) {
+	case nil:
+		e.buff.WriteByte(noPattern)
+		return
+	case *backtracker:
+		e.buff.WriteByte(backtrackPattern)
+	default:
+		e.buff.WriteByte(goPattern)
+	}
+	e.string(re.String())
+}
+
+// decoder reads what encoder writes. The first error it meets is kept in
+// err, after which it returns zero values.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) fail(format string, args ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("%w: %s", ErrEncoding, fmt.Sprintf(format, args...))
+	}
+	d.data = nil
+}
+
+func (d *decoder) byte() byte {
+	if len(d.data) == 0 {
+		d.fail("unexpected end of data")
+		return 0
+	}
+	b := d.data[0]
+	d.data = d.data[1:]
+	return b
+}
+
+func (d *decoder) uint() int {
+	n, size := binary.Uvarint(d.data)
+	if size <= 0 || n > math.MaxInt32 {
+		d.fail("bad number")
+		return 0
+	}
+	d.data = d.data[size:]
+	return int(n)
+}
+
+func (d *decoder) int() int {
+	n, size := binary.Varint(d.data)
+	if size <= 0 || n > math.MaxInt32 || n < math.MinInt32 {
+		d.fail("bad number")
+		return 0
+	}
+	d.data = d.data[size:]
+	return int(n)
+}
+
+// count reads the length of a list, which can't be more than the bytes
+// left.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > len(d.data) {
+		d.fail("list of %d longer than the data", n)
+		return 0
+	}
+	return n
+}
+
+// natural reads a number that can't be negative, such as a line number,
+// failing with what it is if it is.
+func (d *decoder) natural(what string) int {
+	n := d.int()
+	if n < 0 {
+		d.fail("negative %s %d", what, n)
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) bool() bool {
+	return d.byte() != 0
+}
+
+func (d *decoder) string() string {
+	n := d.uint()
+	if n > len(d.data) {
+		d.fail("string of %d longer than the data", n)
+		return ""
+	}
+	s := string(d.data[:n])
+	d.data = d.data[n:]
+	return s
+}
+
+func (d *decoder) ints() []int {
+	var ns []int
+	for n := d.count(); n > 0; n-- {
+		ns = append(ns, d.int())
+	}
+	return ns
+}
+
+func (d *decoder) program() *Program {
+	p := &Program{Statements: []statement{}, Labels: make(map[string]int)}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		p.Statements = append(p.Statements, d.statement())
+	}
+	p.SourceLines = d.ints()
+	p.SourceColumns = d.ints()
+	p.SourceOffsets = d.ints()
+	for n := d.count(); n > 0; n-- {
+		l := d.string()
+		pos := d.int()
+		if pos < 0 || pos > len(p.Statements) {
+			d.fail("label %q at %d of %d commands", l, pos, len(p.Statements))
+			return p
+		}
+		p.Labels[l] = pos
+	}
+	return p
+}
+
+func (d *decoder) statement() statement {
+	a := d.address()
+	switch c := d.byte(); c {
+	case 'a':
+		return &aStmt{addresser: a, AppendLine: d.string(), HasText: d.bool()}
+	case 'b':
+		return &bStmt{addresser: a, BranchIdent: d.string()}
+	case 'c':
+		return &cStmt{addresser: a, ChangeLine: d.string(), HasText: d.bool()}
+	case 's':
+		s := &sStmt{addresser: a, FindAddr: d.string(), ReplaceAddr: d.string()}
+		s.Flags.NFlag = d.natural("occurrence")
+		s.Flags.GFlag = d.bool()
+		s.Flags.PFlag = d.bool()
+		s.Flags.WFile = d.string()
+		s.Flags.IFlag = d.bool()
+		s.Flags.MFlag = d.bool()
+		s.Flags.EFlag = d.bool()
+		s.regexp = d.pattern()
+		for n := d.count(); n > 0; n-- {
+			s.replacement = append(s.replacement, d.replacePart(s.regexp))
+		}
+		return s
+	case 'd':
+		return &dStmt{addresser: a}
+	case 'D':
+		return &d2Stmt{addresser: a}
+	case 'e':
+		return &eStmt{addresser: a, Command: d.string()}
+	case 'g':
+		return &gStmt{addresser: a}
+	case 'G':
+		return &g2Stmt{addresser: a}
+	case 'h':
+		return &hStmt{addresser: a}
+	case 'H':
+		return &h2Stmt{addresser: a}
+	case 'i':
+		return &iStmt{addresser: a, InsertLine: d.string(), HasText: d.bool()}
+	case 'l':
+		l := &lStmt{addresser: a, Width: d.int()}
+		if l.Width < -1 {
+			d.fail("negative line length %d", l.Width)
+		}
+		return l
+	case 'n':
+		return &nStmt{addresser: a}
+	case 'N':
+		return &n2Stmt{addresser: a}
+	case 'p':
+		return &pStmt{addresser: a}
+	case 'P':
+		return &p2Stmt{addresser: a}
+	case 'q':
+		return &qStmt{addresser: a, ExitCode: d.natural("exit code")}
+	case 'Q':
+		return &q2Stmt{addresser: a, ExitCode: d.natural("exit code")}
+	case 'F':
+		return &fStmt{addresser: a}
+	case 'r':
+		return &rStmt{addresser: a, FileName: d.string()}
+	case 'R':
+		return &r2Stmt{addresser: a, FileName: d.string()}
+	case 't':
+		return &tStmt{addresser: a, BranchIdent: d.string()}
+	case 'T':
+		return &t2Stmt{addresser: a, BranchIdent: d.string()}
+	case 'w':
+		return &wStmt{addresser: a, FileName: d.string()}
+	case 'W':
+		return &w2Stmt{addresser: a, FileName: d.string()}
+	case 'x':
+		return &xStmt{addresser: a}
+	case 'y':
+		s, err := newYStmt(d.string(), d.string(), a)
+		if err != nil {
+			d.fail("%v", err)
+			return &yStmt{addresser: a}
+		}
+		return s
+	case 'z':
+		return &zStmt{addresser: a}
+	case '=':
+		return &equStmt{addresser: a}
+	case '{':
+		return &blockStmt{addresser: a, Code: d.program()}
+	default:
+		d.fail("unknown command %q", c)
+		return &dStmt{addresser: a}
+	}
+}
+
+// replacePart reads a part of the replacement of an s command whose
+// regexp is re, or nil for the last regexp used.
+func (d *decoder) replacePart(re pattern) replacePart {
+	part := replacePart{literal: d.string(), group: d.int(), caseOp: d.byte()}
+	switch {
+	case part.group < -1 || part.group > 9:
+		d.fail("bad group %d in replacement", part.group)
+	case re != nil && part.group > re.NumSubexp():
+		d.fail("group %d in replacement of a regexp with %d", part.group, re.NumSubexp())
+	case part.caseOp != 0 && strings.IndexByte("LUElu", part.caseOp) == -1:
+		d.fail("unknown case conversion %q in replacement", part.caseOp)
+	}
+	return part
+}
+
+func (d *decoder) address() addresser {
+	switch tag := d.byte(); tag {
+	case addrBlank:
+		return &blankAddress{}
+	case addrLine:
+		return &lineNoAddr{LineNo: d.natural("line number")}
+	case addrLast:
+		return &eofAddr{}
+	case addrRegexp:
+		a := &regexpAddr{Source: d.string()}
+		a.Flags.icase = d.bool()
+		a.Flags.multiline = d.bool()
+		a.Regexp = d.pattern()
+		return a
+	case addrNot:
+		return &notAddr{Addr: d.address()}
+	case addrStep:
+		return &stepAddr{First: d.natural("line number"), Step: d.natural("step")}
+	case addrRelative:
+		return &relLineAddr{N: d.natural("line count")}
+	case addrMultiple:
+		return &multipleAddr{N: d.natural("line count")}
+	case addrRange:
+		return &rangeAddress{Addr1: d.address(), Addr2: d.address()}
+	default:
+		d.fail("unknown address %d", tag)
+		return &blankAddress{}
+	}
+}
+
+func (d *decoder) pattern() pattern {
+	kind := d.byte()
+	if kind == noPattern {
+		return nil
+	}
+	expr := d.string()
+	if d.err != nil {
+		return nil
+	}
+	switch kind {
+	case goPattern:
+		re, err := compileGo(expr)
+		if err != nil {
+			d.fail("%v", err)
+			return nil
+		}
+		return re
+	case backtrackPattern:
+		bt, err := newBacktracker(expr)
+		if err != nil {
+			d.fail("%v", err)
+			return nil
+		}
+		return bt
+	}
+	d.fail("unknown regexp kind %d", kind)
+	return nil
+}
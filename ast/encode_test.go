@@ -0,0 +1,85 @@
+package ast
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		script string
+		opts   ParseOptions
+		input  string
+	}{
+		{script: "# swap\n/a/I,+2!{ s/\\(x\\)\\(y\\)/\\U\\2\\E\\1/2gp\n  y/abc/xyz/ }\n\n:top\n$!N;P;D;t top", input: "xy xy\nAXY\nb\nc\nd"},
+		{script: "0,/b/c\\\nchanged\n1~2h;3,~4G;$=;l 5;s/(a+)\\1/[&]/w /dev/null", opts: ParseOptions{ExtendedRegexp: true}, input: "aa\nb\naaaa\nx"},
+		{script: "/x/,/y/ {\n  //d\n  l\n}\n2q", opts: ParseOptions{Dialect: BSD}, input: "w\nx\ny"},
+		{script: "", input: "a"},
+	}
+	for i, test := range tests {
+		p := NewWithOptions(test.script, test.opts)
+		prg := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("Encode [%d] script errors: %v", i, p.Errors())
+		}
+		data := prg.Encode(test.opts)
+		got, opts, err := Decode(data)
+		if err != nil {
+			t.Errorf("Decode [%d] failed: %v", i, err)
+			continue
+		}
+		if opts != test.opts {
+			t.Errorf("Decode [%d] options incorrect.\n  Got: %+v\n  Expected: %+v", i, opts, test.opts)
+		}
+		if got.Format() != prg.Format() {
+			t.Errorf("Decode [%d] incorrect.\n  Got: %q\n  Expected: %q", i, got.Format(), prg.Format())
+		}
+		if !reflect.DeepEqual(got.Commands(), prg.Commands()) {
+			t.Errorf("Decode [%d] commands differ.", i)
+		}
+		ro := RuntimeOptions{AutoPrint: true, Dialect: test.opts.Dialect}
+		gotOut, _ := got.Run(test.input, ro)
+		expected, _ := prg.Run(test.input, ro)
+		if gotOut != expected {
+			t.Errorf("Decode [%d] runs incorrectly.\n  Got: %q\n  Expected: %q", i, gotOut, expected)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	data := New("s/a/b/;2{p;b}").ParseProgram().Encode(ParseOptions{})
+	for n := 0; n < len(data); n++ {
+		if _, _, err := Decode(data[:n]); !errors.Is(err, ErrEncoding) {
+			t.Errorf("Decode of %d bytes returned %v, expected ErrEncoding", n, err)
+		}
+	}
+	corrupt := append([]byte{}, data...)
+	corrupt[len(encodingMagic)+4] = '?'
+	if _, _, err := Decode(corrupt); !errors.Is(err, ErrEncoding) {
+		t.Errorf("Decode of corrupt data returned %v, expected ErrEncoding", err)
+	}
+	for i, corrupt := range []struct {
+		script string
+		change func(*Program)
+	}{
+		{"s/a/b/2", func(p *Program) { p.Statements[0].(*sStmt).Flags.NFlag = -1 }},
+		{"s/\\(a\\)/\\1/", func(p *Program) { p.Statements[0].(*sStmt).replacement[0].group = 2 }},
+		{"s/a/\\U&/", func(p *Program) { p.Statements[0].(*sStmt).replacement[0].caseOp = 'X' }},
+		{"3p", func(p *Program) { p.Statements[0].(*pStmt).addresser = &lineNoAddr{LineNo: -3} }},
+		{"1~2p", func(p *Program) { p.Statements[0].(*pStmt).addresser = &stepAddr{First: 1, Step: -2} }},
+		{"l", func(p *Program) { p.Statements[0].(*lStmt).Width = -5 }},
+		{":a;ba", func(p *Program) { p.Labels["a"] = 2 }},
+	} {
+		prg := New(corrupt.script).ParseProgram()
+		corrupt.change(prg)
+		if _, _, err := Decode(prg.Encode(ParseOptions{})); !errors.Is(err, ErrEncoding) {
+			t.Errorf("Decode of corrupt program [%d] %q returned %v, expected ErrEncoding", i, corrupt.script, err)
+		}
+	}
+	old := append([]byte{}, data...)
+	old[len(encodingMagic)] = EncodingVersion + 1
+	if _, _, err := Decode(old); !errors.Is(err, ErrVersion) {
+		t.Errorf("Decode of another version returned %v, expected ErrVersion", err)
+	}
+}
@@ -0,0 +1,341 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Explain returns the program in canonical form with a comment before
+// each command describing in English what it does.
+func (p *Program) Explain() string {
+	return ExplainCommands(p.Commands())
+}
+
+// ExplainCommands returns cmds as a script in canonical form, like
+// FormatCommands, with a comment before each command describing in
+// English what it does and on which lines.
+func ExplainCommands(cmds []*Command) string {
+	e := newExplainer(cmds)
+	e.writeCommands(cmds, 0)
+	return e.buff.String()
+}
+
+// ExplainCommand returns the sentence ExplainCommands puts before cmd,
+// one of the commands of cmds or of their blocks.
+func ExplainCommand(cmds []*Command, cmd *Command) string {
+	return newExplainer(cmds).explain(cmd)
+}
+
+func newExplainer(cmds []*Command) *explainer {
+	e := &explainer{labels: make(map[string]int), branches: make(map[string][]int)}
+	Walk(cmds, func(cmd *Command) bool {
+		switch cmd.Name {
+		case ":":
+			e.labels[cmd.Label] = cmd.Line
+		case "b", "t", "T":
+			e.branches[cmd.Label] = append(e.branches[cmd.Label], cmd.Line)
+		}
+		return true
+	})
+	return e
+}
+
+type explainer struct {
+	buff     bytes.Buffer
+	labels   map[string]int   // The line of each label.
+	branches map[string][]int // The lines of the branches to each label.
+}
+
+func (e *explainer) writeCommands(cmds []*Command, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, cmd := range cmds {
+		e.buff.WriteString(indent + "# " + e.explain(cmd) + "\n")
+		e.buff.WriteString(indent + cmd.String() + "\n")
+		if cmd.Name == "{" {
+			e.writeCommands(cmd.Block, depth+1)
+			e.buff.WriteString(indent + "}\n")
+		}
+	}
+}
+
+// explain returns a sentence describing cmd and its address.
+func (e *explainer) explain(cmd *Command) string {
+	what := e.explainCommand(cmd)
+	where := explainAddress(cmd)
+	if where == "" {
+		return capitalize(what) + "."
+	}
+	return where + ", " + what + "."
+}
+
+func (e *explainer) explainCommand(cmd *Command) string {
+	switch cmd.Name {
+	case "{":
+		return "run the commands of the block"
+	case ":":
+		lines := e.branches[cmd.Label]
+		if len(lines) == 0 {
+			return fmt.Sprintf("mark label %q, which no branch jumps to", cmd.Label)
+		}
+		return fmt.Sprintf("mark label %q, where the branches on %s continue", cmd.Label, listLines(lines))
+	case "=":
+		return "print the current line number"
+	case "a":
+		return fmt.Sprintf("queue the text %q to be printed at the end of the cycle", cmd.Text)
+	case "i":
+		return fmt.Sprintf("print the text %q", cmd.Text)
+	case "c":
+		if cmd.Addr2 != nil && !cmd.Negated {
+			return fmt.Sprintf("delete the pattern space, printing the text %q at the end of the range, and start the next cycle", cmd.Text)
+		}
+		return fmt.Sprintf("delete the pattern space, print the text %q in its place and start the next cycle", cmd.Text)
+	case "b":
+		return e.explainBranch(cmd.Label)
+	case "t":
+		return "if a substitution has been made since the last line was read or t or T was run, " + e.explainBranch(cmd.Label)
+	case "T":
+		return "if no substitution has been made since the last line was read or t or T was run, " + e.explainBranch(cmd.Label)
+	case "d":
+		return "delete the pattern space and start the next cycle"
+	case "D":
+		return "delete up to the first newline and restart the cycle without reading new input, or act like d if there is no newline"
+	case "e":
+		if cmd.Command == "" {
+			return "run the pattern space as a shell command and replace the pattern space with its output"
+		}
+		return fmt.Sprintf("run the shell command %q and print its output", cmd.Command)
+	case "F":
+		return "print the name of the input file"
+	case "g":
+		return "replace the pattern space with the hold space"
+	case "G":
+		return "append a newline and the hold space to the pattern space"
+	case "h":
+		return "replace the hold space with the pattern space"
+	case "H":
+		return "append a newline and the pattern space to the hold space"
+	case "l":
+		switch {
+		case cmd.Width == 0:
+			return "print the pattern space unambiguously, without wrapping it"
+		case cmd.Width > 0:
+			return fmt.Sprintf("print the pattern space unambiguously, wrapping it at %d characters", cmd.Width)
+		}
+		return "print the pattern space unambiguously"
+	case "n":
+		return "print the pattern space unless -n was given and replace it with the next line of input, or exit if there is none"
+	case "N":
+		return "append a newline and the next line of input to the pattern space, or exit if there is none"
+	case "p":
+		return "print the pattern space"
+	case "P":
+		return "print the pattern space up to the first newline"
+	case "q":
+		return "print the pattern space unless -n was given and exit" + withStatus(cmd.ExitCode)
+	case "Q":
+		return "exit without printing the pattern space" + withStatus(cmd.ExitCode)
+	case "r":
+		return fmt.Sprintf("queue the contents of the file %q to be printed at the end of the cycle", cmd.FileName)
+	case "R":
+		return fmt.Sprintf("queue the next line of the file %q to be printed at the end of the cycle", cmd.FileName)
+	case "s":
+		return explainSubst(cmd)
+	case "w":
+		return fmt.Sprintf("write the pattern space to the file %q", cmd.FileName)
+	case "W":
+		return fmt.Sprintf("write the pattern space up to the first newline to the file %q", cmd.FileName)
+	case "x":
+		return "exchange the pattern space and the hold space"
+	case "y":
+		return fmt.Sprintf("replace each character of %q with the character at the same position in %q", cmd.Find, cmd.Replace)
+	case "z":
+		return "empty the pattern space"
+	}
+	return fmt.Sprintf("run the %s command", cmd.Name)
+}
+
+func (e *explainer) explainBranch(label string) string {
+	if label == "" {
+		return "branch to the end of the script"
+	}
+	return fmt.Sprintf("branch to label %q on line %d", label, e.labels[label])
+}
+
+func explainSubst(cmd *Command) string {
+	var which string
+	n := cmd.Flags.Occurrence
+	switch {
+	case cmd.Flags.Global && n > 1:
+		which = "the " + ordinal(n) + " and every later match"
+	case cmd.Flags.Global:
+		which = "every match"
+	case n > 1:
+		which = "the " + ordinal(n) + " match"
+	default:
+		which = "the first match"
+	}
+	s := fmt.Sprintf("replace %s of %s with %q", which, explainRegexp(cmd.Regexp), cmd.Replacement)
+	var then []string
+	if cmd.Flags.Exec {
+		then = append(then, "run the pattern space as a shell command and replace it with the output")
+	}
+	if cmd.Flags.Print {
+		then = append(then, "print the pattern space")
+	}
+	if cmd.FileName != "" {
+		then = append(then, fmt.Sprintf("write it to the file %q", cmd.FileName))
+	}
+	if len(then) == 0 {
+		return s
+	}
+	return s + ", and if a replacement was made, " + strings.Join(then, " and ")
+}
+
+// explainAddress returns a phrase describing the lines cmd runs on, or ""
+// when it runs on every line.
+func explainAddress(cmd *Command) string {
+	if cmd.Addr1 == nil {
+		return ""
+	}
+	if cmd.Addr2 != nil {
+		from, through := rangeStart(cmd.Addr1), rangeEnd(cmd.Addr2)
+		if cmd.Addr1.Kind == LineAddress && cmd.Addr1.Line == 0 && cmd.Addr2.Kind == RegexpAddress {
+			// 0,/re/ ends on the first line too.
+			through = "the first line matching " + explainRegexp(cmd.Addr2.Regexp)
+		}
+		if cmd.Negated {
+			return fmt.Sprintf("For lines outside the range from %s through %s", from, through)
+		}
+		return fmt.Sprintf("For the lines from %s through %s", from, through)
+	}
+	a := cmd.Addr1
+	if !cmd.Negated {
+		return "For " + linesOf(a)
+	}
+	switch a.Kind {
+	case LastLineAddress:
+		return "For every line except the last"
+	case RegexpAddress:
+		return "For lines not matching " + explainRegexp(a.Regexp)
+	}
+	return "For every line except " + linesOf(a)
+}
+
+// linesOf describes the lines a single address matches.
+func linesOf(a *Address) string {
+	switch a.Kind {
+	case LineAddress:
+		return "line " + strconv.Itoa(a.Line)
+	case LastLineAddress:
+		return "the last line"
+	case RegexpAddress:
+		return "lines matching " + explainRegexp(a.Regexp)
+	case StepAddress:
+		switch {
+		case a.Step <= 0:
+			return "line " + strconv.Itoa(a.Line)
+		case a.Step == 1:
+			return fmt.Sprintf("every line from line %d on", a.Line)
+		case a.Line == 0 || a.Line == a.Step:
+			return "every " + ordinal(a.Step) + " line"
+		}
+		return fmt.Sprintf("every %s line starting at line %d", ordinal(a.Step), a.Line)
+	}
+	return a.String()
+}
+
+func rangeStart(a *Address) string {
+	switch a.Kind {
+	case LineAddress:
+		if a.Line == 0 {
+			return "the start of the input"
+		}
+	case RegexpAddress:
+		return "each line matching " + explainRegexp(a.Regexp)
+	}
+	return linesOf(a)
+}
+
+func rangeEnd(a *Address) string {
+	switch a.Kind {
+	case RegexpAddress:
+		return "the next line matching " + explainRegexp(a.Regexp)
+	case RelativeAddress:
+		switch a.Step {
+		case 0:
+			return "that same line"
+		case 1:
+			return "the line after it"
+		}
+		return fmt.Sprintf("the %d lines after it", a.Step)
+	case MultipleAddress:
+		return fmt.Sprintf("the next line whose number is a multiple of %d", a.Step)
+	}
+	return linesOf(a)
+}
+
+func explainRegexp(re *Regexp) string {
+	if re == nil || re.Source == "" {
+		return "the last regexp used"
+	}
+	s := formatRegexp(re.Source)
+	switch {
+	case re.IgnoreCase && re.Multiline:
+		s += " (ignoring case, in multiline mode)"
+	case re.IgnoreCase:
+		s += " (ignoring case)"
+	case re.Multiline:
+		s += " (in multiline mode)"
+	}
+	return s
+}
+
+func withStatus(code int) string {
+	if code == 0 {
+		return ""
+	}
+	return " with status " + strconv.Itoa(code)
+}
+
+// ordinal returns n as an English ordinal, such as "2nd" or "11th".
+func ordinal(n int) string {
+	suffix := "th"
+	switch n % 10 {
+	case 1:
+		suffix = "st"
+	case 2:
+		suffix = "nd"
+	case 3:
+		suffix = "rd"
+	}
+	if n%100 >= 11 && n%100 <= 13 {
+		suffix = "th"
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+// listLines returns lines as an English list, such as "lines 2 and 5".
+func listLines(lines []int) string {
+	lines = append([]int(nil), lines...)
+	sort.Ints(lines)
+	var uniq []string
+	for i, l := range lines {
+		if i == 0 || l != lines[i-1] {
+			uniq = append(uniq, strconv.Itoa(l))
+		}
+	}
+	if len(uniq) == 1 {
+		return "line " + uniq[0]
+	}
+	return "lines " + strings.Join(uniq[:len(uniq)-1], ", ") + " and " + uniq[len(uniq)-1]
+}
+
+func capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
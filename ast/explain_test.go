@@ -0,0 +1,69 @@
+package ast
+
+import "testing"
+
+func TestExplain(t *testing.T) {
+	cases := []struct {
+		program  string
+		expected string
+	}{
+		{
+			program: "$!N;/^\\(.*\\)\\n\\1$/!P;D",
+			expected: "# For every line except the last, append a newline and the next line of input to the pattern space, or exit if there is none.\n" +
+				"$! N\n" +
+				"# For lines not matching /^\\(.*\\)\\n\\1$/, print the pattern space up to the first newline.\n" +
+				"/^\\(.*\\)\\n\\1$/! P\n" +
+				"# Delete up to the first newline and restart the cycle without reading new input, or act like d if there is no newline.\n" +
+				"D\n",
+		},
+		{
+			program: ":a\n/x/,/y/I {\n  s/a/b/2g\n  0~3 ta\n}\n0,/z/d\n1~2!q 3",
+			expected: "# Mark label \"a\", where the branches on line 4 continue.\n" +
+				":a\n" +
+				"# For the lines from each line matching /x/ through the next line matching /y/ (ignoring case), run the commands of the block.\n" +
+				"/x/,/y/I {\n" +
+				"  # Replace the 2nd and every later match of /a/ with \"b\".\n" +
+				"  s/a/b/2g\n" +
+				"  # For every 3rd line, if a substitution has been made since the last line was read or t or T was run, branch to label \"a\" on line 1.\n" +
+				"  0~3 t a\n" +
+				"}\n" +
+				"# For the lines from the start of the input through the first line matching /z/, delete the pattern space and start the next cycle.\n" +
+				"0,/z/ d\n" +
+				"# For every line except every 2nd line starting at line 1, print the pattern space unless -n was given and exit with status 3.\n" +
+				"1~2! q 3\n",
+		},
+		{
+			program: "/x/,+2!c\\\ngone\ns//&/pw out",
+			expected: "# For lines outside the range from each line matching /x/ through the 2 lines after it, delete the pattern space, print the text \"gone\" in its place and start the next cycle.\n" +
+				"/x/,+2! c\\gone\n" +
+				"# Replace the first match of the last regexp used with \"&\", and if a replacement was made, print the pattern space and write it to the file \"out\".\n" +
+				"s//&/pw out\n",
+		},
+		{
+			program: "1~2,+3p;3,+1d",
+			expected: "# For the lines from every 2nd line starting at line 1 through the 3 lines after it, print the pattern space.\n" +
+				"1~2,+3 p\n" +
+				"# For the lines from line 3 through the line after it, delete the pattern space and start the next cycle.\n" +
+				"3,+1 d\n",
+		},
+	}
+
+	for i, c := range cases {
+		p := New(c.program)
+		prg := p.ParseProgram()
+		if len(p.errors) > 0 {
+			t.Errorf("Program [%d] %q encountered errors %v", i, c.program, p.errors)
+			continue
+		}
+		got := prg.Explain()
+		if got != c.expected {
+			t.Errorf("Explain [%d] incorrect.\n  Got: %q\n  Expected: %q", i, got, c.expected)
+			continue
+		}
+		// The explanation is itself the same script.
+		q := New(got)
+		if reparsed := q.ParseProgram(); len(q.errors) > 0 || reparsed.String() != prg.String() {
+			t.Errorf("Explain [%d] does not parse back to the program: %v", i, q.errors)
+		}
+	}
+}
@@ -0,0 +1,864 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// GenerateGo returns the source of a Go file in package pkg declaring
+//
+//	func name(r io.Reader, w io.Writer) error
+//
+// which runs the program over the lines of r and writes its output to w,
+// as Run and the gosed command do. The program's regexps are compiled
+// once, when the package is initialized, and its control flow is written
+// out with gotos. The file only imports the standard library.
+//
+// The e, r, R and w commands, the W command and the w and e flags of s,
+// and regexps with back-references have no translation and are reported
+// as errors. Exit codes given to q and Q are dropped. Since the function
+// stops reading at q, its output ends with a line delimiter if the input
+// read so far did. F prints the name of r if it is an *os.File other than
+// os.Stdin, and "-" otherwise.
+func (p *Program) GenerateGo(pkg, name string, options RuntimeOptions) ([]byte, error) {
+	if !token.IsIdentifier(pkg) {
+		return nil, fmt.Errorf("invalid package name %q", pkg)
+	}
+	if !token.IsIdentifier(name) {
+		return nil, fmt.Errorf("invalid function name %q", name)
+	}
+	if p.code == nil {
+		// The program was not made by the parser.
+		p.compile()
+	}
+	// A label is only written where a goto reaches it, and a goto only
+	// where it can run, but which code can run depends on the labels. The
+	// first pass labels every instruction, and each pass after labels
+	// those the last one jumped to. Removing labels only makes less code
+	// reachable, so the passes agree in the end.
+	var labels map[int]bool
+	for {
+		g := newGenerator(p, name, options, labels)
+		if err := g.generate(); err != nil {
+			return nil, err
+		}
+		if labels != nil && len(g.gotos) == len(labels) {
+			src, err := format.Source(g.file(pkg))
+			if err != nil {
+				return nil, fmt.Errorf("generated invalid Go: %v", err)
+			}
+			return src, nil
+		}
+		labels = g.gotos
+	}
+}
+
+// generator writes a program as the body of a Go function.
+type generator struct {
+	p       *Program
+	name    string
+	prefix  string // Begins the names of the file's package-level declarations.
+	options RuntimeOptions
+	labels  map[int]bool // The instructions to label, or nil for all of them.
+	gotos   map[int]bool // The instructions jumped to.
+	line    int          // The script line of the instruction being written.
+	guard   string       // The condition the command being written runs under.
+
+	body      bytes.Buffer // The statements of a cycle.
+	ranges    bytes.Buffer // The functions of range addresses.
+	reachable bool         // Whether the next statement can run.
+
+	regexps     []string // The expressions of the program's regexps.
+	regexpIndex map[string]int
+	rangeIndex  map[*rangeAddress]int
+	trackLast   bool            // Whether the program has empty regexps.
+	uses        map[string]bool // The variables and helpers the code needs.
+	imports     map[string]bool
+}
+
+func newGenerator(p *Program, name string, options RuntimeOptions, labels map[int]bool) *generator {
+	g := &generator{
+		p:           p,
+		name:        name,
+		options:     options,
+		labels:      labels,
+		gotos:       make(map[int]bool),
+		regexpIndex: make(map[string]int),
+		rangeIndex:  make(map[*rangeAddress]int),
+		uses:        make(map[string]bool),
+		imports:     map[string]bool{"bufio": true, "io": true},
+	}
+	r, n := utf8.DecodeRuneInString(name)
+	g.prefix = string(unicode.ToLower(r)) + name[n:]
+	// Go rejects variables that are never read, so the code declares only
+	// the state the program uses, and leaves out changes to a space that is
+	// never read.
+	g.uses["ps"] = options.AutoPrint
+	readsHold, writesHold := false, false
+	for _, in := range p.code {
+		switch s := in.stmt.(type) {
+		case *sStmt:
+			g.trackLast = g.trackLast || s.regexp == nil
+			g.uses["ps"] = true
+		case *d2Stmt, *lStmt, *pStmt, *p2Stmt, *yStmt:
+			g.uses["ps"] = true
+		case *xStmt:
+			g.uses["ps"], readsHold = true, true
+		case *gStmt, *g2Stmt:
+			writesHold = true
+		case *tStmt, *t2Stmt:
+			g.uses["sub"] = true
+		case *aStmt:
+			g.uses["append"] = true
+		case *equStmt:
+			g.uses["line"] = true
+		}
+		walkAddress(in.stmt.address(), func(a addresser) {
+			switch a := a.(type) {
+			case *regexpAddr:
+				g.trackLast = g.trackLast || a.Regexp == nil
+				g.uses["ps"] = true
+			case *lineNoAddr, *stepAddr, *rangeAddress:
+				g.uses["line"] = true
+			}
+		})
+	}
+	// g and G read the hold space into a pattern space that may be read.
+	g.uses["hold"] = readsHold || writesHold && g.uses["ps"]
+	return g
+}
+
+// walkAddress calls fn for a and for each address a is made of.
+func walkAddress(a addresser, fn func(addresser)) {
+	fn(a)
+	switch a := a.(type) {
+	case *notAddr:
+		walkAddress(a.Addr, fn)
+	case *rangeAddress:
+		walkAddress(a.Addr1, fn)
+		walkAddress(a.Addr2, fn)
+	}
+}
+
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.body, format, args...)
+}
+
+// generate writes the statements of a cycle to g.body.
+func (g *generator) generate() error {
+	g.reachable = true
+	for i := 0; i <= len(g.p.code); i++ {
+		if g.labels == nil || g.labels[i] {
+			g.printf("l%d:\n", i)
+			g.reachable = true
+		}
+		if i == len(g.p.code) || !g.reachable {
+			// Code that can't run is left out.
+			continue
+		}
+		if err := g.instruction(g.p.code[i]); err != nil {
+			return err
+		}
+	}
+	if g.reachable {
+		if !g.endCycle(true) {
+			// A label needs a statement to label.
+			g.printf("continue\n")
+		}
+	}
+	return nil
+}
+
+func (g *generator) instruction(in instruction) error {
+	if in.close {
+		return nil
+	}
+	g.line = in.line
+	g.comment(&g.body, "", formatStatement(in.stmt))
+	cond, err := g.cond(in.stmt.address())
+	if err != nil {
+		return err
+	}
+	if _, ok := in.stmt.(*blockStmt); ok {
+		if cond != "" {
+			g.printf("if %s {\n%s\n}\n", not(cond), g.jump(in.end))
+		}
+		return nil
+	}
+	if cond != "" {
+		g.printf("if %s {\n", cond)
+	}
+	g.guard = cond
+	ends, err := g.command(in)
+	if err != nil {
+		return err
+	}
+	if cond != "" {
+		g.printf("}\n")
+	} else if ends {
+		g.reachable = false
+	}
+	return nil
+}
+
+// comment writes text as a comment, each line prefixed by indent.
+func (g *generator) comment(b *bytes.Buffer, indent, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.IndexFunc(line, func(r rune) bool { return !unicode.IsPrint(r) && r != '\t' }) >= 0 {
+			line = strconv.Quote(line)
+		}
+		fmt.Fprintf(b, "%s// %s\n", indent, line)
+	}
+}
+
+// jump returns a goto to the instruction at target.
+func (g *generator) jump(target int) string {
+	g.gotos[target] = true
+	return fmt.Sprintf("goto l%d", target)
+}
+
+// cond returns the Go condition that an address matches, or "" if it
+// matches every line.
+func (g *generator) cond(a addresser) (string, error) {
+	switch a := a.(type) {
+	case *blankAddress:
+		return "", nil
+	case *lineNoAddr:
+		return fmt.Sprintf("line == %d", a.LineNo), nil
+	case *eofAddr:
+		return "!more", nil
+	case *stepAddr:
+		switch {
+		case a.Step <= 0:
+			return fmt.Sprintf("line == %d", a.First), nil
+		case a.First == 0:
+			return fmt.Sprintf("line%%%d == 0", a.Step), nil
+		}
+		return fmt.Sprintf("line >= %d && (line-%d)%%%d == 0", a.First, a.First, a.Step), nil
+	case *regexpAddr:
+		if a.Regexp == nil {
+			return "last != nil && last.MatchString(ps)", nil
+		}
+		re, err := g.regexp(a.Regexp)
+		if err != nil {
+			return "", err
+		}
+		if g.trackLast {
+			g.uses["match"] = true
+			return "match(" + re + ")", nil
+		}
+		return re + ".MatchString(ps)", nil
+	case *notAddr:
+		cond, err := g.cond(a.Addr)
+		return not(cond), err
+	case *rangeAddress:
+		return g.rangeFunc(a)
+	}
+	// The ends of ranges, +N and ~N, only match as part of a range.
+	return "false", nil
+}
+
+// not returns the negation of a condition.
+func not(cond string) string {
+	switch {
+	case cond == "":
+		return "false"
+	case strings.HasPrefix(cond, "line == ") && !strings.Contains(cond[len("line == "):], " "):
+		return "line != " + cond[len("line == "):]
+	case strings.Contains(cond, " "):
+		return "!(" + cond + ")"
+	case strings.HasPrefix(cond, "!"):
+		return cond[1:]
+	}
+	return "!" + cond
+}
+
+// regexp returns an expression for the compiled Go regexp of re.
+func (g *generator) regexp(re pattern) (string, error) {
+	if _, ok := re.(*backtracker); ok {
+		return "", fmt.Errorf("line %d: regexps with back-references can't be generated", g.line)
+	}
+	expr := re.String()
+	i, ok := g.regexpIndex[expr]
+	if !ok {
+		i = len(g.regexps)
+		g.regexpIndex[expr] = i
+		g.regexps = append(g.regexps, expr)
+		g.imports["regexp"] = true
+	}
+	return fmt.Sprintf("%sRegexps[%d]", g.prefix, i), nil
+}
+
+// rangeFunc writes a function that reports whether a range address
+// matches, keeping the range's state, and returns a call to it.
+func (g *generator) rangeFunc(a *rangeAddress) (string, error) {
+	if n, ok := g.rangeIndex[a]; ok {
+		return fmt.Sprintf("range%d()", n), nil
+	}
+	n := len(g.rangeIndex)
+	g.rangeIndex[a] = n
+	start, err := g.cond(a.Addr1)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("range%d", n)
+	b := &g.ranges
+	g.comment(b, "\t", formatAddress(a))
+
+	var end string
+	switch addr2 := a.Addr2.(type) {
+	case *lineNoAddr:
+		end = fmt.Sprintf("%sEnd = %d", name, addr2.LineNo)
+	case *relLineAddr:
+		end = fmt.Sprintf("%sEnd = line + %d", name, addr2.N)
+	case *multipleAddr:
+		end = fmt.Sprintf("%sEnd = line", name)
+		if addr2.N > 0 {
+			end += fmt.Sprintf("\nif line%%%d != 0 {\n%sEnd += %d - line%%%d\n}", addr2.N, name, addr2.N, addr2.N)
+		}
+	}
+	if end != "" {
+		// The range ends at a line number, which only the first line
+		// matches if it is not past it.
+		fmt.Fprintf(b, "%sOn, %sEnd := false, 0\n", name, name)
+		fmt.Fprintf(b, "%s := func() bool {\nif %sOn {\n%sOn = line < %sEnd\nreturn true\n}\n", name, name, name, name)
+		fmt.Fprintf(b, "if %s {\nreturn false\n}\n%s\n%sOn = line < %sEnd\nreturn true\n}\n", not(start), end, name, name)
+		return name + "()", nil
+	}
+	stop, err := g.cond(a.Addr2)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(b, "%sOn := %t\n", name, a.startsBeforeInput())
+	fmt.Fprintf(b, "%s := func() bool {\nif %sOn {\n%sOn = %s\nreturn true\n}\n", name, name, name, not(stop))
+	fmt.Fprintf(b, "if %s {\nreturn false\n}\n%sOn = true\nreturn true\n}\n", not(start), name)
+	return name + "()", nil
+}
+
+// endCycle writes the end of a cycle, which prints the pattern space if
+// autoPrint is set and the program has not disabled it, then the text
+// queued by a. It reports whether it wrote anything.
+func (g *generator) endCycle(autoPrint bool) bool {
+	wrote := false
+	if autoPrint && g.options.AutoPrint {
+		g.write("ps + delim")
+		wrote = true
+	}
+	if g.uses["append"] {
+		g.printf("flush()\n")
+		wrote = true
+	}
+	return wrote
+}
+
+// write writes a call writing the string expression s to the output.
+func (g *generator) write(s string) {
+	g.uses["write"] = true
+	g.printf("write(%s)\n", s)
+}
+
+// setPS writes an assignment of the expression to the pattern space,
+// unless the program never reads it.
+func (g *generator) setPS(expr string) {
+	if g.uses["ps"] {
+		g.printf("ps = %s\n", expr)
+	}
+}
+
+// readNext writes the statements that move to the next line of input.
+func (g *generator) readNext() {
+	if g.uses["line"] {
+		g.printf("line++\n")
+	}
+	g.printf("if err := read(); err != nil {\nreturn err\n}\n")
+}
+
+// command writes the statements of a command. It reports whether they
+// always end by jumping elsewhere.
+func (g *generator) command(in instruction) (bool, error) {
+	switch s := in.stmt.(type) {
+	case *aStmt:
+		g.printf("appended += %s\n", strconv.Quote(textLine(s.AppendLine, s.HasText)))
+	case *bStmt:
+		g.printf("%s\n", g.jump(in.target))
+		return true, nil
+	case *cStmt:
+		if a, ok := s.addresser.(*rangeAddress); ok {
+			// A range prints the text once, in place of its last line.
+			g.printf("if !range%dOn {\n", g.rangeIndex[a])
+			g.write(strconv.Quote(textLine(s.ChangeLine, s.HasText)))
+			g.printf("}\n")
+		} else {
+			g.write(strconv.Quote(textLine(s.ChangeLine, s.HasText)))
+		}
+		g.endCycle(false)
+		g.printf("continue\n")
+		return true, nil
+	case *sStmt:
+		if s.Flags.WFile != "" || s.Flags.EFlag {
+			return false, fmt.Errorf("line %d: the w and e flags of s can't be generated", g.line)
+		}
+		return false, g.subst(s)
+	case *dStmt:
+		g.endCycle(false)
+		g.printf("continue\n")
+		return true, nil
+	case *d2Stmt:
+		g.imports["strings"] = true
+		g.printf("if i := strings.IndexByte(ps, '\\n'); i >= 0 {\nps = ps[i+1:]\n")
+		g.endCycle(false)
+		g.printf("%s\n}\n", g.jump(0))
+		g.endCycle(false)
+		g.printf("continue\n")
+		return true, nil
+	case *eStmt, *rStmt, *r2Stmt, *wStmt, *w2Stmt:
+		return false, fmt.Errorf("line %d: the %s command can't be generated", g.line, formatCommand(s)[:1])
+	case *gStmt:
+		g.setPS("hs")
+	case *g2Stmt:
+		g.setPS(`ps + "\n" + hs`)
+	case *hStmt:
+		if g.uses["hold"] {
+			g.printf("hs = ps\n")
+		}
+	case *h2Stmt:
+		if g.uses["hold"] {
+			g.printf("hs += \"\\n\" + ps\n")
+		}
+	case *iStmt:
+		g.write(strconv.Quote(textLine(s.InsertLine, s.HasText)))
+	case *lStmt:
+		width := g.options.lineLength()
+		if s.Width >= 0 {
+			width = s.Width
+		}
+		g.uses["list"] = true
+		g.write(fmt.Sprintf("%sList(ps, %d) + delim", g.prefix, width))
+	case *nStmt:
+		if g.guard != "more" {
+			g.printf("if !more {\n")
+			g.endCycle(true)
+			g.printf("return finish()\n}\n")
+		}
+		g.endCycle(true)
+		g.setPS("next")
+		g.readNext()
+	case *n2Stmt:
+		if g.guard != "more" {
+			g.printf("if !more {\n")
+			g.endCycle(g.options.Dialect == GNU)
+			g.printf("return finish()\n}\n")
+		}
+		g.setPS(`ps + "\n" + next`)
+		g.readNext()
+	case *pStmt:
+		g.write("ps + delim")
+	case *p2Stmt:
+		g.imports["strings"] = true
+		g.uses["write"] = true
+		g.printf("if i := strings.IndexByte(ps, '\\n'); i >= 0 {\nwrite(ps[:i] + delim)\n} else {\nwrite(ps + delim)\n}\n")
+	case *qStmt:
+		g.endCycle(true)
+		g.printf("return finish()\n")
+		return true, nil
+	case *q2Stmt:
+		g.printf("return finish()\n")
+		return true, nil
+	case *fStmt:
+		g.imports["os"] = true
+		g.uses["file"] = true
+		g.write(`file + "\n"`)
+	case *tStmt:
+		g.printf("if subMade {\nsubMade = false\n%s\n}\n", g.jump(in.target))
+	case *t2Stmt:
+		g.printf("if !subMade {\n%s\n}\nsubMade = false\n", g.jump(in.target))
+	case *xStmt:
+		g.printf("ps, hs = hs, ps\n")
+	case *yStmt:
+		g.transliterate(s)
+	case *zStmt:
+		g.setPS(`""`)
+	case *equStmt:
+		g.imports["strconv"] = true
+		g.write(`strconv.Itoa(line) + "\n"`)
+	}
+	return false, nil
+}
+
+// subst writes an s command.
+func (g *generator) subst(s *sStmt) error {
+	g.imports["strings"] = true
+	re, groups := "last", -1
+	if s.regexp == nil {
+		g.printf("if last != nil {\n")
+	} else {
+		var err error
+		if re, err = g.regexp(s.regexp); err != nil {
+			return err
+		}
+		groups = s.regexp.NumSubexp()
+		if g.trackLast {
+			g.printf("last = %s\n", re)
+		}
+	}
+	n := s.Flags.NFlag
+	if n == 0 {
+		n = 1
+	}
+	switch {
+	case !s.Flags.GFlag && n == 1:
+		g.printf("if m := %s.FindStringSubmatchIndex(ps); m != nil {\nvar b strings.Builder\nb.WriteString(ps[:m[0]])\n", re)
+		g.replacement(s.replacement, groups)
+		g.printf("b.WriteString(ps[m[1]:])\n")
+	case !s.Flags.GFlag:
+		g.printf("if all := %s.FindAllStringSubmatchIndex(ps, %d); len(all) == %d {\nm := all[%d]\n", re, n, n, n-1)
+		g.printf("var b strings.Builder\nb.WriteString(ps[:m[0]])\n")
+		g.replacement(s.replacement, groups)
+		g.printf("b.WriteString(ps[m[1]:])\n")
+	default:
+		all := "all"
+		if n > 1 {
+			all = fmt.Sprintf("all[%d:]", n-1)
+		}
+		g.printf("if all := %s.FindAllStringSubmatchIndex(ps, -1); len(all) >= %d {\nvar b strings.Builder\nat := 0\n", re, n)
+		g.printf("for _, m := range %s {\nb.WriteString(ps[at:m[0]])\n", all)
+		g.replacement(s.replacement, groups)
+		g.printf("at = m[1]\n}\nb.WriteString(ps[at:])\n")
+	}
+	g.printf("ps = b.String()\n")
+	if g.uses["sub"] {
+		g.printf("subMade = true\n")
+	}
+	if s.Flags.PFlag {
+		g.write("ps + delim")
+	}
+	g.printf("}\n")
+	if s.regexp == nil {
+		g.printf("}\n")
+	}
+	return nil
+}
+
+// replacement writes the statements adding the replacement for the match
+// m of a regexp with the given number of groups, or -1 if it is not known
+// until the program runs, to the strings.Builder b.
+func (g *generator) replacement(rep replacement, groups int) {
+	to := "b.WriteString"
+	for _, part := range rep {
+		if part.caseOp != 0 {
+			g.uses["case"] = true
+			g.imports["unicode"] = true
+			g.printf("cv := %sCase{b: &b}\n", g.prefix)
+			to = "cv.write"
+			break
+		}
+	}
+	for _, part := range rep {
+		switch {
+		case part.caseOp != 0:
+			g.printf("cv.set(%s)\n", strconv.QuoteRune(rune(part.caseOp)))
+		case part.group == 0:
+			g.printf("%s(ps[m[0]:m[1]])\n", to)
+		case part.group > 0 && groups < 0:
+			g.printf("if %d < len(m) && m[%d] >= 0 {\n%s(ps[m[%d]:m[%d]])\n}\n", 2*part.group+1, 2*part.group, to, 2*part.group, 2*part.group+1)
+		case part.group > 0 && part.group <= groups:
+			g.printf("if m[%d] >= 0 {\n%s(ps[m[%d]:m[%d]])\n}\n", 2*part.group, to, 2*part.group, 2*part.group+1)
+		case part.group < 0:
+			g.printf("%s(%s)\n", to, strconv.Quote(part.literal))
+		}
+	}
+}
+
+// transliterate writes a y command.
+func (g *generator) transliterate(s *yStmt) {
+	var from []rune
+	for r := range s.charMap {
+		if s.charMap[r] != r {
+			from = append(from, r)
+		}
+	}
+	if len(from) == 0 {
+		return
+	}
+	sort.Slice(from, func(i, j int) bool { return from[i] < from[j] })
+	g.imports["strings"] = true
+	g.printf("ps = strings.Map(func(c rune) rune {\nswitch c {\n")
+	for _, r := range from {
+		g.printf("case %s:\nreturn %s\n", strconv.QuoteRune(r), strconv.QuoteRune(s.charMap[r]))
+	}
+	g.printf("}\nreturn c\n}, ps)\n")
+}
+
+// file returns the unformatted source of the generated file.
+func (g *generator) file(pkg string) []byte {
+	if g.uses["list"] {
+		g.imports["strings"] = true
+		if g.options.Dialect == BSD {
+			g.imports["unicode"] = true
+			g.imports["unicode/utf8"] = true
+		}
+		g.imports["fmt"] = true
+	}
+	write := g.uses["write"]
+	if write {
+		g.imports["strings"] = true
+	}
+	var b bytes.Buffer
+	b.WriteString("// Code generated by gosed gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\nimport (\n", pkg)
+	var imports []string
+	for imp := range g.imports {
+		imports = append(imports, imp)
+	}
+	sort.Strings(imports)
+	for _, imp := range imports {
+		fmt.Fprintf(&b, "%q\n", imp)
+	}
+	b.WriteString(")\n\n")
+
+	if len(g.regexps) > 0 {
+		fmt.Fprintf(&b, "// %sRegexps are the regexps of the script in Go's syntax. Like POSIX\n", g.prefix)
+		b.WriteString("// regexps, they prefer the leftmost-longest match.\n")
+		fmt.Fprintf(&b, "var %sRegexps = [...]*regexp.Regexp{\n", g.prefix)
+		for _, expr := range g.regexps {
+			lit := strconv.Quote(expr)
+			if strconv.CanBackquote(expr) {
+				lit = "`" + expr + "`"
+			}
+			fmt.Fprintf(&b, "regexp.MustCompile(%s),\n", lit)
+		}
+		fmt.Fprintf(&b, "}\n\nfunc init() {\nfor _, re := range %sRegexps {\nre.Longest()\n}\n}\n\n", g.prefix)
+	}
+
+	fmt.Fprintf(&b, "// %s runs this sed script over the lines of r and writes its output to\n// w:\n//\n", g.name)
+	g.comment(&b, "", strings.TrimSuffix(indentLines(g.p.String()), "\t"))
+	fmt.Fprintf(&b, "func %s(r io.Reader, w io.Writer) error {\n", g.name)
+	delim := "\n"
+	if g.options.NullData {
+		delim = "\x00"
+	}
+	fmt.Fprintf(&b, "const delim = %s\nin := bufio.NewReader(r)\nout := bufio.NewWriter(w)\n", strconv.Quote(delim))
+	if g.uses["ps"] {
+		b.WriteString("var ps string // The pattern space.\n")
+		b.WriteString("var next string // The line after it.\n")
+	}
+	for _, v := range []struct{ use, decl string }{
+		{"hold", "var hs string // The hold space.\n"},
+		{"append", "var appended string // The text queued by a, written at the end of the cycle.\n"},
+		{"line", "var line int // The number of the line in the pattern space.\n"},
+		{"sub", "var subMade bool // Whether s has replaced anything since the last line or t.\n"},
+	} {
+		if g.uses[v.use] {
+			b.WriteString(v.decl)
+		}
+	}
+	if g.uses["file"] {
+		b.WriteString("file := \"-\" // The name F prints.\nif f, ok := r.(*os.File); ok && f != os.Stdin {\nfile = f.Name()\n}\n")
+	}
+	if g.trackLast {
+		b.WriteString("var last *regexp.Regexp // The last regexp used, which an empty one stands for.\n")
+	}
+	b.WriteString("more := false // Whether there is a next line.\n")
+
+	if write {
+		b.WriteString("terminated := false // Whether the input read so far ends with a delimiter.\n")
+	}
+	eof, line := "more = s != \"\"\n", "more = true\n"
+	if g.uses["ps"] {
+		eof, line = "next, more = s, s != \"\"\n", "next, more = s[:len(s)-1], true\n"
+	}
+	if write {
+		eof += "terminated = terminated && !more\n"
+		line += "terminated = true\n"
+	}
+	b.WriteString("\n// read reads the line after the one in the pattern space, so that the\n// last line is known.\n")
+	b.WriteString("read := func() error {\ns, err := in.ReadString(delim[0])\nif err == io.EOF {\n" + eof)
+	b.WriteString("return nil\n}\nif err != nil {\nreturn err\n}\n" + line + "return nil\n}\n")
+	if write {
+		b.WriteString(`
+// The output ends with a delimiter only if the input does, so write
+// holds back a final delimiter until more output follows it.
+pending, written := false, false
+write := func(s string) {
+if s == "" {
+return
+}
+if pending {
+out.WriteString(delim)
+written = true
+}
+pending = strings.HasSuffix(s, delim)
+s = strings.TrimSuffix(s, delim)
+if s != "" {
+out.WriteString(s)
+written = true
+}
+}
+finish := func() error {
+if written && terminated {
+out.WriteString(delim)
+}
+return out.Flush()
+}
+`)
+	} else {
+		b.WriteString("finish := out.Flush\n")
+	}
+	if g.uses["append"] {
+		b.WriteString("flush := func() {\nwrite(appended)\nappended = \"\"\n}\n")
+	}
+	if g.uses["match"] {
+		b.WriteString("match := func(re *regexp.Regexp) bool {\nlast = re\nreturn re.MatchString(ps)\n}\n")
+	}
+	b.Write(g.ranges.Bytes())
+
+	b.WriteString("\nif err := read(); err != nil {\nreturn err\n}\nfor more {\n")
+	if g.uses["ps"] {
+		b.WriteString("ps = next\n")
+	}
+	if g.uses["sub"] {
+		b.WriteString("subMade = false\n")
+	}
+	b.WriteString("if err := read(); err != nil {\nreturn err\n}\n")
+	if g.uses["line"] {
+		b.WriteString("line++\n")
+	}
+	b.Write(g.body.Bytes())
+	b.WriteString("}\nreturn finish()\n}\n")
+
+	if g.uses["case"] {
+		fmt.Fprintf(&b, caseHelper, g.prefix, g.prefix, g.prefix)
+	}
+	if g.uses["list"] {
+		if g.options.Dialect == BSD {
+			fmt.Fprintf(&b, listBSDHelper, g.prefix)
+		} else {
+			fmt.Fprintf(&b, listGNUHelper, g.prefix)
+		}
+	}
+	return b.Bytes()
+}
+
+// indentLines indents each line of s by a tab.
+func indentLines(s string) string {
+	return "\t" + strings.Replace(s, "\n", "\n\t", -1)
+}
+
+// caseHelper is the type that writes the replacements of s commands with
+// case conversions, as replacement.expand does.
+const caseHelper = `
+// %sCase writes a replacement with the case conversions \L, \U, \E, \l
+// and \u.
+type %sCase struct {
+	b          *strings.Builder
+	mode, once byte // The active \L or \U and a pending \l or \u.
+}
+
+func (c *%sCase) set(op byte) {
+	switch op {
+	case 'l', 'u':
+		c.once = op
+	case 'E':
+		c.mode, c.once = 0, 0
+	default:
+		c.mode, c.once = op, 0
+	}
+}
+
+func (c *` + "%[1]s" + `Case) write(s string) {
+	for _, r := range s {
+		switch {
+		case c.once == 'u':
+			r = unicode.ToUpper(r)
+		case c.once == 'l':
+			r = unicode.ToLower(r)
+		case c.mode == 'U':
+			r = unicode.ToUpper(r)
+		case c.mode == 'L':
+			r = unicode.ToLower(r)
+		}
+		c.once = 0
+		c.b.WriteRune(r)
+	}
+}
+`
+
+// listGNUHelper is listGNU for generated code.
+const listGNUHelper = `
+// %sList returns the pattern space as the l command prints it.
+func %[1]sList(ps string, width int) string {
+	var b strings.Builder
+	col := 0
+	for i := 0; i < len(ps); i++ {
+		c := ps[i]
+		var o string
+		switch {
+		case c == '\\':
+			o = ` + "`\\\\`" + `
+		case c >= ' ' && c < 0x7f:
+			o = string(c)
+		case strings.IndexByte("\a\b\f\n\r\t\v", c) != -1:
+			o = ` + "`\\`" + ` + string("abfnrtv"[strings.IndexByte("\a\b\f\n\r\t\v", c)])
+		default:
+			o = fmt.Sprintf("\\%%03o", c)
+		}
+		if width > 0 && col+len(o) > width-1 {
+			b.WriteString("\\\n")
+			col = 0
+		}
+		b.WriteString(o)
+		col += len(o)
+	}
+	b.WriteString("$")
+	return b.String()
+}
+`
+
+// listBSDHelper is listBSD for generated code.
+const listBSDHelper = `
+// %sList returns the pattern space as the l command prints it.
+func %[1]sList(ps string, width int) string {
+	var b strings.Builder
+	col := 0
+	wrap := func(w int) {
+		if width > 0 && col+w >= width {
+			b.WriteString("\\\n")
+			col = 0
+		}
+	}
+	for len(ps) > 0 {
+		r, n := utf8.DecodeRuneInString(ps)
+		switch {
+		case r == '\n':
+			wrap(1)
+			b.WriteString("$\n")
+			col = 0
+		case r != utf8.RuneError && unicode.IsPrint(r):
+			wrap(1)
+			b.WriteString(ps[:n])
+			col++
+		case strings.IndexByte("\a\b\f\r\t\v", ps[0]) != -1 && n == 1:
+			wrap(2)
+			b.WriteString(` + "`\\`" + ` + string("abfrtv"[strings.IndexByte("\a\b\f\r\t\v", ps[0])]))
+			col += 2
+		default:
+			wrap(4 * n)
+			for i := 0; i < n; i++ {
+				fmt.Fprintf(&b, "\\%%03o", ps[i])
+			}
+			col += 4 * n
+		}
+		ps = ps[n:]
+	}
+	wrap(1)
+	b.WriteString("$")
+	return b.String()
+}
+`
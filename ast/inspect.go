@@ -0,0 +1,311 @@
+package ast
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Command is a read-only description of one command of a compiled
+// program, for tools that inspect scripts. Only the fields that apply to
+// the command are set.
+type Command struct {
+	Name    string   // The command character, such as "s", "{" or "=", or ":" for a label.
+	Addr1   *Address // nil when the command has no address.
+	Addr2   *Address // nil unless the address is a range.
+	Negated bool     // The address is followed by !.
+	Line    int      // The script line the command starts on.
+	Column  int      // The byte column, starting at 1, the command starts at on Line.
+	Offset  int      // The byte offset in the script the command starts at.
+
+	Label       string     // The label of :, and the target of b, t and T; "" branches to the end.
+	Text        string     // The text of a, i and c.
+	HasText     bool       // a, i or c has text, which is false when the script ends right after its backslash.
+	FileName    string     // The file of r, R, w and W, and of the w flag of s.
+	Command     string     // The shell command of e; "" runs the pattern space.
+	ExitCode    int        // The exit code of q and Q.
+	Width       int        // The line length of l, or -1 for the default.
+	Regexp      *Regexp    // The regexp of s.
+	Replacement string     // The replacement of s, as written.
+	Flags       SubstFlags // The flags of s.
+	Find        string     // The characters y replaces.
+	Replace     string     // The characters y replaces them with.
+	Block       []*Command // The commands inside {.
+}
+
+// SubstFlags are the flags of an s command. The i, m and w flags are
+// described by Command.Regexp and Command.FileName.
+type SubstFlags struct {
+	Global     bool // g
+	Occurrence int  // The number flag, or 0.
+	Print      bool // p
+	Exec       bool // e (GNU)
+}
+
+// AddressKind is the form of an Address.
+type AddressKind int
+
+const (
+	LineAddress     AddressKind = iota + 1 // A line number.
+	LastLineAddress                        // $
+	RegexpAddress                          // /regexp/
+	StepAddress                            // first~step (GNU)
+	RelativeAddress                        // +N, which ends a range N lines after its start (GNU).
+	MultipleAddress                        // ~N, which ends a range at a multiple of N (GNU).
+)
+
+// Address is one address of a Command.
+type Address struct {
+	Kind   AddressKind
+	Line   int     // The line of a LineAddress, or the first line of a StepAddress.
+	Step   int     // The step of a StepAddress, or N of a RelativeAddress or MultipleAddress.
+	Regexp *Regexp // The regexp of a RegexpAddress.
+}
+
+// Regexp is a regular expression of an address or an s command.
+type Regexp struct {
+	Source     string // As written in the script; "" reuses the last regexp.
+	IgnoreCase bool   // The I flag (GNU).
+	Multiline  bool   // The M flag (GNU).
+}
+
+// Commands returns a description of the commands of the program, with
+// each label as a ":" command before the command it precedes.
+func (p *Program) Commands() []*Command {
+	return describeProgram(p, p)
+}
+
+// Walk calls fn for each command of cmds in order, visiting the commands
+// of a block after the block itself unless fn returns false for it.
+func Walk(cmds []*Command, fn func(*Command) bool) {
+	for _, cmd := range cmds {
+		if fn(cmd) {
+			Walk(cmd.Block, fn)
+		}
+	}
+}
+
+// describeProgram describes the commands of p, a block of top or top
+// itself, which knows where every label is defined.
+func describeProgram(p, top *Program) []*Command {
+	labelLines := top.labelLines
+	labels := labelsByPosition(p)
+	for _, ls := range labels {
+		sort.SliceStable(ls, func(i, j int) bool { return labelLines[ls[i]] < labelLines[ls[j]] })
+	}
+	var cmds []*Command
+	for i := 0; i <= len(p.Statements); i++ {
+		for _, l := range labels[i] {
+			cmds = append(cmds, &Command{
+				Name:   ":",
+				Label:  l,
+				Line:   labelLines[l],
+				Column: top.labelColumns[l],
+				Offset: top.labelOffsets[l],
+			})
+		}
+		if i == len(p.Statements) {
+			break
+		}
+		cmd := describeStatement(p.Statements[i], top)
+		if i < len(p.SourceLines) {
+			cmd.Line = p.SourceLines[i]
+		}
+		if i < len(p.SourceColumns) && i < len(p.SourceOffsets) {
+			cmd.Column, cmd.Offset = p.SourceColumns[i], p.SourceOffsets[i]
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+func describeStatement(stmt statement, top *Program) *Command {
+	// Every canonical form starts with the command character.
+	cmd := &Command{Name: formatCommand(stmt)[:1], Width: -1}
+	a := stmt.address()
+	if n, ok := a.(*notAddr); ok {
+		cmd.Negated = true
+		a = n.Addr
+	}
+	if r, ok := a.(*rangeAddress); ok {
+		cmd.Addr1, cmd.Addr2 = describeAddress(r.Addr1), describeAddress(r.Addr2)
+	} else {
+		cmd.Addr1 = describeAddress(a)
+	}
+
+	switch s := stmt.(type) {
+	case *aStmt:
+		cmd.Text, cmd.HasText = s.AppendLine, s.HasText
+	case *iStmt:
+		cmd.Text, cmd.HasText = s.InsertLine, s.HasText
+	case *cStmt:
+		cmd.Text, cmd.HasText = s.ChangeLine, s.HasText
+	case *bStmt:
+		cmd.Label = s.BranchIdent
+	case *tStmt:
+		cmd.Label = s.BranchIdent
+	case *t2Stmt:
+		cmd.Label = s.BranchIdent
+	case *rStmt:
+		cmd.FileName = s.FileName
+	case *r2Stmt:
+		cmd.FileName = s.FileName
+	case *wStmt:
+		cmd.FileName = s.FileName
+	case *w2Stmt:
+		cmd.FileName = s.FileName
+	case *eStmt:
+		cmd.Command = s.Command
+	case *qStmt:
+		cmd.ExitCode = s.ExitCode
+	case *q2Stmt:
+		cmd.ExitCode = s.ExitCode
+	case *lStmt:
+		cmd.Width = s.Width
+	case *sStmt:
+		cmd.Regexp = &Regexp{Source: s.FindAddr, IgnoreCase: s.Flags.IFlag, Multiline: s.Flags.MFlag}
+		cmd.Replacement = s.ReplaceAddr
+		cmd.FileName = s.Flags.WFile
+		cmd.Flags = SubstFlags{
+			Global:     s.Flags.GFlag,
+			Occurrence: s.Flags.NFlag,
+			Print:      s.Flags.PFlag,
+			Exec:       s.Flags.EFlag,
+		}
+	case *yStmt:
+		cmd.Find, cmd.Replace = s.Find, s.Replace
+	case *blockStmt:
+		cmd.Block = describeProgram(s.Code, top)
+	}
+	return cmd
+}
+
+func describeAddress(a addresser) *Address {
+	switch addr := a.(type) {
+	case *lineNoAddr:
+		return &Address{Kind: LineAddress, Line: addr.LineNo}
+	case *eofAddr:
+		return &Address{Kind: LastLineAddress}
+	case *regexpAddr:
+		re := &Regexp{Source: addr.Source, IgnoreCase: addr.Flags.icase, Multiline: addr.Flags.multiline}
+		return &Address{Kind: RegexpAddress, Regexp: re}
+	case *stepAddr:
+		return &Address{Kind: StepAddress, Line: addr.First, Step: addr.Step}
+	case *relLineAddr:
+		return &Address{Kind: RelativeAddress, Step: addr.N}
+	case *multipleAddr:
+		return &Address{Kind: MultipleAddress, Step: addr.N}
+	}
+	return nil
+}
+
+// String returns the canonical form of the command, as printed by
+// Program.String. A block is returned as its opening line only.
+func (c *Command) String() string {
+	cmd := c.formatCommand()
+	a := ""
+	if c.Addr1 != nil {
+		a = c.Addr1.String()
+	}
+	if c.Addr2 != nil {
+		a += "," + c.Addr2.String()
+	}
+	if c.Negated {
+		a += "!"
+	}
+	if a == "" {
+		return cmd
+	}
+	return a + " " + cmd
+}
+
+func (c *Command) formatCommand() string {
+	switch c.Name {
+	case ":":
+		return ":" + c.Label
+	case "a", "i", "c":
+		return formatTextCommand(c.Name, c.Text, c.HasText)
+	case "b", "t", "T":
+		return formatBranch(c.Name, c.Label)
+	case "e":
+		if c.Command == "" {
+			return "e"
+		}
+		return "e " + c.Command
+	case "q", "Q":
+		return withInt(c.Name, c.ExitCode)
+	case "l":
+		if c.Width < 0 {
+			return "l"
+		}
+		return "l " + strconv.Itoa(c.Width)
+	case "r", "R", "w", "W":
+		return c.Name + " " + c.FileName
+	case "s":
+		flags := sFlags{
+			NFlag: c.Flags.Occurrence,
+			GFlag: c.Flags.Global,
+			PFlag: c.Flags.Print,
+			EFlag: c.Flags.Exec,
+			WFile: c.FileName,
+		}
+		re := c.Regexp
+		if re == nil {
+			re = &Regexp{}
+		}
+		flags.IFlag, flags.MFlag = re.IgnoreCase, re.Multiline
+		return formatSubst(re.Source, c.Replacement) + formatSFlags(flags)
+	case "y":
+		return formatY(c.Find, c.Replace)
+	}
+	return c.Name
+}
+
+// String returns the address as it is written in a script.
+func (a *Address) String() string {
+	switch a.Kind {
+	case LineAddress:
+		return strconv.Itoa(a.Line)
+	case LastLineAddress:
+		return "$"
+	case RegexpAddress:
+		s := formatRegexp(a.Regexp.Source)
+		if a.Regexp.IgnoreCase {
+			s += "I"
+		}
+		if a.Regexp.Multiline {
+			s += "M"
+		}
+		return s
+	case StepAddress:
+		return strconv.Itoa(a.Line) + "~" + strconv.Itoa(a.Step)
+	case RelativeAddress:
+		return "+" + strconv.Itoa(a.Step)
+	case MultipleAddress:
+		return "~" + strconv.Itoa(a.Step)
+	}
+	return ""
+}
+
+// FormatCommands returns cmds as a script in canonical form, with one
+// command per line and the contents of blocks indented by two spaces.
+func FormatCommands(cmds []*Command) string {
+	var buff bytes.Buffer
+	writeCommands(&buff, cmds, 0)
+	return buff.String()
+}
+
+func writeCommands(buff *bytes.Buffer, cmds []*Command, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, cmd := range cmds {
+		buff.WriteString(indent + cmd.String())
+		if cmd.HasText || !strings.Contains("aic", cmd.Name) {
+			buff.WriteString("\n")
+		}
+		if cmd.Name == "{" {
+			writeCommands(buff, cmd.Block, depth+1)
+			buff.WriteString(indent + "}\n")
+		}
+	}
+}
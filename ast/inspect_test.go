@@ -0,0 +1,81 @@
+package ast
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	program := "1,/end/I!s/a\\(b\\)/\\1/2gw out\n:top\n$ {\n  y/ab/xy/\n  0~2 q 5\n}\nbtop\n/x/,+3 r in"
+	expected := []*Command{
+		{
+			Name:        "s",
+			Addr1:       &Address{Kind: LineAddress, Line: 1},
+			Addr2:       &Address{Kind: RegexpAddress, Regexp: &Regexp{Source: "end", IgnoreCase: true}},
+			Negated:     true,
+			Line:        1,
+			Column:      1,
+			Width:       -1,
+			Regexp:      &Regexp{Source: "a\\(b\\)"},
+			Replacement: "\\1",
+			Flags:       SubstFlags{Global: true, Occurrence: 2},
+			FileName:    "out",
+		},
+		{Name: ":", Label: "top", Line: 2, Column: 1, Offset: 29},
+		{
+			Name:   "{",
+			Addr1:  &Address{Kind: LastLineAddress},
+			Line:   3,
+			Column: 1,
+			Offset: 34,
+			Width:  -1,
+			Block: []*Command{
+				{Name: "y", Line: 4, Column: 3, Offset: 40, Width: -1, Find: "ab", Replace: "xy"},
+				{Name: "q", Addr1: &Address{Kind: StepAddress, Line: 0, Step: 2}, Line: 5, Column: 3, Offset: 51, Width: -1, ExitCode: 5},
+			},
+		},
+		{Name: "b", Label: "top", Line: 7, Column: 1, Offset: 61, Width: -1},
+		{
+			Name:     "r",
+			Addr1:    &Address{Kind: RegexpAddress, Regexp: &Regexp{Source: "x"}},
+			Addr2:    &Address{Kind: RelativeAddress, Step: 3},
+			Line:     8,
+			Column:   1,
+			Offset:   66,
+			Width:    -1,
+			FileName: "in",
+		},
+	}
+
+	p := New(program)
+	prg := p.ParseProgram()
+	if len(p.errors) > 0 {
+		t.Fatalf("Program %q encountered errors %v", program, p.errors)
+	}
+	cmds := prg.Commands()
+	if len(cmds) != len(expected) {
+		t.Fatalf("Expected %d commands, got %d", len(expected), len(cmds))
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(cmds[i], expected[i]) {
+			t.Errorf("Command [%d] incorrect.\n  Got: %+v\n  Expected: %+v", i, cmds[i], expected[i])
+		}
+	}
+
+	var names []string
+	Walk(cmds, func(cmd *Command) bool {
+		names = append(names, cmd.Name)
+		return cmd.Name != "{" || cmd.Line != 3
+	})
+	if !reflect.DeepEqual(names, []string{"s", ":", "{", "b", "r"}) {
+		t.Errorf("Walk skipping the block visited %v", names)
+	}
+	names = nil
+	Walk(cmds, func(cmd *Command) bool {
+		names = append(names, cmd.Name)
+		return true
+	})
+	if !reflect.DeepEqual(names, []string{"s", ":", "{", "y", "q", "b", "r"}) {
+		t.Errorf("Walk visited %v", names)
+	}
+}
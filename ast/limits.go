@@ -0,0 +1,126 @@
+package ast
+
+import "fmt"
+
+// Limits bound the work a single run of a program may do, so that a
+// script such as ":a;s/x/xx/;ta" cannot run forever or use unbounded
+// memory. A zero field means no limit.
+type Limits struct {
+	CommandsPerLine int // Commands run in one cycle, from reading a line to the next.
+	Commands        int // Commands run over the whole input.
+	SpaceBytes      int // Size of the pattern space and of the hold space.
+}
+
+// Limit names the limit that stopped a run.
+type Limit int
+
+const (
+	LimitCommandsPerLine Limit = iota + 1
+	LimitCommands
+	LimitPatternSpace
+	LimitHoldSpace
+	LimitContext // The run's context was canceled or timed out.
+	LimitRegexp  // A regexp with back-references took too long to match.
+)
+
+func (l Limit) String() string {
+	switch l {
+	case LimitCommandsPerLine:
+		return "commands per line"
+	case LimitCommands:
+		return "total commands"
+	case LimitPatternSpace:
+		return "pattern space size"
+	case LimitHoldSpace:
+		return "hold space size"
+	case LimitContext:
+		return "context"
+	case LimitRegexp:
+		return "regexp backtracking"
+	}
+	return fmt.Sprintf("Limit(%d)", int(l))
+}
+
+// LimitError is the error a run stops with when it hits one of its limits
+// or its context is done.
+type LimitError struct {
+	Limit      Limit
+	LineNo     int    // The input line being processed, starting at 1.
+	Command    string // The canonical form of the command that was running.
+	SourceLine int    // The script line of that command.
+	Err        error  // The context's error, for LimitContext.
+}
+
+func (e *LimitError) Error() string {
+	if e.Limit == LimitContext {
+		return fmt.Sprintf("input line %d: stopped at %q (script line %d): %v", e.LineNo, e.Command, e.SourceLine, e.Err)
+	}
+	return fmt.Sprintf("input line %d: %s limit exceeded at %q (script line %d)", e.LineNo, e.Limit, e.Command, e.SourceLine)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// countCommand counts the instruction in as run and returns an error if
+// that takes the machine past one of its command limits.
+func (m *Machine) countCommand(in instruction) error {
+	limits := m.options.Limits
+	m.commands++
+	m.cycleCommands++
+	switch {
+	case limits.Commands > 0 && m.commands > limits.Commands:
+		return m.limitError(LimitCommands, in, nil)
+	case limits.CommandsPerLine > 0 && m.cycleCommands > limits.CommandsPerLine:
+		return m.limitError(LimitCommandsPerLine, in, nil)
+	}
+	return nil
+}
+
+// checkSpace returns an error if the instruction in, which has just been
+// run, left the pattern or hold space larger than the limit.
+func (m *Machine) checkSpace(in instruction) error {
+	limit := m.options.Limits.SpaceBytes
+	switch {
+	case limit <= 0:
+		return nil
+	case len(m.r.patternSpace) > limit:
+		return m.limitError(LimitPatternSpace, in, nil)
+	case len(m.r.holdSpace) > limit:
+		return m.limitError(LimitHoldSpace, in, nil)
+	}
+	return nil
+}
+
+// checkContext returns an error if the run's context is done.
+func (m *Machine) checkContext(in instruction) error {
+	ctx := m.options.Context
+	if ctx == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return m.limitError(LimitContext, in, ctx.Err())
+	default:
+		return nil
+	}
+}
+
+// matchError returns the error for the reason a backtracker gave up
+// matching for the instruction in.
+func (m *Machine) matchError(in instruction) *LimitError {
+	if m.r.matchErr == errBacktrackLimit {
+		return m.limitError(LimitRegexp, in, nil)
+	}
+	return m.limitError(LimitContext, in, m.r.matchErr)
+}
+
+func (m *Machine) limitError(limit Limit, in instruction, err error) *LimitError {
+	return &LimitError{
+		Limit:      limit,
+		LineNo:     m.r.lineNo + 1,
+		Command:    formatStatement(in.stmt),
+		SourceLine: in.line,
+		Err:        err,
+	}
+}
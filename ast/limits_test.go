@@ -0,0 +1,115 @@
+package ast
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		program string
+		input   string
+		limits  Limits
+		output  string
+		err     LimitError
+	}{
+		{
+			program: ":a;ba",
+			input:   "x",
+			limits:  Limits{CommandsPerLine: 100},
+			err:     LimitError{Limit: LimitCommandsPerLine, LineNo: 1, Command: "b a", SourceLine: 1},
+		},
+		{
+			program: "p\np",
+			input:   "a\nb\nc",
+			limits:  Limits{CommandsPerLine: 2},
+			output:  "a\na\na\nb\nb\nb\nc\nc\nc",
+		},
+		{
+			program: "p\np",
+			input:   "a\nb\nc",
+			limits:  Limits{Commands: 5},
+			output:  "a\na\na\nb\nb\nb\nc",
+			err:     LimitError{Limit: LimitCommands, LineNo: 3, Command: "p", SourceLine: 2},
+		},
+		{
+			program: ":a\ns/x/xx/\nta",
+			input:   "x",
+			limits:  Limits{SpaceBytes: 64},
+			err:     LimitError{Limit: LimitPatternSpace, LineNo: 1, Command: "s/x/xx/", SourceLine: 2},
+		},
+		{
+			program: "H;x;s/\\n/-/;x",
+			input:   "aaa\nbbb\nccc",
+			limits:  Limits{SpaceBytes: 8},
+			output:  "aaa\nbbb",
+			err:     LimitError{Limit: LimitHoldSpace, LineNo: 3, Command: "H", SourceLine: 1},
+		},
+		{
+			program: "p\n/\\(a*\\)*b\\1/d",
+			input:   "x\n" + strings.Repeat("a", 30),
+			output:  "x\nx\n" + strings.Repeat("a", 30),
+			err:     LimitError{Limit: LimitRegexp, LineNo: 2, Command: "/\\(a*\\)*b\\1/ d", SourceLine: 2},
+		},
+	}
+
+	for i, tt := range tests {
+		p := New(tt.program)
+		program := p.ParseProgram()
+		if len(p.errors) > 0 {
+			t.Fatalf("Program [%d] %q encountered errors %v", i, tt.program, p.errors)
+		}
+		out, err := program.Run(tt.input, RuntimeOptions{AutoPrint: true, Limits: tt.limits})
+		if out != tt.output {
+			t.Errorf("Program [%d] %q produced %q, expected %q", i, tt.program, out, tt.output)
+		}
+		if tt.err.Limit == 0 {
+			if err != nil {
+				t.Errorf("Program [%d] %q expected no error, got %v", i, tt.program, err)
+			}
+			continue
+		}
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || *limitErr != tt.err {
+			t.Errorf("Program [%d] %q expected error %+v, got %+v", i, tt.program, tt.err, err)
+		}
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	p := New(":a;ba")
+	program := p.ParseProgram()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := program.Run("x", RuntimeOptions{Context: ctx})
+	var limitErr *LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitContext {
+		t.Fatalf("expected a context LimitError, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the error to wrap context.Canceled, got %v", err)
+	}
+}
+
+func TestContextDeadlineInRegexp(t *testing.T) {
+	// Backtracking over 30 a's takes far longer than the deadline, and no
+	// more than one command runs.
+	p := New("s/\\(a*\\)*b\\1/x/")
+	program := p.ParseProgram()
+	for _, tracer := range []Tracer{nil, &nopTracer{}} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		start := time.Now()
+		_, err := program.Run(strings.Repeat("a", 30), RuntimeOptions{Context: ctx, Tracer: tracer})
+		cancel()
+		var limitErr *LimitError
+		if !errors.As(err, &limitErr) || limitErr.Limit != LimitContext || !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Tracer %T: expected a context LimitError wrapping context.DeadlineExceeded, got %v", tracer, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Tracer %T: the run took %v to stop", tracer, elapsed)
+		}
+	}
+}
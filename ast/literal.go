@@ -0,0 +1,200 @@
+package ast
+
+import (
+	"bytes"
+	"regexp"
+	"regexp/syntax"
+)
+
+// maxLiterals is the most strings a literalPattern searches for. Each is
+// searched for in turn, which for a few beats running a regexp.
+const maxLiterals = 16
+
+// literalPattern matches a regexp that can only match a few fixed
+// strings, such as /ERROR/, /^#/ or /WARN\|ERROR/, by searching for the
+// strings with bytes.Index instead of running the regexp. Like the Go
+// regexps compileGo otherwise returns, it prefers the leftmost-longest
+// match.
+type literalPattern struct {
+	expr  string   // The Go regexp the pattern stands for.
+	lits  [][]byte // The strings it matches, none of them empty.
+	start bool     // Whether a match must start at the start of the text.
+	end   bool     // Whether a match must end at the end of the text.
+}
+
+// compileGo compiles the Go regexp expr, preferring the leftmost-longest
+// match, to a literalPattern if it only matches a few fixed strings.
+func compileGo(expr string) (pattern, error) {
+	if lit := newLiteralPattern(expr); lit != nil {
+		return lit, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	re.Longest()
+	return re, nil
+}
+
+// newLiteralPattern returns the literalPattern for expr, or nil if expr
+// could match other strings, an empty string, or has groups or flags
+// that a search for its strings wouldn't respect.
+func newLiteralPattern(expr string) *literalPattern {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	re = re.Simplify()
+	l := &literalPattern{expr: expr}
+	if re.Op == syntax.OpConcat && len(re.Sub) > 1 {
+		subs := re.Sub
+		if subs[0].Op == syntax.OpBeginText {
+			l.start, subs = true, subs[1:]
+		}
+		if n := len(subs); n > 0 && subs[n-1].Op == syntax.OpEndText {
+			l.end, subs = true, subs[:n-1]
+		}
+		re = &syntax.Regexp{Op: syntax.OpConcat, Sub: subs}
+	}
+	lits, ok := literalStrings(re)
+	if !ok {
+		return nil
+	}
+	for _, lit := range lits {
+		if lit == "" {
+			return nil
+		}
+		l.lits = append(l.lits, []byte(lit))
+	}
+	return l
+}
+
+// literalStrings returns the strings re matches, if there are no more
+// than maxLiterals of them.
+func literalStrings(re *syntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch:
+		return []string{""}, true
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(re.Rune)}, true
+	case syntax.OpCharClass:
+		var lits []string
+		for i := 0; i < len(re.Rune); i += 2 {
+			if len(lits)+int(re.Rune[i+1]-re.Rune[i]) >= maxLiterals {
+				return nil, false
+			}
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				lits = append(lits, string(r))
+			}
+		}
+		return lits, true
+	case syntax.OpQuest:
+		lits, ok := literalStrings(re.Sub[0])
+		return append(lits, ""), ok && len(lits) < maxLiterals
+	case syntax.OpAlternate:
+		var lits []string
+		for _, sub := range re.Sub {
+			more, ok := literalStrings(sub)
+			if !ok || len(lits)+len(more) > maxLiterals {
+				return nil, false
+			}
+			lits = append(lits, more...)
+		}
+		return lits, true
+	case syntax.OpConcat:
+		lits := []string{""}
+		for _, sub := range re.Sub {
+			more, ok := literalStrings(sub)
+			if !ok || len(lits)*len(more) > maxLiterals {
+				return nil, false
+			}
+			var joined []string
+			for _, a := range lits {
+				for _, b := range more {
+					joined = append(joined, a+b)
+				}
+			}
+			lits = joined
+		}
+		return lits, true
+	}
+	return nil, false
+}
+
+func (l *literalPattern) String() string { return l.expr }
+
+func (l *literalPattern) NumSubexp() int { return 0 }
+
+func (l *literalPattern) MatchString(s string) bool {
+	return l.Match([]byte(s))
+}
+
+func (l *literalPattern) Match(b []byte) bool {
+	start, _ := l.index(b)
+	return start >= 0
+}
+
+func (l *literalPattern) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	return l.FindAllSubmatchIndex([]byte(s), n)
+}
+
+// FindAllSubmatchIndex returns the start and end of up to n successive
+// matches in b, or all of them if n is negative, as a Go regexp does.
+func (l *literalPattern) FindAllSubmatchIndex(b []byte, n int) [][]int {
+	var matches [][]int
+	for pos := 0; n < 0 || len(matches) < n; {
+		start, end := l.index(b[pos:])
+		if start < 0 {
+			break
+		}
+		matches = append(matches, []int{pos + start, pos + end})
+		if l.start || l.end {
+			// An anchored pattern matches once at most.
+			break
+		}
+		pos += end
+	}
+	return matches
+}
+
+// index returns the start and end of the leftmost-longest match in b, or
+// -1 and -1 if there is none.
+func (l *literalPattern) index(b []byte) (int, int) {
+	start, end := -1, -1
+	for _, lit := range l.lits {
+		i := -1
+		switch {
+		case l.start && l.end:
+			if bytes.Equal(b, lit) {
+				i = 0
+			}
+		case l.start:
+			if bytes.HasPrefix(b, lit) {
+				i = 0
+			}
+		case l.end:
+			if bytes.HasSuffix(b, lit) {
+				i = len(b) - len(lit)
+			}
+		default:
+			// Only a match starting no later than the best so far can
+			// beat it, so there is no need to search past it.
+			limit := b
+			if start >= 0 && start+len(lit) < len(b) {
+				limit = b[:start+len(lit)]
+			}
+			if len(lit) == 1 {
+				i = bytes.IndexByte(limit, lit[0])
+			} else {
+				i = bytes.Index(limit, lit)
+			}
+		}
+		if i >= 0 && (start < 0 || i < start || i == start && i+len(lit) > end) {
+			start, end = i, i+len(lit)
+		}
+	}
+	return start, end
+}
@@ -0,0 +1,119 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLiteralPattern(t *testing.T) {
+	tests := []struct {
+		src      string
+		extended bool
+		flags    regexFlags
+		literal  bool
+	}{
+		{src: "ERROR", literal: true},
+		{src: "^#", literal: true},
+		{src: "end$", literal: true},
+		{src: "^whole line$", literal: true},
+		{src: "WARN\\|ERROR", literal: true},
+		{src: "WARN|ERROR|ERRNO", extended: true, literal: true},
+		{src: "ca[rt]s\\?", literal: true},
+		{src: "colou?r", extended: true, literal: true},
+		{src: "a\\{3\\}", literal: true},
+		{src: "a.c"},
+		{src: "[a-z]"},
+		{src: "ab*"},
+		{src: "\\(ab\\)"},
+		{src: "\\(ab\\)\\1"},
+		{src: "x\\?"},
+		{src: "^"},
+		{src: "^$"},
+		{src: "a\\|^b"},
+		{src: "ERROR", flags: regexFlags{icase: true}},
+		{src: "^#", flags: regexFlags{multiline: true}},
+	}
+	for i, tt := range tests {
+		re, err := compileRegexp(tt.src, tt.extended, tt.flags, GNU)
+		if err != nil {
+			t.Fatalf("Regexp [%d] %q did not compile: %v", i, tt.src, err)
+		}
+		if _, ok := re.(*literalPattern); ok != tt.literal {
+			t.Errorf("Regexp [%d] %q compiled to a %T", i, tt.src, re)
+		}
+	}
+
+	// Literal patterns should find what the regexps they stand for do.
+	exprs := []string{
+		"(?s)ERROR", "(?s)a", "(?s)aa", "(?s)^ab", "(?s)b$", "(?s)^ab$",
+		"(?s)ERR|ERROR|WARN", "(?s)ab|b|abc", "(?s)^(?:a|ab)", "(?s)(?:b|ab)$",
+		"(?s)é|€", "(?s)colou?r",
+	}
+	inputs := []string{
+		"", "a", "aa", "aaa", "ab", "abc", "xabcab", "bab", "ERRORS and ERR WARNING",
+		"café €5 \xff\xe2\x82\xac", "color colour colouur",
+	}
+	for _, expr := range exprs {
+		lit := newLiteralPattern(expr)
+		if lit == nil {
+			t.Errorf("Regexp %q is not literal", expr)
+			continue
+		}
+		re := regexp.MustCompile(expr)
+		re.Longest()
+		for _, in := range inputs {
+			if got, expected := lit.Match([]byte(in)), re.MatchString(in); got != expected {
+				t.Errorf("Regexp %q matching %q incorrect.\n  Got: %v\n  Expected: %v", expr, in, got, expected)
+			}
+			for _, n := range []int{-1, 1, 2} {
+				got := lit.FindAllSubmatchIndex([]byte(in), n)
+				expected := re.FindAllStringSubmatchIndex(in, n)
+				if !reflect.DeepEqual(got, expected) {
+					t.Errorf("Regexp %q finding %d in %q incorrect.\n  Got: %v\n  Expected: %v", expr, n, in, got, expected)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkLiteralPattern matches each line of a generated log file of
+// about 1MB with the address regexps that literalPattern runs, and
+// compares it with running the Go regexp.
+func BenchmarkLiteralPattern(b *testing.B) {
+	var log bytes.Buffer
+	levels := []string{"INFO", "INFO", "INFO", "DEBUG", "WARN", "INFO", "ERROR"}
+	for i := 0; log.Len() < 1<<20; i++ {
+		if i%50 == 0 {
+			log.WriteString("# rotated\n")
+		}
+		fmt.Fprintf(&log, "2024-03-%02d 12:%02d:%02d [%s] request %d served in %dms by worker-%d\n",
+			i%28+1, i/60%60, i%60, levels[i%len(levels)], i, i*7%300, i%8)
+	}
+	lines := bytes.Split(log.Bytes(), []byte("\n"))
+	for _, src := range []string{"ERROR", "^#", "worker-7$", "WARN\\|ERROR"} {
+		lit, err := compileRegexp(src, false, regexFlags{}, GNU)
+		if err != nil {
+			b.Fatal(err)
+		}
+		re := regexp.MustCompile(lit.String())
+		re.Longest()
+		for _, p := range []pattern{lit, re} {
+			name := strings.Replace(src, "\\|", "|", -1) + "/literal"
+			if p == re {
+				name = strings.Replace(src, "\\|", "|", -1) + "/regexp"
+			}
+			b.Run(name, func(b *testing.B) {
+				b.SetBytes(int64(log.Len()))
+				for i := 0; i < b.N; i++ {
+					for _, line := range lines {
+						p.Match(line)
+					}
+				}
+			})
+		}
+	}
+}
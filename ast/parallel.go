@@ -0,0 +1,148 @@
+package ast
+
+import (
+	"bytes"
+	"sync"
+)
+
+// minChunk is the least input worth running apart from the rest; below
+// it, starting a goroutine costs more than it saves.
+const minChunk = 64 << 10
+
+// LineIndependent reports whether the output the program prints for each
+// line of input depends only on that line and its number, so that the
+// input can be split between any two lines and the parts run separately.
+// Static analysis rules out the hold space, the n and N commands that
+// read more input, ranges and the $ address, which depend on other lines,
+// the empty regexp, which matches with the regexp that ran last, and what
+// FileIndependent rules out.
+func (p *Program) LineIndependent() bool {
+	if !p.FileIndependent() {
+		return false
+	}
+	for _, in := range p.code {
+		if in.close {
+			continue
+		}
+		if !independentAddress(in.stmt.address()) {
+			return false
+		}
+		switch s := in.stmt.(type) {
+		case *gStmt, *g2Stmt, *hStmt, *h2Stmt, *xStmt, *nStmt, *n2Stmt:
+			return false
+		case *sStmt:
+			if s.regexp == nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// FileIndependent reports whether running the program over one file can
+// not change what it does with another, so that separate files can be
+// run at once. Static analysis rules out q and Q, which stop the files
+// after them, and the commands that read or write files or run commands.
+func (p *Program) FileIndependent() bool {
+	if p.code == nil {
+		// The program was not made by the parser.
+		p.compile()
+	}
+	for _, in := range p.code {
+		if in.close {
+			continue
+		}
+		switch s := in.stmt.(type) {
+		case *qStmt, *q2Stmt, *eStmt, *rStmt, *r2Stmt, *wStmt, *w2Stmt:
+			return false
+		case *sStmt:
+			if s.Flags.WFile != "" || s.Flags.EFlag {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// independentAddress reports whether a matches a line by that line and
+// its number alone.
+func independentAddress(a addresser) bool {
+	switch a := a.(type) {
+	case *blankAddress, *lineNoAddr, *stepAddr:
+		return true
+	case *regexpAddr:
+		return a.Regexp != nil
+	case *notAddr:
+		return independentAddress(a.Addr)
+	}
+	return false
+}
+
+// chunk is a run of whole lines of the input, run apart from the rest.
+type chunk struct {
+	data   []byte
+	lineNo int // The LineNoStart of the chunk's first line.
+	out    []byte
+	err    error
+}
+
+// runChunks runs a line independent program as runVM does, splitting data
+// between lines into chunks run on up to options.Parallelism goroutines.
+// Since the output of each line depends on that line alone, the output is
+// the chunks' output in order; if a chunk stops at a limit, the output of
+// the chunks after it is dropped.
+func (p *Program) runChunks(data []byte, options RuntimeOptions) ([]byte, error) {
+	delim := options.lineDelim()
+	// Several chunks for each goroutine evens out their work when some
+	// lines take longer to run than others.
+	size := len(data) / (4 * options.Parallelism)
+	if size < minChunk {
+		size = minChunk
+	}
+	var chunks []*chunk
+	lineNo := options.LineNoStart
+	for {
+		c := &chunk{data: data, lineNo: lineNo}
+		chunks = append(chunks, c)
+		i := -1
+		if len(data) > size {
+			i = bytes.IndexByte(data[size:], delim)
+		}
+		if i < 0 {
+			break
+		}
+		// The chunk ends before the delimiter, as if it ended the input.
+		c.data, data = data[:size+i], data[size+i+1:]
+		lineNo += bytes.Count(c.data, []byte{delim}) + 1
+	}
+
+	next := make(chan *chunk)
+	var wg sync.WaitGroup
+	for i := 0; i < options.Parallelism && i < len(chunks); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range next {
+				opts := options
+				opts.LineNoStart = c.lineNo
+				v := p.newVM(c.data, opts)
+				c.err = v.run()
+				c.out = v.out.Bytes()
+			}
+		}()
+	}
+	for _, c := range chunks {
+		next <- c
+	}
+	close(next)
+	wg.Wait()
+
+	var out []byte
+	for _, c := range chunks {
+		out = append(out, c.out...)
+		if c.err != nil {
+			return out, c.err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,65 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLineIndependent(t *testing.T) {
+	tests := []struct {
+		script string
+		line   bool // Whether it is LineIndependent.
+		file   bool // Whether it is FileIndependent.
+	}{
+		{"p", true, true},
+		{"s/a/b/g;y/xyz/XYZ/;/^#/d;3!s/x/y/", true, true},
+		{"/a/{s/b/c/;tx;=;l\n:x\ni\\\nbefore\n};1~3a\\\nafter", true, true},
+		{"5c\\\nchanged\nF;z;P;D", true, true},
+		{"s/a/\\n/;D", true, true},
+		{"$d", false, true},
+		{"1,3d", false, true},
+		{"/a/,/b/!p", false, true},
+		{"h", false, true},
+		{"/a/{/b/x\n}", false, true},
+		{"G", false, true},
+		{"N;P;D", false, true},
+		{"n;d", false, true},
+		{"5q", false, false},
+		{"/a/Q", false, false},
+		{"/a/s//b/", false, true},
+		{"s/a/b/;//d", false, true},
+		{"s/a/b/w out.txt", false, false},
+		{"r in.txt", false, false},
+		{"w out.txt", false, false},
+		{"e date", false, false},
+		{"s/a/date/e", false, false},
+		{"1W out.txt", false, false},
+		{"R in.txt", false, false},
+	}
+	for i, tt := range tests {
+		p := New(tt.script)
+		prg := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("Script [%d] errors: %v", i, p.Errors())
+		}
+		if got := prg.LineIndependent(); got != tt.line {
+			t.Errorf("LineIndependent [%d] %q incorrect.\n  Got: %v\n  Expected: %v", i, tt.script, got, tt.line)
+		}
+		if got := prg.FileIndependent(); got != tt.file {
+			t.Errorf("FileIndependent [%d] %q incorrect.\n  Got: %v\n  Expected: %v", i, tt.script, got, tt.file)
+		}
+	}
+}
+
+func TestRunChunks(t *testing.T) {
+	prg := New("/7$/!s/[0-9]/<&>/2;=").ParseProgram()
+	line := strings.Repeat("x", 99) + "\n"
+	input := []byte(strings.Repeat("12345678\n"+line, 4*minChunk/len(line)) + "17")
+	expected, _ := prg.RunBytes(input, RuntimeOptions{AutoPrint: true})
+	for _, n := range []int{2, 3, 16} {
+		got, err := prg.RunBytes(input, RuntimeOptions{AutoPrint: true, Parallelism: n})
+		if err != nil || string(got) != string(expected) {
+			t.Errorf("RunBytes with Parallelism %d incorrect.\n  Got: %d bytes, %v\n  Expected: %d bytes", n, len(got), err, len(expected))
+		}
+	}
+}
@@ -3,8 +3,8 @@ package ast
 import (
 	"bytes"
 	"fmt"
-	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/zkry/go-sed/lexer"
 )
@@ -13,19 +13,32 @@ type Parser struct {
 	l *lexer.Lexer
 	i chan lexer.Item
 
+	prevToken lexer.Item
 	curToken  lexer.Item
 	peekToken lexer.Item
 
 	lineCt int
 	errors []string
 	tokens []lexer.Item
+	opts   ParseOptions
+	labels map[string]int // The line each label is defined on.
+
+	labelColumns map[string]int // The column of the : of each label.
+	labelOffsets map[string]int // The byte offset of the : of each label.
 }
 
+// New returns a parser for a GNU sed script with basic regexps.
 func New(input string) *Parser {
+	return NewWithOptions(input, ParseOptions{})
+}
+
+// NewWithOptions returns a parser for input that follows opts.
+func NewWithOptions(input string, opts ParseOptions) *Parser {
 	p := &Parser{
 		errors: []string{},
+		opts:   opts,
 	}
-	p.l, p.i = lexer.New(input)
+	p.l, p.i = lexer.NewWithOptions(input, lexer.Options{LabelsToEOL: opts.Dialect == BSD})
 
 	p.nextToken()
 	p.nextToken()
@@ -33,12 +46,17 @@ func New(input string) *Parser {
 }
 
 func (p *Parser) nextToken() {
+	p.prevToken = p.curToken
 	p.curToken = p.peekToken
 	p.peekToken = <-p.i // TODO: Make the next token always be EOF
 
 	// Specail next token logic here.
-	if p.curTokenIs(lexer.ItemNewline) {
+	switch p.curToken.Type {
+	case lexer.ItemNewline:
 		p.lineCt++
+	case lexer.ItemLit:
+		// Text and replacements may continue over several lines.
+		p.lineCt += strings.Count(p.curToken.Value, "\n")
 	}
 
 	p.tokens = append(p.tokens, p.peekToken)
@@ -53,17 +71,35 @@ func (p *Parser) ParseProgram() *Program {
 	program.Statements = []statement{}
 
 	for p.curToken.Type != lexer.ItemEOF {
+		if p.curTokenIs(lexer.ItemRBrace) {
+			p.errorf("unexpected `}'")
+			p.nextToken()
+			continue
+		}
+		if p.parseLayout(program) {
+			p.nextToken()
+			continue
+		}
+		line, start := p.lineNumber(), p.curToken
 		stmt, label := p.parseStatement()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
+			program.SourceLines = append(program.SourceLines, line)
+			program.SourceColumns = append(program.SourceColumns, start.Col)
+			program.SourceOffsets = append(program.SourceOffsets, start.Start)
 		}
 		if label != "" {
 			program.Labels[label] = len(program.Statements)
 		}
-		p.nextToken()
+		p.nextStatement()
 	}
 	program.Tokens = make([]lexer.Item, len(p.tokens))
 	copy(program.Tokens, p.tokens)
+	program.labelLines = p.labels
+	program.labelColumns = p.labelColumns
+	program.labelOffsets = p.labelOffsets
+	program.dialect = p.opts.Dialect
+	p.errors = append(p.errors, program.compile()...)
 	return program
 }
 
@@ -82,6 +118,26 @@ func (p *Parser) Errors() ErrorList {
 	return p.errors
 }
 
+// parseLayout records a comment or an empty line at the current token in
+// program, for Format, and reports whether there was one.
+func (p *Parser) parseLayout(program *Program) bool {
+	switch {
+	case p.curTokenIs(lexer.ItemComment):
+		prev := p.prevToken.Type
+		program.Comments = append(program.Comments, Comment{
+			Pos:      len(program.Statements),
+			Line:     p.lineNumber(),
+			Text:     strings.TrimPrefix(p.curToken.Value, "#"),
+			Trailing: prev != lexer.ItemNewline && prev != lexer.ItemEOF,
+		})
+	case p.curTokenIs(lexer.ItemNewline) && p.prevToken.Type == lexer.ItemNewline:
+		program.blankLines = append(program.blankLines, p.lineCt)
+	default:
+		return false
+	}
+	return true
+}
+
 func (p *Parser) parseStatement() (statement, string) {
 	var stmt statement
 
@@ -90,15 +146,27 @@ func (p *Parser) parseStatement() (statement, string) {
 	}
 
 	if p.curTokenIs(lexer.ItemColon) {
+		colon := p.curToken
 		if !p.expectPeek(lexer.ItemIdent) {
 			return nil, ""
 		}
 		lit := p.curToken.Value
-		// Check if valid literal
 		if lit == "" {
-			p.customError("invalid label name")
+			p.errorf("\":\" lacks a label")
 			return nil, ""
 		}
+		if line, ok := p.labels[lit]; ok {
+			p.errorf("duplicate label `%s', first defined on line %d", lit, line)
+			return nil, ""
+		}
+		if p.labels == nil {
+			p.labels = make(map[string]int)
+			p.labelColumns = make(map[string]int)
+			p.labelOffsets = make(map[string]int)
+		}
+		p.labels[lit] = p.lineNumber()
+		p.labelColumns[lit] = colon.Col
+		p.labelOffsets[lit] = colon.Start
 		return nil, lit
 	}
 
@@ -112,14 +180,26 @@ func (p *Parser) parseStatement() (statement, string) {
 		block.Statements = []statement{}
 		block.Labels = map[string]int{}
 		for p.curToken.Type != lexer.ItemEOF && p.curToken.Type != lexer.ItemRBrace {
+			if p.parseLayout(block) {
+				p.nextToken()
+				continue
+			}
+			line, start := p.lineNumber(), p.curToken
 			stmt, l := p.parseStatement()
 			if stmt != nil {
 				block.Statements = append(block.Statements, stmt)
+				block.SourceLines = append(block.SourceLines, line)
+				block.SourceColumns = append(block.SourceColumns, start.Col)
+				block.SourceOffsets = append(block.SourceOffsets, start.Start)
 			}
 			if l != "" {
 				block.Labels[l] = len(block.Statements)
 			}
-			p.nextToken()
+			p.nextStatement()
+		}
+		if p.curTokenIs(lexer.ItemEOF) {
+			p.errorf("unmatched `{'")
+			return nil, ""
 		}
 		stmt = &blockStmt{
 			Code:      block,
@@ -128,28 +208,23 @@ func (p *Parser) parseStatement() (statement, string) {
 	case lexer.ItemCmd:
 		switch p.curToken.Value {
 		case "a":
-			p.expectPeek(lexer.ItemBackslash)
-			p.expectPeek(lexer.ItemLit)
+			text, hasText := p.parseText("a")
 			stmt = &aStmt{
 				addresser:  addr,
-				AppendLine: p.curToken.Value,
+				AppendLine: text,
+				HasText:    hasText,
 			}
 		case "b":
-			branchIdent := "$" // TODO: Find better way to signify end.
-			if p.peekTokenIs(lexer.ItemIdent) {
-				p.nextToken()
-				branchIdent = p.curToken.Value
-			}
 			stmt = &bStmt{
 				addresser:   addr,
-				BranchIdent: branchIdent,
+				BranchIdent: p.parseBranchLabel(),
 			}
 		case "c":
-			p.expectPeek(lexer.ItemBackslash)
-			p.expectPeek(lexer.ItemLit)
+			text, hasText := p.parseText("c")
 			stmt = &cStmt{
 				addresser:  addr,
-				ChangeLine: p.curToken.Value,
+				ChangeLine: text,
+				HasText:    hasText,
 			}
 		case "d":
 			stmt = &dStmt{
@@ -160,16 +235,21 @@ func (p *Parser) parseStatement() (statement, string) {
 				addresser: addr,
 			}
 		case "e":
-			// cmd := ""
-			// if p.peekTokenIs(token.LIT) {
-			// 	p.expectPeek(token.LIT)
-			// 	cmd = p.curToken.Value
-			// }
-			// stmt = &EStmt{
-			// 	Addresser: addr,
-			// 	Command:   cmd,
-			// }
-		case "F": // Is this even a command
+			p.gnuExtension("the e command")
+			cmd := ""
+			if p.peekTokenIs(lexer.ItemIdent) {
+				p.nextToken()
+				cmd = p.curToken.Value
+			}
+			stmt = &eStmt{
+				addresser: addr,
+				Command:   cmd,
+			}
+		case "F":
+			p.gnuExtension("the F command")
+			stmt = &fStmt{
+				addresser: addr,
+			}
 		case "g":
 			stmt = &gStmt{
 				addresser: addr,
@@ -187,15 +267,16 @@ func (p *Parser) parseStatement() (statement, string) {
 				addresser: addr,
 			}
 		case "i":
-			p.expectPeek(lexer.ItemBackslash)
-			p.expectPeek(lexer.ItemLit)
+			text, hasText := p.parseText("i")
 			stmt = &iStmt{
 				addresser:  addr,
-				InsertLine: p.curToken.Value,
+				InsertLine: text,
+				HasText:    hasText,
 			}
 		case "l":
 			stmt = &lStmt{
 				addresser: addr,
+				Width:     p.parseCommandInt("a line length for l", -1),
 			}
 		case "n":
 			stmt = &nStmt{
@@ -216,6 +297,13 @@ func (p *Parser) parseStatement() (statement, string) {
 		case "q":
 			stmt = &qStmt{
 				addresser: addr,
+				ExitCode:  p.parseCommandInt("an exit code for q", 0),
+			}
+		case "Q":
+			p.gnuExtension("the Q command")
+			stmt = &q2Stmt{
+				addresser: addr,
+				ExitCode:  p.parseCommandInt("an exit code for Q", 0),
 			}
 		case "r":
 			p.expectPeek(lexer.ItemIdent)
@@ -224,6 +312,7 @@ func (p *Parser) parseStatement() (statement, string) {
 				FileName:  p.curToken.Value,
 			}
 		case "R":
+			p.gnuExtension("the R command")
 			p.expectPeek(lexer.ItemIdent)
 			stmt = &r2Stmt{
 				addresser: addr,
@@ -248,25 +337,32 @@ func (p *Parser) parseStatement() (statement, string) {
 				p.expectPeek(lexer.ItemIdent)
 				fl = *p.parseFlags()
 			}
-			stmt = &sStmt{
+			s := &sStmt{
 				addresser:   addr,
 				FindAddr:    fa,
 				ReplaceAddr: ra,
 				Flags:       fl,
 			}
+			p.compileSubst(s)
+			stmt = s
 		case "t":
-			p.expectPeek(lexer.ItemIdent)
 			stmt = &tStmt{
 				addresser:   addr,
-				BranchIdent: p.curToken.Value,
+				BranchIdent: p.parseBranchLabel(),
 			}
 		case "T":
-			p.expectPeek(lexer.ItemIdent)
+			p.gnuExtension("the T command")
 			stmt = &t2Stmt{
-				addresser: addr,
-				FileName:  p.curToken.Value,
+				addresser:   addr,
+				BranchIdent: p.parseBranchLabel(),
 			}
 		case "v":
+			// v only checks that GNU extensions are available, which
+			// they are when the script is parsed as GNU sed.
+			p.gnuExtension("the v command")
+			if p.peekTokenIs(lexer.ItemIdent) {
+				p.nextToken()
+			}
 		case "w":
 			p.expectPeek(lexer.ItemIdent)
 			stmt = &wStmt{
@@ -274,6 +370,7 @@ func (p *Parser) parseStatement() (statement, string) {
 				FileName:  p.curToken.Value,
 			}
 		case "W":
+			p.gnuExtension("the W command")
 			p.expectPeek(lexer.ItemIdent)
 			stmt = &w2Stmt{
 				addresser: addr,
@@ -293,11 +390,13 @@ func (p *Parser) parseStatement() (statement, string) {
 			p.expectPeek(lexer.ItemDiv)
 
 			var err error
-			stmt, err = newYStmt(fa, ra, addr)
+			stmt, err = newYStmt(p.unescapeY(fa), p.unescapeY(ra), addr)
 			if err != nil {
+				p.errorf("%v", err)
 				return nil, ""
 			}
 		case "z":
+			p.gnuExtension("the z command")
 			stmt = &zStmt{
 				addresser: addr,
 			}
@@ -311,62 +410,140 @@ func (p *Parser) parseStatement() (statement, string) {
 	}
 
 	p.nextToken()
-	if !isStatementDelim(p.curToken.Type) {
+	if !isStatementDelim(p.curToken.Type) && !p.curTokenIs(lexer.ItemRBrace) {
 		p.unexpectedTokenError()
 	}
 
 	return stmt, ""
 }
 
+// nextStatement moves past the delimiter that ended a statement. A closing
+// brace, which may follow a command directly, is left for the block it
+// ends, as is a comment, which the program records.
+func (p *Parser) nextStatement() {
+	if !p.curTokenIs(lexer.ItemRBrace) && !p.curTokenIs(lexer.ItemComment) {
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseAddress() addresser {
-	if p.curTokenIs(lexer.ItemCmd) {
+	if p.curTokenIs(lexer.ItemCmd) || p.curTokenIs(lexer.ItemLBrace) {
 		return &blankAddress{}
 	}
 
 	addr1 := p.parseAddressPart()
 	if addr1 == nil {
-		return nil
+		return &blankAddress{}
 	}
-	switch p.curToken.Type {
-	case lexer.ItemCmd:
-		return addr1
-	case lexer.ItemLBrace:
-		return addr1
-	case lexer.ItemComma:
+	addr := addr1
+	if p.curTokenIs(lexer.ItemComma) {
 		p.nextToken()
-		addr2 := p.parseAddressPart()
-		rangeAddr := &rangeAddress{Addr1: addr1, Addr2: addr2}
-		if p.curToken.Type == lexer.ItemExpMark {
-			p.nextToken()
-			return &notAddr{Addr: rangeAddr}
+		addr2 := p.parseAddressEnd()
+		if addr2 == nil {
+			return &blankAddress{}
+		}
+		if isLineZero(addr1) {
+			if _, ok := addr2.(*regexpAddr); ok {
+				p.gnuExtension("the 0,/regexp/ address")
+			} else {
+				p.errorf("invalid usage of line address 0")
+			}
 		}
-		return rangeAddr
+		addr = &rangeAddress{Addr1: addr1, Addr2: addr2}
+	} else if isLineZero(addr1) {
+		p.errorf("invalid usage of line address 0")
+	}
+
+	switch p.curToken.Type {
 	case lexer.ItemExpMark:
 		p.nextToken()
-		return &notAddr{Addr: addr1}
-	default:
-		p.unexpectedTokenError()
+		return &notAddr{Addr: addr}
+	case lexer.ItemCmd, lexer.ItemLBrace, lexer.ItemComma:
+		// A third address is reported by the statement.
+		return addr
 	}
+	p.unexpectedTokenError()
+	return &blankAddress{}
+}
 
-	return nil
+func isLineZero(a addresser) bool {
+	l, ok := a.(*lineNoAddr)
+	return ok && l.LineNo == 0
+}
+
+// parseAddressEnd parses the address after the comma of a range, which
+// may also be one of the GNU forms +N and ~N.
+func (p *Parser) parseAddressEnd() addresser {
+	op := p.curToken.Type
+	if op != lexer.ItemPlus && op != lexer.ItemTilde {
+		return p.parseAddressPart()
+	}
+	if !p.expectPeek(lexer.ItemInt) {
+		return nil
+	}
+	n := p.atoi(p.curToken.Value)
+	p.nextToken()
+	if op == lexer.ItemPlus {
+		p.gnuExtension("the addr1,+N address")
+		return &relLineAddr{N: n}
+	}
+	p.gnuExtension("the addr1,~N address")
+	return &multipleAddr{N: n}
+}
+
+// parseBranchLabel parses the optional label of a b, t or T command. A
+// missing label, returned as "", branches to the end of the script.
+func (p *Parser) parseBranchLabel() string {
+	if p.peekTokenIs(lexer.ItemIdent) {
+		p.nextToken()
+		return p.curToken.Value
+	}
+	return ""
+}
+
+// parseCommandInt parses the optional number after a q, Q or l command,
+// a GNU extension described by construct. It returns def if there is none.
+func (p *Parser) parseCommandInt(construct string, def int) int {
+	if !p.peekTokenIs(lexer.ItemInt) {
+		return def
+	}
+	p.nextToken()
+	p.gnuExtension(construct)
+	return p.atoi(p.curToken.Value)
 }
 
 func (p *Parser) parseFlags() *sFlags {
 	flg := &sFlags{}
 	for {
-		if p.curToken.Value[0] >= '1' && p.curToken.Value[0] <= '9' {
-			flg.NFlag = int(p.curToken.Value[0] - '0')
-		} else if p.curToken.Value == "g" {
+		switch v := p.curToken.Value; {
+		case v != "" && v[0] >= '0' && v[0] <= '9':
+			flg.NFlag = p.atoi(v)
+			if flg.NFlag == 0 {
+				p.errorf("number option to s command may not be zero")
+			}
+		case v == "g":
 			flg.GFlag = true
-		} else if p.curToken.Value == "p" {
+		case v == "p":
 			flg.PFlag = true
-		} else if p.curToken.Value == "w" {
+		case v == "i" || v == "I":
+			p.extension("the "+v+" flag of s", GNU, BSD)
+			flg.IFlag = true
+		case v == "m" || v == "M":
+			p.gnuExtension("the " + v + " flag of s")
+			flg.MFlag = true
+		case v == "e":
+			p.gnuExtension("the e flag of s")
+			flg.EFlag = true
+		case v == "w":
 			if p.expectPeek(lexer.ItemIdent) {
 				flg.WFile = p.curToken.Value
 			} else {
 				p.unexpectedTokenError()
 			}
 			return flg // No more flags after this.
+		default:
+			p.unexpectedFlagError(v)
+			return flg
 		}
 		if !p.peekTokenIs(lexer.ItemIdent) {
 			return flg
@@ -375,70 +552,81 @@ func (p *Parser) parseFlags() *sFlags {
 	}
 }
 
-// translateLiteral performs the translation from user input
-// to the string that shoudl be processed in the regexp. This
-// incluedes processing escape characters.
-func translateLiteral(l string) string {
-	var retData bytes.Buffer
-	var escState bool
-	for _, r := range l {
-		if escState {
-			switch {
-			case r == '\\':
-				retData.WriteRune('\\')
-			case r == 'n':
-				retData.WriteRune('\n')
-				// TODO: Check case of the literal that was used to divide
-			default:
-				// TODO: Error
-			}
-			escState = false
-		} else {
-			switch {
-			case r == '\\':
-				escState = true
-			default:
-				retData.WriteRune(r)
-			}
+// compileSubst compiles the regexp and replacement of an s command.
+func (p *Parser) compileSubst(s *sStmt) {
+	if s.FindAddr != "" {
+		flags := regexFlags{icase: s.Flags.IFlag, multiline: s.Flags.MFlag}
+		re, err := compileRegexp(s.FindAddr, p.opts.ExtendedRegexp, flags, p.opts.Dialect)
+		if err != nil {
+			p.errorf("%v", err)
+			return
+		}
+		s.regexp = re
+	}
+	rep, err := parseReplacement(s.ReplaceAddr, p.opts.Dialect)
+	if err != nil {
+		p.errorf("%v", err)
+		return
+	}
+	if s.regexp != nil && rep.maxGroup() > s.regexp.NumSubexp() {
+		p.errorf("invalid reference \\%d on s command's RHS", rep.maxGroup())
+	}
+	s.replacement = rep
+}
+
+// parseRegexFlags parses the I and M modifiers that may follow an address
+// regexp.
+func (p *Parser) parseRegexFlags(flags string) regexFlags {
+	var f regexFlags
+	for _, c := range flags {
+		switch c {
+		case 'I':
+			p.extension("the I modifier of an address", GNU, BSD)
+			f.icase = true
+		case 'M':
+			p.gnuExtension("the M modifier of an address")
+			f.multiline = true
 		}
 	}
-	return retData.String()
+	return f
 }
+
 func (p *Parser) parseAddressPart() addresser {
 	var addr addresser
 	switch p.curToken.Type {
 	case lexer.ItemSlash:
-		if !p.peekTokenIs(lexer.ItemLit) {
-			// Could be a blank literal
-			if p.peekTokenIs(lexer.ItemSlash) {
-				p.nextToken()
-				regex := regexp.MustCompile("")
-				addr = &regexpAddr{Regexp: regex}
-				break
-			}
-			return nil
+		src := ""
+		if p.peekTokenIs(lexer.ItemLit) {
+			p.nextToken()
+			src = p.curToken.Value
 		}
-		p.nextToken()
-
-		lit := p.curToken.Value
 		if !p.expectPeek(lexer.ItemSlash) {
 			return nil
 		}
-		// TODO: Should have own form of regexp
-		lit = translateLiteral(lit)
-		regex, err := regexp.Compile(lit)
-		if err != nil {
-			regex = regexp.MustCompile(".*")
+		a := &regexpAddr{Source: src}
+		if p.peekTokenIs(lexer.ItemIdent) {
+			p.nextToken()
+			a.Flags = p.parseRegexFlags(p.curToken.Value)
 		}
-
-		addr = &regexpAddr{Regexp: regex}
-	case lexer.ItemInt:
-		i, err := strconv.Atoi(p.curToken.Value)
-		if err != nil {
-			// TODO: Have a better way of doing error handling
-			panic(err)
+		if src != "" {
+			re, err := compileRegexp(src, p.opts.ExtendedRegexp, a.Flags, p.opts.Dialect)
+			if err != nil {
+				p.errorf("%v", err)
+			}
+			a.Regexp = re
 		}
+		addr = a
+	case lexer.ItemInt:
+		i := p.atoi(p.curToken.Value)
 		addr = &lineNoAddr{LineNo: i}
+		if p.peekTokenIs(lexer.ItemTilde) {
+			p.nextToken()
+			if !p.expectPeek(lexer.ItemInt) {
+				return nil
+			}
+			p.gnuExtension("the first~step address")
+			addr = &stepAddr{First: i, Step: p.atoi(p.curToken.Value)}
+		}
 	case lexer.ItemDollar:
 		addr = &eofAddr{}
 	default:
@@ -448,6 +636,108 @@ func (p *Parser) parseAddressPart() addresser {
 	return addr
 }
 
+// parseText parses the text of an a, i or c command. POSIX puts the text
+// after a backslash and newline; GNU sed also takes it from the rest of the
+// line, after the backslash ("a\text") or without one ("a text"). hasText
+// is false when the script ends right after the backslash, which GNU sed
+// takes as no text at all rather than as an empty line.
+func (p *Parser) parseText(cmd string) (text string, hasText bool) {
+	backslash := p.peekTokenIs(lexer.ItemBackslash)
+	if backslash {
+		p.nextToken()
+	} else if p.opts.Dialect == BSD {
+		p.errorf("command %s expects \\ followed by text", cmd)
+	} else {
+		p.gnuExtension("the one-line form of " + cmd)
+	}
+	if backslash && p.peekTokenIs(lexer.ItemLit) && p.peekToken.Value != "" &&
+		p.peekToken.Line == p.curToken.Line {
+		p.gnuExtension("text on the same line as " + cmd + "\\")
+	}
+	end := p.curToken.End
+	if !p.expectPeek(lexer.ItemLit) {
+		return "", false
+	}
+	if backslash && p.curToken.Value == "" && p.curToken.Start == end && p.peekTokenIs(lexer.ItemEOF) {
+		return "", false
+	}
+	return p.text(p.curToken.Value), true
+}
+
+// text returns the text of an a, i or c command as the dialect reads it.
+// A backslash is removed and the character after it kept. GNU sed first
+// turns escapes such as \t into the characters they stand for, and BSD
+// sed drops the leading whitespace of every line.
+func (p *Parser) text(lit string) string {
+	var buff bytes.Buffer
+	lineStart := true
+	for i := 0; i < len(lit); i++ {
+		c := lit[i]
+		if lineStart && p.opts.Dialect == BSD && (c == ' ' || c == '\t') {
+			continue
+		}
+		lineStart = c == '\n'
+		if c != '\\' {
+			buff.WriteByte(c)
+			continue
+		}
+		if i+1 == len(lit) {
+			// A backslash at the very end escapes nothing.
+			break
+		}
+		i++
+		c = lit[i]
+		lineStart = c == '\n'
+		if p.opts.Dialect == GNU {
+			if e, n, ok := gnuEscape(lit[i:]); ok {
+				buff.WriteString(e)
+				i += n - 1
+				continue
+			}
+		}
+		buff.WriteByte(c)
+	}
+	return buff.String()
+}
+
+// unescapeY returns a string of the y command with its \\ and \n escapes,
+// and in GNU sed the other character escapes, replaced.
+func (p *Parser) unescapeY(s string) string {
+	var buff bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			buff.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch {
+		case s[i] == '\\':
+			buff.WriteByte('\\')
+		case s[i] == 'n':
+			buff.WriteByte('\n')
+		default:
+			if e, n, ok := gnuEscape(s[i:]); ok && p.opts.Dialect == GNU {
+				buff.WriteString(e)
+				i += n - 1
+				continue
+			}
+			buff.WriteByte('\\')
+			buff.WriteByte(s[i])
+		}
+	}
+	return buff.String()
+}
+
+// atoi converts a number in the script, recording an error if it does not
+// fit in an int.
+func (p *Parser) atoi(s string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		p.errorf("invalid number %s", s)
+	}
+	return i
+}
+
 func (p *Parser) curTokenIs(t lexer.ItemType) bool {
 	return p.curToken.Type == t
 }
@@ -470,17 +760,32 @@ func (p *Parser) lineNumber() int {
 }
 
 func (p *Parser) peekError(t lexer.ItemType) {
+	if p.peekTokenIs(lexer.ItemError) {
+		// The lexer's error is reported when the parser reaches it.
+		return
+	}
 	msg := fmt.Sprintf("line %d: expected next token to be %s, got %s instead", p.lineNumber(), t, p.peekToken.Type)
 	p.errors = append(p.errors, msg)
 }
 
 func (p *Parser) unexpectedTokenError() {
+	if p.curTokenIs(lexer.ItemError) && p.curToken.Value != "" {
+		// Pass on the lexer's description of the problem.
+		p.errorf("%s", p.curToken.Value)
+		return
+	}
 	msg := fmt.Sprintf("line %d: unexpected token type %s", p.lineNumber(), p.curToken.Type)
 	p.errors = append(p.errors, msg)
 }
 
-func (p *Parser) unexpectedFlagError(f rune) {
-	msg := fmt.Sprintf("line %d: unexpected flag type %v", p.lineNumber(), f)
+func (p *Parser) unexpectedFlagError(f string) {
+	msg := fmt.Sprintf("line %d: unknown option to s: %q", p.lineNumber(), f)
+	p.errors = append(p.errors, msg)
+}
+
+// errorf records an error at the current line of the script.
+func (p *Parser) errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf("line %d: ", p.lineNumber()) + fmt.Sprintf(format, args...)
 	p.errors = append(p.errors, msg)
 }
 
@@ -489,5 +794,5 @@ func (p *Parser) customError(f string) {
 }
 
 func isStatementDelim(t lexer.ItemType) bool {
-	return t == lexer.ItemNewline || t == lexer.ItemEOF || t == lexer.ItemSemicolon
+	return t == lexer.ItemNewline || t == lexer.ItemEOF || t == lexer.ItemSemicolon || t == lexer.ItemComment
 }
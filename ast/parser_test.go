@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -17,7 +18,7 @@ func TestStatement(t *testing.T) {
 		{program: "s//2/", isError: false},
 		{program: "s/2//", isError: false},
 		{program: "a\\\ntext", isError: false},
-		{program: "a text", isError: true},
+		{program: "a text", isError: false},
 		{program: "btext", isError: false},
 		{program: "b", isError: false},
 		{program: "c\\\ntext", isError: false},
@@ -61,6 +62,134 @@ func TestStatement(t *testing.T) {
 	// pretty.Println(stmt)
 }
 
+func TestDialect(t *testing.T) {
+	tests := []struct {
+		program  string
+		posixErr string // The error in POSIX mode, or "" if the program is POSIX.
+	}{
+		{program: "s/a*b/x/g;1,$p;/re/!d", posixErr: ""},
+		{program: "s/\\(a\\)\\{2\\}/\\1&/2w out", posixErr: ""},
+		{program: "p\nQ", posixErr: "line 2: the Q command is a GNU extension, not allowed in POSIX mode"},
+		{program: "F", posixErr: "line 1: the F command is a GNU extension, not allowed in POSIX mode"},
+		{program: "Tend\n:end", posixErr: "line 1: the T command is a GNU extension, not allowed in POSIX mode"},
+		{program: "1~2d", posixErr: "line 1: the first~step address is a GNU extension, not allowed in POSIX mode"},
+		{program: "/a/,+2d", posixErr: "line 1: the addr1,+N address is a GNU extension, not allowed in POSIX mode"},
+		{program: "/a/,~2d", posixErr: "line 1: the addr1,~N address is a GNU extension, not allowed in POSIX mode"},
+		{program: "0,/a/d", posixErr: "line 1: the 0,/regexp/ address is a GNU extension, not allowed in POSIX mode"},
+		{program: "/a/Md", posixErr: "line 1: the M modifier of an address is a GNU extension, not allowed in POSIX mode"},
+		{program: "s/a/b/I", posixErr: "line 1: the I flag of s is a GNU extension, not allowed in POSIX mode"},
+		{program: "q5", posixErr: "line 1: an exit code for q is a GNU extension, not allowed in POSIX mode"},
+		{program: "\n\ns/a\\+/b/", posixErr: "line 3: \\+ in a regular expression is a GNU extension, not allowed in POSIX mode"},
+		{program: "/\\bword/p", posixErr: "line 1: \\b in a regular expression is a GNU extension, not allowed in POSIX mode"},
+		{program: "s/a/\\u&/", posixErr: "line 1: \\u in a replacement is a GNU extension, not allowed in POSIX mode"},
+		{program: "a\\\ntext\ni\\\none\\\ntwo", posixErr: ""},
+		{program: "1a text", posixErr: "line 1: the one-line form of a is a GNU extension, not allowed in POSIX mode"},
+		{program: "1i\\text", posixErr: "line 1: text on the same line as i\\ is a GNU extension, not allowed in POSIX mode"},
+		{program: "p\n$c\\  text\\\nmore", posixErr: "line 2: text on the same line as c\\ is a GNU extension, not allowed in POSIX mode"},
+	}
+
+	for i, tt := range tests {
+		p := New(tt.program)
+		p.ParseProgram()
+		if len(p.errors) > 0 {
+			t.Errorf("Program [%d] %q: expected no errors in GNU mode, got %v", i, tt.program, p.errors)
+		}
+
+		p = NewWithOptions(tt.program, ParseOptions{Dialect: POSIX})
+		p.ParseProgram()
+		switch {
+		case tt.posixErr == "" && len(p.errors) > 0:
+			t.Errorf("Program [%d] %q: expected no errors in POSIX mode, got %v", i, tt.program, p.errors)
+		case tt.posixErr != "" && (len(p.errors) != 1 || p.errors[0] != tt.posixErr):
+			t.Errorf("Program [%d] %q: expected POSIX error %q, got %v", i, tt.program, tt.posixErr, p.errors)
+		}
+	}
+}
+
+func TestBSDDialect(t *testing.T) {
+	tests := []struct {
+		program string
+		input   string
+		output  string
+		err     string // The expected error, if the program is not BSD sed.
+	}{
+		{program: "s/A/x/Ig;/C/Id", input: "ab\nc", output: "xb"},
+		{program: "/[[:<:]]a/s//x/g", input: "a ba a", output: "x ba x"},
+		{program: "1a\\\n   after", input: "1\n2", output: "1\nafter\n2"},
+		{program: "i\\\n\tin\\t", input: "1", output: "int\n1"},
+		{program: "y/ab/\\\\\\n/", input: "ab", output: "\\\n"},
+		{program: "n;l;d", input: "1\na\tb\\c\xc3\xa9", output: "1\na\\tb\\c\xc3\xa9$"},
+		{program: "c text", err: "line 1: command c expects \\ followed by text"},
+		{program: "1i\\text", err: "line 1: text on the same line as i\\ is not supported by BSD sed"},
+		{program: "$a\\text\\\nmore", err: "line 1: text on the same line as a\\ is not supported by BSD sed"},
+		{program: "Q", err: "line 1: the Q command is not supported by BSD sed"},
+		{program: "/a/Mp", err: "line 1: the M modifier of an address is not supported by BSD sed"},
+		{program: "s/a/b/m", err: "line 1: the m flag of s is not supported by BSD sed"},
+		{program: "l 5", err: "line 1: a line length for l is not supported by BSD sed"},
+		{program: "s/a/\\t/", err: "line 1: \\t in a replacement is not supported by BSD sed"},
+	}
+
+	for i, tt := range tests {
+		p := NewWithOptions(tt.program, ParseOptions{Dialect: BSD})
+		program := p.ParseProgram()
+		if tt.err != "" {
+			if len(p.errors) != 1 || p.errors[0] != tt.err {
+				t.Errorf("Program [%d] %q: expected error %q, got %v", i, tt.program, tt.err, p.errors)
+			}
+			continue
+		}
+		if len(p.errors) > 0 {
+			t.Errorf("Program [%d] %q encountered errors %v", i, tt.program, p.errors)
+			continue
+		}
+		out, _ := program.Run(tt.input, RuntimeOptions{AutoPrint: true, Dialect: BSD})
+		if out != tt.output {
+			t.Errorf("Program [%d] %q produced incorrect output.\n Expected: %q\n Got: %q", i, tt.program, tt.output, out)
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	tests := []struct {
+		program string
+		dialect Dialect
+		input   string
+		output  string
+		errs    []string
+	}{
+		{program: ": a\ns/x/y/;/x/b  a \np", input: "xx", output: "yy\nyy"},
+		{program: "b end;s/^/!/;:end", input: "1", output: "1"},
+		{program: "{b in};p;{:in}", input: "1", output: "1"},
+		{program: "tx;s/1/2/;:x;Tx", input: "1", output: "2"},
+		{program: ":a;N;$!ba;s/\\n/+/g", input: "1\n2\n3", output: "1+2+3"},
+		{program: ":a;N;$!ba;s/\\n/+/g", dialect: BSD, input: "1\n2", output: "1\n2"},
+		{program: ":a \nN;$!b a \ns/\\n/+/g", dialect: BSD, input: "1\n2\n3", output: "1+2+3"},
+		{program: "b nowhere", errs: []string{"line 1: can't find label for jump to `nowhere'"}},
+		{program: "p\n{t typo\n}", errs: []string{"line 2: can't find label for jump to `typo'"}},
+		{program: ":a\np\n{:a}", errs: []string{"line 3: duplicate label `a', first defined on line 1"}},
+		{program: ":;p", errs: []string{"line 1: \":\" lacks a label"}},
+	}
+
+	for i, tt := range tests {
+		p := NewWithOptions(tt.program, ParseOptions{Dialect: tt.dialect})
+		program := p.ParseProgram()
+		if tt.errs != nil {
+			if !reflect.DeepEqual([]string(p.Errors()), tt.errs) {
+				t.Errorf("Program [%d] %q: expected errors %q, got %q", i, tt.program, tt.errs, p.Errors())
+			}
+			continue
+		}
+		if len(p.errors) > 0 {
+			t.Errorf("Program [%d] %q encountered errors %v", i, tt.program, p.errors)
+			continue
+		}
+		out, _ := program.Run(tt.input, RuntimeOptions{AutoPrint: true, Dialect: tt.dialect})
+		if out != tt.output {
+			t.Errorf("Program [%d] %q produced incorrect output.\n Expected: %q\n Got: %q", i, tt.program, tt.output, out)
+		}
+	}
+}
+
 func TestParse(t *testing.T) {
 	tests := []struct {
 		program string
@@ -82,6 +211,10 @@ func TestParse(t *testing.T) {
 		{program: "s/one/two/\n\ns/two/three/;", isError: false},
 		{program: "\ns/one/two/\n\ns/two/three/\n", isError: false},
 		{program: "/quit_now/q", isError: false},
+		{program: "1{p};{{p}}", isError: false},
+		{program: "p}", isError: true},
+		{program: "1{p", isError: true},
+		{program: "{p};}", isError: true},
 	}
 	for i, test := range tests {
 		p := New(test.program)
@@ -89,6 +222,9 @@ func TestParse(t *testing.T) {
 		if !test.isError && len(p.errors) > 0 {
 			t.Errorf("Program [%d] %s expected no errors but got: %v", i, test.program, p.errors)
 		}
+		if test.isError && len(p.errors) == 0 {
+			t.Errorf("Program [%d] %s expected an error and got none.", i, test.program)
+		}
 	}
 }
 
@@ -128,6 +264,156 @@ func TestRun(t *testing.T) {
 			input:   "1\n2\n3",
 			output:  "insert\n1\nafter\ninsert\n2\nafter\ninsert\n3\nafter",
 		},
+		{
+			program: "c\\\nX",
+			input:   "1\n2",
+			output:  "X\nX",
+		},
+		{
+			program: "2c\\\nX",
+			input:   "1\n2\n3",
+			output:  "1\nX\n3",
+		},
+		{
+			program: "$!c\\\nX",
+			input:   "1\n2\n3",
+			output:  "X\nX\n3",
+		},
+		{
+			program: "2,$c\\\nX",
+			input:   "1\n2\n3",
+			output:  "1\nX",
+		},
+		{
+			program: "/2/,/x/c\\\nX",
+			input:   "1\n2\n3",
+			output:  "1",
+		},
+		{
+			program: "/1/,/2/!c\\\nX",
+			input:   "1\n2\n3",
+			output:  "1\n2\nX",
+		},
+		{
+			program: "2,1c\\\nX",
+			input:   "1\n2\n3",
+			output:  "1\nX\n3",
+		},
+		{
+			program: "0,/2/c\\\nX",
+			input:   "1\n2\n3",
+			output:  "X\n3",
+		},
+		{
+			program: "/2/,+1c\\\nX",
+			input:   "1\n2\n3\n4",
+			output:  "1\nX\n4",
+		},
+		{
+			program: "1~2,+0c\\\nX",
+			input:   "1\n2\n3\n4",
+			output:  "X\n2\nX\n4",
+		},
+		{
+			program: "1,2{c\\\nX\n}",
+			input:   "1\n2\n3",
+			output:  "X\nX\n3",
+		},
+		{
+			program: "1,2c\\\nX\np",
+			input:   "1\n2\n3",
+			output:  "X\n3\n3",
+		},
+		{
+			program: "/2/{s/2/two/;b end\n};s/$/!/;:end",
+			input:   "1\n2\n3",
+			output:  "1!\ntwo\n3!",
+		},
+		{
+			program: "1{b in};s/^/o/;{:in;s/$/i/}",
+			input:   "1\n2",
+			output:  "1i\no2i",
+		},
+		{
+			program: "/b/{:x;s/b/bb/;/bbbb/!{bx}}",
+			input:   "a\nb\nc",
+			output:  "a\nbbbb\nc",
+		},
+		{
+			program: ":top;/1/{s/1/x/;{b top}}",
+			input:   "1\n2",
+			output:  "x\n2",
+		},
+		{
+			program: "2{N;{s/\\n/+/;p;b}};p",
+			input:   "1\n2\n3\n4",
+			output:  "1\n1\n2+3\n2+3\n4\n4",
+		},
+		{
+			program: "2{{q}}",
+			input:   "1\n2\n3",
+			output:  "1\n2",
+		},
+		{
+			program: "1{N;N;{D}}",
+			input:   "1\n2\n3\n4",
+			output:  "2\n3\n4",
+		},
+		{
+			program: "{{n;d}}",
+			input:   "1\n2\n3",
+			output:  "1\n3",
+		},
+		{
+			program: "1a  one-liner; p\n2i\\  kept\n$a\\\nfirst\\\n\\tsecond\\",
+			input:   "1\n2",
+			output:  "1\none-liner; p\n  kept\n2\nfirst\n\tsecond",
+		},
+		{
+			program: "1a\\",
+			input:   "1\n2",
+			output:  "1\n2",
+		},
+		{
+			program: "1i\\",
+			input:   "1\n2",
+			output:  "1\n2",
+		},
+		{
+			program: "$c\\",
+			input:   "1\n2",
+			output:  "1",
+		},
+		{
+			program: "1,2c\\",
+			input:   "1\n2\n3",
+			output:  "3",
+		},
+		{
+			program: "1a\\\n",
+			input:   "1\n2",
+			output:  "1\n\n2",
+		},
+		{
+			program: "1a\\\n\n2p",
+			input:   "1\n2",
+			output:  "1\n\n2\n2",
+		},
+		{
+			program: "1a\\\\",
+			input:   "1\n2",
+			output:  "1\n\n2",
+		},
+		{
+			program: "2i\\\n",
+			input:   "1\n2",
+			output:  "1\n\n2",
+		},
+		{
+			program: "1c\\\n",
+			input:   "1\n2",
+			output:  "\n2",
+		},
 		{
 			program: "a\\\na1\na\\\na2\na\\\na3",
 			input:   "1\n2\n3",
@@ -153,8 +439,8 @@ func TestRun(t *testing.T) {
 			input:   "This is a word.",
 			output:  "This is b word.",
 		},
-		{ // TODO: Change to traditional Sed syntax
-			program: "s/This is a (.*)\\./$1/",
+		{
+			program: "s/This is a \\(.*\\)\\./\\1/",
 			input:   "This is a word.",
 			output:  "word",
 		},
@@ -273,7 +559,7 @@ N
 D
 `,
 			input:  "line1\nline2\nline3\nline4",
-			output: "line1",
+			output: "line1\nline4",
 		},
 		{
 			program: `
@@ -323,7 +609,7 @@ s/here/HERE/
 			t.Errorf("Program [%d] %s encountered errors %v", i, tt.program, p.errors)
 			continue
 		}
-		out := program.Run(tt.input, opt)
+		out, _ := program.Run(tt.input, opt)
 		if out != tt.output {
 			t.Errorf("Program [%d] %s produced incorrect output.\n Expected:\n-----\n%s\n-----\n Got:\n-----\n%s\n-----\n", i, tt.program, tt.output, out)
 		}
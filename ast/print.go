@@ -0,0 +1,455 @@
+package ast
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// String returns the program in canonical form: one command per line,
+// with the contents of blocks indented by two spaces. This is the form
+// GNU sed prints with --debug. Outside GNU mode the text of a, i and c
+// starts on the line after the backslash, as POSIX requires.
+func (p *Program) String() string {
+	pr := printer{dialect: p.dialect}
+	pr.writeProgram(p, 0)
+	return pr.buff.String()
+}
+
+// Format returns the program in the canonical form of String with the
+// comments of the script kept in place. A comment that followed a command
+// stays on the command's line unless the command's last argument runs to
+// the end of the line.
+func (p *Program) Format() string {
+	pr := printer{comments: true, labelLines: p.labelLines, dialect: p.dialect}
+	pr.writeProgram(p, 0)
+	return pr.buff.String()
+}
+
+// printer writes a program in canonical form.
+type printer struct {
+	buff       bytes.Buffer
+	comments   bool           // Whether to write comments.
+	labelLines map[string]int // Orders labels among comments.
+	open       bool           // Whether a comment may be added to the last line.
+	blanks     []int          // The empty lines of the program being written.
+	started    bool           // Whether a line of that program has been written.
+	dialect    Dialect        // The dialect to write text commands for.
+}
+
+func (pr *printer) writeProgram(p *Program, depth int) {
+	indent := strings.Repeat("  ", depth)
+	labels := labelsByPosition(p)
+	var comments []Comment
+	if pr.comments {
+		comments = p.Comments
+		pr.blanks, pr.started = p.blankLines, false
+	}
+	for i := 0; i <= len(p.Statements); i++ {
+		ls := labels[i]
+		if pr.labelLines != nil {
+			sort.SliceStable(ls, func(a, b int) bool { return pr.labelLines[ls[a]] < pr.labelLines[ls[b]] })
+		}
+		for len(ls) > 0 || (len(comments) > 0 && comments[0].Pos == i) {
+			if len(comments) > 0 && comments[0].Pos == i && (len(ls) == 0 || comments[0].Line < pr.labelLines[ls[0]]) {
+				pr.separate(comments[0].Line)
+				pr.writeComment(indent, comments[0])
+				comments = comments[1:]
+				continue
+			}
+			pr.separate(pr.labelLines[ls[0]])
+			pr.writeLine(indent+":"+ls[0], false)
+			ls = ls[1:]
+		}
+		if i == len(p.Statements) {
+			break
+		}
+		stmt := p.Statements[i]
+		if pr.comments && i < len(p.SourceLines) {
+			pr.separate(p.SourceLines[i])
+		}
+		pr.writeLine(indent+pr.statement(stmt), !takesRestOfLine(stmt))
+		if !hasText(stmt) {
+			// The command ends the script, and a newline after its
+			// backslash would give it an empty line as text.
+			pr.buff.Truncate(pr.buff.Len() - 1)
+		}
+		if block, ok := stmt.(*blockStmt); ok {
+			blanks := pr.blanks
+			pr.writeProgram(block.Code, depth+1)
+			pr.blanks, pr.started = blanks, true
+			pr.writeLine(indent+"}", true)
+		}
+	}
+}
+
+// separate writes an empty line before the line of the script at line
+// if there were empty lines before it. Empty lines at the start of a
+// block or of the script are dropped, and several become one.
+func (pr *printer) separate(line int) {
+	blank := false
+	for len(pr.blanks) > 0 && pr.blanks[0] < line {
+		blank = true
+		pr.blanks = pr.blanks[1:]
+	}
+	if blank && pr.started {
+		pr.buff.WriteString("\n")
+	}
+	pr.started = true
+}
+
+func (pr *printer) writeLine(line string, open bool) {
+	pr.buff.WriteString(line + "\n")
+	pr.open = open
+}
+
+func (pr *printer) writeComment(indent string, c Comment) {
+	if c.Trailing && pr.open {
+		pr.buff.Truncate(pr.buff.Len() - 1)
+		pr.writeLine(" #"+c.Text, false)
+		return
+	}
+	pr.writeLine(indent+"#"+c.Text, false)
+}
+
+// takesRestOfLine reports whether the last argument of stmt runs to the
+// end of its line, so that a comment cannot follow it there.
+func takesRestOfLine(stmt statement) bool {
+	switch s := stmt.(type) {
+	case *aStmt, *iStmt, *cStmt, *bStmt, *tStmt, *t2Stmt, *eStmt,
+		*rStmt, *r2Stmt, *wStmt, *w2Stmt:
+		return true
+	case *sStmt:
+		return s.Flags.WFile != ""
+	}
+	return false
+}
+
+// hasText reports whether stmt is not an a, i or c command without text.
+func hasText(stmt statement) bool {
+	switch s := stmt.(type) {
+	case *aStmt:
+		return s.HasText
+	case *iStmt:
+		return s.HasText
+	case *cStmt:
+		return s.HasText
+	}
+	return true
+}
+
+// labelsByPosition groups the labels of p by the index of the statement
+// they precede.
+func labelsByPosition(p *Program) map[int][]string {
+	labels := make(map[int][]string)
+	for l, pos := range p.Labels {
+		labels[pos] = append(labels[pos], l)
+	}
+	for _, ls := range labels {
+		sort.Strings(ls)
+	}
+	return labels
+}
+
+// statement returns the canonical form of stmt as formatStatement does,
+// except that outside GNU mode an a, i or c command has its text on the
+// lines after the backslash rather than on the same line.
+func (pr *printer) statement(stmt statement) string {
+	var cmd, text string
+	var hasText bool
+	switch s := stmt.(type) {
+	case *aStmt:
+		cmd, text, hasText = "a", s.AppendLine, s.HasText
+	case *iStmt:
+		cmd, text, hasText = "i", s.InsertLine, s.HasText
+	case *cStmt:
+		cmd, text, hasText = "c", s.ChangeLine, s.HasText
+	}
+	if cmd == "" || pr.dialect == GNU {
+		return formatStatement(stmt)
+	}
+	line := cmd + "\\"
+	if hasText {
+		line += "\n" + formatText(text)
+	}
+	if a := formatAddress(stmt.address()); a != "" {
+		return a + " " + line
+	}
+	return line
+}
+
+// formatStatement returns the canonical form of a single statement and
+// its address. Blocks are returned as their opening line only.
+func formatStatement(stmt statement) string {
+	cmd := formatCommand(stmt)
+	a := formatAddress(stmt.address())
+	if a == "" {
+		return cmd
+	}
+	return a + " " + cmd
+}
+
+func formatCommand(stmt statement) string {
+	switch s := stmt.(type) {
+	case *aStmt:
+		return formatTextCommand("a", s.AppendLine, s.HasText)
+	case *bStmt:
+		return formatBranch("b", s.BranchIdent)
+	case *cStmt:
+		return formatTextCommand("c", s.ChangeLine, s.HasText)
+	case *sStmt:
+		return formatSubst(s.FindAddr, s.ReplaceAddr) + formatSFlags(s.Flags)
+	case *dStmt:
+		return "d"
+	case *d2Stmt:
+		return "D"
+	case *eStmt:
+		if s.Command == "" {
+			return "e"
+		}
+		return "e " + s.Command
+	case *gStmt:
+		return "g"
+	case *g2Stmt:
+		return "G"
+	case *hStmt:
+		return "h"
+	case *h2Stmt:
+		return "H"
+	case *iStmt:
+		return formatTextCommand("i", s.InsertLine, s.HasText)
+	case *lStmt:
+		if s.Width < 0 {
+			return "l"
+		}
+		return "l " + strconv.Itoa(s.Width)
+	case *nStmt:
+		return "n"
+	case *n2Stmt:
+		return "N"
+	case *pStmt:
+		return "p"
+	case *p2Stmt:
+		return "P"
+	case *qStmt:
+		return withInt("q", s.ExitCode)
+	case *q2Stmt:
+		return withInt("Q", s.ExitCode)
+	case *fStmt:
+		return "F"
+	case *rStmt:
+		return "r " + s.FileName
+	case *r2Stmt:
+		return "R " + s.FileName
+	case *tStmt:
+		return formatBranch("t", s.BranchIdent)
+	case *t2Stmt:
+		return formatBranch("T", s.BranchIdent)
+	case *wStmt:
+		return "w " + s.FileName
+	case *w2Stmt:
+		return "W " + s.FileName
+	case *xStmt:
+		return "x"
+	case *yStmt:
+		return formatY(s.Find, s.Replace)
+	case *zStmt:
+		return "z"
+	case *equStmt:
+		return "="
+	case *blockStmt:
+		return "{"
+	}
+	return ""
+}
+
+// withInt appends the optional number of a q, Q or l command.
+func withInt(cmd string, n int) string {
+	if n == 0 {
+		return cmd
+	}
+	return cmd + " " + strconv.Itoa(n)
+}
+
+func formatBranch(cmd, label string) string {
+	if label == "" {
+		return cmd
+	}
+	return cmd + " " + label
+}
+
+// formatY returns a y command, escaping backslashes as well as the
+// delimiter, since y reads \\ as a backslash.
+func formatY(find, replace string) string {
+	find = strings.Replace(find, "\\", "\\\\", -1)
+	replace = strings.Replace(replace, "\\", "\\\\", -1)
+	return "y/" + escapeDelim(find, '/') + "/" + escapeDelim(replace, '/') + "/"
+}
+
+func formatSFlags(f sFlags) string {
+	var flags string
+	if f.NFlag != 0 {
+		flags += strconv.Itoa(f.NFlag)
+	}
+	if f.GFlag {
+		flags += "g"
+	}
+	if f.PFlag {
+		flags += "p"
+	}
+	if f.IFlag {
+		flags += "i"
+	}
+	if f.MFlag {
+		flags += "m"
+	}
+	if f.EFlag {
+		flags += "e"
+	}
+	if f.WFile != "" {
+		flags += "w " + f.WFile
+	}
+	return flags
+}
+
+// formatText returns the text of an a, i or c command with embedded
+// newlines escaped so that it reads back as the same text.
+// formatTextCommand returns the a, i or c command cmd with its text on
+// the same line as the backslash. A command without text is a bare
+// backslash, and empty text is put on the next line, where it is an empty
+// line; otherwise the line after the command would be taken as its text.
+func formatTextCommand(cmd, text string, hasText bool) string {
+	switch {
+	case !hasText:
+		return cmd + "\\"
+	case text == "":
+		return cmd + "\\\n"
+	}
+	return cmd + "\\" + formatText(text)
+}
+
+func formatText(text string) string {
+	text = strings.Replace(text, "\\", "\\\\", -1)
+	return strings.Replace(text, "\n", "\\\n", -1)
+}
+
+// delimiters are the delimiters regexpDelim picks from, in order.
+const delimiters = "/|,:#!@%"
+
+// regexpDelim returns the delimiter to print the regexp re and the other
+// parts of its command between. It is / unless a bracket expression in re
+// contains a /, which escaping would turn into a bracket that also holds
+// a backslash; then it is the first of delimiters that none of them
+// contains.
+func regexpDelim(re string, parts ...string) string {
+	if !inBracket(re, '/') {
+		return "/"
+	}
+next:
+	for _, d := range delimiters[1:] {
+		for _, s := range append(parts, re) {
+			if strings.ContainsRune(s, d) {
+				continue next
+			}
+		}
+		return string(d)
+	}
+	return "/"
+}
+
+// inBracket reports whether a bracket expression in the regexp src, such
+// as [^a-z[:digit:]/], contains c.
+func inBracket(src string, c byte) bool {
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '[':
+			j := i + 1
+			if j < len(src) && src[j] == '^' {
+				j++
+			}
+			if j < len(src) && src[j] == ']' {
+				j++
+			}
+			for j < len(src) && src[j] != ']' {
+				if src[j] == '[' && j+1 < len(src) && strings.IndexByte(":.=", src[j+1]) >= 0 {
+					if end := strings.Index(src[j+2:], string(src[j+1])+"]"); end >= 0 {
+						j += end + 3
+					}
+				}
+				j++
+			}
+			if strings.IndexByte(src[i:j], c) >= 0 {
+				return true
+			}
+			i = j
+		}
+	}
+	return false
+}
+
+// formatRegexp returns the regexp of an address between delimiters.
+func formatRegexp(src string) string {
+	d := regexpDelim(src)
+	re := d + escapeDelim(src, rune(d[0])) + d
+	if d != "/" {
+		return "\\" + re
+	}
+	return re
+}
+
+// formatSubst returns the start of an s command: its regexp and
+// replacement between delimiters.
+func formatSubst(find, replace string) string {
+	d := regexpDelim(find, replace)
+	return "s" + d + escapeDelim(find, rune(d[0])) + d + escapeDelim(replace, rune(d[0])) + d
+}
+
+// escapeDelim escapes occurrences of the delimiter and newlines in a
+// regexp or replacement so that it can be printed between delimiters.
+func escapeDelim(s string, delim rune) string {
+	var buff bytes.Buffer
+	for _, r := range s {
+		switch r {
+		case delim:
+			buff.WriteRune('\\')
+			buff.WriteRune(r)
+		case '\n':
+			buff.WriteString("\\n")
+		default:
+			buff.WriteRune(r)
+		}
+	}
+	return buff.String()
+}
+
+func formatAddress(a addresser) string {
+	switch addr := a.(type) {
+	case *regexpAddr:
+		a := formatRegexp(addr.Source)
+		if addr.Flags.icase {
+			a += "I"
+		}
+		if addr.Flags.multiline {
+			a += "M"
+		}
+		return a
+	case *stepAddr:
+		return strconv.Itoa(addr.First) + "~" + strconv.Itoa(addr.Step)
+	case *relLineAddr:
+		return "+" + strconv.Itoa(addr.N)
+	case *multipleAddr:
+		return "~" + strconv.Itoa(addr.N)
+	case *lineNoAddr:
+		return strconv.Itoa(addr.LineNo)
+	case *eofAddr:
+		return "$"
+	case *notAddr:
+		return formatAddress(addr.Addr) + "!"
+	case *rangeAddress:
+		return formatAddress(addr.Addr1) + "," + formatAddress(addr.Addr2)
+	}
+	return ""
+}
@@ -0,0 +1,92 @@
+package ast
+
+import "testing"
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		program string
+		output  string
+	}{
+		{program: "s/one/two/g", output: "s/one/two/g\n"},
+		{program: "s:a/b:c:2p", output: "s/a\\/b/c/2p\n"},
+		{program: "s|[/]|X|g", output: "s|[/]|X|g\n"},
+		{program: "\\:[^/]:d;s,[/|]x,a|b,", output: "\\|[^/]| d\ns,[/|]x,a|b,\n"},
+		{program: "s|[[:alpha:]/]x/y|z|", output: "s|[[:alpha:]/]x/y|z|\n"},
+		{program: "s|a[^]]/b|c|", output: "s/a[^]]\\/b/c/\n"},
+		{program: "/x/!d;$p;1,/end/ =", output: "/x/! d\n$ p\n1,/end/ =\n"},
+		{program: ":top\nN;b top\nb", output: ":top\nN\nb top\nb\n"},
+		{program: "/a/ {\nh\n/b/ {\nx\n}\n}", output: "/a/ {\n  h\n  /b/ {\n    x\n  }\n}\n"},
+		{program: "a\\\nafter\ny/abc/xyz/", output: "a\\after\ny/abc/xyz/\n"},
+		{program: "1a\\\n\n2i\\", output: "1 a\\\n\n2 i\\"},
+		{program: "0,/x/Id;2~3p;/a/,+2s/b/c/Ig;$,~4q 5", output: "0,/x/I d\n2~3 p\n/a/,+2 s/b/c/gi\n$,~4 q 5\n"},
+	}
+
+	for i, tt := range tests {
+		p := New(tt.program)
+		program := p.ParseProgram()
+		if len(p.errors) > 0 {
+			t.Errorf("Program [%d] %s encountered errors %v", i, tt.program, p.errors)
+			continue
+		}
+		if out := program.String(); out != tt.output {
+			t.Errorf("Program [%d] %q printed incorrectly.\n Expected:\n%s\n Got:\n%s", i, tt.program, tt.output, out)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		program string
+		output  string
+	}{
+		{program: "#n\n# Print lines with x\n/x/p", output: "#n\n# Print lines with x\n/x/ p\n"},
+		{program: "s|a/b|c|;p # print it\n# done", output: "s/a\\/b/c/\np # print it\n# done\n"},
+		{program: "/a/{ # start\n  h;x\n# inner\n} # end", output: "/a/ { # start\n  h\n  x\n  # inner\n} # end\n"},
+		{program: "# loop\n:top # here\ns/x/y/;t top # again\nw out", output: "# loop\n:top\n# here\ns/x/y/\nt top\n# again\nw out\n"},
+		{program: ":a\n# between\n:b\np", output: ":a\n# between\n:b\np\n"},
+	}
+
+	for i, tt := range tests {
+		p := New(tt.program)
+		program := p.ParseProgram()
+		if len(p.errors) > 0 {
+			t.Errorf("Program [%d] %q encountered errors %v", i, tt.program, p.errors)
+			continue
+		}
+		if out := program.Format(); out != tt.output {
+			t.Errorf("Program [%d] %q formatted incorrectly.\n Expected:\n%s\n Got:\n%s", i, tt.program, tt.output, out)
+		}
+	}
+}
+
+func TestFormatPOSIX(t *testing.T) {
+	tests := []struct {
+		program string
+		dialect Dialect
+		output  string
+	}{
+		{program: "1a\\\nafter", dialect: POSIX, output: "1 a\\\nafter\n"},
+		{program: "i\\\none\\\ntwo\n$c\\\n  indented", dialect: POSIX, output: "i\\\none\\\ntwo\n$ c\\\n  indented\n"},
+		{program: "/x/{\na\\\nback\\\\slash\np\n}", dialect: POSIX, output: "/x/ {\n  a\\\nback\\\\slash\n  p\n}\n"},
+		{program: "1i\\\n   before", dialect: BSD, output: "1 i\\\nbefore\n"},
+		{program: "1a\\\n\n$c\\", dialect: POSIX, output: "1 a\\\n\n$ c\\"},
+	}
+
+	for i, tt := range tests {
+		p := NewWithOptions(tt.program, ParseOptions{Dialect: tt.dialect})
+		program := p.ParseProgram()
+		if len(p.errors) > 0 {
+			t.Errorf("Program [%d] %q encountered errors %v", i, tt.program, p.errors)
+			continue
+		}
+		out := program.Format()
+		if out != tt.output {
+			t.Errorf("Program [%d] %q formatted incorrectly.\n Expected:\n%s\n Got:\n%s", i, tt.program, tt.output, out)
+		}
+		p = NewWithOptions(out, ParseOptions{Dialect: tt.dialect})
+		reparsed := p.ParseProgram()
+		if len(p.errors) > 0 || reparsed.String() != program.String() {
+			t.Errorf("Program [%d] %q did not read back.\n Errors: %v\n Got:\n%s", i, tt.program, p.errors, reparsed.String())
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package ast
+
+// raceEnabled reports whether the tests run with the race detector, which
+// makes allocation counts meaningless.
+const raceEnabled = true
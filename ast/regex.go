@@ -0,0 +1,594 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// regexFlags are the modifiers that may follow an address regex (I, M) or
+// be given to the s command (i/I, m/M).
+type regexFlags struct {
+	icase     bool // Match without regard to case.
+	multiline bool // ^ and $ match around embedded newlines; . does not match one.
+}
+
+// compileRegexp compiles a sed regular expression, basic or extended, into
+// a Go regexp with the same meaning. Like POSIX regexps the result prefers
+// the leftmost-longest match. Expressions with back-references get a
+// backtracker instead, and those that only match a few fixed strings a
+// literalPattern.
+func compileRegexp(src string, extended bool, flags regexFlags, dialect Dialect) (pattern, error) {
+	expr, backrefs, err := translateRegexp(src, extended, dialect)
+	if err != nil {
+		return nil, err
+	}
+	prefix := "(?s)"
+	if flags.multiline {
+		prefix = "(?m)"
+	}
+	if flags.icase {
+		prefix += "(?i)"
+	}
+	if backrefs {
+		bt, err := newBacktracker(prefix + expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %v", src, err)
+		}
+		return bt, nil
+	}
+	re, err := compileGo(prefix + expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %v", src, err)
+	}
+	return re, nil
+}
+
+// regexTranslator rewrites a POSIX regular expression in the syntax of Go's
+// regexp package, one token at a time.
+type regexTranslator struct {
+	src      string
+	pos      int
+	extended bool
+	dialect  Dialect
+	out      bytes.Buffer
+	atStart  bool // A '*' here is literal and a '^' is an anchor.
+	groups   int  // The number of groups opened so far.
+	backrefs bool // Whether the expression refers back to a group.
+
+	atom     int   // Where the last atom starts in out.
+	repeated bool  // Whether the last token repeated that atom.
+	stacked  bool  // Whether the token before the one being read did.
+	opens    []int // Where the groups still open start in out.
+}
+
+// translateRegexp returns the Go regexp syntax for the sed regular
+// expression src. GNU extensions are only accepted in the GNU dialect.
+// Go's syntax has no back-references, so they are written as private use
+// runes for the backtracker, and backrefs reports whether there are any.
+func translateRegexp(src string, extended bool, dialect Dialect) (expr string, backrefs bool, err error) {
+	t := &regexTranslator{src: src, extended: extended, dialect: dialect, atStart: true}
+	for t.pos < len(t.src) {
+		if err := t.token(); err != nil {
+			return "", false, err
+		}
+	}
+	return t.out.String(), t.backrefs, nil
+}
+
+func (t *regexTranslator) gnu(construct string) error {
+	if t.dialect != GNU {
+		return extensionError(construct+" in a regular expression", t.dialect)
+	}
+	return nil
+}
+
+// token translates the next token of the source, keeping track of the
+// atom a repetition operator after it would repeat.
+func (t *regexTranslator) token() error {
+	at, open := t.out.Len(), len(t.opens)
+	t.stacked, t.repeated = t.repeated, false
+	if err := t.translateToken(); err != nil {
+		return err
+	}
+	if !t.repeated && len(t.opens) >= open {
+		// A group that was closed set the atom to the whole group.
+		t.atom = at
+	}
+	return nil
+}
+
+// repeat writes the repetition operator op for the last atom. Go rejects
+// an operator straight after another and takes ? after one to make it
+// lazy, so a repeated atom is grouped before it is repeated again. Like
+// GNU sed, a basic regexp only lets \+ and \? repeat it again.
+func (t *regexTranslator) repeat(op string) error {
+	if t.stacked {
+		if !t.extended && op != "+" && op != "?" {
+			return fmt.Errorf("invalid preceding regular expression in %q", t.src)
+		}
+		atom := t.out.String()[t.atom:]
+		t.out.Truncate(t.atom)
+		t.out.WriteString("(?:" + atom + ")")
+	}
+	t.out.WriteString(op)
+	t.repeated = true
+	return nil
+}
+
+func (t *regexTranslator) translateToken() error {
+	c := t.src[t.pos]
+	t.pos++
+	start := t.atStart
+	t.atStart = false
+	switch c {
+	case '\\':
+		return t.escape(start)
+	case '[':
+		if t.dialect == BSD && (strings.HasPrefix(t.src[t.pos:], "[:<:]]") || strings.HasPrefix(t.src[t.pos:], "[:>:]]")) {
+			// The BSD start and end of word classes.
+			t.pos += len("[:<:]]")
+			t.out.WriteString(`\b`)
+			return nil
+		}
+		return t.bracket()
+	case '.':
+		t.out.WriteByte('.')
+	case '*':
+		if start {
+			t.out.WriteString(`\*`)
+		} else {
+			return t.repeat("*")
+		}
+	case '^':
+		if start || t.extended {
+			t.out.WriteByte('^')
+			t.atStart = true
+		} else {
+			t.out.WriteString(`\^`)
+		}
+	case '$':
+		if t.extended || t.atGroupEnd() {
+			t.out.WriteByte('$')
+		} else {
+			t.out.WriteString(`\$`)
+		}
+	case '(', ')', '|', '+', '?', '{':
+		if !t.extended {
+			t.out.WriteString(regexp.QuoteMeta(string(c)))
+			break
+		}
+		return t.operator(c, start)
+	default:
+		// Copy the whole character so multi-byte runes stay intact.
+		t.pos--
+		r, w := utf8.DecodeRuneInString(t.src[t.pos:])
+		t.pos += w
+		t.out.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	return nil
+}
+
+// atGroupEnd reports whether a '$' just read ends the expression, a group
+// or an alternative, where it is an anchor in a basic regexp.
+func (t *regexTranslator) atGroupEnd() bool {
+	rest := t.src[t.pos:]
+	return rest == "" || strings.HasPrefix(rest, `\)`) || strings.HasPrefix(rest, `\|`)
+}
+
+// operator translates the grouping, alternation and repetition operators
+// of an extended regexp, or their escaped forms in a basic one.
+func (t *regexTranslator) operator(c byte, start bool) error {
+	switch c {
+	case '(':
+		t.opens = append(t.opens, t.out.Len())
+		t.out.WriteByte('(')
+		t.atStart = true
+		t.groups++
+	case ')':
+		if n := len(t.opens); n > 0 {
+			t.atom, t.opens = t.opens[n-1], t.opens[:n-1]
+		}
+		t.out.WriteByte(')')
+	case '|':
+		t.out.WriteByte('|')
+		t.atStart = true
+	case '+', '?':
+		if start {
+			t.out.WriteString(regexp.QuoteMeta(string(c)))
+		} else {
+			return t.repeat(string(c))
+		}
+	case '{':
+		return t.interval(start)
+	}
+	return nil
+}
+
+// interval translates a repetition count such as {2,5}. In a basic regexp
+// it is written \{2,5\}.
+func (t *regexTranslator) interval(start bool) error {
+	end := "}"
+	if !t.extended {
+		end = `\}`
+	}
+	idx := strings.Index(t.src[t.pos:], end)
+	if idx == -1 {
+		if t.extended {
+			t.out.WriteString(`\{`)
+			return nil
+		}
+		return fmt.Errorf("unmatched \\{ in regular expression %q", t.src)
+	}
+	body := t.src[t.pos : t.pos+idx]
+	if !validInterval(body) {
+		if t.extended {
+			t.out.WriteString(`\{`)
+			return nil
+		}
+		return fmt.Errorf("invalid content of \\{\\} in regular expression %q", t.src)
+	}
+	t.pos += idx + len(end)
+	if start {
+		return fmt.Errorf("invalid preceding regular expression in %q", t.src)
+	}
+	if strings.HasPrefix(body, ",") {
+		body = "0" + body
+	}
+	return t.repeat("{" + body + "}")
+}
+
+func validInterval(body string) bool {
+	parts := strings.Split(body, ",")
+	if len(parts) > 2 || (parts[0] == "" && (len(parts) == 1 || parts[1] == "")) {
+		return false
+	}
+	for _, p := range parts {
+		for _, r := range p {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// escape translates the character following a backslash.
+func (t *regexTranslator) escape(start bool) error {
+	if t.pos >= len(t.src) {
+		return fmt.Errorf("trailing backslash in regular expression %q", t.src)
+	}
+	c := t.src[t.pos]
+	t.pos++
+	switch c {
+	case '(', ')', '{', '|', '+', '?':
+		if t.extended {
+			t.out.WriteString(regexp.QuoteMeta(string(c)))
+			break
+		}
+		if c == '|' || c == '+' || c == '?' {
+			if err := t.gnu(`\` + string(c)); err != nil {
+				return err
+			}
+		}
+		return t.operator(c, start)
+	case '}':
+		if t.extended {
+			t.out.WriteString(`\}`)
+			break
+		}
+		return fmt.Errorf("unmatched \\} in regular expression %q", t.src)
+	case '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		n := int(c - '0')
+		if n > t.groups {
+			return fmt.Errorf("invalid reference \\%d in regular expression %q", n, t.src)
+		}
+		t.backrefs = true
+		fmt.Fprintf(&t.out, `\x{%x}`, backrefBase+n)
+	case 'n':
+		t.out.WriteString(`\n`)
+	case 'a', 'f', 'r', 't', 'v':
+		if err := t.gnu(`\` + string(c)); err != nil {
+			return err
+		}
+		t.out.WriteString(`\` + string(c))
+	case 'w', 'W', 's', 'S', 'b', 'B':
+		if err := t.gnu(`\` + string(c)); err != nil {
+			return err
+		}
+		t.out.WriteString(`\` + string(c))
+	case '<', '>':
+		if err := t.gnu(`\` + string(c)); err != nil {
+			return err
+		}
+		// Go has no start or end of word assertions; a word boundary is
+		// the nearest it can express.
+		t.out.WriteString(`\b`)
+	case '`':
+		if err := t.gnu("\\`"); err != nil {
+			return err
+		}
+		t.out.WriteString(`\A`)
+	case '\'':
+		if err := t.gnu(`\'`); err != nil {
+			return err
+		}
+		t.out.WriteString(`\z`)
+	case 'c', 'd', 'o', 'x':
+		if err := t.gnu(`\` + string(c)); err != nil {
+			return err
+		}
+		r, err := t.charEscape(c)
+		if err != nil {
+			return err
+		}
+		t.out.WriteString(regexp.QuoteMeta(string(r)))
+	default:
+		t.pos--
+		r, w := utf8.DecodeRuneInString(t.src[t.pos:])
+		t.pos += w
+		t.out.WriteString(regexp.QuoteMeta(string(r)))
+	}
+	return nil
+}
+
+// charEscape reads the rest of a GNU character escape: \cX, \dNNN, \oNNN
+// or \xHH.
+func (t *regexTranslator) charEscape(kind byte) (rune, error) {
+	r, n, err := parseCharEscape(kind, t.src[t.pos:])
+	if err != nil {
+		return 0, fmt.Errorf("%v in regular expression %q", err, t.src)
+	}
+	t.pos += n
+	return r, nil
+}
+
+// parseCharEscape decodes the argument of a \c, \d, \o or \x escape at the
+// start of s and returns the character and the number of bytes used.
+func parseCharEscape(kind byte, s string) (rune, int, error) {
+	if kind == 'c' {
+		if s == "" {
+			return 0, 0, fmt.Errorf("stray \\c")
+		}
+		return rune(unicode.ToUpper(rune(s[0])) ^ 0x40), 1, nil
+	}
+	base, digits, valid := 10, 3, "0123456789"
+	switch kind {
+	case 'o':
+		base, valid = 8, "01234567"
+	case 'x':
+		base, digits, valid = 16, 2, "0123456789abcdefABCDEF"
+	}
+	n := 0
+	for n < len(s) && n < digits && strings.IndexByte(valid, s[n]) != -1 {
+		n++
+	}
+	if n == 0 {
+		return rune(kind), 0, nil
+	}
+	v, err := strconv.ParseUint(s[:n], base, 8)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid \\%c escape", kind)
+	}
+	return rune(v), n, nil
+}
+
+// gnuEscape decodes the GNU character escape at the start of s, which
+// follows a backslash: \a, \f, \n, \r, \t, \v, \cX, \dNNN, \oNNN or \xHH.
+// It returns the character and the number of bytes of s used.
+func gnuEscape(s string) (string, int, bool) {
+	switch s[0] {
+	case 'a':
+		return "\a", 1, true
+	case 'f':
+		return "\f", 1, true
+	case 'n':
+		return "\n", 1, true
+	case 'r':
+		return "\r", 1, true
+	case 't':
+		return "\t", 1, true
+	case 'v':
+		return "\v", 1, true
+	case 'c', 'd', 'o', 'x':
+		r, n, err := parseCharEscape(s[0], s[1:])
+		if err != nil {
+			return "", 0, false
+		}
+		return string(r), n + 1, true
+	}
+	return "", 0, false
+}
+
+// bracket translates a bracket expression such as [^a-z[:digit:]].
+func (t *regexTranslator) bracket() error {
+	t.out.WriteByte('[')
+	if t.pos < len(t.src) && t.src[t.pos] == '^' {
+		t.out.WriteByte('^')
+		t.pos++
+	}
+	first := true
+	for t.pos < len(t.src) {
+		c := t.src[t.pos]
+		switch {
+		case c == ']' && !first:
+			t.pos++
+			t.out.WriteByte(']')
+			return nil
+		case c == '[' && t.pos+1 < len(t.src) && strings.IndexByte(":.=", t.src[t.pos+1]) != -1:
+			kind := t.src[t.pos+1]
+			end := strings.Index(t.src[t.pos+2:], string(kind)+"]")
+			if end == -1 {
+				return fmt.Errorf("unterminated [%c in regular expression %q", kind, t.src)
+			}
+			name := t.src[t.pos+2 : t.pos+2+end]
+			t.pos += end + 4
+			if kind == ':' {
+				t.out.WriteString("[:" + name + ":]")
+			} else {
+				t.out.WriteString(regexp.QuoteMeta(name))
+			}
+		case c == '\\' && t.pos+1 < len(t.src):
+			// Inside brackets a backslash is an ordinary character to
+			// POSIX; GNU sed reads the usual escapes first.
+			next := t.src[t.pos+1]
+			switch {
+			case next == 'n':
+				t.out.WriteString(`\n`)
+				t.pos += 2
+			case next == '\\' && t.dialect == GNU:
+				t.out.WriteString(`\\`)
+				t.pos += 2
+			case strings.IndexByte("aftrv", next) != -1 && t.dialect == GNU:
+				t.out.WriteString(`\` + string(next))
+				t.pos += 2
+			default:
+				t.out.WriteString(`\\`)
+				t.pos++
+			}
+		default:
+			r, w := utf8.DecodeRuneInString(t.src[t.pos:])
+			t.pos += w
+			if r == '[' || r == ']' {
+				t.out.WriteByte('\\')
+			}
+			t.out.WriteRune(r)
+		}
+		first = false
+	}
+	return fmt.Errorf("unterminated [ in regular expression %q", t.src)
+}
+
+// replacement is the parsed replacement of an s command.
+type replacement []replacePart
+
+// replacePart is a piece of a replacement: literal text, a group of the
+// match, or a GNU case conversion (\L, \U, \E, \l or \u).
+type replacePart struct {
+	literal string
+	group   int  // The group to insert, or -1.
+	caseOp  byte // The case conversion letter, if any.
+}
+
+// parseReplacement parses the replacement of an s command. & and \0
+// stand for the whole match and \1 to \9 for its groups.
+func parseReplacement(src string, dialect Dialect) (replacement, error) {
+	var rep replacement
+	var lit bytes.Buffer
+	flush := func() {
+		if lit.Len() > 0 {
+			rep = append(rep, replacePart{literal: lit.String(), group: -1})
+			lit.Reset()
+		}
+	}
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '&':
+			flush()
+			rep = append(rep, replacePart{group: 0})
+		case c == '\\' && i+1 < len(src):
+			i++
+			c = src[i]
+			switch {
+			case c >= '0' && c <= '9':
+				flush()
+				rep = append(rep, replacePart{group: int(c - '0')})
+			case c == 'n':
+				lit.WriteByte('\n')
+			case c == '\n':
+				lit.WriteByte('\n')
+			case strings.IndexByte("LUElu", c) != -1:
+				if dialect != GNU {
+					return nil, extensionError(`\`+string(c)+" in a replacement", dialect)
+				}
+				flush()
+				rep = append(rep, replacePart{group: -1, caseOp: c})
+			case strings.IndexByte("aftrv", c) != -1:
+				if dialect != GNU {
+					return nil, extensionError(`\`+string(c)+" in a replacement", dialect)
+				}
+				lit.WriteByte(map[byte]byte{'a': '\a', 'f': '\f', 't': '\t', 'r': '\r', 'v': '\v'}[c])
+			case strings.IndexByte("cdox", c) != -1:
+				if dialect != GNU {
+					return nil, extensionError(`\`+string(c)+" in a replacement", dialect)
+				}
+				r, n, err := parseCharEscape(c, src[i+1:])
+				if err != nil {
+					return nil, fmt.Errorf("%v in replacement %q", err, src)
+				}
+				lit.WriteRune(r)
+				i += n
+			default:
+				lit.WriteByte(c)
+			}
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+	return rep, nil
+}
+
+// maxGroup returns the highest group the replacement refers to.
+func (rep replacement) maxGroup() int {
+	max := 0
+	for _, part := range rep {
+		if part.group > max {
+			max = part.group
+		}
+	}
+	return max
+}
+
+// expand appends the replacement for a match of src to dst and returns
+// the result. match holds the group offsets as returned by
+// FindSubmatchIndex.
+func (rep replacement) expand(dst, src []byte, match []int) []byte {
+	var mode, once byte // The active \L/\U conversion and a pending \l/\u.
+	write := func(s []byte) {
+		for len(s) > 0 && (mode != 0 || once != 0) {
+			r, n := utf8.DecodeRune(s)
+			switch {
+			case r == utf8.RuneError && n == 1:
+				dst = append(dst, s[0])
+				s = s[1:]
+				once = 0
+				continue
+			case once == 'u':
+				r = unicode.ToUpper(r)
+			case once == 'l':
+				r = unicode.ToLower(r)
+			case mode == 'U':
+				r = unicode.ToUpper(r)
+			case mode == 'L':
+				r = unicode.ToLower(r)
+			}
+			once = 0
+			dst = append(dst, string(r)...)
+			s = s[n:]
+		}
+		dst = append(dst, s...)
+	}
+	for _, part := range rep {
+		switch {
+		case part.caseOp == 'l' || part.caseOp == 'u':
+			once = part.caseOp
+		case part.caseOp == 'E':
+			mode, once = 0, 0
+		case part.caseOp != 0:
+			mode, once = part.caseOp, 0
+		case part.group >= 0:
+			if 2*part.group+1 < len(match) && match[2*part.group] >= 0 {
+				write(src[match[2*part.group]:match[2*part.group+1]])
+			}
+		default:
+			write([]byte(part.literal))
+		}
+	}
+	return dst
+}
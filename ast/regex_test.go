@@ -0,0 +1,152 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranslateRegexp(t *testing.T) {
+	tests := []struct {
+		src      string
+		extended bool
+		expr     string
+	}{
+		{src: `a*b`, expr: `a*b`},
+		{src: `*a`, expr: `\*a`},
+		{src: `^*a`, expr: `^\*a`},
+		{src: `a^b$c$`, expr: `a\^b\$c$`},
+		{src: `\(ab\)\{2,\}`, expr: `(ab){2,}`},
+		{src: `a\{,3\}`, expr: `a{0,3}`},
+		{src: `(a|b)+?`, expr: `\(a\|b\)\+\?`},
+		{src: `\(a\|b\)\+`, expr: `(a|b)+`},
+		{src: `(a|b)+`, extended: true, expr: `(a|b)+`},
+		{src: `\(a\)`, extended: true, expr: `\(a\)`},
+		{src: `x{2}y{`, extended: true, expr: `x{2}y\{`},
+		{src: `a+?`, extended: true, expr: `(?:a+)?`},
+		{src: `x*+y`, extended: true, expr: `(?:x*)+y`},
+		{src: `(ab)+*`, extended: true, expr: `(?:(ab)+)*`},
+		{src: `a{2}{3}`, extended: true, expr: `(?:a{2}){3}`},
+		{src: `a*?+`, extended: true, expr: `(?:(?:a*)?)+`},
+		{src: `(a(b)*?)c`, extended: true, expr: `(a(?:(b)*)?)c`},
+		{src: `a*\?\+`, expr: `(?:(?:a*)?)+`},
+		{src: `a\.b\n\t`, expr: `a\.b\n\t`},
+		{src: `[]a-z[:digit:]]`, expr: `[\]a-z[:digit:]]`},
+		{src: `[^\n.]`, expr: `[^\n.]`},
+		{src: `\<\w\+\>`, expr: `\b\w+\b`},
+		{src: `\x41\o102\d067\cd`, expr: "ABC\x04"},
+	}
+
+	for i, tt := range tests {
+		expr, backrefs, err := translateRegexp(tt.src, tt.extended, GNU)
+		if err != nil {
+			t.Errorf("Regexp [%d] %q: unexpected error %v", i, tt.src, err)
+			continue
+		}
+		if expr != tt.expr || backrefs {
+			t.Errorf("Regexp [%d] %q: expected %q, got %q (back-references %v)", i, tt.src, tt.expr, expr, backrefs)
+		}
+	}
+
+	for _, src := range []string{`a\{2`, `[abc`, `\(a\)\2`, `a\`, `a**`, `a\+\{2\}`} {
+		if _, _, err := translateRegexp(src, false, GNU); err == nil {
+			t.Errorf("Regexp %q: expected an error", src)
+		}
+	}
+}
+
+func TestBacktracker(t *testing.T) {
+	tests := []struct {
+		src     string
+		input   string
+		matches [][]int
+	}{
+		{src: `\(a*\)b\1`, input: "aabaa-ab", matches: [][]int{{0, 5, 0, 2}, {7, 8, 7, 7}}},
+		{src: `\(.\)\1`, input: "abbcdd", matches: [][]int{{1, 3, 1, 2}, {4, 6, 4, 5}}},
+		{src: `^\(.*\)\n\1$`, input: "one\none", matches: [][]int{{0, 7, 0, 3}}},
+		{src: `^\(.*\)\n\1$`, input: "one\ntwo", matches: nil},
+		{src: `\([ab]\)x*\1`, input: "axxa bxb ab", matches: [][]int{{0, 4, 0, 1}, {5, 8, 5, 6}}},
+		// The longest match, not the first found, as GNU finds.
+		{src: `x\(a\|ab\)\1*`, input: "xabab", matches: [][]int{{0, 5, 1, 3}}},
+		{src: `\(a\|ab\)\(b*\)\1`, input: "abab", matches: [][]int{{0, 4, 0, 2, 2, 2}}},
+		{src: `\(a\|b\)*\1`, input: "aab", matches: [][]int{{0, 2, 0, 1}}},
+		// GNU matches "ba", repeating the group once more on empty text.
+		{src: `b\(a*\)*\1`, input: "bab", matches: nil},
+		{src: `b\(a*\)*\1`, input: "baa", matches: [][]int{{0, 3, 1, 2}}},
+	}
+
+	for i, tt := range tests {
+		re, err := compileRegexp(tt.src, false, regexFlags{}, GNU)
+		if err != nil {
+			t.Errorf("Regexp [%d] %q: unexpected error %v", i, tt.src, err)
+			continue
+		}
+		if _, ok := re.(*backtracker); !ok {
+			t.Errorf("Regexp [%d] %q: expected a backtracker, got %T", i, tt.src, re)
+		}
+		got := re.FindAllStringSubmatchIndex(tt.input, -1)
+		if len(got) != len(tt.matches) {
+			t.Errorf("Regexp [%d] %q on %q: expected matches %v, got %v", i, tt.src, tt.input, tt.matches, got)
+			continue
+		}
+		for j := range got {
+			if len(got[j]) != len(tt.matches[j]) {
+				t.Errorf("Regexp [%d] %q on %q: expected matches %v, got %v", i, tt.src, tt.input, tt.matches, got)
+				break
+			}
+			for k := range got[j] {
+				if got[j][k] != tt.matches[j][k] {
+					t.Errorf("Regexp [%d] %q on %q: expected matches %v, got %v", i, tt.src, tt.input, tt.matches, got)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestBacktrackLimit(t *testing.T) {
+	tests := []struct {
+		src   string
+		input string
+	}{
+		{`\(a*\)*b\1`, strings.Repeat("a", 30)},
+		{`\(.\)*x\1`, strings.Repeat("a", 2*maxBacktrackDepth)},
+	}
+	for i, tt := range tests {
+		re, err := compileRegexp(tt.src, false, regexFlags{}, GNU)
+		if err != nil {
+			t.Fatalf("Regexp [%d] %q: unexpected error %v", i, tt.src, err)
+		}
+		if _, err := re.(*backtracker).findAll(nil, tt.input, -1); err != errBacktrackLimit {
+			t.Errorf("Regexp [%d] %q: expected errBacktrackLimit, got %v", i, tt.src, err)
+		}
+	}
+}
+
+func TestReplacement(t *testing.T) {
+	tests := []struct {
+		program string
+		input   string
+		output  string
+	}{
+		{program: `s/\(.\)\(.\)/\2\1/`, input: "abcd", output: "bacd"},
+		{program: `s/b/[&][\&]/`, input: "abc", output: "a[b][&]c"},
+		{program: `s/\w\+/\u&/g`, input: "hello big world", output: "Hello Big World"},
+		{program: `s/\(.*\) \(.*\)/\U\1\E \L\2/`, input: "one TWO", output: "ONE two"},
+		{program: `s/ /\n/`, input: "a b", output: "a\nb"},
+		{program: `s/a/x/3g`, input: "aaaaa", output: "aaxxx"},
+		{program: `s/a/b/9`, input: "aaaaa", output: "aaaaa"},
+		{program: `/x/s//y/g`, input: "axbx", output: "ayby"},
+	}
+
+	for i, tt := range tests {
+		p := New(tt.program)
+		program := p.ParseProgram()
+		if len(p.errors) > 0 {
+			t.Errorf("Program [%d] %s encountered errors %v", i, tt.program, p.errors)
+			continue
+		}
+		if out, _ := program.Run(tt.input, RuntimeOptions{AutoPrint: true}); out != tt.output {
+			t.Errorf("Program [%d] %s: expected %q, got %q", i, tt.program, tt.output, out)
+		}
+	}
+}
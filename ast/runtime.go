@@ -1,6 +1,9 @@
 package ast
 
 import (
+	"bytes"
+	"context"
+	"sort"
 	"strings"
 )
 
@@ -10,8 +13,8 @@ type directives struct {
 	restartScript bool // Used for the 'D' command
 	quitCmd       bool
 	quitNoPattern bool
-	runBlock      *Program
-	jumpTo        string
+	lastLine      bool // N found no next line to append.
+	branch        bool // Jump to the target of the running command.
 }
 
 type runtime struct {
@@ -24,103 +27,493 @@ type runtime struct {
 	output       string
 	directives   directives
 	subMade      bool
+	lineDelim    string // Separates input lines and ends printed pattern spaces.
+	tracer       Tracer
+	depth        int // Block nesting level of the running statements.
+	lineLength   int // The wrap length of the l command.
+	dialect      Dialect
+	lastRegexp   pattern
+	ranges       map[*rangeAddress]*rangeState
+	exitCode     int
+	files        []InputFile
+	matchErr     error           // Why a backtracker gave up matching.
+	ctx          context.Context // Stops backtracking matches once done.
+}
+
+// regexp returns the regexp to match with, recording it as the last one
+// used. A nil re stands for the empty regexp, which matches with the last
+// regexp used instead; it returns nil if there is none.
+func (r *runtime) regexp(re pattern) pattern {
+	if re == nil {
+		return r.lastRegexp
+	}
+	r.lastRegexp = re
+	return re
+}
+
+// match reports whether re matches the pattern space, recording why if
+// a backtracker gives up.
+func (r *runtime) match(re pattern) bool {
+	bt, ok := re.(*backtracker)
+	if !ok {
+		return re.MatchString(r.patternSpace)
+	}
+	matches, err := bt.findAll(r.ctx, r.patternSpace, 1)
+	if err != nil {
+		r.matchErr = err
+	}
+	return len(matches) > 0
+}
+
+// findAll returns all the matches of re in the pattern space, recording
+// why if a backtracker gives up.
+func (r *runtime) findAll(re pattern) [][]int {
+	bt, ok := re.(*backtracker)
+	if !ok {
+		return re.FindAllStringSubmatchIndex(r.patternSpace, -1)
+	}
+	matches, err := bt.findAll(r.ctx, r.patternSpace, -1)
+	if err != nil {
+		r.matchErr = err
+	}
+	return matches
+}
+
+// rangeState returns the state of the range address a in this run.
+func (r *runtime) rangeState(a *rangeAddress) *rangeState {
+	state, ok := r.ranges[a]
+	if !ok {
+		state = &rangeState{on: a.startsBeforeInput()}
+		r.ranges[a] = state
+	}
+	return state
 }
 
 type RuntimeOptions struct {
-	AllowExec      bool
-	AutoPrint      bool
-	AppendFile     bool
-	DefaultRuntime *runtime
-	IsBlock        bool
-	LineNoStart    int
+	AllowExec   bool
+	AutoPrint   bool
+	AppendFile  bool
+	LineNoStart int
+	NullData    bool   // Lines are separated by NUL characters instead of newlines.
+	Tracer      Tracer // Receives each step of the run when set.
+	// LineLength is the length the l command wraps lines at. Zero selects
+	// the dialect's default and a negative length never wraps.
+	LineLength int
+	Dialect    Dialect // The dialect whose output format l follows.
+	// Context stops the run with a *LimitError once it is done.
+	Context context.Context
+	Limits  Limits // Stop the run with a *LimitError once exceeded.
+	// Parallelism is the most goroutines a run splits a long input
+	// between when the program is LineIndependent and Limits.Commands,
+	// which counts across lines, is not set. One or less runs on the
+	// calling goroutine alone.
+	Parallelism int
+	// Files are the files the input was read from, in order, for F to
+	// print the name of the one holding the current line. Without them,
+	// and past their lines, F prints "-", the name of the standard input.
+	Files []InputFile
 }
 
-func (p *Program) Run(text string, options RuntimeOptions) string {
-	// TODO: Setup runtime flags
-	// Create runtime
-	r := &runtime{
-		program: p,
-		lines:   strings.Split(text, "\n"),
+// InputFile is one of the files the input of a run was read from.
+type InputFile struct {
+	Name  string // The name F prints.
+	Lines int    // The number of lines of the input read from the file.
+}
+
+// fileName returns the name of the file of files holding the input line
+// numbered lineNo, counting from 1.
+func fileName(files []InputFile, lineNo int) string {
+	for _, f := range files {
+		if lineNo <= f.Lines {
+			return f.Name
+		}
+		lineNo -= f.Lines
 	}
-	if options.DefaultRuntime != nil {
-		r = options.DefaultRuntime
-		r.directives = directives{}
+	return "-"
+}
+
+// Run runs the program over text and returns the output. If the run is
+// stopped by one of its limits it returns the output so far and a
+// *LimitError. The program is run by the bytecode VM unless it is traced,
+// which needs a Machine.
+func (p *Program) Run(text string, options RuntimeOptions) (string, error) {
+	if options.Tracer == nil {
+		out, err := p.RunBytes([]byte(text), options)
+		return string(out), err
+	}
+	m := p.NewMachine(text, options)
+	for m.Step() {
 	}
+	return strings.TrimSuffix(m.Output(), m.r.lineDelim), m.Err()
+}
 
-	retStr := ""
+// RunBytes runs the program over data as Run does, without converting
+// data or the output to strings. data is not changed, and the output
+// does not share memory with it.
+func (p *Program) RunBytes(data []byte, options RuntimeOptions) ([]byte, error) {
+	out, _, err := p.run(data, options)
+	return bytes.TrimSuffix(out, []byte{options.lineDelim()}), err
+}
 
-lineLoop:
-	for r.lineNo = options.LineNoStart; r.lineNo < len(r.lines); r.lineNo++ {
-		r.output = ""
-		r.patternSpace = r.lines[r.lineNo]
-		r.subMade = false
-		pc := 0
-		for pc < len(p.Statements) {
-			s := p.Statements[pc]
-			match := s.Address(r)
-			if !match {
-				pc++
-				continue
-			}
-			s.Run(r)
-			if r.directives.nextCmd {
-				// Proceed to the next line printing out current pattern space.
-				r.directives.nextCmd = false
-				if options.AutoPrint {
-					r.output += r.patternSpace + "\n"
-				}
-				retStr += r.output
-				continue lineLoop
-			} else if r.directives.deleteCmd {
-				// Proceed to the next line not printing out current pattern space.
-				r.directives.deleteCmd = false
-				retStr += r.output
-				continue lineLoop
-			} else if r.directives.quitCmd {
-				r.directives.quitCmd = false
-				// Quit the program with the rest of the pattern space.
-				retStr += r.output
-				retStr += r.patternSpace
-				return retStr
-			} else if r.directives.quitNoPattern {
-				r.directives.quitNoPattern = false
-				// Quit the program with the rest of the output.
-				retStr += r.output
-				return retStr
-			} else if r.directives.runBlock != nil {
-				opt := options
-				opt.DefaultRuntime = r
-				opt.IsBlock = true
-				opt.LineNoStart = r.lineNo
-
-				r.output = r.directives.runBlock.Run("", opt)
-
-			} else if r.directives.jumpTo != "" {
-				label := r.directives.jumpTo
-				r.directives.jumpTo = ""
-				pc = p.Labels[label]
-				continue
-			} else if r.directives.restartScript {
-				r.directives.restartScript = false
-				pc = 0
-				continue
-			}
-			pc++
+// RunFile runs the program over data, the contents of a file, as sed
+// does. Unlike with Run, a final line delimiter ends the last line rather
+// than starting an empty one, and the output ends with a delimiter unless
+// data doesn't. An empty file has no lines to run the program over. quit
+// reports whether a q or Q command ended the run, after which sed reads
+// no more files.
+func (p *Program) RunFile(data []byte, options RuntimeOptions) (out []byte, quit bool, err error) {
+	if len(data) == 0 {
+		return nil, false, nil
+	}
+	delim := options.lineDelim()
+	terminated := data[len(data)-1] == delim
+	if terminated {
+		data = data[:len(data)-1]
+	}
+	out, quit, err = p.run(data, options)
+	if !terminated {
+		out = bytes.TrimSuffix(out, []byte{delim})
+	}
+	return out, quit, err
+}
+
+// run runs the program over data and returns the output with the
+// delimiter that ends its last line, and whether q or Q ended the run.
+func (p *Program) run(data []byte, options RuntimeOptions) ([]byte, bool, error) {
+	if p.code == nil {
+		// The program was not made by the parser.
+		p.compile()
+	}
+	if options.Tracer != nil {
+		m := p.NewMachine(string(data), options)
+		for m.Step() {
 		}
-		if options.AutoPrint && !options.IsBlock {
-			r.output += r.patternSpace + "\n"
+		return []byte(m.Output()), m.Quit(), m.Err()
+	}
+	if options.Parallelism > 1 && len(data) >= 2*minChunk &&
+		options.Limits.Commands == 0 && p.LineIndependent() {
+		// A line independent program has no q or Q to quit with.
+		out, err := p.runChunks(data, options)
+		return out, false, err
+	}
+	return p.runVM(data, options)
+}
+
+// lineDelim returns the byte that separates lines.
+func (options RuntimeOptions) lineDelim() byte {
+	if options.NullData {
+		return 0
+	}
+	return '\n'
+}
+
+// lineLength returns the length the l command wraps lines at, or 0 if it
+// never wraps them.
+func (options RuntimeOptions) lineLength() int {
+	switch {
+	case options.LineLength < 0:
+		return 0
+	case options.LineLength > 0:
+		return options.LineLength
+	case options.Dialect == BSD:
+		return 60
+	}
+	return 70
+}
+
+// Machine runs a program over its input one command at a time. Between
+// steps the machine rests just before the next command to run, where its
+// pattern and hold spaces can be inspected or changed.
+type Machine struct {
+	r       *runtime
+	options RuntimeOptions
+	code    []instruction
+	pc      int // The next instruction to run.
+	done    bool
+	quit    bool  // Whether q or Q ended the run.
+	err     error // Why the run was stopped early.
+
+	commands      int // Commands run so far.
+	cycleCommands int // Commands run so far in the current cycle.
+}
+
+// NewMachine returns a machine ready to run the first command of the
+// program on the first line of text.
+func (p *Program) NewMachine(text string, options RuntimeOptions) *Machine {
+	delim := "\n"
+	if options.NullData {
+		delim = "\x00"
+	}
+	r := &runtime{
+		program:    p,
+		lines:      strings.Split(text, delim),
+		lineDelim:  delim,
+		tracer:     options.Tracer,
+		lineNo:     options.LineNoStart - 1,
+		ranges:     make(map[*rangeAddress]*rangeState),
+		dialect:    options.Dialect,
+		lineLength: options.lineLength(),
+		ctx:        options.Context,
+		files:      options.Files,
+	}
+	if p.code == nil {
+		// The program was not made by the parser.
+		p.compile()
+	}
+	m := &Machine{r: r, options: options, code: p.code}
+	m.startCycle()
+	m.settle()
+	return m
+}
+
+// Step runs the next command. It returns false once the program has
+// finished, after which Step does nothing.
+func (m *Machine) Step() bool {
+	if m.done {
+		return false
+	}
+	r := m.r
+	in := m.code[m.pc]
+	if err := m.checkContext(in); err != nil {
+		return m.stop(err)
+	}
+	if err := m.countCommand(in); err != nil {
+		return m.stop(err)
+	}
+	r.depth = in.depth
+	match := in.stmt.Address(r)
+	if r.matchErr != nil {
+		return m.stop(m.matchError(in))
+	}
+	r.traceCommand(in.stmt, match)
+	if _, ok := in.stmt.(*blockStmt); ok {
+		if match {
+			m.pc++
+		} else {
+			// Skipping a block jumps to its closing brace.
+			r.traceBlockEnd()
+			m.pc = in.end
 		}
-		retStr += r.output
-		if len(r.appendSpace) > 0 {
-			retStr += r.appendSpace
-			r.appendSpace = ""
+		m.settle()
+		return !m.done
+	}
+	if !match {
+		m.pc++
+		m.settle()
+		return !m.done
+	}
+
+	prevPattern, prevHold := r.patternSpace, r.holdSpace
+	in.stmt.Run(r)
+	if r.matchErr != nil {
+		return m.stop(m.matchError(in))
+	}
+	r.traceStatement(in.stmt, prevPattern, prevHold)
+	if err := m.checkSpace(in); err != nil {
+		return m.stop(err)
+	}
+	d := r.directives
+	r.directives = directives{}
+	switch {
+	case d.nextCmd:
+		if r.lineNo+1 >= len(r.lines) {
+			// Without a next line sed ends as if the script had finished.
+			m.endCycle(true)
+			m.done = true
+			return false
 		}
-		if options.IsBlock {
-			return retStr
+		m.flushCycle(true)
+		r.lineNo++
+		prev := r.patternSpace
+		r.patternSpace = r.lines[r.lineNo]
+		r.traceBuffers(prev, r.holdSpace)
+		m.pc++
+	case d.deleteCmd:
+		m.endCycle(false)
+		m.startCycle()
+	case d.quitCmd:
+		m.endCycle(true)
+		m.done, m.quit = true, true
+	case d.quitNoPattern:
+		m.done, m.quit = true, true
+	case d.lastLine:
+		m.endCycle(r.dialect == GNU)
+		m.done = true
+	case d.branch:
+		m.pc = in.target
+	case d.restartScript:
+		m.flushCycle(false)
+		m.pc = 0
+	default:
+		m.pc++
+	}
+	m.settle()
+	return !m.done
+}
+
+// stop finishes the machine early because of err.
+func (m *Machine) stop(err error) bool {
+	m.err = err
+	m.done = true
+	return false
+}
+
+// settle moves the machine past the closing braces of blocks and the ends
+// of cycles so that it rests before a command, or is done.
+func (m *Machine) settle() {
+	for !m.done {
+		if m.pc < len(m.code) {
+			in := m.code[m.pc]
+			if !in.close {
+				return
+			}
+			m.r.depth = in.depth
+			m.r.traceBlockEnd()
+			m.pc++
+			continue
 		}
+		m.endCycle(true)
+		m.startCycle()
 	}
-	if len(retStr) > 0 && retStr[len(retStr)-1] == '\n' && !options.IsBlock {
-		retStr = retStr[0 : len(retStr)-1]
+}
+
+// startCycle reads the next line into the pattern space and starts the
+// script from the top, or finishes the machine if there is no more input.
+func (m *Machine) startCycle() {
+	r := m.r
+	if m.done || r.lineNo+1 >= len(r.lines) {
+		m.done = true
+		return
+	}
+	r.lineNo++
+	r.patternSpace = r.lines[r.lineNo]
+	r.subMade = false
+	r.depth = 0
+	m.pc = 0
+	m.cycleCommands = 0
+	if r.tracer != nil {
+		r.tracer.StartCycle(r.lineNo+1, r.patternSpace)
+	}
+}
+
+// endCycle finishes the current cycle, printing the pattern space if
+// autoPrint is set and the program has not disabled it.
+func (m *Machine) endCycle(autoPrint bool) {
+	m.r.traceEndCycle()
+	m.flushCycle(autoPrint)
+}
+
+// flushCycle writes the pattern space, when asked to, followed by the
+// text queued by the a command.
+func (m *Machine) flushCycle(autoPrint bool) {
+	r := m.r
+	if autoPrint && m.options.AutoPrint {
+		r.write(r.patternSpace + r.lineDelim)
+	}
+	if len(r.appendSpace) > 0 {
+		r.write(r.appendSpace)
+		r.appendSpace = ""
+	}
+}
+
+// Done reports whether the program has finished.
+func (m *Machine) Done() bool {
+	return m.done
+}
+
+// Err returns the *LimitError that stopped the machine, or nil.
+func (m *Machine) Err() error {
+	return m.err
+}
+
+// Output returns everything the program has written so far.
+func (m *Machine) Output() string {
+	return m.r.output
+}
+
+// Quit reports whether a q or Q command ended the run.
+func (m *Machine) Quit() bool {
+	return m.quit
+}
+
+// ExitCode returns the exit code given to a q or Q command, or 0.
+func (m *Machine) ExitCode() int {
+	return m.r.exitCode
+}
+
+// LineNo returns the number of the last input line read, starting at 1.
+func (m *Machine) LineNo() int {
+	return m.r.lineNo + 1
+}
+
+// LineCount returns the number of lines of input.
+func (m *Machine) LineCount() int {
+	return len(m.r.lines)
+}
+
+// PatternSpace returns the contents of the pattern space.
+func (m *Machine) PatternSpace() string {
+	return m.r.patternSpace
+}
+
+// SetPatternSpace replaces the contents of the pattern space.
+func (m *Machine) SetPatternSpace(ps string) {
+	m.r.patternSpace = ps
+}
+
+// HoldSpace returns the contents of the hold space.
+func (m *Machine) HoldSpace() string {
+	return m.r.holdSpace
+}
+
+// SetHoldSpace replaces the contents of the hold space.
+func (m *Machine) SetHoldSpace(hs string) {
+	m.r.holdSpace = hs
+}
+
+// AtCycleStart reports whether the next command is the first command of
+// the script in a new cycle.
+func (m *Machine) AtCycleStart() bool {
+	return !m.done && m.pc == 0
+}
+
+// Depth returns the block nesting level of the next command.
+func (m *Machine) Depth() int {
+	if m.done {
+		return 0
+	}
+	return m.code[m.pc].depth
+}
+
+// Command returns the canonical form of the next command, or "" if the
+// program has finished.
+func (m *Machine) Command() string {
+	if m.done {
+		return ""
+	}
+	return formatStatement(m.code[m.pc].stmt)
+}
+
+// SourceLine returns the line of the script the next command is on, or 0
+// if the program has finished.
+func (m *Machine) SourceLine() int {
+	if m.done {
+		return 0
+	}
+	return m.code[m.pc].line
+}
+
+// Labels returns the labels placed directly before the next command.
+func (m *Machine) Labels() []string {
+	if m.done {
+		return nil
+	}
+	var labels []string
+	for l, pos := range m.r.program.labels {
+		if pos == m.pc {
+			labels = append(labels, l)
+		}
 	}
-	return retStr
+	sort.Strings(labels)
+	return labels
 }
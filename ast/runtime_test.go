@@ -0,0 +1,112 @@
+package ast
+
+import "testing"
+
+func TestMachine(t *testing.T) {
+	program := "h\n/b/ {\ns/b/B/\nG\n}\n:end\np"
+	p := New(program)
+	prg := p.ParseProgram()
+	if len(p.errors) > 0 {
+		t.Fatalf("Program %s encountered errors %v", program, p.errors)
+	}
+
+	type state struct {
+		lineNo     int
+		sourceLine int
+		command    string
+		pattern    string
+		hold       string
+	}
+	expected := []state{
+		{1, 1, "h", "a", ""},
+		{1, 2, "/b/ {", "a", "a"},
+		{1, 7, "p", "a", "a"},
+		{2, 1, "h", "b", "a"},
+		{2, 2, "/b/ {", "b", "b"},
+		{2, 3, "s/b/B/", "b", "b"},
+		{2, 4, "G", "B", "b"},
+		{2, 7, "p", "B\nb", "b"},
+	}
+
+	m := prg.NewMachine("a\nb", RuntimeOptions{AutoPrint: true})
+	for i, e := range expected {
+		got := state{m.LineNo(), m.SourceLine(), m.Command(), m.PatternSpace(), m.HoldSpace()}
+		if got != e {
+			t.Errorf("Step [%d] incorrect state.\n Expected: %+v\n Got: %+v", i, e, got)
+		}
+		if i == 2 && (len(m.Labels()) != 1 || m.Labels()[0] != "end") {
+			t.Errorf("Step [%d] expected label end, got %v", i, m.Labels())
+		}
+		if !m.Step() && i != len(expected)-1 {
+			t.Fatalf("Step [%d] machine finished early", i)
+		}
+	}
+	if !m.Done() {
+		t.Errorf("Expected machine to be done")
+	}
+	if out := m.Output(); out != "a\na\nB\nb\nB\nb\n" {
+		t.Errorf("Incorrect output %q", out)
+	}
+}
+
+func TestMachineSetBuffers(t *testing.T) {
+	p := New("G")
+	prg := p.ParseProgram()
+	m := prg.NewMachine("one\ntwo", RuntimeOptions{AutoPrint: true})
+	m.SetPatternSpace("ONE")
+	m.SetHoldSpace("held")
+	for m.Step() {
+	}
+	if out := m.Output(); out != "ONE\nheld\ntwo\nheld\n" {
+		t.Errorf("Incorrect output %q", out)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	tests := []struct {
+		program   string
+		autoPrint bool
+		input     string
+		output    string
+		quit      bool
+	}{
+		{"$p", false, "", "", false},
+		{"$p", false, "\n", "\n", false},
+		{"$p", false, "a", "a", false},
+		{"$p", false, "a\n", "a\n", false},
+		{"$p", false, "a\n\n", "\n", false},
+		{"$!N;P;D", true, "a\n\n", "a\n\n", false},
+		{"$!N;P;D", true, "a\nb", "a\nb", false},
+		{"P", false, "a\nb\n", "a\nb\n", false},
+		{"$!N;P;d", false, "a\nb\nc\nd\n", "a\nc\n", false},
+		{"2q", true, "a\nb\nc\n", "a\nb\n", true},
+		{"2Q", true, "a\nb\nc\n", "a\n", true},
+		{"3q", true, "a\nb\n", "a\nb\n", false},
+		{"n;d", true, "a\nb\nc\n", "a\nc\n", false},
+	}
+	for i, tt := range tests {
+		p := New(tt.program)
+		prg := p.ParseProgram()
+		if len(p.errors) > 0 {
+			t.Fatalf("Program [%d] %q encountered errors %v", i, tt.program, p.errors)
+		}
+		for _, tracer := range []Tracer{nil, &nopTracer{}} {
+			out, quit, err := prg.RunFile([]byte(tt.input), RuntimeOptions{AutoPrint: tt.autoPrint, Tracer: tracer})
+			if err != nil || string(out) != tt.output || quit != tt.quit {
+				t.Errorf("Program [%d] %q over %q incorrect.\n  Got: %q, %v, %v\n  Expected: %q, %v", i, tt.program, tt.input, out, quit, err, tt.output, tt.quit)
+			}
+		}
+	}
+}
+
+func TestRunFileNames(t *testing.T) {
+	prg := New("F;$!N;F").ParseProgram()
+	files := []InputFile{{Name: "one", Lines: 1}, {Name: "two", Lines: 2}}
+	expected := "one\ntwo\nx\ny\ntwo\n-\nz\nw\n"
+	for _, tracer := range []Tracer{nil, &nopTracer{}} {
+		out, _, err := prg.RunFile([]byte("x\ny\nz\nw\n"), RuntimeOptions{AutoPrint: true, Tracer: tracer, Files: files})
+		if err != nil || string(out) != expected {
+			t.Errorf("F with tracer %v incorrect.\n  Got: %q, %v\n  Expected: %q", tracer, out, err, expected)
+		}
+	}
+}
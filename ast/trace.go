@@ -0,0 +1,88 @@
+package ast
+
+// Tracer is notified of every step a program takes while running. It
+// is used to implement GNU sed's --debug output.
+type Tracer interface {
+	// StartCycle is called when a new line is read into the pattern space.
+	StartCycle(lineNo int, patternSpace string)
+	// Command is called for every command whose address is tested, with
+	// matched reporting the result. depth is the block nesting level of
+	// the command, and the end of a block is reported as the command "}".
+	Command(cmd string, depth int, matched bool)
+	// PatternSpace and HoldSpace are called when a command changes them.
+	// PatternSpace is also called after every s and y command, which GNU
+	// sed's --debug shows the pattern space after even if it is unchanged.
+	PatternSpace(ps string)
+	HoldSpace(hs string)
+	// Match is called when an s command finds its regexp in the pattern
+	// space ps, with the start and end offsets in ps of the first match
+	// and of each group in it, or -1 for a group that took no part.
+	Match(ps string, loc []int)
+	// Output is called with all text written to the output, in order.
+	Output(out string)
+	// EndCycle is called when the end of the script is reached or the
+	// cycle is ended early, before the pattern space is printed.
+	EndCycle()
+}
+
+// write appends s to the output of the current cycle.
+func (r *runtime) write(s string) {
+	r.output += s
+	if r.tracer != nil {
+		r.tracer.Output(s)
+	}
+}
+
+// traceCommand reports the address decision for stmt.
+func (r *runtime) traceCommand(stmt statement, matched bool) {
+	if r.tracer != nil {
+		r.tracer.Command(formatStatement(stmt), r.depth, matched)
+	}
+}
+
+// traceBlockEnd reports reaching the end of a block.
+func (r *runtime) traceBlockEnd() {
+	if r.tracer != nil {
+		r.tracer.Command("}", r.depth, true)
+	}
+}
+
+// traceMatch reports the first match of an s command.
+func (r *runtime) traceMatch(loc []int) {
+	if r.tracer != nil {
+		r.tracer.Match(r.patternSpace, loc)
+	}
+}
+
+// traceStatement reports the pattern and hold spaces after stmt ran, as
+// traceBuffers does, except that the pattern space is always reported
+// after s and y.
+func (r *runtime) traceStatement(stmt statement, prevPattern, prevHold string) {
+	switch stmt.(type) {
+	case *sStmt, *yStmt:
+		if r.tracer != nil && r.patternSpace == prevPattern {
+			r.tracer.PatternSpace(r.patternSpace)
+		}
+	}
+	r.traceBuffers(prevPattern, prevHold)
+}
+
+// traceBuffers reports the pattern and hold spaces if they differ from
+// their previous values.
+func (r *runtime) traceBuffers(prevPattern, prevHold string) {
+	if r.tracer == nil {
+		return
+	}
+	if r.patternSpace != prevPattern {
+		r.tracer.PatternSpace(r.patternSpace)
+	}
+	if r.holdSpace != prevHold {
+		r.tracer.HoldSpace(r.holdSpace)
+	}
+}
+
+func (r *runtime) traceEndCycle() {
+	if r.tracer != nil {
+		r.tracer.EndCycle()
+	}
+}
@@ -0,0 +1,75 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// recordingTracer records every event as a line of text.
+type recordingTracer struct {
+	events []string
+}
+
+func (t *recordingTracer) StartCycle(lineNo int, ps string) {
+	t.events = append(t.events, fmt.Sprintf("cycle %d %q", lineNo, ps))
+}
+
+func (t *recordingTracer) Command(cmd string, depth int, matched bool) {
+	t.events = append(t.events, fmt.Sprintf("cmd %d %s %v", depth, cmd, matched))
+}
+
+func (t *recordingTracer) PatternSpace(ps string) {
+	t.events = append(t.events, fmt.Sprintf("pattern %q", ps))
+}
+
+func (t *recordingTracer) HoldSpace(hs string) {
+	t.events = append(t.events, fmt.Sprintf("hold %q", hs))
+}
+
+func (t *recordingTracer) Output(out string) {
+	t.events = append(t.events, fmt.Sprintf("output %q", out))
+}
+
+func (t *recordingTracer) Match(ps string, loc []int) {
+	t.events = append(t.events, fmt.Sprintf("match %q %v", ps, loc))
+}
+
+func (t *recordingTracer) EndCycle() {
+	t.events = append(t.events, "end")
+}
+
+func TestTrace(t *testing.T) {
+	program := "/a/ {\nh\ns/a/x/\n}\n$!d\nG"
+	expected := []string{
+		`cycle 1 "a"`,
+		`cmd 0 /a/ { true`,
+		`cmd 1 h true`,
+		`hold "a"`,
+		`cmd 1 s/a/x/ true`,
+		`match "a" [0 1]`,
+		`pattern "x"`,
+		`cmd 0 } true`,
+		`cmd 0 $! d true`,
+		`end`,
+		`cycle 2 "b"`,
+		`cmd 0 /a/ { false`,
+		`cmd 0 } true`,
+		`cmd 0 $! d false`,
+		`cmd 0 G true`,
+		`pattern "b\na"`,
+		`end`,
+		`output "b\na\n"`,
+	}
+
+	p := New(program)
+	prg := p.ParseProgram()
+	if len(p.errors) > 0 {
+		t.Fatalf("Program %s encountered errors %v", program, p.errors)
+	}
+	tracer := &recordingTracer{}
+	prg.Run("a\nb", RuntimeOptions{AutoPrint: true, Tracer: tracer})
+	if strings.Join(tracer.events, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Incorrect trace.\n Expected:\n%s\n Got:\n%s", strings.Join(expected, "\n"), strings.Join(tracer.events, "\n"))
+	}
+}
@@ -0,0 +1,430 @@
+package ast
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// Warning is a likely mistake in a script found by Vet.
+type Warning struct {
+	Line    int    // The script line of the command or label.
+	Check   string // The check that found it, such as "unreachable".
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("line %d: %s (%s)", w.Line, w.Message, w.Check)
+}
+
+// The checks Vet runs.
+const (
+	CheckUnreachable = "unreachable"   // Commands after an unconditional b, d, D, q or Q.
+	CheckUnusedLabel = "unused-label"  // Labels no branch jumps to.
+	CheckTWithoutS   = "t-without-s"   // t and T with no s command that can run before them.
+	CheckNOnLastLine = "n-last-line"   // N not guarded by $!, which differs between seds on the last line.
+	CheckSharedWrite = "shared-write"  // A file written by more than one command.
+	CheckNeverMatch  = "never-matches" // Regexps that cannot match anything.
+)
+
+// ignoreDirective is the comment that suppresses warnings for the command
+// on its line, or on the next line when the comment has a line to itself.
+// It may be followed by the checks to suppress; otherwise all are.
+const ignoreDirective = "gosed:ignore"
+
+// Vet checks the program for common mistakes and returns a warning for
+// each, in script order.
+func (p *Program) Vet() []Warning {
+	var warnings []Warning
+	warnings = append(warnings, p.vetUnreachable()...)
+	warnings = append(warnings, p.vetLabels()...)
+	warnings = append(warnings, p.vetT()...)
+	warnings = append(warnings, p.vetN()...)
+	warnings = append(warnings, p.vetWrites()...)
+	warnings = append(warnings, p.vetRegexps()...)
+	warnings = p.suppress(warnings)
+	sort.SliceStable(warnings, func(i, j int) bool { return warnings[i].Line < warnings[j].Line })
+	return warnings
+}
+
+// isJump reports whether the instruction always leaves the normal flow of
+// the script.
+func isJump(in instruction) bool {
+	if _, ok := in.stmt.address().(*blankAddress); !ok || in.close {
+		return false
+	}
+	return jumps(in.stmt)
+}
+
+// jumps reports whether stmt leaves the normal flow of the script when it
+// runs.
+func jumps(stmt statement) bool {
+	switch stmt.(type) {
+	case *bStmt, *dStmt, *d2Stmt, *qStmt, *q2Stmt:
+		return true
+	}
+	return false
+}
+
+func (p *Program) vetUnreachable() []Warning {
+	targets := make(map[int]bool)
+	for _, target := range p.labels {
+		targets[target] = true
+	}
+	var warnings []Warning
+	dead, reported := false, false
+	depth := 0 // The depth of the jump that made the code dead.
+	for i, in := range p.code {
+		if targets[i] {
+			dead = false
+		}
+		if dead && in.close && in.depth < depth {
+			// Code after a block runs when the block is skipped.
+			if _, ok := in.stmt.address().(*blankAddress); !ok {
+				dead = false
+			}
+			depth = in.depth
+		}
+		if dead && !reported && !in.close {
+			warnings = append(warnings, Warning{
+				Line:    in.line,
+				Check:   CheckUnreachable,
+				Message: fmt.Sprintf("%q can never run", formatStatement(in.stmt)),
+			})
+			reported = true
+		}
+		if !dead && isJump(in) {
+			dead, reported, depth = true, false, in.depth
+		}
+	}
+	return warnings
+}
+
+func (p *Program) vetLabels() []Warning {
+	used := make(map[string]bool)
+	for _, in := range p.code {
+		if b, ok := in.stmt.(brancher); ok && !in.close {
+			used[b.label()] = true
+		}
+	}
+	var warnings []Warning
+	for l := range p.labels {
+		if !used[l] {
+			warnings = append(warnings, Warning{
+				Line:    p.labelLines[l],
+				Check:   CheckUnusedLabel,
+				Message: fmt.Sprintf("label %q is never used", l),
+			})
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i].Line < warnings[j].Line })
+	return warnings
+}
+
+func (p *Program) vetT() []Warning {
+	isSubst := func(i int) bool {
+		_, ok := p.code[i].stmt.(*sStmt)
+		return ok && !p.code[i].close
+	}
+	var warnings []Warning
+	for i, in := range p.code {
+		switch in.stmt.(type) {
+		case *tStmt, *t2Stmt:
+		default:
+			continue
+		}
+		covered := false
+		for j := 0; j < i && !covered; j++ {
+			covered = isSubst(j)
+		}
+		// An s after the t can run before it if a branch loops back.
+		for j := i + 1; j < len(p.code) && !covered; j++ {
+			if _, ok := p.code[j].stmt.(brancher); !ok || p.code[j].target > i {
+				continue
+			}
+			for k := i + 1; k < j && !covered; k++ {
+				covered = isSubst(k)
+			}
+		}
+		if !covered {
+			warnings = append(warnings, Warning{
+				Line:    in.line,
+				Check:   CheckTWithoutS,
+				Message: fmt.Sprintf("%q has no s command before it, so its condition never changes", formatStatement(in.stmt)),
+			})
+		}
+	}
+	return warnings
+}
+
+// notLastLine reports whether a is $!, which never matches the last line.
+func notLastLine(a addresser) bool {
+	n, ok := a.(*notAddr)
+	if !ok {
+		return false
+	}
+	_, ok = n.Addr.(*eofAddr)
+	return ok
+}
+
+// lastLineJump reports whether the instruction at i leaves the script on
+// the last line: a $ jump, or a $ block that jumps unconditionally.
+func (p *Program) lastLineJump(i int) bool {
+	in := p.code[i]
+	if _, ok := in.stmt.address().(*eofAddr); !ok || in.close {
+		return false
+	}
+	if _, ok := in.stmt.(*blockStmt); !ok {
+		return jumps(in.stmt)
+	}
+	for j := i + 1; j < in.end; j++ {
+		if p.code[j].depth == in.depth+1 && isJump(p.code[j]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Program) vetN() []Warning {
+	targets := make(map[int]bool)
+	for _, target := range p.labels {
+		targets[target] = true
+	}
+	var warnings []Warning
+	// For each open block, whether its address is $!, and for the script
+	// and each open block, whether an earlier $ jump has left the script
+	// on the last line.
+	var notLast []bool
+	jumped := []bool{false}
+	for i, in := range p.code {
+		if targets[i] {
+			// A branch here may come from before the jump.
+			for d := range jumped {
+				jumped[d] = false
+			}
+		}
+		if p.lastLineJump(i) {
+			jumped[len(jumped)-1] = true
+		}
+		if _, ok := in.stmt.(*blockStmt); ok {
+			if in.close {
+				notLast = notLast[:len(notLast)-1]
+				jumped = jumped[:len(jumped)-1]
+			} else {
+				notLast = append(notLast, notLastLine(in.stmt.address()))
+				jumped = append(jumped, false)
+			}
+		}
+		if _, ok := in.stmt.(*n2Stmt); !ok || notLastLine(in.stmt.address()) {
+			continue
+		}
+		safe := false
+		for _, g := range append(notLast, jumped...) {
+			safe = safe || g
+		}
+		if !safe {
+			warnings = append(warnings, Warning{
+				Line:    in.line,
+				Check:   CheckNOnLastLine,
+				Message: "N on the last line prints the pattern space in GNU sed but quits without printing it in POSIX and BSD sed; use $!N",
+			})
+		}
+	}
+	return warnings
+}
+
+func (p *Program) vetWrites() []Warning {
+	first := make(map[string]instruction)
+	var warnings []Warning
+	for _, in := range p.code {
+		var file string
+		switch s := in.stmt.(type) {
+		case *wStmt:
+			file = s.FileName
+		case *w2Stmt:
+			file = s.FileName
+		case *sStmt:
+			file = s.Flags.WFile
+		}
+		if file == "" || file == "/dev/stdout" || file == "/dev/stderr" {
+			continue
+		}
+		prev, ok := first[file]
+		if !ok {
+			first[file] = in
+			continue
+		}
+		warnings = append(warnings, Warning{
+			Line:  in.line,
+			Check: CheckSharedWrite,
+			Message: fmt.Sprintf("%q writes to %s, as line %d does; GNU sed shares the file but other seds may truncate it twice",
+				formatStatement(in.stmt), file, prev.line),
+		})
+	}
+	return warnings
+}
+
+func (p *Program) vetRegexps() []Warning {
+	var warnings []Warning
+	check := func(in instruction, re pattern, source string) {
+		if re != nil && neverMatches(re) {
+			warnings = append(warnings, Warning{
+				Line:    in.line,
+				Check:   CheckNeverMatch,
+				Message: fmt.Sprintf("regexp %q can never match", source),
+			})
+		}
+	}
+	for _, in := range p.code {
+		if in.close {
+			continue
+		}
+		if s, ok := in.stmt.(*sStmt); ok {
+			check(in, s.regexp, s.FindAddr)
+		}
+		a := in.stmt.address()
+		if n, ok := a.(*notAddr); ok {
+			a = n.Addr
+		}
+		addrs := []addresser{a}
+		if r, ok := a.(*rangeAddress); ok {
+			addrs = []addresser{r.Addr1, r.Addr2}
+		}
+		for _, a := range addrs {
+			if r, ok := a.(*regexpAddr); ok {
+				check(in, r.Regexp, r.Source)
+			}
+		}
+	}
+	return warnings
+}
+
+// neverMatches reports whether re cannot match any string, because it
+// needs text after the end of the input or before its start. Regexps
+// with back-references are not checked.
+func neverMatches(re pattern) bool {
+	src := re.String()
+	for _, r := range src {
+		if r >= backrefBase && r < backrefBase+10 {
+			return false
+		}
+	}
+	r, err := syntax.Parse(src, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	return impossible(r.Simplify())
+}
+
+func impossible(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return impossible(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min > 0 && impossible(re.Sub[0])
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !impossible(sub) {
+				return false
+			}
+		}
+		return true
+	case syntax.OpConcat:
+		consumed := false
+		for i, sub := range re.Sub {
+			if impossible(sub) {
+				return true
+			}
+			if sub.Op == syntax.OpBeginText && consumed {
+				return true
+			}
+			if sub.Op == syntax.OpEndText {
+				for _, after := range re.Sub[i+1:] {
+					if consumes(after) {
+						return true
+					}
+				}
+			}
+			consumed = consumed || consumes(sub)
+		}
+	}
+	return false
+}
+
+// consumes reports whether every match of re is at least one character.
+func consumes(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral, syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return true
+	case syntax.OpCapture, syntax.OpPlus:
+		return consumes(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min > 0 && consumes(re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if consumes(sub) {
+				return true
+			}
+		}
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !consumes(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// suppress drops the warnings for lines with a gosed:ignore comment.
+func (p *Program) suppress(warnings []Warning) []Warning {
+	ignored := make(map[int][]string) // The checks ignored on each line; nil for all.
+	var collect func(p *Program)
+	collect = func(prg *Program) {
+		for _, c := range prg.Comments {
+			text := strings.TrimSpace(c.Text)
+			if !strings.HasPrefix(text, ignoreDirective) {
+				continue
+			}
+			line := c.Line
+			if !c.Trailing {
+				line++
+			}
+			checks := strings.Fields(strings.TrimPrefix(text, ignoreDirective))
+			if len(checks) == 0 {
+				ignored[line] = nil
+			} else if prev, ok := ignored[line]; !ok || prev != nil {
+				ignored[line] = append(prev, checks...)
+			}
+		}
+		for _, stmt := range prg.Statements {
+			if b, ok := stmt.(*blockStmt); ok {
+				collect(b.Code)
+			}
+		}
+	}
+	collect(p)
+
+	var kept []Warning
+	for _, w := range warnings {
+		checks, ok := ignored[w.Line]
+		if ok && (checks == nil || contains(checks, w.Check)) {
+			continue
+		}
+		kept = append(kept, w)
+	}
+	return kept
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package ast
+
+import "testing"
+
+func TestVet(t *testing.T) {
+	type found struct {
+		line  int
+		check string
+	}
+	cases := []struct {
+		program  string
+		expected []found
+	}{
+		{"s/a/b/\np", nil},
+		{"p\nd\np\n=", []found{{3, CheckUnreachable}}},
+		{"/x/d\np", nil},
+		{"b end\ns/a/b/\n:end\np", []found{{2, CheckUnreachable}}},
+		{"/x/ {\n  q\n  p\n}\np", []found{{3, CheckUnreachable}}},
+		{"{\n  d\n}\np", []found{{4, CheckUnreachable}}},
+		{":a\n:b\nb b", []found{{1, CheckUnusedLabel}}},
+		{"t\ns/a/b/", []found{{1, CheckTWithoutS}}},
+		{":a\nT\ns/a/b/\nb a", nil},
+		{"N\nP\nD", []found{{1, CheckNOnLastLine}}},
+		{"$!N\nP\nD", nil},
+		{"$q\nN", nil},
+		{"$! {\n  N\n}", nil},
+		{"$ {\n  s/a/b/\n  b\n}\nN", nil},
+		{":a\nN\n$q\nb a", []found{{2, CheckNOnLastLine}}},
+		{"w out\n/x/s/a/b/w out\nw /dev/stdout\nw /dev/stdout", []found{{2, CheckSharedWrite}}},
+		{"/a\\`b/p\ns/x\\'y/z/\n/^a$/p\n/a$b/p", []found{{1, CheckNeverMatch}, {2, CheckNeverMatch}}},
+		{"/\\(a\\)$\\1/p", nil},
+		{"p\nd\np # gosed:ignore", nil},
+		{":a\nd\n# gosed:ignore unreachable\np", []found{{1, CheckUnusedLabel}}},
+		{"d\n# gosed:ignore t-without-s\np", []found{{3, CheckUnreachable}}},
+	}
+
+	for i, c := range cases {
+		p := New(c.program)
+		prg := p.ParseProgram()
+		if len(p.errors) > 0 {
+			t.Errorf("Vet [%d] %q encountered errors %v", i, c.program, p.errors)
+			continue
+		}
+		warnings := prg.Vet()
+		var got []found
+		for _, w := range warnings {
+			got = append(got, found{w.Line, w.Check})
+		}
+		if len(got) != len(c.expected) {
+			t.Errorf("Vet [%d] %q incorrect.\n  Got: %v\n  Expected: %v", i, c.program, warnings, c.expected)
+			continue
+		}
+		for j := range got {
+			if got[j] != c.expected[j] {
+				t.Errorf("Vet [%d] %q incorrect.\n  Got: %v\n  Expected: %v", i, c.program, warnings, c.expected)
+				break
+			}
+		}
+	}
+}
+
+// TestNOnLastLine checks that N on the last line does what the
+// n-last-line warning says in each dialect.
+func TestNOnLastLine(t *testing.T) {
+	prg := New("$a\\\nend\nN\nP\nD").ParseProgram()
+	for _, c := range []struct {
+		dialect  Dialect
+		expected string
+	}{
+		{GNU, "1\n2\n3\nend"},
+		{POSIX, "1\n2\nend"},
+		{BSD, "1\n2\nend"},
+	} {
+		got, _ := prg.Run("1\n2\n3", RuntimeOptions{AutoPrint: true, Dialect: c.dialect})
+		if got != c.expected {
+			t.Errorf("N on the last line in dialect %d incorrect.\n  Got: %q\n  Expected: %q", c.dialect, got, c.expected)
+		}
+	}
+}
@@ -0,0 +1,454 @@
+package ast
+
+import (
+	"bytes"
+	"strconv"
+)
+
+// vm runs a program's bytecode over its input in one loop. Unlike the
+// Machine it can't be stepped or traced, but it makes no calls through
+// interfaces to run commands, keeps the state of its ranges in a slice
+// and works on byte slices: its pattern and hold spaces are buffers that
+// are reused from line to line, input lines are copied into the pattern
+// space without being split out first, and output is written to one
+// buffer. Besides what the regexps allocate to find matches, a line only
+// allocates when a buffer has to grow.
+type vm struct {
+	bc      *bytecode
+	code    []instruction
+	options RuntimeOptions
+
+	input        []byte // The input not yet read.
+	more         bool   // Whether there is another line to read.
+	lineNo       int
+	patternSpace []byte
+	holdSpace    []byte
+	scratch      []byte // Where s and y build the new pattern space.
+	appendSpace  []byte
+	out          bytes.Buffer
+	subMade      bool
+	quit         bool  // Whether q or Q ended the run.
+	matchErr     error // Why a backtracker gave up matching.
+	lastRegexp   pattern
+	ranges       []rangeState
+	lineDelim    byte
+	lineLength   int
+	num          [20]byte // Where = formats the line number.
+
+	in            int  // The instruction running, for errors.
+	checking      bool // Whether there are limits or a context to check.
+	commands      int
+	cycleCommands int
+}
+
+// runVM runs the program as run does. It doesn't support tracing.
+func (p *Program) runVM(data []byte, options RuntimeOptions) ([]byte, bool, error) {
+	v := p.newVM(data, options)
+	err := v.run()
+	return v.out.Bytes(), v.quit, err
+}
+
+// newVM returns a VM ready to run the program over data.
+func (p *Program) newVM(data []byte, options RuntimeOptions) *vm {
+	v := &vm{
+		bc:         p.bc,
+		code:       p.code,
+		options:    options,
+		input:      data,
+		more:       true,
+		lineNo:     options.LineNoStart - 1,
+		lineDelim:  options.lineDelim(),
+		lineLength: options.lineLength(),
+		ranges:     make([]rangeState, len(p.bc.ranges)),
+		checking:   options.Context != nil || options.Limits != Limits{},
+	}
+	for i, r := range v.bc.ranges {
+		v.ranges[i].on = r.startsOn
+	}
+	return v
+}
+
+// read copies the next line of input onto the end of the pattern space.
+// Like strings.Split, it reads an empty last line after a final
+// delimiter.
+func (v *vm) read() {
+	line := v.input
+	if i := bytes.IndexByte(v.input, v.lineDelim); i >= 0 {
+		line, v.input = v.input[:i], v.input[i+1:]
+	} else {
+		v.input, v.more = nil, false
+	}
+	v.patternSpace = append(v.patternSpace, line...)
+	v.lineNo++
+}
+
+// run runs cycles until the input or the program ends.
+func (v *vm) run() error {
+	for v.more {
+		v.patternSpace = v.patternSpace[:0]
+		v.read()
+		v.subMade = false
+		v.cycleCommands = 0
+		if quit, err := v.cycle(); quit || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cycle runs the script over the pattern space and reports whether the
+// program quit.
+func (v *vm) cycle() (bool, error) {
+	ops := v.bc.ops
+	for pc := 0; pc < len(ops); {
+		o := &ops[pc]
+		if o.cmd >= 0 {
+			v.in = o.cmd
+			if v.checking {
+				if err := v.count(); err != nil {
+					return true, err
+				}
+			}
+		}
+		pc++
+		switch o.code {
+		case opLine, opLast, opMatch, opStep, opNever, opRelative, opMultiple:
+			if v.test(o) == o.not {
+				pc = o.jump
+			}
+			if v.matchErr != nil {
+				return true, v.matchError()
+			}
+			continue
+		case opRange:
+			if v.inRange(o.n) == o.not {
+				pc = o.jump
+			}
+			if v.matchErr != nil {
+				return true, v.matchError()
+			}
+			continue
+		case opAppend:
+			v.appendSpace = append(v.appendSpace, o.text...)
+		case opBranch:
+			pc = o.jump
+		case opChange:
+			if o.n < 0 || !v.ranges[o.n].on {
+				v.out.WriteString(o.text)
+			}
+			v.flush(false)
+			return false, nil
+		case opSubst:
+			v.subst(o)
+			if v.matchErr != nil {
+				return true, v.matchError()
+			}
+		case opDelete:
+			v.flush(false)
+			return false, nil
+		case opDeleteFirst:
+			i := bytes.IndexByte(v.patternSpace, '\n')
+			v.flush(false)
+			if i < 0 {
+				return false, nil
+			}
+			v.patternSpace = v.patternSpace[:copy(v.patternSpace, v.patternSpace[i+1:])]
+			pc = 0
+		case opGet:
+			v.patternSpace = append(v.patternSpace[:0], v.holdSpace...)
+		case opGetAppend:
+			v.patternSpace = append(append(v.patternSpace, '\n'), v.holdSpace...)
+		case opHold:
+			v.holdSpace = append(v.holdSpace[:0], v.patternSpace...)
+		case opHoldAppend:
+			v.holdSpace = append(append(v.holdSpace, '\n'), v.patternSpace...)
+		case opInsert:
+			v.out.WriteString(o.text)
+		case opList:
+			width := v.lineLength
+			if o.n >= 0 {
+				width = o.n
+			}
+			if v.options.Dialect == BSD {
+				v.out.WriteString(listBSD(string(v.patternSpace), width))
+			} else {
+				v.out.WriteString(listGNU(string(v.patternSpace), width))
+			}
+			v.out.WriteByte(v.lineDelim)
+		case opNext:
+			// Without a next line sed ends as if the script had finished.
+			v.flush(true)
+			if !v.more {
+				return true, nil
+			}
+			v.patternSpace = v.patternSpace[:0]
+			v.read()
+		case opNextAppend:
+			if !v.more {
+				// GNU sed prints the pattern space as if the script had
+				// finished; POSIX and BSD sed don't.
+				v.flush(v.options.Dialect == GNU)
+				return true, nil
+			}
+			v.patternSpace = append(v.patternSpace, '\n')
+			v.read()
+		case opPrint:
+			v.out.Write(v.patternSpace)
+			v.out.WriteByte(v.lineDelim)
+		case opPrintFirst:
+			if i := bytes.IndexByte(v.patternSpace, '\n'); i >= 0 {
+				v.out.Write(v.patternSpace[:i])
+			} else {
+				v.out.Write(v.patternSpace)
+			}
+			v.out.WriteByte(v.lineDelim)
+		case opQuit:
+			v.flush(true)
+			v.quit = true
+			return true, nil
+		case opQuitSilent:
+			v.quit = true
+			return true, nil
+		case opFile:
+			v.out.WriteString(fileName(v.options.Files, v.lineNo+1))
+			v.out.WriteByte('\n')
+		case opBranchSub:
+			if v.subMade {
+				v.subMade = false
+				pc = o.jump
+			}
+		case opBranchNotSub:
+			if !v.subMade {
+				pc = o.jump
+			}
+			v.subMade = false
+		case opExchange:
+			v.patternSpace, v.holdSpace = v.holdSpace, v.patternSpace
+		case opTranslate:
+			v.translate(o.y)
+		case opZap:
+			v.patternSpace = v.patternSpace[:0]
+		case opLineNo:
+			v.out.Write(strconv.AppendInt(v.num[:0], int64(v.lineNo+1), 10))
+			v.out.WriteByte('\n')
+		}
+		if v.checking {
+			if err := v.checkSpace(); err != nil {
+				return true, err
+			}
+		}
+	}
+	v.flush(true)
+	return false, nil
+}
+
+// test reports whether the address tested by o matches.
+func (v *vm) test(o *op) bool {
+	switch o.code {
+	case opLine:
+		return v.lineNo+1 == o.n
+	case opLast:
+		return !v.more
+	case opMatch:
+		re := o.re
+		if re == nil {
+			re = v.lastRegexp
+		} else {
+			v.lastRegexp = re
+		}
+		return re != nil && v.match(re)
+	case opStep:
+		line := v.lineNo + 1
+		if o.m <= 0 {
+			return line == o.n
+		}
+		return line >= o.n && (line-o.n)%o.m == 0
+	}
+	return false
+}
+
+// match reports whether re matches the pattern space, recording why if
+// a backtracker gives up.
+func (v *vm) match(re pattern) bool {
+	bt, ok := re.(*backtracker)
+	if !ok {
+		return re.Match(v.patternSpace)
+	}
+	matches, err := bt.findAll(v.options.Context, string(v.patternSpace), 1)
+	if err != nil {
+		v.matchErr = err
+	}
+	return len(matches) > 0
+}
+
+// findAll returns up to n matches of re in b as FindAllSubmatchIndex
+// does, recording why if a backtracker gives up.
+func (v *vm) findAll(re pattern, b []byte, n int) [][]int {
+	bt, ok := re.(*backtracker)
+	if !ok {
+		return re.FindAllSubmatchIndex(b, n)
+	}
+	matches, err := bt.findAll(v.options.Context, string(b), n)
+	if err != nil {
+		v.matchErr = err
+	}
+	return matches
+}
+
+// inRange reports whether the line is in the range of slot, as
+// rangeAddress.Address does.
+func (v *vm) inRange(slot int) bool {
+	state, r := &v.ranges[slot], &v.bc.ranges[slot]
+	line := v.lineNo + 1
+	if state.on {
+		if state.end > 0 {
+			state.on = line < state.end
+		} else if v.test(&r.last) {
+			state.on = false
+		}
+		return true
+	}
+	if !v.test(&r.first) {
+		return false
+	}
+	switch r.last.code {
+	case opLine:
+		state.end = r.last.n
+	case opRelative:
+		state.end = line + r.last.n
+	case opMultiple:
+		state.end = line
+		if n := r.last.n; n > 0 && line%n != 0 {
+			state.end = line + n - line%n
+		}
+	default:
+		state.on = true
+		return true
+	}
+	state.on = line < state.end
+	return true
+}
+
+// subst runs the s command of o, building the new pattern space in the
+// scratch buffer and swapping the two.
+func (v *vm) subst(o *op) {
+	re := o.re
+	if re == nil {
+		re = v.lastRegexp
+	} else {
+		v.lastRegexp = re
+	}
+	if re == nil {
+		return
+	}
+	s := o.subst
+	n := 1
+	if s.Flags.NFlag != 0 {
+		n = s.Flags.NFlag
+	}
+	// Matches past the nth are only needed to replace them all.
+	limit := n
+	if s.Flags.GFlag {
+		limit = -1
+	}
+	ps := v.patternSpace
+	matches := v.findAll(re, ps, limit)
+	if len(matches) < n {
+		return
+	}
+	matches = matches[n-1:]
+	out := v.scratch[:0]
+	last := 0
+	for _, m := range matches {
+		out = append(out, ps[last:m[0]]...)
+		out = s.replacement.expand(out, ps, m)
+		last = m[1]
+	}
+	out = append(out, ps[last:]...)
+	v.patternSpace, v.scratch = out, ps
+	v.subMade = true
+	if s.Flags.PFlag {
+		v.out.Write(v.patternSpace)
+		v.out.WriteByte(v.lineDelim)
+	}
+}
+
+// translate runs the y command s, in place when it only maps ASCII.
+func (v *vm) translate(s *yStmt) {
+	if s.table != nil {
+		for i, c := range v.patternSpace {
+			v.patternSpace[i] = s.table[c]
+		}
+		return
+	}
+	ps := v.patternSpace
+	v.scratch = s.translate(v.scratch[:0], ps)
+	v.patternSpace, v.scratch = v.scratch, ps
+}
+
+// flush writes the pattern space, if autoPrint is set and the program
+// prints it, followed by the text queued by the a command.
+func (v *vm) flush(autoPrint bool) {
+	if autoPrint && v.options.AutoPrint {
+		v.out.Write(v.patternSpace)
+		v.out.WriteByte(v.lineDelim)
+	}
+	v.out.Write(v.appendSpace)
+	v.appendSpace = v.appendSpace[:0]
+}
+
+// count checks the run's context and counts the instruction starting as
+// run, as Machine.Step does.
+func (v *vm) count() error {
+	if ctx := v.options.Context; ctx != nil {
+		select {
+		case <-ctx.Done():
+			return v.limitError(LimitContext, ctx.Err())
+		default:
+		}
+	}
+	limits := v.options.Limits
+	v.commands++
+	v.cycleCommands++
+	switch {
+	case limits.Commands > 0 && v.commands > limits.Commands:
+		return v.limitError(LimitCommands, nil)
+	case limits.CommandsPerLine > 0 && v.cycleCommands > limits.CommandsPerLine:
+		return v.limitError(LimitCommandsPerLine, nil)
+	}
+	return nil
+}
+
+// checkSpace returns an error if the pattern or hold space is larger than
+// the limit.
+func (v *vm) checkSpace() error {
+	limit := v.options.Limits.SpaceBytes
+	switch {
+	case limit <= 0:
+		return nil
+	case len(v.patternSpace) > limit:
+		return v.limitError(LimitPatternSpace, nil)
+	case len(v.holdSpace) > limit:
+		return v.limitError(LimitHoldSpace, nil)
+	}
+	return nil
+}
+
+// matchError returns the error for the reason a backtracker gave up.
+func (v *vm) matchError() *LimitError {
+	if v.matchErr == errBacktrackLimit {
+		return v.limitError(LimitRegexp, nil)
+	}
+	return v.limitError(LimitContext, v.matchErr)
+}
+
+func (v *vm) limitError(limit Limit, err error) *LimitError {
+	in := v.code[v.in]
+	return &LimitError{
+		Limit:      limit,
+		LineNo:     v.lineNo + 1,
+		Command:    formatStatement(in.stmt),
+		SourceLine: in.line,
+		Err:        err,
+	}
+}
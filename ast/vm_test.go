@@ -0,0 +1,57 @@
+package ast
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunBytes(t *testing.T) {
+	p := New("y/abc/ABC/;/B/{h;G;x;s/A\\(.\\)/\\1a/};$!N;P;D")
+	prg := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("Script errors: %v", p.Errors())
+	}
+	input := []byte(strings.Repeat("abc\nxyz\né b\n", 3))
+	original := append([]byte{}, input...)
+	options := RuntimeOptions{AutoPrint: true}
+	got, err := prg.RunBytes(input, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := prg.Run(string(input), RuntimeOptions{AutoPrint: true, Tracer: &nopTracer{}})
+	if string(got) != expected {
+		t.Errorf("RunBytes incorrect.\n  Got: %q\n  Expected: %q", got, expected)
+	}
+	if !bytes.Equal(input, original) {
+		t.Errorf("RunBytes changed its input to %q", input)
+	}
+
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	// Past the first lines, which grow the buffers, lines should not
+	// allocate unless a regexp does to find its matches. The program
+	// keeps its buffers the same size from line to line.
+	prg = New("y/abc/ABC/;/B/{h;G;x};$!N;P;D").ParseProgram()
+	allocs := func(lines int) float64 {
+		input := []byte(strings.Repeat("abc\nxyz\n", lines/2))
+		return testing.AllocsPerRun(10, func() {
+			prg.RunBytes(input, options)
+		})
+	}
+	few, many := allocs(100), allocs(1000)
+	if many > few+5 {
+		t.Errorf("RunBytes made %v allocations over 100 lines and %v over 1000", few, many)
+	}
+}
+
+type nopTracer struct{}
+
+func (*nopTracer) StartCycle(int, string)    {}
+func (*nopTracer) Command(string, int, bool) {}
+func (*nopTracer) PatternSpace(string)       {}
+func (*nopTracer) HoldSpace(string)          {}
+func (*nopTracer) Output(string)             {}
+func (*nopTracer) Match(string, []int)       {}
+func (*nopTracer) EndCycle()                 {}
@@ -0,0 +1,345 @@
+package gosed
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/zkry/go-sed/ast"
+)
+
+// Builder constructs a sed program command by command, so that scripts
+// can be made from data without quoting regexps, replacements and text
+// by hand. Each method adds a command and returns the builder, so calls
+// chain:
+//
+//	gosed.NewBuilder().Range(gosed.Regex("start"), gosed.Line(10)).Subst("a", "b", gosed.Global)
+//
+// Regexps and replacements are written in sed syntax, as they would be
+// between the delimiters of an s command; delimiters and newlines in them
+// need no escaping.
+type Builder struct {
+	cmds []*ast.Command
+	next ast.Command   // The address of the next command.
+	errs ast.ErrorList // Problems with the commands, which Compile returns.
+}
+
+// NewBuilder returns a builder for an empty program.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Line returns the address of line n.
+func Line(n int) *ast.Address {
+	return &ast.Address{Kind: ast.LineAddress, Line: n}
+}
+
+// Last returns the $ address, which matches the last line of input.
+func Last() *ast.Address {
+	return &ast.Address{Kind: ast.LastLineAddress}
+}
+
+// Regex returns the address of the lines matching re. Of flags, only
+// IgnoreCase and Multiline apply to an address.
+func Regex(re string, flags ...Flag) *ast.Address {
+	cmd := &ast.Command{Regexp: &ast.Regexp{Source: re}}
+	for _, f := range flags {
+		f(cmd)
+	}
+	return &ast.Address{Kind: ast.RegexpAddress, Regexp: cmd.Regexp}
+}
+
+// Step returns the GNU first~step address, which matches every step'th
+// line starting with line first.
+func Step(first, step int) *ast.Address {
+	return &ast.Address{Kind: ast.StepAddress, Line: first, Step: step}
+}
+
+// Relative returns the GNU +n address, which ends a range n lines after
+// the line that started it.
+func Relative(n int) *ast.Address {
+	return &ast.Address{Kind: ast.RelativeAddress, Step: n}
+}
+
+// Multiple returns the GNU ~n address, which ends a range at the next
+// line whose number is a multiple of n.
+func Multiple(n int) *ast.Address {
+	return &ast.Address{Kind: ast.MultipleAddress, Step: n}
+}
+
+// A Flag modifies an s command or, for IgnoreCase and Multiline, a regexp
+// address.
+type Flag func(*ast.Command)
+
+var (
+	Global     Flag = func(c *ast.Command) { c.Flags.Global = true }      // g
+	Print      Flag = func(c *ast.Command) { c.Flags.Print = true }       // p
+	Exec       Flag = func(c *ast.Command) { c.Flags.Exec = true }        // e (GNU)
+	IgnoreCase Flag = func(c *ast.Command) { c.Regexp.IgnoreCase = true } // I (GNU)
+	Multiline  Flag = func(c *ast.Command) { c.Regexp.Multiline = true }  // M (GNU)
+)
+
+// Occurrence replaces only the nth match, or with Global the nth and
+// those after it.
+func Occurrence(n int) Flag {
+	return func(c *ast.Command) { c.Flags.Occurrence = n }
+}
+
+// WriteTo appends the pattern space to file when a substitution is made.
+func WriteTo(file string) Flag {
+	return func(c *ast.Command) { c.FileName = file }
+}
+
+// At addresses the next command to the lines matching a.
+func (b *Builder) At(a *ast.Address) *Builder {
+	b.next.Addr1, b.next.Addr2 = a, nil
+	return b
+}
+
+// Range addresses the next command to the lines from one matching a1 to
+// the next one matching a2.
+func (b *Builder) Range(a1, a2 *ast.Address) *Builder {
+	b.next.Addr1, b.next.Addr2 = a1, a2
+	return b
+}
+
+// Not negates the address of the next command, so that it runs on the
+// lines the address does not match.
+func (b *Builder) Not() *Builder {
+	b.next.Negated = true
+	return b
+}
+
+// add adds cmd with the pending address, which it then clears.
+func (b *Builder) add(cmd *ast.Command) *Builder {
+	if cmd.Name != ":" {
+		cmd.Addr1, cmd.Addr2, cmd.Negated = b.next.Addr1, b.next.Addr2, b.next.Negated
+	}
+	b.next = ast.Command{}
+	b.cmds = append(b.cmds, cmd)
+	return b
+}
+
+func (b *Builder) command(name string) *Builder {
+	return b.add(&ast.Command{Name: name, Width: -1})
+}
+
+// Block adds a { command whose contents are the commands fn adds to the
+// builder it is given.
+func (b *Builder) Block(fn func(*Builder)) *Builder {
+	block := NewBuilder()
+	fn(block)
+	b.errs = append(b.errs, block.errs...)
+	return b.add(&ast.Command{Name: "{", Width: -1, Block: block.cmds})
+}
+
+// Label adds the label name, which branches can jump to.
+func (b *Builder) Label(name string) *Builder {
+	b.checkLabel(name)
+	return b.add(&ast.Command{Name: ":", Label: name})
+}
+
+// Branch adds a b command, which jumps to label, or to the end of the
+// script when label is "".
+func (b *Builder) Branch(label string) *Builder {
+	b.checkLabel(label)
+	return b.add(&ast.Command{Name: "b", Label: label, Width: -1})
+}
+
+// BranchIfSubst adds a t command, which jumps to label if a substitution
+// has been made since the last line was read or t was run.
+func (b *Builder) BranchIfSubst(label string) *Builder {
+	b.checkLabel(label)
+	return b.add(&ast.Command{Name: "t", Label: label, Width: -1})
+}
+
+// BranchUnlessSubst adds the GNU T command, the opposite of t.
+func (b *Builder) BranchUnlessSubst(label string) *Builder {
+	b.checkLabel(label)
+	return b.add(&ast.Command{Name: "T", Label: label, Width: -1})
+}
+
+// checkLabel records an error for a label that would not survive being
+// printed as source: whitespace or a semicolon would end it early.
+func (b *Builder) checkLabel(name string) {
+	if strings.IndexFunc(name, func(r rune) bool { return r == ';' || unicode.IsSpace(r) }) != -1 {
+		b.errs = append(b.errs, fmt.Sprintf("label %q contains whitespace or a semicolon", name))
+	}
+}
+
+// Subst adds an s command replacing matches of re with replacement.
+func (b *Builder) Subst(re, replacement string, flags ...Flag) *Builder {
+	cmd := &ast.Command{Name: "s", Width: -1, Regexp: &ast.Regexp{Source: re}, Replacement: replacement}
+	for _, f := range flags {
+		f(cmd)
+	}
+	return b.add(cmd)
+}
+
+// Translate adds a y command, which replaces each character of find with
+// the character at the same position in replace.
+func (b *Builder) Translate(find, replace string) *Builder {
+	return b.add(&ast.Command{Name: "y", Width: -1, Find: find, Replace: replace})
+}
+
+// Append adds an a command, which outputs text at the end of the cycle.
+func (b *Builder) Append(text string) *Builder {
+	return b.add(&ast.Command{Name: "a", Width: -1, Text: text, HasText: true})
+}
+
+// Insert adds an i command, which outputs text immediately.
+func (b *Builder) Insert(text string) *Builder {
+	return b.add(&ast.Command{Name: "i", Width: -1, Text: text, HasText: true})
+}
+
+// Change adds a c command, which deletes the pattern space and outputs
+// text in its place.
+func (b *Builder) Change(text string) *Builder {
+	return b.add(&ast.Command{Name: "c", Width: -1, Text: text, HasText: true})
+}
+
+// Quit adds a q command, which prints the pattern space and exits with
+// code.
+func (b *Builder) Quit(code int) *Builder {
+	return b.add(&ast.Command{Name: "q", Width: -1, ExitCode: code})
+}
+
+// QuitSilent adds the GNU Q command, which exits with code without
+// printing.
+func (b *Builder) QuitSilent(code int) *Builder {
+	return b.add(&ast.Command{Name: "Q", Width: -1, ExitCode: code})
+}
+
+// List adds an l command, which prints the pattern space unambiguously,
+// wrapping at width, or at the default length when width is negative.
+func (b *Builder) List(width int) *Builder {
+	return b.add(&ast.Command{Name: "l", Width: width})
+}
+
+// Exec adds the GNU e command, which runs command, or the pattern space
+// when command is "".
+func (b *Builder) Exec(command string) *Builder {
+	return b.add(&ast.Command{Name: "e", Width: -1, Command: command})
+}
+
+// ReadFile adds an r command, which outputs the contents of file at the
+// end of the cycle.
+func (b *Builder) ReadFile(file string) *Builder {
+	return b.add(&ast.Command{Name: "r", Width: -1, FileName: file})
+}
+
+// ReadLine adds the GNU R command, which outputs the next line of file.
+func (b *Builder) ReadLine(file string) *Builder {
+	return b.add(&ast.Command{Name: "R", Width: -1, FileName: file})
+}
+
+// WriteFile adds a w command, which writes the pattern space to file.
+func (b *Builder) WriteFile(file string) *Builder {
+	return b.add(&ast.Command{Name: "w", Width: -1, FileName: file})
+}
+
+// WriteFirstLine adds the GNU W command, which writes the first line of
+// the pattern space to file.
+func (b *Builder) WriteFirstLine(file string) *Builder {
+	return b.add(&ast.Command{Name: "W", Width: -1, FileName: file})
+}
+
+// Delete adds a d command, which deletes the pattern space and starts the
+// next cycle.
+func (b *Builder) Delete() *Builder {
+	return b.command("d")
+}
+
+// DeleteFirstLine adds a D command, which deletes the first line of the
+// pattern space and restarts the cycle.
+func (b *Builder) DeleteFirstLine() *Builder {
+	return b.command("D")
+}
+
+// PrintFileName adds the GNU F command, which prints the name of the input
+// file.
+func (b *Builder) PrintFileName() *Builder {
+	return b.command("F")
+}
+
+// Get adds a g command, which copies the hold space to the pattern space.
+func (b *Builder) Get() *Builder {
+	return b.command("g")
+}
+
+// GetAppend adds a G command, which appends a newline and the hold space
+// to the pattern space.
+func (b *Builder) GetAppend() *Builder {
+	return b.command("G")
+}
+
+// Hold adds an h command, which copies the pattern space to the hold
+// space.
+func (b *Builder) Hold() *Builder {
+	return b.command("h")
+}
+
+// HoldAppend adds an H command, which appends a newline and the pattern
+// space to the hold space.
+func (b *Builder) HoldAppend() *Builder {
+	return b.command("H")
+}
+
+// Next adds an n command, which prints the pattern space and reads the
+// next line into it.
+func (b *Builder) Next() *Builder {
+	return b.command("n")
+}
+
+// NextAppend adds an N command, which appends a newline and the next line
+// to the pattern space.
+func (b *Builder) NextAppend() *Builder {
+	return b.command("N")
+}
+
+// Print adds a p command, which prints the pattern space.
+func (b *Builder) Print() *Builder {
+	return b.command("p")
+}
+
+// PrintFirstLine adds a P command, which prints the first line of the
+// pattern space.
+func (b *Builder) PrintFirstLine() *Builder {
+	return b.command("P")
+}
+
+// Exchange adds an x command, which exchanges the pattern and hold spaces.
+func (b *Builder) Exchange() *Builder {
+	return b.command("x")
+}
+
+// Zap adds the GNU z command, which empties the pattern space.
+func (b *Builder) Zap() *Builder {
+	return b.command("z")
+}
+
+// LineNumber adds a = command, which prints the line number.
+func (b *Builder) LineNumber() *Builder {
+	return b.command("=")
+}
+
+// Commands returns the commands added so far.
+func (b *Builder) Commands() []*ast.Command {
+	return b.cmds
+}
+
+// String returns the program as sed source, one command per line.
+func (b *Builder) String() string {
+	return ast.FormatCommands(b.cmds)
+}
+
+// Compile compiles the program. It compiles the source that String
+// returns, so the program is exactly the one the parser makes from it and
+// is checked against the dialect of opt like any other script. It fails
+// without compiling if a label contains whitespace or a semicolon.
+func (b *Builder) Compile(opt Options) (*Program, ast.ErrorList) {
+	if len(b.errs) > 0 {
+		return nil, b.errs
+	}
+	return Compile(b.String(), opt)
+}
@@ -0,0 +1,91 @@
+package gosed
+
+import (
+	"testing"
+
+	"github.com/zkry/go-sed/ast"
+)
+
+func TestBuilder(t *testing.T) {
+	cases := []struct {
+		builder *Builder
+		source  string
+		input   string
+		output  string
+	}{
+		{
+			builder: NewBuilder().Range(Regex("start"), Line(3)).Subst("a/b", "c\nd", Global),
+			source:  "/start/,3 s/a\\/b/c\\nd/g\n",
+			input:   "a/b\nstart a/b a/b\na/b\na/b",
+			output:  "a/b\nstart c\nd c\nd\nc\nd\na/b",
+		},
+		{
+			builder: NewBuilder().
+				Label("top").
+				Subst("x", "y", Occurrence(2)).
+				BranchIfSubst("top").
+				At(Last()).Not().Delete(),
+			source: ":top\ns/x/y/2\nt top\n$! d\n",
+			input:  "xx\nxxxx",
+			output: "xyyy",
+		},
+		{
+			builder: NewBuilder().
+				At(Regex("^#", IgnoreCase)).Block(func(b *Builder) {
+					b.Translate("a\\/", "b/|").Append("back\\slash\nnext")
+				}).
+				At(Step(1, 2)).LineNumber(),
+			source: "/^#/I {\n  y/a\\\\\\//b\\/|/\n  a\\back\\\\slash\\\nnext\n}\n1~2 =\n",
+			input:  "#a\\/\nb",
+			output: "1\n#b/|\nback\\slash\nnext\nb",
+		},
+		{
+			builder: NewBuilder().At(Line(1)).Append("").Print(),
+			source:  "1 a\\\n\np\n",
+			input:   "1\n2",
+			output:  "1\n1\n\n2\n2",
+		},
+	}
+
+	for i, c := range cases {
+		if got := c.builder.String(); got != c.source {
+			t.Errorf("Builder [%d] printed wrong source:\n  Got: %q\n  Expected: %q", i, got, c.source)
+		}
+		prg, errs := c.builder.Compile(Options{})
+		if len(errs) > 0 {
+			t.Errorf("Builder [%d] did not compile: %v", i, errs)
+			continue
+		}
+		if got := prg.String(); got != c.source {
+			t.Errorf("Builder [%d] compiled to a different program:\n  Got: %q\n  Expected: %q", i, got, c.source)
+		}
+		if got := prg.FilterString(c.input); got != c.output {
+			t.Errorf("Builder [%d] produced wrong output:\n  Got: %q\n  Expected: %q", i, got, c.output)
+		}
+	}
+}
+
+func TestBuilderCommands(t *testing.T) {
+	b := NewBuilder().At(Line(2)).Block(func(b *Builder) { b.Print().Quit(1) })
+	var names []string
+	ast.Walk(b.Commands(), func(cmd *ast.Command) bool {
+		names = append(names, cmd.Name)
+		return true
+	})
+	if got := len(names); got != 3 || names[0] != "{" || names[2] != "q" {
+		t.Errorf("Walk over the builder's commands visited %v", names)
+	}
+}
+
+func TestBuilderLabels(t *testing.T) {
+	for i, b := range []*Builder{
+		NewBuilder().Label("a b").Branch("a b"),
+		NewBuilder().Label("top").Block(func(b *Builder) { b.BranchIfSubst("top;p") }),
+		NewBuilder().BranchUnlessSubst("end\n"),
+		NewBuilder().Label("a;p").Branch("a"),
+	} {
+		if _, errs := b.Compile(Options{}); len(errs) == 0 {
+			t.Errorf("Builder [%d] %q compiled with a bad label", i, b.String())
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+// bsdOptions are the options of FreeBSD and macOS sed. There are no long
+// options, and -i and -I always take a suffix, which may be empty.
+var bsdOptions = []option{
+	{name: "regexp-extended", short: 'E'},
+	{name: "delay-open", short: 'a'},
+	{name: "expression", short: 'e', arg: requiredArgument},
+	{name: "file", short: 'f', arg: requiredArgument},
+	{name: "in-place-joined", short: 'I', arg: requiredArgument},
+	{name: "in-place", short: 'i', arg: requiredArgument},
+	{name: "line-buffered", short: 'l'},
+	{name: "quiet", short: 'n'},
+	{name: "regexp-extended", short: 'r'},
+	{name: "unbuffered", short: 'u'},
+}
+
+const bsdUsage = `usage: gosed script [-Ealnru] [-i extension] [file ...]
+	gosed [-Ealnu] [-i extension] [-e script] ... [-f script_file] ... [file ...]
+`
+
+// bsdGetopt splits args into options and operands the way BSD getopt
+// does: options end at the first operand or at "--", and a lone "-" is an
+// operand.
+func bsdGetopt(args []string, opts []option) ([]parsedOption, []string, error) {
+	var parsed []parsedOption
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return parsed, args[i+1:], nil
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			return parsed, args[i:], nil
+		}
+		for j, r := range arg[1:] {
+			opt, ok := lookupShort(r, opts)
+			if !ok {
+				return nil, nil, fmt.Errorf("illegal option -- %c", r)
+			}
+			po := parsedOption{name: opt.name}
+			if opt.arg == noArgument {
+				parsed = append(parsed, po)
+				continue
+			}
+			if remainder := arg[1+j+len(string(r)):]; remainder != "" {
+				po.value = remainder
+			} else if i+1 < len(args) {
+				i++
+				po.value = args[i]
+			} else {
+				return nil, nil, fmt.Errorf("option requires an argument -- %c", r)
+			}
+			po.hasArg = true
+			parsed = append(parsed, po)
+			break
+		}
+	}
+	return parsed, nil, nil
+}
+
+// configFromBSDArgs builds the configuration from command line arguments
+// in the syntax of BSD sed.
+func configFromBSDArgs(args []string) (Config, []string, error) {
+	conf := Config{dialect: gosed.BSD}
+	if cols, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && cols > 1 {
+		// As in BSD sed, l wraps to the terminal width when it is known.
+		conf.lineLength = cols
+	}
+	opts, operands, err := bsdGetopt(args, bsdOptions)
+	if err != nil {
+		return conf, nil, err
+	}
+	for _, opt := range opts {
+		switch opt.name {
+		case "regexp-extended":
+			conf.extendedRegexp = true
+		case "delay-open":
+			// Files for w are always opened when first written to.
+		case "expression":
+			conf.scripts = append(conf.scripts, scriptSource{text: opt.value})
+		case "file":
+			conf.scripts = append(conf.scripts, scriptSource{text: opt.value, isFile: true})
+		case "in-place-joined":
+			conf.editInplace = true
+			conf.separate = false
+			conf.inplaceExtension = opt.value
+		case "in-place":
+			conf.editInplace = true
+			conf.separate = true
+			conf.inplaceExtension = opt.value
+		case "line-buffered", "unbuffered":
+			conf.unbuffered = true
+		case "quiet":
+			conf.silenceLine = true
+		}
+	}
+	return conf, operands, nil
+}
+
+// bsdSyntaxError formats the first compile error the way BSD sed does,
+// naming the script piece it came from and the line within that piece.
+func bsdSyntaxError(conf Config, pieces []string, errs []string) error {
+	msg := errs[0]
+	line := 1
+	if strings.HasPrefix(msg, "line ") {
+		if idx := strings.Index(msg, ": "); idx != -1 {
+			if n, err := strconv.Atoi(msg[len("line "):idx]); err == nil {
+				line, msg = n, msg[idx+2:]
+			}
+		}
+	}
+	for i, src := range conf.scripts {
+		n := strings.Count(pieces[i], "\n") + 1
+		if line <= n {
+			if src.isFile {
+				return fmt.Errorf("%s: %d: %s", src.text, line, msg)
+			}
+			return fmt.Errorf("%d: %q: %s", line, src.text, msg)
+		}
+		line -= n
+	}
+	return errors.New(msg)
+}
+
+// bsdErrorText formats an error opening name like BSD's warn(3).
+func bsdErrorText(name string, err error) string {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	text := []rune(err.Error())
+	if len(text) > 0 {
+		text[0] = unicode.ToUpper(text[0])
+	}
+	return name + ": " + string(text)
+}
+
+// runJoinedInplace runs the program over all files as one stream, as with
+// BSD sed's -I, writing the output of each line back to the file the line
+// came from.
+func runJoinedInplace(program *gosed.Program, conf Config, files []string) int {
+	status := 0
+	delim := conf.lineDelim()
+	var buff bytes.Buffer
+	var names []string
+	var contents [][]byte
+	for _, f := range files {
+		if f == "-" {
+			fmt.Fprintln(os.Stderr, "gosed: couldn't edit -: not a regular file")
+			status = exitPanic
+			continue
+		}
+		d, err := readInput(f)
+		if err != nil {
+			status = conf.cantRead(f, err)
+			continue
+		}
+		if buff.Len() > 0 && buff.Bytes()[buff.Len()-1] != delim {
+			buff.WriteByte(delim)
+		}
+		buff.Write(d)
+		names = append(names, f)
+		contents = append(contents, d)
+	}
+	if len(names) == 0 {
+		return status
+	}
+
+	router := conf.router
+	router.firstLines = firstLines(contents, delim)
+	router.outputs = make([]bytes.Buffer, len(names))
+	if conf.tracer != nil {
+		conf.tracer.setInputs(names, contents, delim)
+	}
+	data := buff.Bytes()
+	terminated := len(data) > 0 && data[len(data)-1] == delim
+	if terminated {
+		data = data[:len(data)-1]
+	}
+	if len(data) > 0 {
+		program.Filter(data)
+	}
+	if out := &router.outputs[router.written]; !terminated && out.Len() > 0 {
+		// The output always ends in a delimiter, but the input did not.
+		out.Truncate(out.Len() - 1)
+	}
+
+	for i, name := range names {
+		if err := editInplace(name, router.outputs[i].Bytes(), conf); err != nil {
+			fmt.Fprintf(os.Stderr, "gosed: couldn't edit %s: %v\n", name, err)
+			status = exitPanic
+		}
+	}
+	return status
+}
+
+// inplaceRouter is a tracer that sends the output written during each
+// cycle to the file the cycle's line was read from. Other events are
+// passed on to next, if set.
+type inplaceRouter struct {
+	firstLines []int // The line number each file starts at.
+	outputs    []bytes.Buffer
+	last       int // The index of the file of the current line.
+	written    int // The index of the file last written to.
+	next       *debugTracer
+}
+
+func (r *inplaceRouter) StartCycle(lineNo int, patternSpace string) {
+	for r.last+1 < len(r.firstLines) && r.firstLines[r.last+1] <= lineNo {
+		r.last++
+	}
+	if r.next != nil {
+		r.next.StartCycle(lineNo, patternSpace)
+	}
+}
+
+func (r *inplaceRouter) Command(cmd string, depth int, matched bool) {
+	if r.next != nil {
+		r.next.Command(cmd, depth, matched)
+	}
+}
+
+func (r *inplaceRouter) PatternSpace(ps string) {
+	if r.next != nil {
+		r.next.PatternSpace(ps)
+	}
+}
+
+func (r *inplaceRouter) HoldSpace(hs string) {
+	if r.next != nil {
+		r.next.HoldSpace(hs)
+	}
+}
+
+func (r *inplaceRouter) Match(ps string, loc []int) {
+	if r.next != nil {
+		r.next.Match(ps, loc)
+	}
+}
+
+func (r *inplaceRouter) Output(out string) {
+	r.outputs[r.last].WriteString(out)
+	r.written = r.last
+	if r.next != nil {
+		r.next.Output(out)
+	}
+}
+
+func (r *inplaceRouter) EndCycle() {
+	if r.next != nil {
+		r.next.EndCycle()
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestBSDGetopt(t *testing.T) {
+	tests := []struct {
+		args     []string
+		opts     []parsedOption
+		operands []string
+		err      string
+	}{
+		{
+			args:     []string{"-ne", "p", "file"},
+			opts:     []parsedOption{{name: "quiet"}, {name: "expression", value: "p", hasArg: true}},
+			operands: []string{"file"},
+		},
+		{
+			args:     []string{"-i", "", "s/a/b/", "file"},
+			opts:     []parsedOption{{name: "in-place", hasArg: true}},
+			operands: []string{"s/a/b/", "file"},
+		},
+		{
+			args:     []string{"-I.bak", "-E", "p"},
+			opts:     []parsedOption{{name: "in-place-joined", value: ".bak", hasArg: true}, {name: "regexp-extended"}},
+			operands: []string{"p"},
+		},
+		{
+			args:     []string{"p", "-n", "file"},
+			operands: []string{"p", "-n", "file"},
+		},
+		{
+			args:     []string{"-n", "--", "-p"},
+			opts:     []parsedOption{{name: "quiet"}},
+			operands: []string{"-p"},
+		},
+		{
+			args:     []string{"-", "-n"},
+			operands: []string{"-", "-n"},
+		},
+		{args: []string{"-s"}, err: "illegal option -- s"},
+		{args: []string{"--posix"}, err: "illegal option -- -"},
+		{args: []string{"-ni"}, err: "option requires an argument -- i"},
+	}
+
+	for i, tt := range tests {
+		opts, operands, err := bsdGetopt(tt.args, bsdOptions)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Args [%d] %v expected error %q, got %v", i, tt.args, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Args [%d] %v expected no error, got %v", i, tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(opts, tt.opts) {
+			t.Errorf("Args [%d] %v produced wrong options.\n  Got: %v\n  Expected: %v", i, tt.args, opts, tt.opts)
+		}
+		if !reflect.DeepEqual(operands, tt.operands) {
+			t.Errorf("Args [%d] %v produced wrong operands.\n  Got: %v\n  Expected: %v", i, tt.args, operands, tt.operands)
+		}
+	}
+}
+
+func TestBSDSyntaxError(t *testing.T) {
+	conf := Config{scripts: []scriptSource{
+		{text: "p"},
+		{text: "s/a/b/\nk"},
+		{text: "prog.sed", isFile: true},
+	}}
+	pieces := []string{"p", "s/a/b/\nk", "p\n\nk"}
+	tests := []struct {
+		err      string
+		expected string
+	}{
+		{"line 3: unknown command", `2: "s/a/b/\nk": unknown command`},
+		{"line 6: unknown command", "prog.sed: 3: unknown command"},
+		{"unexpected EOF", `1: "p": unexpected EOF`},
+	}
+	for _, tt := range tests {
+		if got := bsdSyntaxError(conf, pieces, []string{tt.err}).Error(); got != tt.expected {
+			t.Errorf("bsdSyntaxError(%q) = %q, expected %q", tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestBSDErrorText(t *testing.T) {
+	err := &os.PathError{Op: "open", Path: "missing", Err: errors.New("no such file or directory")}
+	if got := bsdErrorText("missing", err); got != "missing: No such file or directory" {
+		t.Errorf("bsdErrorText() = %q", got)
+	}
+}
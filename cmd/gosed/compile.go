@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+// compileOptions are the options of the compile subcommand besides
+// scriptOptions.
+var compileOptions = []option{
+	{name: "output", short: 'o', long: "output", arg: requiredArgument},
+	{name: "sandbox", long: "sandbox"},
+}
+
+const compileUsage = `Usage: gosed compile [-o FILE] [--sandbox] [-E] [--posix] script-file
+
+Compile a sed script and write the compiled program, for embedding in a
+Go binary with //go:embed and loading with gosed.Load without parsing
+the script at run time:
+
+  //go:embed prog.bin
+  var prog []byte
+
+  p, err := gosed.Load(prog, gosed.Options{})
+
+Syntax errors are reported when the script is compiled rather than when
+the program is loaded. A program only loads with the version of gosed
+that compiled it.
+
+  -o FILE, --output=FILE
+                 write the program to FILE instead of standard output
+      --sandbox  reject scripts that use the e, r or w commands
+  -E, -r, --regexp-extended
+                 the script uses extended regular expressions
+      --posix    the script is POSIX sed
+`
+
+// runCompile runs the compile subcommand with the arguments that follow
+// it.
+func runCompile(args []string, w io.Writer) int {
+	options, opts, files, err := parseScriptArgs(args, compileOptions)
+	output := ""
+	for _, opt := range opts {
+		switch opt.name {
+		case "output":
+			output = opt.value
+		case "sandbox":
+			options.Sandbox = true
+		case "help":
+			fmt.Fprint(w, compileUsage)
+			return 0
+		}
+	}
+	if err == nil && len(files) != 1 {
+		err = errors.New("compile takes one script file")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %v\n%s", err, compileUsage)
+		return exitBadUsage
+	}
+
+	script, err := readInput(files[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: can't read %s: %v\n", files[0], err)
+		return exitBadInput
+	}
+	program, errs := gosed.Compile(string(script), options)
+	if errs != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %s: syntax error: %s\n", files[0], strings.Join(errs, "; "))
+		return exitBadUsage
+	}
+	data, _ := program.MarshalBinary()
+	if output == "" {
+		w.Write(data)
+		return 0
+	}
+	if err := ioutil.WriteFile(output, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: couldn't write %s: %v\n", output, err)
+		return exitPanic
+	}
+	return 0
+}
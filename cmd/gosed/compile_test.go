@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+func TestRunCompile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosed-compile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a.sed")
+	if err := ioutil.WriteFile(name, []byte("s/a+/b/g\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "a.bin")
+	if status := runCompile([]string{"-E", "-o", output, name}, &bytes.Buffer{}); status != 0 {
+		t.Fatalf("gosed compile exited with %d", status)
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := gosed.Load(data, gosed.Options{})
+	if err != nil {
+		t.Fatalf("gosed compile wrote a program Load can't read: %v", err)
+	}
+	if got := p.FilterString("caaat"); got != "cbt" {
+		t.Errorf("compiled program printed %q, expected %q", got, "cbt")
+	}
+
+	var out bytes.Buffer
+	if status := runCompile([]string{name}, &out); status != 0 || !bytes.HasPrefix(out.Bytes(), []byte("gosed")) {
+		t.Errorf("gosed compile to standard output exited with %d and wrote %q", status, out.Bytes())
+	}
+
+	if err := ioutil.WriteFile(name, []byte("w out\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{}, {"--sandbox", name}} {
+		if status := runCompile(args, &bytes.Buffer{}); status != exitBadUsage {
+			t.Errorf("gosed compile %q exited with %d, expected %d", args, status, exitBadUsage)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// debugTracer prints the execution of a program in the format of GNU
+// sed's --debug flag. Program output is written through the tracer so
+// that it is interleaved with the trace.
+type debugTracer struct {
+	w          io.Writer
+	showOutput bool // False when output goes to a file instead, as with -i.
+	inputs     []debugInput
+}
+
+// debugInput records the line number at which an input file starts.
+type debugInput struct {
+	name      string
+	firstLine int
+}
+
+// setInputs records the files that make up the input of the next run
+// along with their contents, so that line numbers can be mapped back to
+// file names.
+func (t *debugTracer) setInputs(names []string, data [][]byte, delim byte) {
+	t.inputs = t.inputs[:0]
+	lines := firstLines(data, delim)
+	for i, name := range names {
+		if name == "-" {
+			name = "STDIN"
+		}
+		t.inputs = append(t.inputs, debugInput{name: name, firstLine: lines[i]})
+	}
+}
+
+// firstLines returns the line number at which each of the files with the
+// given contents starts when they are joined into one stream.
+func firstLines(data [][]byte, delim byte) []int {
+	lines := make([]int, len(data))
+	line := 1
+	for i, d := range data {
+		lines[i] = line
+		line += bytes.Count(d, []byte{delim})
+		if len(d) > 0 && d[len(d)-1] != delim {
+			line++
+		}
+	}
+	return lines
+}
+
+func (t *debugTracer) inputName(lineNo int) string {
+	name := "STDIN"
+	for _, in := range t.inputs {
+		if in.firstLine > lineNo {
+			break
+		}
+		name = in.name
+	}
+	return name
+}
+
+// printProgram prints the canonical form of the program.
+func (t *debugTracer) printProgram(program string) {
+	fmt.Fprintln(t.w, "SED PROGRAM:")
+	for _, line := range strings.Split(strings.TrimSuffix(program, "\n"), "\n") {
+		fmt.Fprintln(t.w, "  "+line)
+	}
+}
+
+func (t *debugTracer) StartCycle(lineNo int, patternSpace string) {
+	fmt.Fprintf(t.w, "INPUT:   '%s' line %d\n", t.inputName(lineNo), lineNo)
+	t.PatternSpace(patternSpace)
+}
+
+func (t *debugTracer) Command(cmd string, depth int, matched bool) {
+	fmt.Fprintf(t.w, "COMMAND: %s%s\n", strings.Repeat("  ", depth), cmd)
+}
+
+func (t *debugTracer) PatternSpace(ps string) {
+	fmt.Fprintf(t.w, "PATTERN: %s\n", debugEscape(ps))
+}
+
+func (t *debugTracer) HoldSpace(hs string) {
+	fmt.Fprintf(t.w, "HOLD:    %s\n", debugEscape(hs))
+}
+
+// Match prints the registers of the first match of an s command. GNU sed
+// leaves out the groups that took no part and doesn't escape the text.
+func (t *debugTracer) Match(ps string, loc []int) {
+	fmt.Fprintln(t.w, "MATCHED REGEX REGISTERS")
+	for i := 0; i+1 < len(loc); i += 2 {
+		if loc[i] < 0 {
+			continue
+		}
+		fmt.Fprintf(t.w, "  regex[%d] = %d-%d '%s'\n", i/2, loc[i], loc[i+1], ps[loc[i]:loc[i+1]])
+	}
+}
+
+func (t *debugTracer) Output(out string) {
+	if t.showOutput {
+		io.WriteString(t.w, out)
+	}
+}
+
+func (t *debugTracer) EndCycle() {
+	fmt.Fprintln(t.w, "END-OF-CYCLE:")
+}
+
+// debugEscape makes the contents of a buffer printable on one line the
+// way GNU sed does: control characters and backslashes are escaped and
+// other non-printable bytes are shown in octal. Bytes from 0x80 up are
+// non-printable too, and GNU sed shows them sign-extended as its signed
+// chars are, so that \xc3 is \o37777777703.
+func debugEscape(s string) string {
+	var buff bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			buff.WriteString(`\\`)
+		case '\a':
+			buff.WriteString(`\a`)
+		case '\f':
+			buff.WriteString(`\f`)
+		case '\r':
+			buff.WriteString(`\r`)
+		case '\t':
+			buff.WriteString(`\t`)
+		case '\v':
+			buff.WriteString(`\v`)
+		case '\n':
+			buff.WriteString(`\n`)
+		default:
+			switch {
+			case c < ' ' || c == 0x7f:
+				fmt.Fprintf(&buff, `\o%03o`, c)
+			case c >= 0x80:
+				fmt.Fprintf(&buff, `\o%o`, uint32(int8(c)))
+			default:
+				buff.WriteByte(c)
+			}
+		}
+	}
+	return buff.String()
+}
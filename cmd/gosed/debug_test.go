@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+// gnuTrace is what GNU sed 4.9 prints for
+//
+//	sed --debug -E 's/(o)(.)|(q)/[\2\1]/;s/zz/y/;/f(o)/h;x;G;y/o/0/' in.txt
+//
+// with debugText as in.txt.
+const gnuTrace = `SED PROGRAM:
+  s/(o)(.)|(q)/[\2\1]/
+  s/zz/y/
+  /f(o)/ h
+  x
+  G
+  y/o/0/
+INPUT:   'in.txt' line 1
+PATTERN: hello world
+COMMAND: s/(o)(.)|(q)/[\2\1]/
+MATCHED REGEX REGISTERS
+  regex[0] = 4-6 'o '
+  regex[1] = 4-5 'o'
+  regex[2] = 5-6 ' '
+PATTERN: hell[ o]world
+COMMAND: s/zz/y/
+PATTERN: hell[ o]world
+COMMAND: /f(o)/ h
+COMMAND: x
+PATTERN: 
+HOLD:    hell[ o]world
+COMMAND: G
+PATTERN: \nhell[ o]world
+COMMAND: y/o/0/
+PATTERN: \nhell[ 0]w0rld
+END-OF-CYCLE:
+
+hell[ 0]w0rld
+INPUT:   'in.txt' line 2
+PATTERN: foo
+COMMAND: s/(o)(.)|(q)/[\2\1]/
+MATCHED REGEX REGISTERS
+  regex[0] = 1-3 'oo'
+  regex[1] = 1-2 'o'
+  regex[2] = 2-3 'o'
+PATTERN: f[oo]
+COMMAND: s/zz/y/
+PATTERN: f[oo]
+COMMAND: /f(o)/ h
+COMMAND: x
+PATTERN: hell[ o]world
+HOLD:    f[oo]
+COMMAND: G
+PATTERN: hell[ o]world\nf[oo]
+COMMAND: y/o/0/
+PATTERN: hell[ 0]w0rld\nf[00]
+END-OF-CYCLE:
+hell[ 0]w0rld
+f[00]
+INPUT:   'in.txt' line 3
+PATTERN: \tb\\\o37777777703\o37777777651
+COMMAND: s/(o)(.)|(q)/[\2\1]/
+PATTERN: \tb\\\o37777777703\o37777777651
+COMMAND: s/zz/y/
+PATTERN: \tb\\\o37777777703\o37777777651
+COMMAND: /f(o)/ h
+COMMAND: x
+PATTERN: f[oo]
+HOLD:    \tb\\\o37777777703\o37777777651
+COMMAND: G
+PATTERN: f[oo]\n\tb\\\o37777777703\o37777777651
+COMMAND: y/o/0/
+PATTERN: f[00]\n\tb\\\o37777777703\o37777777651
+END-OF-CYCLE:
+f[00]
+	b\é
+`
+
+const debugText = "hello world\nfoo\n\tb\\\xc3\xa9\n"
+
+func TestDebugTracer(t *testing.T) {
+	var out bytes.Buffer
+	tracer := &debugTracer{w: &out, showOutput: true}
+	prg, errs := gosed.Compile(`s/(o)(.)|(q)/[\2\1]/;s/zz/y/;/f(o)/h;x;G;y/o/0/`, gosed.Options{ExtendRegexp: true, Trace: tracer})
+	if errs != nil {
+		t.Fatal(errs)
+	}
+	tracer.printProgram(prg.String())
+	tracer.setInputs([]string{"in.txt"}, [][]byte{[]byte(debugText)}, '\n')
+	if _, _, err := prg.RunFile([]byte(debugText)); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != gnuTrace {
+		t.Errorf("Trace differs from GNU sed's.\n  Got:\n%s\n  Expected:\n%s", got, gnuTrace)
+	}
+}
+
+func TestDebugEscape(t *testing.T) {
+	tests := []struct {
+		buffer   string
+		expected string
+	}{
+		{"hello world", "hello world"},
+		{"a\nb", `a\nb`},
+		{"\tb\\\xc3\xa9", `\tb\\\o37777777703\o37777777651`},
+		{"\x01\x7f\r\f\v\a", `\o001\o177\r\f\v\a`},
+	}
+	for i, tt := range tests {
+		if got := debugEscape(tt.buffer); got != tt.expected {
+			t.Errorf("debugEscape [%d] incorrect.\n  Got: %q\n  Expected: %q", i, got, tt.expected)
+		}
+	}
+}
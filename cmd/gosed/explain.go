@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+// explainOptions are the options of the explain subcommand besides
+// scriptOptions. They give the script as for gosed itself.
+var explainOptions = []option{
+	{name: "expression", short: 'e', long: "expression", arg: requiredArgument},
+	{name: "file", short: 'f', long: "file", arg: requiredArgument},
+}
+
+const explainUsage = `Usage: gosed explain [-E] [--posix] script
+  or:  gosed explain [-E] [--posix] {-e script | -f script-file}...
+
+Describe in English what each command of a sed script does. The script
+is printed in canonical form with a comment before each command.
+
+  -e script, --expression=script
+                 add the script to the commands to explain
+  -f script-file, --file=script-file
+                 add the contents of script-file to the commands to explain
+  -E, -r, --regexp-extended
+                 the script uses extended regular expressions
+      --posix    the script is POSIX sed
+`
+
+// runExplain runs the explain subcommand with the arguments that follow
+// it.
+func runExplain(args []string, w io.Writer) int {
+	options, opts, operands, err := parseScriptArgs(args, explainOptions)
+	var parts []string
+	for _, opt := range opts {
+		if err != nil {
+			break
+		}
+		switch opt.name {
+		case "expression":
+			parts = append(parts, opt.value)
+		case "file":
+			var script []byte
+			if script, err = readInput(opt.value); err == nil {
+				parts = append(parts, strings.TrimSuffix(string(script), "\n"))
+			}
+		case "help":
+			fmt.Fprint(w, explainUsage)
+			return 0
+		}
+	}
+	if err == nil && len(parts) == 0 {
+		if len(operands) != 1 {
+			err = errors.New("explain takes one script")
+		} else {
+			parts, operands = operands, nil
+		}
+	}
+	if err == nil && len(operands) > 0 {
+		err = fmt.Errorf("unexpected argument %q", operands[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %v\n%s", err, explainUsage)
+		return exitBadUsage
+	}
+
+	program, errs := gosed.Compile(strings.Join(parts, "\n"), options)
+	if errs != nil {
+		fmt.Fprintf(os.Stderr, "gosed: syntax error: %s\n", strings.Join(errs, "; "))
+		return exitBadUsage
+	}
+	fmt.Fprint(w, program.Explain())
+	return 0
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRunExplain(t *testing.T) {
+	expected := "# Print the pattern space.\np\n# For line 2, exit without printing the pattern space.\n2 Q\n"
+	for _, args := range [][]string{{"p;2Q"}, {"-e", "p", "--expression=2Q"}} {
+		var out bytes.Buffer
+		if status := runExplain(args, &out); status != 0 {
+			t.Errorf("gosed explain %q exited with %d", args, status)
+		}
+		if out.String() != expected {
+			t.Errorf("gosed explain %q printed:\n%s\nexpected:\n%s", args, out.String(), expected)
+		}
+	}
+	if status := runExplain([]string{"p", "d"}, &bytes.Buffer{}); status != exitBadUsage {
+		t.Errorf("gosed explain with two scripts exited with %d, expected %d", status, exitBadUsage)
+	}
+}
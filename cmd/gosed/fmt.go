@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+// fmtOptions are the options of the fmt subcommand besides scriptOptions.
+var fmtOptions = []option{
+	{name: "write", short: 'w', long: "write"},
+}
+
+const fmtUsage = `Usage: gosed fmt [-w] [-E] [--posix] [script-file]...
+
+Print each sed script in canonical form: one command per line, blocks
+indented by two spaces, / delimiters and comments kept. With no files,
+the script is read from standard input.
+
+  -w, --write    write the result back to each file instead of printing it
+  -E, -r, --regexp-extended
+                 the scripts use extended regular expressions
+      --posix    the scripts are POSIX sed
+`
+
+// runFmt runs the fmt subcommand with the arguments that follow it.
+func runFmt(args []string, w io.Writer) int {
+	options, opts, files, err := parseScriptArgs(args, fmtOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %v\n%s", err, fmtUsage)
+		return exitBadUsage
+	}
+	write := false
+	for _, opt := range opts {
+		switch opt.name {
+		case "write":
+			write = true
+		case "help":
+			fmt.Fprint(w, fmtUsage)
+			return 0
+		}
+	}
+	if len(files) == 0 {
+		if write {
+			fmt.Fprintln(os.Stderr, "gosed: fmt -w requires script files")
+			return exitBadUsage
+		}
+		files = []string{"-"}
+	}
+
+	status := 0
+	for _, name := range files {
+		script, err := readInput(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gosed: can't read %s: %v\n", name, err)
+			status = exitBadInput
+			continue
+		}
+		out, errs := gosed.Format(string(script), options)
+		if errs != nil {
+			fmt.Fprintf(os.Stderr, "gosed: %s: syntax error: %s\n", name, strings.Join(errs, "; "))
+			status = exitBadUsage
+			continue
+		}
+		if !write {
+			fmt.Fprint(w, out)
+			continue
+		}
+		if out == string(script) {
+			continue
+		}
+		info, err := os.Stat(name)
+		if err == nil {
+			err = ioutil.WriteFile(name, []byte(out), info.Mode())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gosed: couldn't write %s: %v\n", name, err)
+			status = exitPanic
+		}
+	}
+	return status
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunFmt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosed-fmt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := "# swap\n/a/{h;x} # both\n\n\ns|/|-|g"
+	expected := "# swap\n/a/ {\n  h\n  x\n} # both\n\ns/\\//-/g\n"
+	name := filepath.Join(dir, "swap.sed")
+	if err := ioutil.WriteFile(name, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if status := runFmt([]string{name}, &out); status != 0 {
+		t.Fatalf("gosed fmt exited with %d", status)
+	}
+	if out.String() != expected {
+		t.Errorf("gosed fmt printed:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	if status := runFmt([]string{"-w", name}, &out); status != 0 {
+		t.Fatalf("gosed fmt -w exited with %d", status)
+	}
+	if out.Len() != 0 {
+		t.Errorf("gosed fmt -w printed %q", out.String())
+	}
+	if data, _ := ioutil.ReadFile(name); string(data) != expected {
+		t.Errorf("gosed fmt -w wrote:\n%s\nexpected:\n%s", data, expected)
+	}
+
+	bad := filepath.Join(dir, "bad.sed")
+	if err := ioutil.WriteFile(bad, []byte("k"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if status := runFmt([]string{"-w", bad}, &out); status != exitBadUsage {
+		t.Errorf("gosed fmt on a bad script exited with %d, expected %d", status, exitBadUsage)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+// genOptions are the options of the gen subcommand besides scriptOptions.
+var genOptions = []option{
+	{name: "pkg", long: "pkg", arg: requiredArgument},
+	{name: "func", long: "func", arg: requiredArgument},
+	{name: "output", short: 'o', long: "output", arg: requiredArgument},
+	{name: "quiet", short: 'n', long: "quiet"},
+	{name: "null-data", short: 'z', long: "null-data"},
+	{name: "line-length", short: 'l', long: "line-length", arg: requiredArgument},
+}
+
+const genUsage = `Usage: gosed gen [-pkg NAME] [-func NAME] [-o FILE] [-n] [-z] [-l N] [-E] [--posix] script-file
+
+Write Go source for a function that runs the sed script ahead of time:
+
+  func NAME(r io.Reader, w io.Writer) error
+
+It reads lines from r and writes what the script prints to w, as gosed
+would, with the script's regexps compiled once and its branches written
+as gotos. The file only needs the standard library, so it suits a
+//go:generate comment. Scripts that read or write files, run commands
+or use back-references in regexps can't be generated.
+
+  -pkg NAME, --pkg=NAME
+                 the package of the file (default main)
+  -func NAME, --func=NAME
+                 the name of the function (default Transform)
+  -o FILE, --output=FILE
+                 write the file to FILE instead of standard output
+  -n, --quiet    don't print the pattern space at the end of each cycle
+  -z, --null-data
+                 separate lines by NUL characters
+  -l N, --line-length=N
+                 the line-wrap length for the l command
+  -E, -r, --regexp-extended
+                 the script uses extended regular expressions
+      --posix    the script is POSIX sed
+`
+
+// runGen runs the gen subcommand with the arguments that follow it.
+func runGen(args []string, w io.Writer) int {
+	// -pkg and -func are written as for the go tools.
+	args = append([]string{}, args...)
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if name := strings.SplitN(arg, "=", 2)[0]; name == "-pkg" || name == "-func" {
+			args[i] = "-" + arg
+		}
+	}
+	options, opts, files, err := parseScriptArgs(args, genOptions)
+	pkg, name, output := "main", "Transform", ""
+	for _, opt := range opts {
+		if err != nil {
+			break
+		}
+		switch opt.name {
+		case "pkg":
+			pkg = opt.value
+		case "func":
+			name = opt.value
+		case "output":
+			output = opt.value
+		case "quiet":
+			options.SupressOutput = true
+		case "null-data":
+			options.NullData = true
+		case "line-length":
+			n, convErr := strconv.Atoi(opt.value)
+			if convErr != nil || n < 0 {
+				err = fmt.Errorf("invalid line length: %s", opt.value)
+			}
+			options.LineLength = n
+			if n == 0 {
+				// -l 0 turns wrapping off.
+				options.LineLength = -1
+			}
+		case "help":
+			fmt.Fprint(w, genUsage)
+			return 0
+		}
+	}
+	if err == nil && len(files) != 1 {
+		err = errors.New("gen takes one script file")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %v\n%s", err, genUsage)
+		return exitBadUsage
+	}
+
+	script, err := readInput(files[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: can't read %s: %v\n", files[0], err)
+		return exitBadInput
+	}
+	program, errs := gosed.Compile(string(script), options)
+	if errs != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %s: syntax error: %s\n", files[0], strings.Join(errs, "; "))
+		return exitBadUsage
+	}
+	src, err := program.GenerateGo(pkg, name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %s: %v\n", files[0], err)
+		return exitBadUsage
+	}
+	if output == "" {
+		w.Write(src)
+		return 0
+	}
+	if err := ioutil.WriteFile(output, src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: couldn't write %s: %v\n", output, err)
+		return exitPanic
+	}
+	return 0
+}
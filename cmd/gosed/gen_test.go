@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunGen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosed-gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a.sed")
+	if err := ioutil.WriteFile(name, []byte("s/a/b/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if status := runGen([]string{"-pkg", "foo", "-func=Swap", name}, &out); status != 0 {
+		t.Fatalf("gosed gen exited with %d", status)
+	}
+	for _, expected := range []string{"package foo\n", "func Swap(r io.Reader, w io.Writer) error {\n", "regexp.MustCompile(`(?s)a`)"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("gosed gen printed:\n%s\nexpected it to contain %q", out.String(), expected)
+		}
+	}
+
+	output := filepath.Join(dir, "swap.go")
+	if status := runGen([]string{"--pkg=foo", "-o", output, name}, &bytes.Buffer{}); status != 0 {
+		t.Fatalf("gosed gen -o exited with %d", status)
+	}
+	if data, _ := ioutil.ReadFile(output); !bytes.Contains(data, []byte("func Transform(")) {
+		t.Errorf("gosed gen -o wrote:\n%s", data)
+	}
+
+	for _, args := range [][]string{{}, {"-pkg", "no-pkg", name}} {
+		if status := runGen(args, &bytes.Buffer{}); status != exitBadUsage {
+			t.Errorf("gosed gen %q exited with %d, expected %d", args, status, exitBadUsage)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// argKind describes whether an option takes an argument.
+type argKind int
+
+const (
+	noArgument       argKind = iota
+	requiredArgument         // -e script, -escript, --expression=script, --expression script
+	optionalArgument         // -i.bak, --in-place=.bak; never taken from the next argument
+)
+
+// option describes a single command line option as getopt_long sees it.
+// Either short or long may be left empty. Options with the same name are
+// aliases of each other (e.g. --quiet and --silent).
+type option struct {
+	name  string
+	short rune
+	long  string
+	arg   argKind
+}
+
+// parsedOption is an option found on the command line.
+type parsedOption struct {
+	name   string
+	value  string
+	hasArg bool
+}
+
+// gnuOptions is the full option list of GNU sed 4.9, along with the
+// options only gosed has.
+var gnuOptions = []option{
+	{name: "quiet", short: 'n', long: "quiet"},
+	{name: "quiet", long: "silent"},
+	{name: "debug", long: "debug"},
+	{name: "expression", short: 'e', long: "expression", arg: requiredArgument},
+	{name: "file", short: 'f', long: "file", arg: requiredArgument},
+	{name: "follow-symlinks", long: "follow-symlinks"},
+	{name: "in-place", short: 'i', long: "in-place", arg: optionalArgument},
+	{name: "line-length", short: 'l', long: "line-length", arg: requiredArgument},
+	{name: "posix", long: "posix"},
+	{name: "regexp-extended", short: 'E', long: "regexp-extended"},
+	{name: "regexp-extended", short: 'r'},
+	{name: "separate", short: 's', long: "separate"},
+	{name: "sandbox", long: "sandbox"},
+	{name: "unbuffered", short: 'u', long: "unbuffered"},
+	{name: "null-data", short: 'z', long: "null-data"},
+	{name: "null-data", long: "zero-terminated"},
+	{name: "binary", short: 'b', long: "binary"},
+	{name: "help", short: 'h', long: "help"},
+	{name: "version", long: "version"},
+
+	// gosed extensions.
+	{name: "interactive", long: "interactive"},
+	{name: "jobs", long: "jobs", arg: requiredArgument},
+}
+
+// getopt splits args into options and operands the way GNU getopt_long
+// does. Short options may be combined (-ne 'p'), long options may be
+// abbreviated to any unambiguous prefix, operands may appear between
+// options and "--" ends option processing. A lone "-" is an operand.
+func getopt(args []string, opts []option) ([]parsedOption, []string, error) {
+	var parsed []parsedOption
+	var operands []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			operands = append(operands, args[i+1:]...)
+			return parsed, operands, nil
+		case strings.HasPrefix(arg, "--"):
+			po, consumed, err := parseLong(arg[2:], args[i+1:], opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			parsed = append(parsed, po)
+			i += consumed
+		case len(arg) > 1 && arg[0] == '-':
+			pos, consumed, err := parseShort(arg[1:], args[i+1:], opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			parsed = append(parsed, pos...)
+			i += consumed
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return parsed, operands, nil
+}
+
+// parseLong parses a single long option (without the leading "--"). rest
+// holds the arguments after it, and consumed reports how many of them were
+// used as the option's argument.
+func parseLong(arg string, rest []string, opts []option) (po parsedOption, consumed int, err error) {
+	name, value := arg, ""
+	hasValue := false
+	if idx := strings.IndexByte(arg, '='); idx != -1 {
+		name, value, hasValue = arg[:idx], arg[idx+1:], true
+	}
+
+	opt, err := lookupLong(name, opts)
+	if err != nil {
+		return po, 0, err
+	}
+	po = parsedOption{name: opt.name}
+	switch opt.arg {
+	case noArgument:
+		if hasValue {
+			return po, 0, fmt.Errorf("option '--%s' doesn't allow an argument", opt.long)
+		}
+	case requiredArgument:
+		if !hasValue {
+			if len(rest) == 0 {
+				return po, 0, fmt.Errorf("option '--%s' requires an argument", opt.long)
+			}
+			value, hasValue = rest[0], true
+			consumed = 1
+		}
+	}
+	po.value, po.hasArg = value, hasValue
+	return po, consumed, nil
+}
+
+// lookupLong finds the long option matching name exactly or, failing that,
+// the one option that name is a prefix of.
+func lookupLong(name string, opts []option) (option, error) {
+	var matches []option
+	for _, opt := range opts {
+		if opt.long == "" {
+			continue
+		}
+		if opt.long == name {
+			return opt, nil
+		}
+		if strings.HasPrefix(opt.long, name) {
+			matches = append(matches, opt)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return option{}, fmt.Errorf("unrecognized option '--%s'", name)
+	case len(matches) > 1:
+		for _, m := range matches[1:] {
+			if m.name != matches[0].name {
+				var possible []string
+				for _, m := range matches {
+					possible = append(possible, "'--"+m.long+"'")
+				}
+				return option{}, fmt.Errorf("option '--%s' is ambiguous; possibilities: %s", name, strings.Join(possible, " "))
+			}
+		}
+	}
+	return matches[0], nil
+}
+
+// parseShort parses a cluster of short options (without the leading "-").
+// An option taking an argument ends the cluster: the remaining characters,
+// or otherwise the next argument, become its value.
+func parseShort(cluster string, rest []string, opts []option) ([]parsedOption, int, error) {
+	var parsed []parsedOption
+	for j, r := range cluster {
+		opt, ok := lookupShort(r, opts)
+		if !ok {
+			return nil, 0, fmt.Errorf("invalid option -- '%c'", r)
+		}
+		po := parsedOption{name: opt.name}
+		remainder := cluster[j+len(string(r)):]
+		switch opt.arg {
+		case noArgument:
+			parsed = append(parsed, po)
+			continue
+		case requiredArgument:
+			if remainder != "" {
+				po.value, po.hasArg = remainder, true
+				return append(parsed, po), 0, nil
+			}
+			if len(rest) == 0 {
+				return nil, 0, fmt.Errorf("option requires an argument -- '%c'", r)
+			}
+			po.value, po.hasArg = rest[0], true
+			return append(parsed, po), 1, nil
+		case optionalArgument:
+			if remainder != "" {
+				po.value, po.hasArg = remainder, true
+			}
+			return append(parsed, po), 0, nil
+		}
+	}
+	return parsed, 0, nil
+}
+
+func lookupShort(r rune, opts []option) (option, bool) {
+	for _, opt := range opts {
+		if opt.short != 0 && opt.short == r {
+			return opt, true
+		}
+	}
+	return option{}, false
+}
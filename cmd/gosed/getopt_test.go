@@ -0,0 +1,112 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetopt(t *testing.T) {
+	tests := []struct {
+		args     []string
+		opts     []parsedOption
+		operands []string
+		isError  bool
+	}{
+		{
+			args:     []string{"-ne", "p", "file"},
+			opts:     []parsedOption{{name: "quiet"}, {name: "expression", value: "p", hasArg: true}},
+			operands: []string{"file"},
+		},
+		{
+			args: []string{"-nep"},
+			opts: []parsedOption{{name: "quiet"}, {name: "expression", value: "p", hasArg: true}},
+		},
+		{
+			args: []string{"--expression=s/a/b/", "--expression", "p"},
+			opts: []parsedOption{
+				{name: "expression", value: "s/a/b/", hasArg: true},
+				{name: "expression", value: "p", hasArg: true},
+			},
+		},
+		{
+			args: []string{"--quiet", "--silent", "--regexp-extended", "-r", "--separate", "--null-data", "--posix", "--debug"},
+			opts: []parsedOption{
+				{name: "quiet"}, {name: "quiet"}, {name: "regexp-extended"}, {name: "regexp-extended"},
+				{name: "separate"}, {name: "null-data"}, {name: "posix"}, {name: "debug"},
+			},
+		},
+		{
+			args:     []string{"-i", "s/a/b/", "file"},
+			opts:     []parsedOption{{name: "in-place"}},
+			operands: []string{"s/a/b/", "file"},
+		},
+		{
+			args:     []string{"-i.bak", "--in-place=.orig", "--in-place", "file"},
+			opts:     []parsedOption{{name: "in-place", value: ".bak", hasArg: true}, {name: "in-place", value: ".orig", hasArg: true}, {name: "in-place"}},
+			operands: []string{"file"},
+		},
+		{
+			args:     []string{"-si~", "p"},
+			opts:     []parsedOption{{name: "separate"}, {name: "in-place", value: "~", hasArg: true}},
+			operands: []string{"p"},
+		},
+		{
+			args:     []string{"p", "-n", "file"},
+			opts:     []parsedOption{{name: "quiet"}},
+			operands: []string{"p", "file"},
+		},
+		{
+			args:     []string{"-n", "--", "-p", "-"},
+			opts:     []parsedOption{{name: "quiet"}},
+			operands: []string{"-p", "-"},
+		},
+		{
+			args: []string{"--qui", "--exp=p", "--vers"},
+			opts: []parsedOption{{name: "quiet"}, {name: "expression", value: "p", hasArg: true}, {name: "version"}},
+		},
+		{args: []string{"-x"}, isError: true},
+		{args: []string{"-e"}, isError: true},
+		{args: []string{"--expression"}, isError: true},
+		{args: []string{"--quiet=yes"}, isError: true},
+		{args: []string{"--nope"}, isError: true},
+		{args: []string{"--s"}, isError: true},
+	}
+
+	for i, tt := range tests {
+		opts, operands, err := getopt(tt.args, gnuOptions)
+		if tt.isError {
+			if err == nil {
+				t.Errorf("Args [%d] %v expected an error and got none.", i, tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Args [%d] %v expected no error, got %v", i, tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(opts, tt.opts) {
+			t.Errorf("Args [%d] %v produced wrong options.\n  Got: %v\n  Expected: %v", i, tt.args, opts, tt.opts)
+		}
+		if !reflect.DeepEqual(operands, tt.operands) {
+			t.Errorf("Args [%d] %v produced wrong operands.\n  Got: %v\n  Expected: %v", i, tt.args, operands, tt.operands)
+		}
+	}
+}
+
+func TestBackupName(t *testing.T) {
+	tests := []struct {
+		name   string
+		suffix string
+		backup string
+	}{
+		{"file.txt", ".bak", "file.txt.bak"},
+		{"dir/file.txt", "~", "dir/file.txt~"},
+		{"dir/file.txt", "old_*", "dir/old_file.txt"},
+		{"dir/file.txt", "bak/*.orig", "dir/bak/file.txt.orig"},
+	}
+	for _, tt := range tests {
+		if got := backupName(tt.name, tt.suffix); got != tt.backup {
+			t.Errorf("backupName(%q, %q) = %q, expected %q", tt.name, tt.suffix, got, tt.backup)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zkry/go-sed/highlight"
+)
+
+// highlightOptions are the options of the highlight subcommand besides
+// scriptOptions.
+var highlightOptions = []option{
+	{name: "html", long: "html"},
+	{name: "ansi", long: "ansi"},
+}
+
+const highlightUsage = `Usage: gosed highlight [--html | --ansi] [-E] [script-file]...
+
+Print each sed script with syntax highlighting, including the syntax of
+its regexps and the escapes of its replacements. With no files, the
+script is read from standard input. Syntax errors are highlighted rather
+than reported.
+
+      --ansi     colour the scripts for a terminal (the default)
+      --html     print each script as an HTML pre element
+  -E, -r, --regexp-extended
+                 the scripts use extended regular expressions
+`
+
+// runHighlight runs the highlight subcommand with the arguments that
+// follow it.
+func runHighlight(args []string, w io.Writer) int {
+	options, opts, files, err := parseScriptArgs(args, highlightOptions)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %v\n%s", err, highlightUsage)
+		return exitBadUsage
+	}
+	h := &highlight.Highlighter{Theme: highlight.DefaultTheme, ExtendedRegexp: options.ExtendRegexp}
+	render := h.ANSI
+	for _, opt := range opts {
+		switch opt.name {
+		case "html":
+			render = h.HTML
+		case "ansi":
+			render = h.ANSI
+		case "help":
+			fmt.Fprint(w, highlightUsage)
+			return 0
+		}
+	}
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	status := 0
+	for _, name := range files {
+		script, err := readInput(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gosed: can't read %s: %v\n", name, err)
+			status = exitBadInput
+			continue
+		}
+		fmt.Fprint(w, render(string(script)))
+	}
+	return status
+}
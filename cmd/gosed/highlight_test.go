@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunHighlight(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosed-highlight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "a.sed")
+	if err := ioutil.WriteFile(name, []byte("s/a+/&/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		args     []string
+		contains string
+	}{
+		{[]string{name}, "\x1b["},
+		{[]string{"--html", name}, `style="color:#a5d6ff">a+</span>`},
+		{[]string{"--html", "-E", name}, `<span class="sed-regexp-meta"`},
+	} {
+		var out bytes.Buffer
+		if status := runHighlight(test.args, &out); status != 0 {
+			t.Errorf("gosed highlight %q exited with %d", test.args, status)
+		}
+		if !strings.Contains(out.String(), test.contains) {
+			t.Errorf("gosed highlight %q printed %q, expected it to contain %q", test.args, out.String(), test.contains)
+		}
+	}
+	if status := runHighlight([]string{filepath.Join(dir, "missing.sed")}, &bytes.Buffer{}); status != exitBadInput {
+		t.Errorf("gosed highlight of a missing file exited with %d, expected %d", status, exitBadInput)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	gosed "github.com/zkry/go-sed"
+	"github.com/zkry/go-sed/ast"
+)
+
+// debugger is an interactive session that steps through a program one
+// command at a time.
+type debugger struct {
+	program     *gosed.Program
+	script      []string // The lines of the script, for listing.
+	input       string
+	m           *ast.Machine
+	lineBreaks  map[int]bool
+	labelBreaks map[string]bool
+	in          *bufio.Scanner
+	out         io.Writer
+}
+
+func newDebugger(program *gosed.Program, script, input string, in io.Reader, out io.Writer) *debugger {
+	return &debugger{
+		program:     program,
+		script:      strings.Split(script, "\n"),
+		input:       input,
+		m:           program.Machine(input),
+		lineBreaks:  make(map[int]bool),
+		labelBreaks: make(map[string]bool),
+		in:          bufio.NewScanner(in),
+		out:         out,
+	}
+}
+
+const debuggerHelp = `Commands:
+  step [N]          (s)  run the next N commands
+  continue          (c)  run until a breakpoint or the end of input
+  next              (n)  run until the next input cycle starts
+  break LINE|:LABEL (b)  stop before the command on a script line or label
+  delete LINE|:LABEL     remove a breakpoint
+  breakpoints            list the breakpoints
+  print             (p)  show the current line, buffers and command
+  pattern [TEXT]         show or set the pattern space
+  hold [TEXT]            show or set the hold space
+  output            (o)  show the output written so far
+  goto N            (g)  run until input line N is read
+  list              (l)  show the script, marking the next command
+  rerun             (r)  start again from the first line of input
+  help              (h)  show this help
+  quit              (q)  leave the debugger
+TEXT may contain \n, \t and \\ escapes.
+`
+
+// run reads debugger commands until the input ends or the user quits.
+func (d *debugger) run() {
+	fmt.Fprintln(d.out, "gosed interactive debugger. Type 'help' for a list of commands.")
+	d.printState()
+	for {
+		fmt.Fprint(d.out, "(gosed) ")
+		if !d.in.Scan() {
+			fmt.Fprintln(d.out)
+			return
+		}
+		fields := strings.Fields(d.in.Text())
+		if len(fields) == 0 {
+			fields = []string{"step"}
+		}
+		arg := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(d.in.Text()), fields[0]))
+		switch fields[0] {
+		case "step", "s":
+			n := 1
+			if arg != "" {
+				var err error
+				if n, err = strconv.Atoi(arg); err != nil || n < 1 {
+					fmt.Fprintf(d.out, "invalid step count: %s\n", arg)
+					continue
+				}
+			}
+			for i := 0; i < n && d.m.Step(); i++ {
+			}
+			d.printState()
+		case "continue", "c":
+			d.m.Step()
+			for !d.m.Done() && !d.atBreakpoint() {
+				d.m.Step()
+			}
+			d.printState()
+		case "next", "n":
+			d.m.Step()
+			for !d.m.Done() && !d.m.AtCycleStart() {
+				d.m.Step()
+			}
+			d.printState()
+		case "break", "b":
+			d.setBreakpoint(arg, true)
+		case "delete":
+			d.setBreakpoint(arg, false)
+		case "breakpoints":
+			d.listBreakpoints()
+		case "print", "p":
+			d.printState()
+		case "pattern":
+			if arg != "" {
+				d.m.SetPatternSpace(unescapeText(arg))
+			}
+			fmt.Fprintf(d.out, "pattern: %s\n", debugEscape(d.m.PatternSpace()))
+		case "hold":
+			if arg != "" {
+				d.m.SetHoldSpace(unescapeText(arg))
+			}
+			fmt.Fprintf(d.out, "hold:    %s\n", debugEscape(d.m.HoldSpace()))
+		case "output", "o":
+			fmt.Fprint(d.out, d.m.Output())
+		case "goto", "g":
+			d.gotoLine(arg)
+		case "list", "l":
+			d.list()
+		case "rerun", "r":
+			d.m = d.program.Machine(d.input)
+			d.printState()
+		case "help", "h":
+			fmt.Fprint(d.out, debuggerHelp)
+		case "quit", "q":
+			return
+		default:
+			fmt.Fprintf(d.out, "unknown command %q, type 'help' for a list of commands\n", fields[0])
+		}
+	}
+}
+
+// atBreakpoint reports whether the next command has a breakpoint on it.
+func (d *debugger) atBreakpoint() bool {
+	if d.lineBreaks[d.m.SourceLine()] {
+		return true
+	}
+	for _, l := range d.m.Labels() {
+		if d.labelBreaks[l] {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *debugger) setBreakpoint(arg string, set bool) {
+	if strings.HasPrefix(arg, ":") && len(arg) > 1 {
+		if set {
+			d.labelBreaks[arg[1:]] = true
+		} else {
+			delete(d.labelBreaks, arg[1:])
+		}
+		return
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(d.script) {
+		fmt.Fprintf(d.out, "invalid breakpoint %q: expected a script line or :label\n", arg)
+		return
+	}
+	if set {
+		d.lineBreaks[n] = true
+	} else {
+		delete(d.lineBreaks, n)
+	}
+}
+
+func (d *debugger) listBreakpoints() {
+	for i := range d.script {
+		if d.lineBreaks[i+1] {
+			fmt.Fprintf(d.out, "line %d\n", i+1)
+		}
+	}
+	var labels []string
+	for l := range d.labelBreaks {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(d.out, ":%s\n", l)
+	}
+}
+
+// gotoLine runs the program until line n of the input is read, starting
+// again from the beginning if that line has already been passed.
+func (d *debugger) gotoLine(arg string) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > d.m.LineCount() {
+		fmt.Fprintf(d.out, "invalid input line %q\n", arg)
+		return
+	}
+	if n < d.m.LineNo() || (n == d.m.LineNo() && !d.m.AtCycleStart()) {
+		d.m = d.program.Machine(d.input)
+	}
+	for !d.m.Done() && d.m.LineNo() < n {
+		d.m.Step()
+	}
+	d.printState()
+}
+
+func (d *debugger) list() {
+	cur := d.m.SourceLine()
+	for i, line := range d.script {
+		marker := "  "
+		if i+1 == cur {
+			marker = "=>"
+		} else if d.lineBreaks[i+1] {
+			marker = " *"
+		}
+		fmt.Fprintf(d.out, "%s %3d  %s\n", marker, i+1, line)
+	}
+}
+
+func (d *debugger) printState() {
+	if d.m.Done() {
+		fmt.Fprintln(d.out, "program finished; 'output' shows the result, 'rerun' starts again")
+		return
+	}
+	fmt.Fprintf(d.out, "input line %d\n", d.m.LineNo())
+	fmt.Fprintf(d.out, "pattern: %s\n", debugEscape(d.m.PatternSpace()))
+	fmt.Fprintf(d.out, "hold:    %s\n", debugEscape(d.m.HoldSpace()))
+	fmt.Fprintf(d.out, "next:    %d: %s%s\n", d.m.SourceLine(), strings.Repeat("  ", d.m.Depth()), d.m.Command())
+}
+
+// unescapeText replaces the \n, \t and \\ escapes in text typed at the
+// debugger prompt.
+func unescapeText(text string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(text)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+func TestDebugger(t *testing.T) {
+	script := "s/a/A/\n:top\n/x/d\np"
+	program, errs := gosed.Compile(script, gosed.Options{})
+	if errs != nil {
+		t.Fatalf("Script did not compile: %v", errs)
+	}
+
+	commands := strings.Join([]string{
+		"step",
+		"break :top",
+		"break 4",
+		"continue",
+		"continue",
+		"hold held",
+		"pattern changed\\nline",
+		"goto 3",
+		"rerun",
+		"continue",
+		"delete 4",
+		"delete :top",
+		"continue",
+		"output",
+	}, "\n")
+	var out bytes.Buffer
+	newDebugger(program, script, "abc\nxyz\nabc", strings.NewReader(commands), &out).run()
+
+	expected := []string{
+		"next:    1: s/a/A/",
+		"pattern: Abc",
+		"next:    3: /x/ d",
+		"next:    4: p",
+		"hold:    held",
+		"pattern: changed\\nline",
+		"input line 3",
+		"program finished",
+		"Abc\nAbc\nAbc\nAbc\n",
+	}
+	got := out.String()
+	for _, e := range expected {
+		idx := strings.Index(got, e)
+		if idx == -1 {
+			t.Fatalf("Expected debugger output to contain %q after previous expectations.\n Got:\n%s", e, out.String())
+		}
+		got = got[idx+len(e):]
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/zkry/go-sed/lsp"
+)
+
+const lspUsage = `Usage: gosed lsp [-E] [--posix]
+
+Serve the Language Server Protocol on standard input and output, for
+editors working on sed scripts. The server reports errors and vet
+warnings, highlights scripts, finds labels and their branches, explains
+commands on hover, formats scripts and completes commands and s flags.
+
+  -E, -r, --regexp-extended
+                 scripts use extended regular expressions
+      --posix    scripts are POSIX sed
+`
+
+// runLSP runs the lsp subcommand with the arguments that follow it.
+func runLSP(args []string, w io.Writer) int {
+	options, opts, operands, err := parseScriptArgs(args, nil)
+	if err == nil && len(operands) > 0 {
+		err = fmt.Errorf("unexpected argument %q", operands[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %v\n%s", err, lspUsage)
+		return exitBadUsage
+	}
+	for _, opt := range opts {
+		if opt.name == "help" {
+			fmt.Fprint(w, lspUsage)
+			return 0
+		}
+	}
+	if err := lsp.NewServer(os.Stdin, w, options).Run(); err != nil {
+		// The protocol asks for status 1 when the client exits without
+		// shutting the server down.
+		fmt.Fprintf(os.Stderr, "gosed: lsp: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -4,204 +4,622 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
-	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 
 	gosed "github.com/zkry/go-sed"
+	"github.com/zkry/go-sed/ast"
 )
 
-var order int
+const version = "0.1.0"
 
-type Command struct {
-	order int
-	cmd   string
+// Exit statuses, matching GNU sed.
+const (
+	exitBadUsage = 1
+	exitBadInput = 2
+	exitPanic    = 4
+)
+
+// scriptSource is one piece of the sed program, given by -e or -f.
+type scriptSource struct {
+	text   string // The script itself, or the file name for -f.
+	isFile bool
 }
-type FileCommands []Command
-type ECommands []Command
 
-func (a *ECommands) String() string {
-	if a == nil {
-		return "[nil]"
-	}
-	return ""
+// Config specifies all of the pass-in parameters that the
+// command can take.
+type Config struct {
+	scripts          []scriptSource // Translates to -e and -f flags, in order
+	editInplace      bool           // Translates to -i flag, and -I for BSD
+	inplaceExtension string         // Prameter for -i flag
+	extendedRegexp   bool           // Translates to -E and -r flags
+	separate         bool           // Translates to -s flag
+	nullData         bool           // Translates to -z flag
+	unbuffered       bool           // Translates to -u flag
+	lineLength       int            // Prameter for -l flag
+	silenceLine      bool           // Translates to -n flag
+	dialect          gosed.Dialect  // Set by --posix, --dialect or GOSED_DIALECT
+	debug            bool           // Translates to --debug flag
+	sandbox          bool           // Translates to --sandbox flag
+	followSymlinks   bool           // Translates to --follow-symlinks flag
+	jobs             int            // Set by --jobs; more than one filters in parallel
+	showHelp         bool
+	showVersion      bool
+	interactive      bool
+	tracer           *debugTracer   // Set when --debug is given
+	router           *inplaceRouter // Set when editing in place with BSD's -I
 }
 
-func (a *ECommands) Set(v string) error {
-	newCmd := Command{
-		order: order,
-		cmd:   v,
+// configFromArgs builds the configuration from the command line arguments,
+// returning it along with the remaining operands. The arguments follow the
+// rules of the selected dialect's sed.
+func configFromArgs(args []string) (Config, []string, error) {
+	dialect, args, err := selectDialect(args)
+	if err != nil {
+		return Config{}, nil, err
+	}
+	if dialect == gosed.BSD {
+		return configFromBSDArgs(args)
 	}
-	*a = append(*a, newCmd)
-	order++
-	return nil
+	conf := Config{lineLength: 70, dialect: dialect}
+	opts, operands, err := getopt(args, gnuOptions)
+	if err != nil {
+		return conf, nil, err
+	}
+	for _, opt := range opts {
+		switch opt.name {
+		case "quiet":
+			conf.silenceLine = true
+		case "debug":
+			conf.debug = true
+		case "expression":
+			conf.scripts = append(conf.scripts, scriptSource{text: opt.value})
+		case "file":
+			conf.scripts = append(conf.scripts, scriptSource{text: opt.value, isFile: true})
+		case "follow-symlinks":
+			conf.followSymlinks = true
+		case "in-place":
+			conf.editInplace = true
+			conf.separate = true
+			conf.inplaceExtension = opt.value
+		case "line-length":
+			n, err := strconv.Atoi(opt.value)
+			if err != nil || n < 0 {
+				return conf, nil, fmt.Errorf("invalid line length: %s", opt.value)
+			}
+			conf.lineLength = n
+		case "posix":
+			conf.dialect = gosed.POSIX
+		case "regexp-extended":
+			conf.extendedRegexp = true
+		case "separate":
+			conf.separate = true
+		case "sandbox":
+			conf.sandbox = true
+		case "unbuffered":
+			conf.unbuffered = true
+		case "null-data":
+			conf.nullData = true
+		case "binary":
+			// Only meaningful on Windows; accepted for compatibility.
+		case "help":
+			conf.showHelp = true
+		case "version":
+			conf.showVersion = true
+		case "interactive":
+			conf.interactive = true
+		case "jobs":
+			n, err := strconv.Atoi(opt.value)
+			if err != nil || n < 0 {
+				return conf, nil, fmt.Errorf("invalid number of jobs: %s", opt.value)
+			}
+			if n == 0 {
+				n = runtime.NumCPU()
+			}
+			conf.jobs = n
+		}
+	}
+	return conf, operands, nil
 }
 
-func (a *FileCommands) String() string {
-	if a == nil {
-		return "[nil]"
+// selectDialect returns the dialect named by a leading --dialect option or,
+// failing that, the GOSED_DIALECT environment variable, along with the
+// arguments that follow the option. The dialect decides how the remaining
+// arguments are parsed, so the option must come first.
+func selectDialect(args []string) (gosed.Dialect, []string, error) {
+	name := os.Getenv("GOSED_DIALECT")
+	switch {
+	case len(args) > 0 && strings.HasPrefix(args[0], "--dialect="):
+		name, args = strings.TrimPrefix(args[0], "--dialect="), args[1:]
+	case len(args) > 0 && args[0] == "--dialect":
+		if len(args) == 1 {
+			return gosed.GNU, nil, errors.New("option '--dialect' requires an argument")
+		}
+		name, args = args[1], args[2:]
 	}
-	return ""
+	if name == "" {
+		return gosed.GNU, args, nil
+	}
+	dialect, err := ast.ParseDialect(name)
+	return dialect, args, err
 }
 
-func (a *FileCommands) Set(v string) error {
-	newCmd := Command{
-		order: order,
-		cmd:   v,
+func (conf Config) options() gosed.Options {
+	opt := gosed.Options{
+		SupressOutput: conf.silenceLine,
+		ExtendRegexp:  conf.extendedRegexp,
+		NullData:      conf.nullData,
+		Sandbox:       conf.sandbox,
+		Dialect:       conf.dialect,
+		LineLength:    conf.lineLength,
+		Parallelism:   conf.jobs,
+	}
+	if conf.lineLength == 0 {
+		// -l 0 turns wrapping off.
+		opt.LineLength = -1
+	}
+	if conf.tracer != nil {
+		opt.Trace = conf.tracer
 	}
-	*a = append(*a, newCmd)
-	order++
-	return nil
+	if conf.router != nil {
+		opt.Trace = conf.router
+	}
+	return opt
 }
 
-// Config specifies all of the pass-in parameters that the
-// command can take.
-type Config struct {
-	fileCommands     FileCommands // Translates to -e flag
-	eCommands        ECommands    // Translates to -e flag
-	editInplace      bool         // Translates to -i flag
-	inplaceExtension string       // Prameter for -i flag
-	extendedRegexp   bool         // Translates to -E flag
-	appendFile       bool         // Translates to -a flag
-	bufferedOutput   bool         // Translates to -l flag
-	silenceLine      bool         // Translates to -n flag
-	commandCt        int
-	interactive      bool
+func (conf Config) lineDelim() byte {
+	if conf.nullData {
+		return 0
+	}
+	return '\n'
 }
 
-func combineInputs(files []string) []byte {
-	var buff bytes.Buffer
-	for i, f := range files {
-		d, err := ioutil.ReadFile(f)
+// scriptFromConfig returns the pieces of the script given with -e and -f,
+// in order. The script is the pieces joined by newlines.
+func scriptFromConfig(conf Config) ([]string, error) {
+	pieces := make([]string, 0, len(conf.scripts))
+	for _, src := range conf.scripts {
+		if !src.isFile {
+			pieces = append(pieces, src.text)
+			continue
+		}
+		var fdata []byte
+		var err error
+		if src.text == "-" {
+			fdata, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			fdata, err = ioutil.ReadFile(src.text)
+		}
 		if err != nil {
-			fmt.Printf("gosed: %s: %v\n", f, err)
+			return nil, fmt.Errorf("couldn't open file %s: %v", src.text, err)
 		}
-		if i > 0 {
-			buff.WriteRune('\n')
+		pieces = append(pieces, strings.TrimSuffix(string(fdata), "\n"))
+	}
+	return pieces, nil
+}
+
+func programFromConfig(conf Config, pieces []string) (*gosed.Program, error) {
+	program, errs := gosed.Compile(strings.Join(pieces, "\n"), conf.options())
+	if errs != nil {
+		if conf.dialect == gosed.BSD {
+			return nil, bsdSyntaxError(conf, pieces, errs)
 		}
-		buff.Write(d)
+		return nil, errors.New("syntax error: " + strings.Join(errs, "; "))
 	}
-	return buff.Bytes()
+	return program, nil
 }
 
-func programFromConfig(conf Config) (*gosed.Program, error) {
-	var programBuff bytes.Buffer
-	// Iterate through all of the commands, processing the two slices of commands,
-	// (conf.fileCommands and conf.eCommands) in the order that they arrived.
-	for {
-		if len(conf.fileCommands) == 0 && len(conf.eCommands) == 0 {
-			break
-		} else if len(conf.fileCommands) == 0 || conf.eCommands[0].order < conf.fileCommands[0].order {
-			cmd := conf.eCommands[0].cmd
-			conf.eCommands = conf.eCommands[1:]
-			programBuff.WriteString("\n" + cmd)
-		} else {
-			fname := conf.fileCommands[0].cmd
-			conf.fileCommands = conf.fileCommands[1:]
-			fdata, err := ioutil.ReadFile(fname)
-			if err != nil {
-				return nil, errors.New("could not read file " + fname)
+// readInput reads the named input file, with "-" standing for stdin.
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(name)
+}
+
+// cantRead reports that an input file could not be read and returns the
+// exit status to use.
+func (conf Config) cantRead(name string, err error) int {
+	if conf.dialect == gosed.BSD {
+		fmt.Fprintf(os.Stderr, "gosed: %s\n", bsdErrorText(name, err))
+		return exitBadUsage
+	}
+	fmt.Fprintf(os.Stderr, "gosed: can't read %s: %v\n", name, err)
+	return exitBadInput
+}
+
+// runJoined treats all input files as one continuous stream.
+func runJoined(program *gosed.Program, conf Config, files []string, w *bufio.Writer) int {
+	status := 0
+	var inputs []gosed.File
+	var names []string
+	var contents [][]byte
+	for _, f := range files {
+		d, err := readInput(f)
+		if err != nil {
+			status = conf.cantRead(f, err)
+			continue
+		}
+		inputs = append(inputs, gosed.File{Name: f, Data: d})
+		names = append(names, f)
+		contents = append(contents, d)
+	}
+	if conf.tracer != nil {
+		// Output is printed by the tracer as it happens.
+		conf.tracer.setInputs(names, contents, conf.lineDelim())
+	}
+	out, _, err := program.RunFiles(inputs)
+	if conf.tracer == nil {
+		w.Write(out)
+	}
+	if err != nil {
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "gosed: %v\n", err)
+		status = exitPanic
+	}
+	return status
+}
+
+// runSeparate processes each input file on its own, writing the result
+// back to the file when editing in place. With --jobs, several files are
+// filtered at once and their output is written in order. A q or Q ends
+// the run, leaving the files after it alone.
+func runSeparate(program *gosed.Program, conf Config, files []string, w *bufio.Writer) int {
+	status := 0
+	result := filterFiles(program, conf, files)
+	for i, f := range files {
+		res := result(i)
+		switch {
+		case res.readErr != nil:
+			status = conf.cantRead(f, res.readErr)
+		case res.editErr != nil:
+			fmt.Fprintf(os.Stderr, "gosed: couldn't edit %s: %v\n", f, res.editErr)
+			status = exitPanic
+		case !conf.editInplace:
+			if conf.tracer == nil {
+				w.Write(res.out)
+			}
+			if conf.unbuffered || res.runErr != nil {
+				w.Flush()
 			}
-			programBuff.WriteRune('\n')
-			programBuff.Write(fdata)
+			if res.runErr != nil {
+				fmt.Fprintf(os.Stderr, "gosed: %s: %v\n", f, res.runErr)
+				status = exitPanic
+			}
+		}
+		if res.quit {
+			break
+		}
+	}
+	return status
+}
+
+// fileResult is the outcome of filtering one file on its own.
+type fileResult struct {
+	out     []byte
+	readErr error // Why the file couldn't be read.
+	editErr error // Why the file couldn't be edited in place.
+	runErr  error // Why the program stopped before the end of the file.
+	quit    bool  // Whether q or Q ended the run.
+}
+
+// filterFiles returns a function giving the result of filtering the ith
+// of files, to be called for each file in order. It filters up to
+// conf.jobs files at once on a pool of goroutines, unless the debugger's
+// output, the program's quitting or files, or editing one file twice
+// depends on the order of the files, in which case each file is filtered
+// when its result is asked for.
+func filterFiles(program *gosed.Program, conf Config, files []string) func(i int) fileResult {
+	if conf.jobs <= 1 || conf.tracer != nil || !program.FileIndependent() ||
+		conf.editInplace && !distinctFiles(files) {
+		return func(i int) fileResult {
+			return filterFile(program, conf, files[i])
+		}
+	}
+	results := make([]chan fileResult, len(files))
+	for i := range results {
+		results[i] = make(chan fileResult, 1)
+	}
+	next := make(chan int)
+	go func() {
+		for i := range files {
+			next <- i
 		}
+		close(next)
+	}()
+	for j := 0; j < conf.jobs; j++ {
+		go func() {
+			for i := range next {
+				results[i] <- filterFile(program, conf, files[i])
+			}
+		}()
+	}
+	return func(i int) fileResult {
+		return <-results[i]
 	}
+}
 
-	program, err := gosed.Compile(programBuff.String(), gosed.Options{})
+// filterFile filters the file name on its own, editing it in place if
+// conf says to.
+func filterFile(program *gosed.Program, conf Config, name string) fileResult {
+	if conf.editInplace && name == "-" {
+		return fileResult{editErr: errors.New("not a regular file")}
+	}
+	d, err := readInput(name)
 	if err != nil {
-		return nil, errors.New("syntax error: " + err.Error())
+		return fileResult{readErr: err}
 	}
-	return program, nil
+	if conf.tracer != nil {
+		conf.tracer.setInputs([]string{name}, [][]byte{d}, conf.lineDelim())
+	}
+	out, quit, err := program.RunFiles([]gosed.File{{Name: name, Data: d}})
+	if conf.editInplace {
+		if err != nil {
+			// Leave the file as it was rather than truncate it.
+			return fileResult{editErr: err}
+		}
+		return fileResult{editErr: editInplace(name, out, conf), quit: quit}
+	}
+	return fileResult{out: out, runErr: err, quit: quit}
 }
 
-func runFromStdin(program *gosed.Program) {
-	r := bufio.NewReader(os.Stdin)
-	for {
-		line, err := r.ReadString('\n')
-		if err == io.EOF {
-			break
+// distinctFiles reports whether no two of files name the same file, even
+// through symlinks, so that they can be edited in place at once.
+func distinctFiles(files []string) bool {
+	seen := make(map[string]bool)
+	for _, f := range files {
+		path, err := filepath.EvalSymlinks(f)
+		if err != nil {
+			path = f
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if seen[path] {
+			return false
 		}
-		out := program.FilterString(line)
-		fmt.Print(out)
+		seen[path] = true
 	}
+	return true
 }
 
-func main() {
-	var helpFlag bool
-	var config Config
-	// flag.Var(&config.commandFiles, "f", "")
-	flag.Var(&config.fileCommands, "f", "file with sed commands to run")
-	flag.Var(&config.eCommands, "e", "string of command to execute")
-	flag.BoolVar(&config.silenceLine, "n", false, "silence the auto-print-line functionality")
-	flag.BoolVar(&helpFlag, "h", false, "usage guide")
-
-	// TODO: Implement support for following flags
-	//flag.BoolVar(&config.bufferedOutput, "l", false, "")
-	//flag.BoolVar(&config.appendFile, "a", false, "")
-	//flag.BoolVar(&config.extendedRegexp, "E", false, "")
-	//flag.BoolVar(&config.interactive, "i", false, "")
-	flag.Parse()
-	config.commandCt = order
-
-	if helpFlag || (config.commandCt == 0 && flag.NArg() == 0) {
-		displayHelp()
-		return
-	}
-	//if config.interactive {
-	//runInteractive()
-	//}
-
-	if config.commandCt > 0 {
-		program, err := programFromConfig(config)
+// editInplace replaces the contents of name with data, first keeping a
+// backup of the original if a suffix was given.
+func editInplace(name string, data []byte, conf Config) error {
+	if conf.followSymlinks {
+		target, err := filepath.EvalSymlinks(name)
 		if err != nil {
-			fmt.Println(err)
-			return
-		}
-		if flag.NArg() > 0 {
-			// Read files and send them through commands.
-			programInput := combineInputs(flag.Args())
-			out := program.Filter(programInput)
-			fmt.Print(string(out))
+			return err
+		}
+		name = target
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("not a regular file")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "gosed")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if conf.inplaceExtension != "" {
+		backup := name + conf.inplaceExtension
+		if conf.dialect != gosed.BSD {
+			backup = backupName(name, conf.inplaceExtension)
+		}
+		if err := os.Rename(name, backup); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// backupName returns the backup file name for -i. As in GNU sed, every
+// '*' in the suffix is replaced with the file's base name, otherwise the
+// suffix is appended. A suffix containing '/' names a path relative to
+// the file's directory.
+func backupName(name, suffix string) string {
+	dir, base := filepath.Split(name)
+	if !strings.Contains(suffix, "*") {
+		return name + suffix
+	}
+	backup := strings.Replace(suffix, "*", base, -1)
+	if strings.Contains(backup, "/") {
+		return filepath.Join(dir, backup)
+	}
+	return dir + backup
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) > 0 && subcommands[args[0]] != nil {
+		return subcommands[args[0]](args[1:], os.Stdout)
+	}
+	config, operands, err := configFromArgs(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %v\n", err)
+		if config.dialect == gosed.BSD {
+			fmt.Fprint(os.Stderr, bsdUsage)
 		} else {
-			// Read Stdout through commands
-			runFromStdin(program)
+			displayHelp(os.Stderr)
 		}
-		return
+		return exitBadUsage
+	}
+	if config.showHelp {
+		displayHelp(os.Stdout)
+		return 0
+	}
+	if config.showVersion {
+		fmt.Printf("gosed (go-sed) %s\n", version)
+		return 0
 	}
 
-	if flag.NArg() > 0 {
-		// Use arg[0] as command and arg[1:] as input files. If only one arg,
-		// read from stdout
-		fname := flag.Arg(0)
-		program, err := gosed.Compile(fname, gosed.Options{})
+	if len(config.scripts) == 0 {
+		if len(operands) == 0 {
+			if config.dialect == gosed.BSD {
+				fmt.Fprint(os.Stderr, bsdUsage)
+			} else {
+				displayHelp(os.Stderr)
+			}
+			return exitBadUsage
+		}
+		// Use the first operand as the script and the rest as input files.
+		config.scripts = append(config.scripts, scriptSource{text: operands[0]})
+		operands = operands[1:]
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if config.debug {
+		config.tracer = &debugTracer{w: w, showOutput: !config.editInplace}
+	}
+
+	if config.editInplace && !config.separate {
+		config.router = &inplaceRouter{next: config.tracer}
+	}
+
+	pieces, err := scriptFromConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %v\n", err)
+		return exitBadUsage
+	}
+	script := strings.Join(pieces, "\n")
+	program, err := programFromConfig(config, pieces)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %v\n", err)
+		return exitBadUsage
+	}
+	if config.tracer != nil {
+		config.tracer.printProgram(program.String())
+	}
+
+	if config.interactive {
+		return runInteractive(program, config, script, operands)
+	}
+
+	if len(operands) == 0 {
+		if config.editInplace {
+			fmt.Fprintln(os.Stderr, "gosed: no input files")
+			return exitBadUsage
+		}
+		operands = []string{"-"}
+	}
+
+	if config.editInplace && !config.separate {
+		return runJoinedInplace(program, config, operands)
+	}
+	if config.separate {
+		return runSeparate(program, config, operands, w)
+	}
+	return runJoined(program, config, operands, w)
+}
+
+// runInteractive opens the debugger on the joined input files. Debugger
+// commands are read from stdin, so the input must come from files.
+func runInteractive(program *gosed.Program, conf Config, script string, files []string) int {
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "gosed: --interactive requires input files")
+		return exitBadUsage
+	}
+	delim := conf.lineDelim()
+	var buff bytes.Buffer
+	for _, f := range files {
+		d, err := readInput(f)
 		if err != nil {
-			fmt.Printf("gosed: syntax error in %s\nerror:%v\n", fname, err.Error())
-			return
+			return conf.cantRead(f, err)
 		}
-		if flag.NArg() == 1 {
-			runFromStdin(program)
-			return
+		if buff.Len() > 0 && buff.Bytes()[buff.Len()-1] != delim {
+			buff.WriteByte(delim)
 		}
-		programInput := combineInputs(flag.Args()[1:])
-		out := program.Filter(programInput)
-		fmt.Print(string(out))
+		buff.Write(d)
 	}
+	input := strings.TrimSuffix(buff.String(), string(delim))
+	newDebugger(program, script, input, os.Stdin, os.Stdout).run()
+	return 0
 }
 
-func displayHelp() {
-	fmt.Println(`gosed is a Go implementation of the Sed stream editor. 
-It seeks to behave as the standard sed command. There is currently
-no support for the GNU sed features. The following in an example of
-running two commands processing the file 'input.txt'. 
+func displayHelp(w io.Writer) {
+	fmt.Fprint(w, `Usage: gosed [OPTION]... {script-only-if-no-other-script} [input-file]...
 
-      gosed -e 's/one/ONE/g' -e '/two/d' input.txt
+gosed is a Go implementation of the sed stream editor. It accepts the
+same options as GNU sed.
 
-You can also run a file with sed commands as follows:
+  -n, --quiet, --silent
+                 suppress automatic printing of pattern space
+      --debug
+                 annotate program execution
+  -e script, --expression=script
+                 add the script to the commands to be executed
+  -f script-file, --file=script-file
+                 add the contents of script-file to the commands to be executed
+  --follow-symlinks
+                 follow symlinks when processing in place
+  -i[SUFFIX], --in-place[=SUFFIX]
+                 edit files in place (makes backup if SUFFIX supplied)
+  -l N, --line-length=N
+                 specify the desired line-wrap length for the 'l' command
+  --posix
+                 disable all GNU extensions.
+  -E, -r, --regexp-extended
+                 use extended regular expressions in the script
+  -s, --separate
+                 consider files as separate rather than as a single
+                 continuous long stream.
+      --sandbox
+                 operate in sandbox mode (disable e/r/w commands).
+  -u, --unbuffered
+                 flush output after every file
+  -z, --null-data
+                 separate lines by NUL characters
+      --help     display this help and exit
+      --version  output version information and exit
+      --interactive
+                 step through the script in an interactive debugger
+      --jobs=N
+                 filter separate files, and long inputs of scripts whose
+                 lines are independent, on N goroutines; 0 uses one for
+                 each CPU
+      --dialect=NAME
+                 follow the syntax of GNU, POSIX or BSD sed; must be
+                 the first option, and overrides $GOSED_DIALECT. With
+                 BSD, the remaining options are those of BSD sed.
 
-      gosed -f processor.sed mytext.txt
+Tools for working on scripts are run as 'gosed NAME'; see
+'gosed NAME --help':
+  fmt            print scripts in canonical form
+  vet            report likely mistakes in scripts
+  explain        describe what a script does in English
+  highlight      print scripts with syntax highlighting
+  gen            compile a script to Go source
+  compile        compile a script for gosed.Load
+  lsp            serve the Language Server Protocol for editors
 
-Flags:`)
-	flag.PrintDefaults()
+If no -e, --expression, -f, or --file option is given, then the first
+non-option argument is taken as the sed script to interpret. All
+remaining arguments are names of input files; if no input files are
+specified, then the standard input is read.
+`)
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+func TestRunSeparateJobs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Each run edits its own copy of the files, one of them twice through
+	// a symlink.
+	write := func(sub string) []string {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for i := 0; i < 30; i++ {
+			name := filepath.Join(dir, sub, fmt.Sprintf("%d.txt", i))
+			data := strings.Repeat(fmt.Sprintf("line %d\n", i), i+1)
+			if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, name)
+		}
+		link := filepath.Join(dir, sub, "link.txt")
+		if err := os.Symlink(files[3], link); err != nil {
+			t.Fatal(err)
+		}
+		return append(files, link)
+	}
+	// The programs that quit or use files must run the files in order
+	// whatever the jobs; %s stands for the directory of the run's files.
+	programs := []string{
+		"$!N;s/\\n/ /;=;s/line/x&/",
+		"3q",
+		"/line 2/Q",
+		"1d;$r %s/0.txt",
+		"s/line 1/&/w %s/w.out",
+	}
+
+	tests := []struct {
+		sub      string
+		conf     Config
+		distinct bool // Whether to leave out the symlink.
+	}{
+		{"serial", Config{separate: true}, false},
+		{"parallel", Config{separate: true, jobs: 4}, false},
+		{"inplace-serial", Config{separate: true, editInplace: true}, false},
+		{"inplace-parallel", Config{separate: true, editInplace: true, jobs: 4}, false},
+		{"distinct-serial", Config{separate: true, editInplace: true}, true},
+		{"distinct-parallel", Config{separate: true, editInplace: true, jobs: 4}, true},
+	}
+	for k, script := range programs {
+		outputs := make([]string, len(tests))
+		for i, tt := range tests {
+			sub := fmt.Sprintf("%s-%d", tt.sub, k)
+			files := write(sub)
+			if distinctFiles(files) || !distinctFiles(files[:len(files)-1]) {
+				t.Errorf("Run [%d] %s: distinctFiles missed the symlink", i, sub)
+			}
+			if tt.distinct {
+				files = files[:len(files)-1]
+			}
+			program := gosed.MustCompile(strings.Replace(script, "%s", filepath.Join(dir, sub), -1), gosed.Options{})
+			var out bytes.Buffer
+			w := bufio.NewWriter(&out)
+			if status := runSeparate(program, tt.conf, files, w); status != 0 {
+				t.Errorf("Run [%d] %s exited with %d", i, sub, status)
+			}
+			w.Flush()
+			for _, f := range append(files, filepath.Join(dir, sub, "w.out")) {
+				data, err := ioutil.ReadFile(f)
+				if err != nil && !os.IsNotExist(err) {
+					t.Fatal(err)
+				}
+				out.Write(data)
+			}
+			outputs[i] = out.String()
+		}
+		for i := 1; i < len(tests); i += 2 {
+			if outputs[i] != outputs[i-1] {
+				t.Errorf("Run [%d] %q %s incorrect.\n  Got: %q\n  Expected: %q", i, script, tests[i].sub, outputs[i], outputs[i-1])
+			}
+		}
+	}
+}
+
+func TestRunLimitError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stderr := os.Stderr
+	defer func() { os.Stderr = stderr }()
+	os.Stderr, err = os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(dir, "in.txt")
+	program := gosed.MustCompile("2{:a;s/^/x/;ta\n}", gosed.Options{Limits: gosed.Limits{Commands: 50}})
+	tests := []struct {
+		conf   Config
+		output string
+	}{
+		{Config{}, "one\n"},
+		{Config{separate: true}, "one\n"},
+		{Config{separate: true, editInplace: true}, ""},
+	}
+	for i, tt := range tests {
+		if err := ioutil.WriteFile(name, []byte("one\ntwo\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		var status int
+		if tt.conf.separate {
+			status = runSeparate(program, tt.conf, []string{name}, w)
+		} else {
+			status = runJoined(program, tt.conf, []string{name}, w)
+		}
+		w.Flush()
+		if status != exitPanic || out.String() != tt.output {
+			t.Errorf("Run [%d] incorrect.\n  Got: %d, %q\n  Expected: %d, %q", i, status, out.String(), exitPanic, tt.output)
+		}
+		if data, _ := ioutil.ReadFile(name); string(data) != "one\ntwo\n" {
+			t.Errorf("Run [%d] changed the input to %q", i, data)
+		}
+	}
+	os.Stderr.Close()
+	messages, _ := ioutil.ReadFile(filepath.Join(dir, "stderr"))
+	if n := strings.Count(string(messages), "limit"); n != len(tests) {
+		t.Errorf("Expected %d limit errors, got:\n%s", len(tests), messages)
+	}
+}
+
+func TestRunSeparateQuit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inputs := []string{"a\nb\nc\n", "x\ny\n", "1\n2\n3\n"}
+	tests := []struct {
+		program string
+		conf    Config
+		output  string
+		files   []string // The files' contents afterwards.
+	}{
+		{"2q", Config{separate: true}, "a\nb\n", inputs},
+		{"5q", Config{separate: true}, "a\nb\nc\nx\ny\n1\n2\n3\n", inputs},
+		{"$!N;2Q", Config{separate: true}, "", inputs},
+		{"1q", Config{separate: true, editInplace: true}, "", []string{"a\n", "x\ny\n", "1\n2\n3\n"}},
+		{"/y/Q", Config{separate: true, editInplace: true}, "", []string{"a\nb\nc\n", "x\n", "1\n2\n3\n"}},
+	}
+	for i, tt := range tests {
+		var files []string
+		for j, data := range inputs {
+			name := filepath.Join(dir, fmt.Sprintf("%d.txt", j))
+			if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, name)
+		}
+		var out bytes.Buffer
+		w := bufio.NewWriter(&out)
+		if status := runSeparate(gosed.MustCompile(tt.program, gosed.Options{}), tt.conf, files, w); status != 0 {
+			t.Errorf("Run [%d] %q exited with %d", i, tt.program, status)
+		}
+		w.Flush()
+		if out.String() != tt.output {
+			t.Errorf("Run [%d] %q incorrect.\n  Got: %q\n  Expected: %q", i, tt.program, out.String(), tt.output)
+		}
+		for j, f := range files {
+			if data, _ := ioutil.ReadFile(f); string(data) != tt.files[j] {
+				t.Errorf("Run [%d] %q left file %d as %q, expected %q", i, tt.program, j, data, tt.files[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+// subcommands are the tools run by "gosed NAME ...". A first argument
+// naming one is taken as the subcommand rather than as a script; a script
+// with the same text can still be given with -e.
+var subcommands = map[string]func(args []string, w io.Writer) int{
+	"compile":   runCompile,
+	"explain":   runExplain,
+	"fmt":       runFmt,
+	"gen":       runGen,
+	"highlight": runHighlight,
+	"lsp":       runLSP,
+	"vet":       runVet,
+}
+
+// scriptOptions are the options of every subcommand that reads sed
+// scripts.
+var scriptOptions = []option{
+	{name: "regexp-extended", short: 'E', long: "regexp-extended"},
+	{name: "regexp-extended", short: 'r'},
+	{name: "posix", long: "posix"},
+	{name: "help", short: 'h', long: "help"},
+}
+
+// parseScriptArgs parses the arguments of a subcommand that reads sed
+// scripts, whose own options are extra. It returns the options to compile
+// the scripts with, the subcommand's own options and --help, and the
+// script files. A leading --dialect selects the dialect, as for gosed.
+func parseScriptArgs(args []string, extra []option) (gosed.Options, []parsedOption, []string, error) {
+	dialect, args, err := selectDialect(args)
+	if err != nil {
+		return gosed.Options{}, nil, nil, err
+	}
+	opts, files, err := getopt(args, append(append([]option{}, extra...), scriptOptions...))
+	if err != nil {
+		return gosed.Options{}, nil, nil, err
+	}
+	options := gosed.Options{Dialect: dialect}
+	var rest []parsedOption
+	for _, opt := range opts {
+		switch opt.name {
+		case "regexp-extended":
+			options.ExtendRegexp = true
+		case "posix":
+			options.Dialect = gosed.POSIX
+		default:
+			rest = append(rest, opt)
+		}
+	}
+	return options, rest, files, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+// exitWarnings is the exit status of gosed vet when it finds problems.
+const exitWarnings = 1
+
+const vetUsage = `Usage: gosed vet [-E] [--posix] [script-file]...
+
+Report likely mistakes in each sed script, as FILE:LINE: MESSAGE (CHECK).
+With no files, the script is read from standard input. A comment
+"# gosed:ignore [CHECK]..." after a command, or on the line before it,
+suppresses the named checks, or all of them, for that command.
+
+  -E, -r, --regexp-extended
+                 the scripts use extended regular expressions
+      --posix    the scripts are POSIX sed
+`
+
+// runVet runs the vet subcommand with the arguments that follow it.
+func runVet(args []string, w io.Writer) int {
+	options, opts, files, err := parseScriptArgs(args, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gosed: %v\n%s", err, vetUsage)
+		return exitBadUsage
+	}
+	for _, opt := range opts {
+		if opt.name == "help" {
+			fmt.Fprint(w, vetUsage)
+			return 0
+		}
+	}
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	status := 0
+	for _, name := range files {
+		script, err := readInput(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gosed: can't read %s: %v\n", name, err)
+			status = exitBadInput
+			continue
+		}
+		program, errs := gosed.Compile(string(script), options)
+		if errs != nil {
+			fmt.Fprintf(os.Stderr, "gosed: %s: syntax error: %s\n", name, strings.Join(errs, "; "))
+			status = exitBadUsage
+			continue
+		}
+		for _, warning := range program.Vet() {
+			fmt.Fprintf(w, "%s:%d: %s (%s)\n", name, warning.Line, warning.Message, warning.Check)
+			if status == 0 {
+				status = exitWarnings
+			}
+		}
+	}
+	return status
+}
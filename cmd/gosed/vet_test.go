@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunVet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosed-vet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clean := filepath.Join(dir, "clean.sed")
+	if err := ioutil.WriteFile(clean, []byte("$!N\nP\nD\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if status := runVet([]string{clean}, &out); status != 0 || out.Len() != 0 {
+		t.Errorf("gosed vet on a clean script exited with %d and printed %q", status, out.String())
+	}
+
+	name := filepath.Join(dir, "join.sed")
+	if err := ioutil.WriteFile(name, []byte(":a\nN\nP\nD\nb a # gosed:ignore\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected := name + `:2: N on the last line prints the pattern space in GNU sed but quits without printing it in POSIX and BSD sed; use $!N (n-last-line)` + "\n"
+	if status := runVet([]string{name}, &out); status != exitWarnings {
+		t.Errorf("gosed vet exited with %d, expected %d", status, exitWarnings)
+	}
+	if out.String() != expected {
+		t.Errorf("gosed vet printed:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+}
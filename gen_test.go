@@ -0,0 +1,135 @@
+package gosed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestGenerateGo checks that Go generated from each program in testdata
+// passes go vet and prints what the interpreter does for each input the
+// program is tested against, as described for TestSed.
+func TestGenerateGo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated code")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command")
+	}
+	dir, err := ioutil.TempDir("", "gosed-gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	type run struct {
+		testProgram
+		program *Program
+		name    string // The generated function.
+	}
+	var candidates []run
+	for i, p := range testScripts {
+		p.path = fmt.Sprintf("script %d", i)
+		candidates = append(candidates, run{testProgram: p})
+	}
+	inputs, _ := filepath.Glob("testdata/inputs/*.txt")
+	for _, p := range testPrograms(t) {
+		candidates = append(candidates, run{testProgram: p})
+	}
+
+	var runs []run
+	files := []string{"main.go"}
+	var table bytes.Buffer
+	for _, r := range candidates {
+		r.name = fmt.Sprintf("transform%d", len(runs))
+		if len(r.inputs) == 0 {
+			r.inputs = inputs
+		}
+		var errs []string
+		if r.program, errs = Compile(r.script, r.opt); errs != nil {
+			t.Errorf("%s did not compile: %v", r.path, errs)
+			continue
+		}
+		src, err := r.program.GenerateGo("main", r.name)
+		if err != nil {
+			t.Logf("%s: %v", r.path, err)
+			continue
+		}
+		file := r.name + ".go"
+		if err := ioutil.WriteFile(filepath.Join(dir, file), src, 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+		fmt.Fprintf(&table, "\t%q: %s,\n", r.name, r.name)
+		runs = append(runs, r)
+	}
+	main := fmt.Sprintf(`package main
+
+import (
+	"io"
+	"os"
+)
+
+var transforms = map[string]func(io.Reader, io.Writer) error{
+%s}
+
+func main() {
+	if err := transforms[os.Args[1]](os.Stdin, os.Stdout); err != nil {
+		panic(err)
+	}
+}
+`, table.String())
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(main), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{{"vet"}, {"build", "-o", "gen"}} {
+		cmd := exec.Command(goTool, append(args, files...)...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("go %s failed: %v\n%s", args[0], err, out)
+		}
+	}
+
+	for _, r := range runs {
+		r.program.opt.Limits = Limits{Commands: 1000000}
+		for _, input := range r.inputs {
+			data, err := ioutil.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The interpreter is given the input as the gosed command
+			// gives it, without its final newline.
+			delim := []byte("\n")
+			if r.opt.NullData {
+				data = bytes.Replace(data, delim, []byte{0}, -1)
+				delim = []byte{0}
+			}
+			expected, err := r.program.Run(bytes.TrimSuffix(data, delim))
+			if err != nil {
+				continue
+			}
+			if len(expected) > 0 && bytes.HasSuffix(data, delim) {
+				expected = append(expected, delim...)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			cmd := exec.CommandContext(ctx, filepath.Join(dir, "gen"), r.name)
+			cmd.Stdin = bytes.NewReader(data)
+			got, err := cmd.Output()
+			cancel()
+			if err != nil {
+				t.Errorf("generated %s for %s on %s failed: %v", r.name, r.path, input, err)
+				continue
+			}
+			if !bytes.Equal(got, expected) {
+				t.Errorf("generated %s for %s on %s incorrect.\n  Got: %q\n  Expected: %q", r.name, r.path, input, got, expected)
+			}
+		}
+	}
+}
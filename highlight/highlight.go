@@ -0,0 +1,208 @@
+// Package highlight renders sed scripts with syntax highlighting, as
+// ANSI terminal colours or HTML. It splits the tokens of gosed.Info
+// further, so that the syntax of regexps and the escapes of replacements
+// are highlighted too.
+package highlight
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	gosed "github.com/zkry/go-sed"
+	"github.com/zkry/go-sed/lexer"
+)
+
+// Class is what a span of a script is, which its style is chosen by.
+type Class string
+
+const (
+	Plain              Class = "plain"               // Blanks and newlines.
+	Comment            Class = "comment"             // # to the end of the line.
+	Command            Class = "command"             // Command characters, braces and the : of labels.
+	Address            Class = "address"             // Line numbers, $, and the ~, +, comma and ! of addresses.
+	Number             Class = "number"              // The arguments of q, Q and l.
+	Delimiter          Class = "delimiter"           // The delimiters of regexps, s and y, and semicolons.
+	Regexp             Class = "regexp"              // Characters of a regexp that match themselves.
+	RegexpMeta         Class = "regexp-meta"         // Operators, anchors and groups of a regexp.
+	RegexpClass        Class = "regexp-class"        // Bracket expressions such as [a-z].
+	RegexpEscape       Class = "regexp-escape"       // Escapes and back-references in a regexp.
+	Replacement        Class = "replacement"         // Text of a replacement.
+	ReplacementSpecial Class = "replacement-special" // &, back-references and case conversions.
+	ReplacementEscape  Class = "replacement-escape"  // Other escapes in a replacement.
+	Flags              Class = "flags"               // Flags of s and of regexp addresses.
+	Label              Class = "label"               // The labels of :, b, t and T.
+	Text               Class = "text"                // The text of a, i and c, y's characters and file names.
+	Error              Class = "error"               // The rest of a script after a syntax error.
+)
+
+// Span is a piece of a script and what it is.
+type Span struct {
+	Class Class
+	Text  string
+	Start int // The byte offset of Text in the script.
+}
+
+// Spans splits script into spans that cover it with no gaps. Regexps are
+// read as extended regular expressions if extended is set.
+func Spans(script string, extended bool) []Span {
+	var spans []Span
+	add := func(class Class, text string, start int) {
+		if text == "" {
+			return
+		}
+		if n := len(spans); n > 0 && spans[n-1].Class == class && spans[n-1].Start+len(spans[n-1].Text) == start {
+			spans[n-1].Text += text
+			return
+		}
+		spans = append(spans, Span{Class: class, Text: text, Start: start})
+	}
+
+	inCommand := false
+	for _, t := range gosed.Info(script) {
+		class := Text
+		switch t.Type {
+		case lexer.ItemSpace, lexer.ItemNewline:
+			class = Plain
+		case lexer.ItemComment:
+			class = Comment
+		case lexer.ItemCmd, lexer.ItemColon, lexer.ItemLBrace, lexer.ItemRBrace, lexer.ItemBackslash:
+			class = Command
+		case lexer.ItemInt:
+			class = Address
+			if inCommand {
+				class = Number
+			}
+		case lexer.ItemDollar, lexer.ItemComma, lexer.ItemExpMark, lexer.ItemTilde, lexer.ItemPlus:
+			class = Address
+		case lexer.ItemSlash, lexer.ItemDiv, lexer.ItemSemicolon:
+			class = Delimiter
+		case lexer.ItemFlags:
+			class = Flags
+		case lexer.ItemLabel:
+			class = Label
+		case lexer.ItemError:
+			class = Error
+		case lexer.ItemRegexp:
+			for _, s := range regexpSpans(t.Value, extended) {
+				add(s.Class, s.Text, t.Start+s.Start)
+			}
+			continue
+		case lexer.ItemReplacement:
+			for _, s := range replacementSpans(t.Value) {
+				add(s.Class, s.Text, t.Start+s.Start)
+			}
+			continue
+		}
+		switch t.Type {
+		case lexer.ItemCmd, lexer.ItemColon:
+			inCommand = true
+		case lexer.ItemNewline, lexer.ItemSemicolon, lexer.ItemLBrace, lexer.ItemRBrace:
+			inCommand = false
+		}
+		add(class, t.Value, t.Start)
+	}
+	return spans
+}
+
+// regexpSpans splits the source of a regexp, as written between its
+// delimiters.
+func regexpSpans(re string, extended bool) []Span {
+	var spans []Span
+	add := func(class Class, start, end int) {
+		spans = append(spans, Span{Class: class, Text: re[start:end], Start: start})
+	}
+	// The operators that are special unescaped in EREs and escaped in BREs.
+	const operators = "(){}|+?"
+	atStart := true // Whether ^ here is an anchor.
+	for i := 0; i < len(re); {
+		c := re[i]
+		switch {
+		case c == '\\' && i+1 < len(re):
+			next := re[i+1]
+			switch {
+			case strings.IndexByte(operators, next) >= 0 && !extended:
+				add(RegexpMeta, i, i+2)
+				atStart = next == '(' || next == '|'
+			default:
+				_, size := utf8.DecodeRuneInString(re[i+1:])
+				add(RegexpEscape, i, i+1+size)
+				i += size - 1
+				atStart = false
+			}
+			i += 2
+			continue
+		case c == '[':
+			end := bracketEnd(re, i)
+			add(RegexpClass, i, end)
+			i, atStart = end, false
+			continue
+		case c == '^' && (atStart || extended):
+			add(RegexpMeta, i, i+1)
+		case c == '$' && (extended || i+1 == len(re) || strings.HasPrefix(re[i+1:], `\)`) || strings.HasPrefix(re[i+1:], `\|`)):
+			add(RegexpMeta, i, i+1)
+		case c == '.' || c == '*' && !atStart:
+			add(RegexpMeta, i, i+1)
+		case extended && strings.IndexByte(operators, c) >= 0:
+			add(RegexpMeta, i, i+1)
+			atStart = c == '(' || c == '|'
+			i++
+			continue
+		default:
+			add(Regexp, i, i+1)
+		}
+		atStart = false
+		i++
+	}
+	return spans
+}
+
+// bracketEnd returns the offset just past the bracket expression that
+// starts at re[start], or the end of re if it is not closed.
+func bracketEnd(re string, start int) int {
+	i := start + 1
+	if i < len(re) && re[i] == '^' {
+		i++
+	}
+	if i < len(re) && re[i] == ']' {
+		// A ] first in the list is one of its characters.
+		i++
+	}
+	for i < len(re) {
+		switch {
+		case re[i] == ']':
+			return i + 1
+		case re[i] == '[' && i+1 < len(re) && strings.IndexByte(":.=", re[i+1]) >= 0:
+			// A class such as [:alpha:] runs to the matching :].
+			if end := strings.Index(re[i+2:], string(re[i+1])+"]"); end >= 0 {
+				i += end + 4
+				continue
+			}
+		}
+		i++
+	}
+	return len(re)
+}
+
+// replacementSpans splits the replacement of an s command.
+func replacementSpans(repl string) []Span {
+	var spans []Span
+	for i := 0; i < len(repl); {
+		start, class := i, Replacement
+		switch {
+		case repl[i] == '&':
+			class = ReplacementSpecial
+			i++
+		case repl[i] == '\\' && i+1 < len(repl):
+			class = ReplacementEscape
+			if c := repl[i+1]; c >= '0' && c <= '9' || strings.IndexByte("LUElu", c) >= 0 {
+				class = ReplacementSpecial
+			}
+			_, size := utf8.DecodeRuneInString(repl[i+1:])
+			i += 1 + size
+		default:
+			i++
+		}
+		spans = append(spans, Span{Class: class, Text: repl[start:i], Start: start})
+	}
+	return spans
+}
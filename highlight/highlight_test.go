@@ -0,0 +1,99 @@
+package highlight
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSpans(t *testing.T) {
+	type span struct {
+		class Class
+		text  string
+	}
+	tests := []struct {
+		script   string
+		extended bool
+		expected []span
+	}{
+		{
+			script: `/^a\(b\|c\)*$/I,+2!s/[^[:alpha:]]x\.(y)/<&\1\n\U\&>/2g # c`,
+			expected: []span{
+				{Delimiter, "/"}, {RegexpMeta, "^"}, {Regexp, "a"}, {RegexpMeta, `\(`}, {Regexp, "b"},
+				{RegexpMeta, `\|`}, {Regexp, "c"}, {RegexpMeta, `\)*$`}, {Delimiter, "/"}, {Flags, "I"},
+				{Address, ",+2!"}, {Command, "s"}, {Delimiter, "/"}, {RegexpClass, "[^[:alpha:]]"},
+				{Regexp, "x"}, {RegexpEscape, `\.`}, {Regexp, "(y)"}, {Delimiter, "/"},
+				{Replacement, "<"}, {ReplacementSpecial, `&\1`}, {ReplacementEscape, `\n`},
+				{ReplacementSpecial, `\U`}, {ReplacementEscape, `\&`}, {Replacement, ">"},
+				{Delimiter, "/"}, {Flags, "2g"}, {Plain, " "}, {Comment, "# c"},
+			},
+		},
+		{
+			script:   `s/^a(b|c)+$\(/x/`,
+			extended: true,
+			expected: []span{
+				{Command, "s"}, {Delimiter, "/"}, {RegexpMeta, "^"}, {Regexp, "a"}, {RegexpMeta, "("},
+				{Regexp, "b"}, {RegexpMeta, "|"}, {Regexp, "c"}, {RegexpMeta, ")+$"}, {RegexpEscape, `\(`},
+				{Delimiter, "/"}, {Replacement, "x"}, {Delimiter, "/"},
+			},
+		},
+		{
+			script: "/a^b*[]x]$c/!{ $q 5\n  t top\n}",
+			expected: []span{
+				{Delimiter, "/"}, {Regexp, "a^b"}, {RegexpMeta, "*"}, {RegexpClass, "[]x]"}, {Regexp, "$c"},
+				{Delimiter, "/"}, {Address, "!"}, {Command, "{"}, {Plain, " "}, {Address, "$"}, {Command, "q"},
+				{Plain, " "}, {Number, "5"}, {Plain, "\n  "}, {Command, "t"}, {Plain, " "}, {Label, "top"},
+				{Plain, "\n"}, {Command, "}"},
+			},
+		},
+		{
+			script:   `s/\é/x/;k`,
+			expected: []span{{Command, "s"}, {Delimiter, "/"}, {RegexpEscape, `\é`}, {Delimiter, "/"}, {Replacement, "x"}, {Delimiter, "/;"}, {Error, "k"}},
+		},
+	}
+	for i, test := range tests {
+		var got []span
+		text, start := "", 0
+		for _, s := range Spans(test.script, test.extended) {
+			got = append(got, span{s.Class, s.Text})
+			if s.Start != start {
+				t.Errorf("Spans [%d]: %q starts at %d, expected %d", i, s.Text, s.Start, start)
+			}
+			text += s.Text
+			start += len(s.Text)
+		}
+		if text != test.script {
+			t.Errorf("Spans [%d] cover %q, expected %q", i, text, test.script)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Spans [%d] incorrect.\n  Got: %v\n  Expected: %v", i, got, test.expected)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	h := &Highlighter{Theme: Theme{
+		Command: {Color: "#ff0000", Bold: true},
+		Text:    {Italic: true},
+	}}
+	tests := []struct {
+		render   func(string) string
+		expected string
+	}{
+		{h.ANSI, "\x1b[1;38;2;255;0;0mi\\\x1b[0m\n\x1b[3ma<b>\x1b[0m\n\x1b[1;38;2;255;0;0mp\x1b[0m"},
+		{h.HTML, `<pre class="sed"><code><span class="sed-command" style="color:#ff0000;font-weight:bold">i\</span>` +
+			"\n" + `<span class="sed-text" style="font-style:italic">a&lt;b&gt;</span>` + "\n" +
+			`<span class="sed-command" style="color:#ff0000;font-weight:bold">p</span></code></pre>` + "\n"},
+	}
+	for i, test := range tests {
+		if got := test.render("i\\\na<b>\np"); got != test.expected {
+			t.Errorf("Render [%d] incorrect.\n  Got: %q\n  Expected: %q", i, got, test.expected)
+		}
+	}
+	if got := (&Highlighter{}).ANSI("p;d"); got != "p;d" {
+		t.Errorf("ANSI with no theme printed %q", got)
+	}
+	if strings.Contains(h.HTML("p"), "sed-plain") {
+		t.Error("HTML wrapped plain text in a span")
+	}
+}
@@ -0,0 +1,126 @@
+package highlight
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Style is how spans of a class are shown.
+type Style struct {
+	Color     string // A colour as #rrggbb, or "" for the default.
+	Bold      bool
+	Italic    bool
+	Underline bool
+}
+
+// Theme gives the style of each class. Classes it leaves out are shown
+// plainly.
+type Theme map[Class]Style
+
+// DefaultTheme suits dark backgrounds.
+var DefaultTheme = Theme{
+	Comment:            {Color: "#8b949e", Italic: true},
+	Command:            {Color: "#ff7b72", Bold: true},
+	Address:            {Color: "#79c0ff"},
+	Number:             {Color: "#79c0ff"},
+	Delimiter:          {Color: "#8b949e"},
+	Regexp:             {Color: "#a5d6ff"},
+	RegexpMeta:         {Color: "#d2a8ff", Bold: true},
+	RegexpClass:        {Color: "#ffa657"},
+	RegexpEscape:       {Color: "#f2cc60"},
+	Replacement:        {Color: "#7ee787"},
+	ReplacementSpecial: {Color: "#d2a8ff", Bold: true},
+	ReplacementEscape:  {Color: "#f2cc60"},
+	Flags:              {Color: "#ffa657"},
+	Label:              {Color: "#d2a8ff"},
+	Text:               {Color: "#e6edf3"},
+	Error:              {Color: "#f85149", Underline: true},
+}
+
+// Highlighter renders scripts in a theme.
+type Highlighter struct {
+	Theme          Theme
+	ExtendedRegexp bool // Read regexps as extended regular expressions.
+}
+
+// ANSI returns script with ANSI escape sequences that colour it on a
+// terminal. Styles are ended at each newline, so that each line can be
+// shown alone.
+func (h *Highlighter) ANSI(script string) string {
+	var buff bytes.Buffer
+	for _, s := range Spans(script, h.ExtendedRegexp) {
+		sgr := h.Theme[s.Class].sgr()
+		for i, line := range strings.Split(s.Text, "\n") {
+			if i > 0 {
+				buff.WriteByte('\n')
+			}
+			if line == "" || sgr == "" {
+				buff.WriteString(line)
+				continue
+			}
+			buff.WriteString("\x1b[" + sgr + "m" + line + "\x1b[0m")
+		}
+	}
+	return buff.String()
+}
+
+// sgr returns the parameters of the escape sequence that selects the
+// style, or "" for none.
+func (st Style) sgr() string {
+	var params []string
+	if st.Bold {
+		params = append(params, "1")
+	}
+	if st.Italic {
+		params = append(params, "3")
+	}
+	if st.Underline {
+		params = append(params, "4")
+	}
+	var r, g, b int
+	if _, err := fmt.Sscanf(st.Color, "#%02x%02x%02x", &r, &g, &b); err == nil {
+		params = append(params, fmt.Sprintf("38;2;%d;%d;%d", r, g, b))
+	}
+	return strings.Join(params, ";")
+}
+
+// HTML returns script as a pre element. Each span that is not plain is a
+// span element with the class sed-CLASS, such as sed-regexp-meta, and
+// its style inline.
+func (h *Highlighter) HTML(script string) string {
+	var buff bytes.Buffer
+	buff.WriteString(`<pre class="sed"><code>`)
+	for _, s := range Spans(script, h.ExtendedRegexp) {
+		text := html.EscapeString(s.Text)
+		if s.Class == Plain {
+			buff.WriteString(text)
+			continue
+		}
+		fmt.Fprintf(&buff, `<span class="sed-%s"`, s.Class)
+		if css := h.Theme[s.Class].css(); css != "" {
+			fmt.Fprintf(&buff, ` style="%s"`, css)
+		}
+		buff.WriteString(">" + text + "</span>")
+	}
+	buff.WriteString("</code></pre>\n")
+	return buff.String()
+}
+
+func (st Style) css() string {
+	var props []string
+	if st.Color != "" {
+		props = append(props, "color:"+html.EscapeString(st.Color))
+	}
+	if st.Bold {
+		props = append(props, "font-weight:bold")
+	}
+	if st.Italic {
+		props = append(props, "font-style:italic")
+	}
+	if st.Underline {
+		props = append(props, "text-decoration:underline")
+	}
+	return strings.Join(props, ";")
+}
@@ -7,7 +7,10 @@ type ItemType string
 type Item struct {
 	Type  ItemType
 	Value string
-	End   int
+	Start int // The byte offset of the item in the source.
+	End   int // The byte offset just past the item in the source.
+	Line  int // The line of Start, starting at 1.
+	Col   int // The byte column of Start, starting at 1.
 }
 
 // TODO: Write a description of what each item does.
@@ -29,6 +32,17 @@ const (
 	ItemExpMark   ItemType = "EXP-MARK"
 	ItemSemicolon ItemType = "SEMICOLON"
 	ItemNewline   ItemType = "NEW-LINE"
+	ItemTilde     ItemType = "TILDE"   // ~ in the GNU first~step and addr1,~N addresses
+	ItemPlus      ItemType = "PLUS"    // + in the GNU addr1,+N address
+	ItemComment   ItemType = "COMMENT" // # and the rest of the line
+
+	// Tokens tells apart what the lexer sends as LIT and IDENT items, and
+	// adds the text between items.
+	ItemSpace       ItemType = "SPACE"       // blanks and newlines that separate nothing
+	ItemRegexp      ItemType = "REGEXP"      // the regexp of an address or s command
+	ItemReplacement ItemType = "REPLACEMENT" // the replacement of an s command
+	ItemFlags       ItemType = "FLAGS"       // a flag of an s command or regexp address
+	ItemLabel       ItemType = "LABEL"       // the label of :, b, t or T
 
 	// TODO: Are some of these even used?
 	ItemLParen   ItemType = "L-PAREN"
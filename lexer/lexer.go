@@ -62,9 +62,31 @@ type Lexer struct {
 	pos   int       // the current position we are at
 	width int       // the width of the last rune read
 	items chan Item // the cannel to which we send our output tokens
+	opts  Options
+
+	// escapePrev removes escaping backslashes from input. removed holds
+	// the position in input of each, so that items can be given their
+	// place in the source; the first shifted of them come before the
+	// last position srcOffset was given.
+	src     string
+	removed []int
+	shifted int
+	lines   lineCounter
+}
+
+// Options change how a script is split into items.
+type Options struct {
+	// LabelsToEOL makes labels run to the end of the line, as in BSD sed,
+	// instead of ending at a semicolon, whitespace or closing brace.
+	LabelsToEOL bool
 }
 
 func New(input string) (*Lexer, chan Item) {
+	return NewWithOptions(input, Options{})
+}
+
+// NewWithOptions returns a lexer for input that follows opts.
+func NewWithOptions(input string, opts Options) (*Lexer, chan Item) {
 	l := &Lexer{
 		name:  "Test Lexer",
 		input: input,
@@ -72,6 +94,9 @@ func New(input string) (*Lexer, chan Item) {
 		pos:   0,
 		width: -1, // we haven't read anything but startState shoudn't go back
 		items: make(chan Item),
+		opts:  opts,
+		src:   input,
+		lines: lineCounter{src: input, line: 1},
 	}
 	go l.run()
 	return l, l.items
@@ -85,13 +110,50 @@ func (l *Lexer) run() {
 }
 
 func (l *Lexer) emit(t ItemType) {
-	l.items <- Item{t, l.input[l.start:l.pos], l.pos}
+	l.items <- l.item(t, l.input[l.start:l.pos])
 	l.start = l.pos
 }
 
+// item returns an item of the text from start to pos.
+func (l *Lexer) item(t ItemType, value string) Item {
+	start, end := l.srcOffset(l.start), l.srcOffset(l.pos)
+	line, col := l.lines.position(start)
+	return Item{Type: t, Value: value, Start: start, End: end, Line: line, Col: col}
+}
+
+// lineCounter finds the line and column of offsets of src that come in
+// order, counting lines on from the last offset.
+type lineCounter struct {
+	src       string
+	line      int // The line of off.
+	lineStart int // The offset at which that line starts.
+	off       int
+}
+
+func (c *lineCounter) position(off int) (line, col int) {
+	for ; c.off < off; c.off++ {
+		if c.src[c.off] == '\n' {
+			c.line++
+			c.lineStart = c.off + 1
+		}
+	}
+	return c.line, off - c.lineStart + 1
+}
+
+// srcOffset returns the offset in the source of the position pos of
+// input. Items come in order, so pos is never before the position it was
+// last given, and removed is counted on from there.
+func (l *Lexer) srcOffset(pos int) int {
+	for l.shifted < len(l.removed) && l.removed[l.shifted] < pos {
+		l.shifted++
+	}
+	return pos + l.shifted
+}
+
 func (l *Lexer) escapePrev() {
 	l.input = l.input[:l.pos-l.width] + l.input[l.pos:]
 	l.pos -= l.width
+	l.removed = append(l.removed, l.pos)
 }
 
 func (l *Lexer) next() (r rune) {
@@ -139,10 +201,7 @@ func (l *Lexer) acceptRun(valid string) {
 }
 
 func (l *Lexer) errorf(format string, args ...interface{}) stateFn {
-	l.items <- Item{
-		Type:  ItemError,
-		Value: fmt.Sprintf(format, args...),
-	}
+	l.items <- l.item(ItemError, fmt.Sprintf(format, args...))
 	return nil
 }
 
@@ -152,6 +211,11 @@ func isCommand(r rune) bool {
 			return true
 		}
 	}
+	for _, cmd := range validGNUCommands {
+		if r == cmd {
+			return true
+		}
+	}
 	return false
 }
 
@@ -205,18 +269,8 @@ func lexStart(l *Lexer) stateFn {
 		l.emit(ItemSemicolon)
 		return lexStart
 	case r == '#':
-		l.emit(ItemNewline)
-		for {
-			switch l.next() {
-			case '\n':
-				l.ignore()
-				return lexStart
-			case 0:
-				l.ignore()
-				l.emit(ItemEOF)
-				return nil
-			}
-		}
+		l.backup()
+		return lexComment
 	case r == '{':
 		l.emit(ItemLBrace)
 		return lexStart
@@ -228,6 +282,23 @@ func lexStart(l *Lexer) stateFn {
 	return l.errorf("no symbol found in start state")
 }
 
+// lexComment emits a comment, from # to the end of the line. The newline
+// is left to end the statement before it.
+func lexComment(l *Lexer) stateFn {
+	for {
+		switch l.next() {
+		case '\n':
+			l.backup()
+			l.emit(ItemComment)
+			return lexStart
+		case 0:
+			l.emit(ItemComment)
+			l.emit(ItemEOF)
+			return nil
+		}
+	}
+}
+
 // lexNextAddrOrCommand lexes the portion after the first address.
 // There could either be a command or another address portion coming.
 func lexNextAddrOrCommand(l *Lexer) stateFn {
@@ -253,6 +324,12 @@ func lexNextAddrOrCommand(l *Lexer) stateFn {
 		case r == '!':
 			l.emit(ItemExpMark)
 			return lex2ndAddrDone
+		case r == '~':
+			// first~step
+			l.emit(ItemTilde)
+		case isNumeric(r):
+			l.acceptRun("0123456789")
+			l.emit(ItemInt)
 		}
 	}
 }
@@ -284,6 +361,12 @@ func lex2ndAddr(l *Lexer) stateFn {
 		case r == '/':
 			l.emit(ItemSlash)
 			return lexInsideAddr('/', lex2ndAddrDone)
+		case r == '+':
+			// addr1,+N
+			l.emit(ItemPlus)
+		case r == '~':
+			// addr1,~N
+			l.emit(ItemTilde)
 		}
 	}
 }
@@ -299,6 +382,11 @@ func lexInsideAddr(div rune, onComplete stateFn) stateFn {
 				l.emit(ItemLit)
 				l.next()
 				l.emit(ItemSlash)
+				// The I and M modifiers of GNU sed.
+				if l.accept("IM") {
+					l.acceptRun("IM")
+					l.emit(ItemIdent)
+				}
 				return onComplete
 			case r == '\\':
 				// Escape the div
@@ -327,6 +415,8 @@ func lex2ndAddrDone(l *Lexer) stateFn {
 	l.ignore()
 	if l.accept("!") {
 		l.emit(ItemExpMark)
+		l.acceptRun(" \t")
+		l.ignore()
 	}
 	r := l.next()
 	if !isCommand(r) {
@@ -334,7 +424,7 @@ func lex2ndAddrDone(l *Lexer) stateFn {
 		switch r {
 		case '{':
 			l.emit(ItemLBrace)
-			return lexEnd
+			return lexStart
 		case '}':
 			l.emit(ItemRBrace)
 			return lexEnd
@@ -354,27 +444,49 @@ func lex2ndAddrDone(l *Lexer) stateFn {
 		}
 		l.emit(ItemDiv)
 		return parseDivExp(div)
-	case 'r', 'w', 'b', 't':
+	case 'b', 't', 'T', 'v':
 		// get identifier, stop and ; or \n
 		l.acceptRun(" ")
 		l.ignore()
-		if r = l.next(); r == '\n' || r == ';' || r == '0' {
-			l.backup()
+		r = l.peek()
+		if r == '\n' || r == ';' || r == '}' || r == 0 {
 			return lexEnd
 		}
 		return lexIdentToEnd
-	case 'c', 'i', 'a':
-		// commands that can take a backslash
+	case 'r', 'R', 'w', 'W', 'e':
+		// A file name or shell command takes the rest of the line.
 		l.acceptRun(" ")
 		l.ignore()
-		if l.next() != '\\' {
-			return l.errorf("c, i, and a cmds must be followed by \\")
+		if r = l.next(); r == '\n' || r == 0 {
+			l.backup()
+			return lexEnd
 		}
-		l.emit(ItemBackslash)
-		if l.next() != '\n' {
-			return l.errorf("newline expected after \\ for c,i,a cmds")
+		return lexRestOfLine
+	case 'q', 'Q', 'l':
+		// GNU sed allows an exit code or line length.
+		l.acceptRun(" ")
+		l.ignore()
+		if isNumeric(l.peek()) {
+			l.acceptRun("0123456789")
+			l.emit(ItemInt)
 		}
+	case 'c', 'i', 'a':
+		// The text comes after a backslash and newline or, in GNU sed,
+		// straight after the backslash ("a\text") or the command ("a text").
+		l.acceptRun(" \t")
 		l.ignore()
+		switch l.next() {
+		case 0, '\n':
+			return l.errorf("expected \\ after `a', `c' or `i'")
+		case '\\':
+			l.emit(ItemBackslash)
+			if l.next() != '\n' {
+				l.backup()
+			}
+			l.ignore()
+		default:
+			l.backup()
+		}
 		return lexLiteralLine
 	}
 	return lexEnd
@@ -392,6 +504,11 @@ func lexEnd(l *Lexer) stateFn {
 		case r == '\n':
 			l.emit(ItemNewline)
 			return lexStart
+		case r == '}':
+			l.emit(ItemRBrace)
+		case r == '#':
+			l.backup()
+			return lexComment
 		case isSpace(r):
 			l.ignore()
 		default:
@@ -408,7 +525,7 @@ func parseDivExp(div rune) stateFn {
 		for {
 			switch r := l.next(); {
 			case r == 0:
-				l.errorf("unexpected EOF while parsing div exp")
+				return l.errorf("unexpected EOF while parsing div exp")
 			case r == '\\':
 				switch r := l.peek(); {
 				// Items that the parser wants to escape. If not, defer
@@ -429,100 +546,111 @@ func parseDivExp(div rune) stateFn {
 				i--
 				if i == 0 {
 					// we collected both parts, look for flags
-				endSY:
-					switch r := l.next(); {
-					case r == 0:
-						l.emit(ItemEOF)
-						return nil
-					case r == '\n':
-						l.emit(ItemNewline)
-						return lexStart
-					case r == ';':
-						l.emit(ItemSemicolon)
-						return lexStart
-					case isSpace(r):
-						l.ignore()
-						goto endSY // this feels so good :)
-					case isFlag(r):
-						l.emit(ItemIdent)
-						l.acceptRun(" ") // optional space before additional arg
-						l.ignore()
-						switch r = l.next(); {
-						case r == 0:
-							l.emit(ItemEOF)
-							return nil
-						case r == '\n':
-							// no flags
-							l.emit(ItemNewline)
-							return lexStart
-						default:
-							// process until end of line as arg
-							for {
-								switch r = l.next(); {
-								case r == 0:
-									l.emit(ItemIdent)
-									l.emit(ItemEOF)
-									return nil
-								case r == '\n':
-									l.backup()
-									l.emit(ItemIdent)
-									l.emit(ItemNewline)
-									return lexStart
-								}
-							}
-						}
-					default:
-						l.emit(ItemError)
-						return nil
-					}
+					return lexSFlags
 				}
 			}
 		}
 	}
 }
 
-func lexLiteralLine(l *Lexer) stateFn {
-	for {
+// lexSFlags lexes the flags of an s command, each as its own identifier.
+// A number is a single flag and the w flag takes the rest of the line as
+// its file name.
+func lexSFlags(l *Lexer) stateFn {
+	l.acceptRun(" \t")
+	l.ignore()
+	for first := true; ; first = false {
 		switch r := l.next(); {
-		case r == 0:
-			l.emit(ItemLit)
+		case r == 0 || r == '\n' || r == ';' || r == '}' || r == '#' || isSpace(r):
+			l.backup()
+			return lexEnd
+		case isNumeric(r):
+			l.acceptRun("0123456789")
+			l.emit(ItemIdent)
+		case r == 'w' || r == 'r':
+			l.emit(ItemIdent)
+			l.acceptRun(" ")
+			l.ignore()
+			return lexRestOfLine
+		case isFlag(r):
+			l.emit(ItemIdent)
+		case first:
+			return l.errorf("unknown option to s")
+		default:
+			// Leave the rest of the line for the parser to reject.
+			l.backup()
+			return lexRestOfLine
+		}
+	}
+}
+
+// lexRestOfLine emits everything up to the end of the line as an
+// identifier.
+func lexRestOfLine(l *Lexer) stateFn {
+	for {
+		switch l.next() {
+		case 0:
+			l.emit(ItemIdent)
 			l.emit(ItemEOF)
 			return nil
-		case r == '\\':
-			if l.next() == '\n' {
-				l.escapePrev()
-			}
-		case r == '\n':
+		case '\n':
 			l.backup()
-			l.emit(ItemLit)
+			l.emit(ItemIdent)
 			l.next()
 			l.emit(ItemNewline)
 			return lexStart
-		default:
 		}
 	}
 }
 
-func lexIdentToEnd(l *Lexer) stateFn {
+// lexLiteralLine lexes the text of an a, i or c command, which runs to the
+// first newline not preceded by a backslash.
+func lexLiteralLine(l *Lexer) stateFn {
 	for {
 		switch r := l.next(); {
 		case r == 0:
-			l.emit(ItemIdent)
+			l.emit(ItemLit)
 			l.emit(ItemEOF)
 			return nil
-		case r == ';':
-			l.backup()
-			l.emit(ItemIdent)
-			l.next()
-			l.emit(ItemSemicolon)
-			return lexStart
+		case r == '\\':
+			// The escape is kept for the parser, but an escaped newline
+			// does not end the text.
+			if l.next() == 0 {
+				l.backup()
+			}
 		case r == '\n':
 			l.backup()
-			l.emit(ItemIdent)
+			l.emit(ItemLit)
 			l.next()
 			l.emit(ItemNewline)
 			return lexStart
+		default:
 		}
+	}
+}
 
+// lexIdentToEnd lexes a label. In GNU sed a label ends at a semicolon,
+// whitespace or the closing brace of a block; in BSD sed it runs to the
+// end of the line. Surrounding whitespace is not part of the label.
+func lexIdentToEnd(l *Lexer) stateFn {
+	l.acceptRun(" \t")
+	l.ignore()
+	start, end := l.pos, l.pos
+	for {
+		r := l.next()
+		atEnd := r == 0 || r == '\n'
+		if !l.opts.LabelsToEOL {
+			// A comment may follow in place of a label, as in "b # to end".
+			atEnd = atEnd || r == ';' || r == '}' || isSpace(r) || (r == '#' && l.pos-l.width == start)
+		}
+		if atEnd {
+			break
+		}
+		if !isSpace(r) {
+			end = l.pos
+		}
 	}
+	l.pos = end
+	l.emit(ItemIdent)
+	return lexEnd
 }
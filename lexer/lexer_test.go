@@ -589,7 +589,8 @@ Replace the current line with the line`,
 		program: `# This is a comment
 	s/blank/lines/`,
 		expected: []Item{
-			Item{Type: ItemNewline, Value: "#"},
+			Item{Type: ItemComment, Value: "# This is a comment"},
+			Item{Type: ItemNewline, Value: "\n"},
 			Item{Type: ItemCmd, Value: "s"},
 			Item{Type: ItemDiv, Value: "/"},
 			Item{Type: ItemLit, Value: "blank"},
@@ -603,7 +604,8 @@ Replace the current line with the line`,
 		program: `    # This is a comment
 	s/blank/lines/`,
 		expected: []Item{
-			Item{Type: ItemNewline, Value: "#"},
+			Item{Type: ItemComment, Value: "# This is a comment"},
+			Item{Type: ItemNewline, Value: "\n"},
 			Item{Type: ItemCmd, Value: "s"},
 			Item{Type: ItemDiv, Value: "/"},
 			Item{Type: ItemLit, Value: "blank"},
@@ -768,8 +770,8 @@ Replace the current line with the line`,
 		p
 	}`,
 		expected: []Item{
-			Item{Type: ItemNewline, Value: "#"},
-
+			Item{Type: ItemComment, Value: "# Testing Grouping"},
+			Item{Type: ItemNewline, Value: "\n"},
 			Item{Type: ItemSlash, Value: "/"},
 			Item{Type: ItemLit, Value: "begin"},
 			Item{Type: ItemSlash, Value: "/"},
@@ -1368,6 +1370,81 @@ Replace the current line with the line`,
 			Item{Type: ItemEOF, Value: ""},
 		},
 	},
+	{
+		program: "1a  hello; p\ni\\  kept\n$!c\\\none\\\ntwo\np",
+		expected: []Item{
+			Item{Type: ItemInt, Value: "1"},
+			Item{Type: ItemCmd, Value: "a"},
+			Item{Type: ItemLit, Value: "hello; p"},
+			Item{Type: ItemNewline, Value: "\n"},
+			Item{Type: ItemCmd, Value: "i"},
+			Item{Type: ItemBackslash, Value: "\\"},
+			Item{Type: ItemLit, Value: "  kept"},
+			Item{Type: ItemNewline, Value: "\n"},
+			Item{Type: ItemDollar, Value: "$"},
+			Item{Type: ItemExpMark, Value: "!"},
+			Item{Type: ItemCmd, Value: "c"},
+			Item{Type: ItemBackslash, Value: "\\"},
+			Item{Type: ItemLit, Value: "one\\\ntwo"},
+			Item{Type: ItemNewline, Value: "\n"},
+			Item{Type: ItemCmd, Value: "p"},
+			Item{Type: ItemEOF, Value: ""},
+		},
+	},
+	{
+		program: "a",
+		expected: []Item{
+			Item{Type: ItemCmd, Value: "a"},
+			Item{Type: ItemError, Value: ""},
+		},
+	},
+	{
+		program: "p # print\ns/a/b/g# sub\nb end #jump\n#",
+		expected: []Item{
+			Item{Type: ItemCmd, Value: "p"},
+			Item{Type: ItemComment, Value: "# print"},
+			Item{Type: ItemNewline, Value: "\n"},
+			Item{Type: ItemCmd, Value: "s"},
+			Item{Type: ItemDiv, Value: "/"},
+			Item{Type: ItemLit, Value: "a"},
+			Item{Type: ItemDiv, Value: "/"},
+			Item{Type: ItemLit, Value: "b"},
+			Item{Type: ItemDiv, Value: "/"},
+			Item{Type: ItemIdent, Value: "g"},
+			Item{Type: ItemComment, Value: "# sub"},
+			Item{Type: ItemNewline, Value: "\n"},
+			Item{Type: ItemCmd, Value: "b"},
+			Item{Type: ItemIdent, Value: "end"},
+			Item{Type: ItemComment, Value: "#jump"},
+			Item{Type: ItemNewline, Value: "\n"},
+			Item{Type: ItemComment, Value: "#"},
+			Item{Type: ItemEOF, Value: ""},
+		},
+	},
+	{
+		program: "b # gosed:ignore\nt a#b",
+		expected: []Item{
+			Item{Type: ItemCmd, Value: "b"},
+			Item{Type: ItemIdent, Value: ""},
+			Item{Type: ItemComment, Value: "# gosed:ignore"},
+			Item{Type: ItemNewline, Value: "\n"},
+			Item{Type: ItemCmd, Value: "t"},
+			Item{Type: ItemIdent, Value: "a#b"},
+			Item{Type: ItemEOF, Value: ""},
+		},
+	},
+	{
+		program: "1,+3! p",
+		expected: []Item{
+			Item{Type: ItemInt, Value: "1"},
+			Item{Type: ItemComma, Value: ","},
+			Item{Type: ItemPlus, Value: "+"},
+			Item{Type: ItemInt, Value: "3"},
+			Item{Type: ItemExpMark, Value: "!"},
+			Item{Type: ItemCmd, Value: "p"},
+			Item{Type: ItemEOF, Value: ""},
+		},
+	},
 }
 
 func TestNextTokens(t *testing.T) {
@@ -1392,3 +1469,53 @@ next_test:
 		}
 	}
 }
+
+func TestTokens(t *testing.T) {
+	program := "/a\\/b/I,+2! s|x\\|y|\\n&|gw out\n:top # loop\nb top"
+	expected := []Item{
+		{Type: ItemSlash, Value: "/", Start: 0, End: 1, Line: 1, Col: 1},
+		{Type: ItemRegexp, Value: "a\\/b", Start: 1, End: 5, Line: 1, Col: 2},
+		{Type: ItemSlash, Value: "/", Start: 5, End: 6, Line: 1, Col: 6},
+		{Type: ItemFlags, Value: "I", Start: 6, End: 7, Line: 1, Col: 7},
+		{Type: ItemComma, Value: ",", Start: 7, End: 8, Line: 1, Col: 8},
+		{Type: ItemPlus, Value: "+", Start: 8, End: 9, Line: 1, Col: 9},
+		{Type: ItemInt, Value: "2", Start: 9, End: 10, Line: 1, Col: 10},
+		{Type: ItemExpMark, Value: "!", Start: 10, End: 11, Line: 1, Col: 11},
+		{Type: ItemSpace, Value: " ", Start: 11, End: 12, Line: 1, Col: 12},
+		{Type: ItemCmd, Value: "s", Start: 12, End: 13, Line: 1, Col: 13},
+		{Type: ItemDiv, Value: "|", Start: 13, End: 14, Line: 1, Col: 14},
+		{Type: ItemRegexp, Value: "x\\|y", Start: 14, End: 18, Line: 1, Col: 15},
+		{Type: ItemDiv, Value: "|", Start: 18, End: 19, Line: 1, Col: 19},
+		{Type: ItemReplacement, Value: "\\n&", Start: 19, End: 22, Line: 1, Col: 20},
+		{Type: ItemDiv, Value: "|", Start: 22, End: 23, Line: 1, Col: 23},
+		{Type: ItemFlags, Value: "g", Start: 23, End: 24, Line: 1, Col: 24},
+		{Type: ItemFlags, Value: "w", Start: 24, End: 25, Line: 1, Col: 25},
+		{Type: ItemSpace, Value: " ", Start: 25, End: 26, Line: 1, Col: 26},
+		{Type: ItemIdent, Value: "out", Start: 26, End: 29, Line: 1, Col: 27},
+		{Type: ItemNewline, Value: "\n", Start: 29, End: 30, Line: 1, Col: 30},
+		{Type: ItemColon, Value: ":", Start: 30, End: 31, Line: 2, Col: 1},
+		{Type: ItemLabel, Value: "top", Start: 31, End: 34, Line: 2, Col: 2},
+		{Type: ItemSpace, Value: " ", Start: 34, End: 35, Line: 2, Col: 5},
+		{Type: ItemComment, Value: "# loop", Start: 35, End: 41, Line: 2, Col: 6},
+		{Type: ItemNewline, Value: "\n", Start: 41, End: 42, Line: 2, Col: 12},
+		{Type: ItemCmd, Value: "b", Start: 42, End: 43, Line: 3, Col: 1},
+		{Type: ItemSpace, Value: " ", Start: 43, End: 44, Line: 3, Col: 2},
+		{Type: ItemLabel, Value: "top", Start: 44, End: 47, Line: 3, Col: 3},
+	}
+	tokens := Tokens(program, Options{})
+	if len(tokens) != len(expected) {
+		t.Fatalf("Tokens returned %d tokens, expected %d: %v", len(tokens), len(expected), tokens)
+	}
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("Token [%d] incorrect.\n  Got: %+v\n  Expected: %+v", i, tokens[i], expected[i])
+		}
+	}
+
+	// After an error the rest of the program is one token.
+	tokens = Tokens("p\nk;p", Options{})
+	last := tokens[len(tokens)-1]
+	if last.Type != ItemError || last.Value != "k;p" || last.Line != 2 {
+		t.Errorf("Tokens ended with %+v, expected the error k;p on line 2", last)
+	}
+}
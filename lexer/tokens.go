@@ -0,0 +1,77 @@
+package lexer
+
+import "strings"
+
+// Tokens returns the items of input for tools such as syntax
+// highlighters. Unlike the items the lexer sends, they cover input with
+// no gaps and the Value of each is the text it covers, escapes included.
+// The text between items is an ItemSpace when it is blank and part of the
+// next item otherwise, and LIT and IDENT items are told apart as regexps,
+// replacements, flags and labels. After an error, the rest of input is
+// one ItemError.
+func Tokens(input string, opts Options) []Item {
+	_, items := NewWithOptions(input, opts)
+	lines := lineCounter{src: input, line: 1}
+	var tokens []Item
+	add := func(t ItemType, start, end int) {
+		line, col := lines.position(start)
+		tokens = append(tokens, Item{Type: t, Value: input[start:end], Start: start, End: end, Line: line, Col: col})
+	}
+
+	end := 0 // The end of the last token.
+	cmd, divs, wFile := "", 0, false
+	for item := range items {
+		t := item.Type
+		switch t {
+		case ItemError:
+			if end < len(input) {
+				add(ItemError, end, len(input))
+				end = len(input)
+			}
+			continue
+		case ItemCmd:
+			cmd, divs, wFile = item.Value, 0, false
+		case ItemColon:
+			cmd = ":"
+		case ItemLBrace, ItemRBrace, ItemSemicolon, ItemNewline:
+			cmd = ""
+		case ItemDiv:
+			divs++
+		case ItemLit:
+			switch {
+			case cmd == "" || cmd == "s" && divs == 1:
+				t = ItemRegexp
+			case cmd == "s" && divs == 2:
+				t = ItemReplacement
+			}
+		case ItemIdent:
+			switch {
+			case cmd == ":" || cmd == "b" || cmd == "t" || cmd == "T":
+				t = ItemLabel
+			case cmd == "" || cmd == "s" && !wFile:
+				t = ItemFlags
+				wFile = item.Value == "w"
+			}
+		}
+		if item.End <= end {
+			continue
+		}
+		start := item.Start
+		if start < end {
+			start = end
+		}
+		if gap := input[end:start]; gap != "" {
+			if strings.TrimSpace(gap) == "" {
+				add(ItemSpace, end, start)
+			} else {
+				start = end
+			}
+		}
+		add(t, start, item.End)
+		end = item.End
+	}
+	if end < len(input) {
+		add(ItemSpace, end, len(input))
+	}
+	return tokens
+}
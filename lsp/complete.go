@@ -0,0 +1,105 @@
+package lsp
+
+import (
+	"sort"
+
+	gosed "github.com/zkry/go-sed"
+	"github.com/zkry/go-sed/lexer"
+)
+
+type completion struct {
+	name   string
+	detail string
+	gnu    bool // Only GNU sed has it.
+}
+
+var commandCompletions = []completion{
+	{name: "{", detail: "run a block of commands"},
+	{name: ":", detail: "mark a label for branches"},
+	{name: "=", detail: "print the line number"},
+	{name: "a", detail: "append text at the end of the cycle"},
+	{name: "b", detail: "branch to a label, or to the end of the script"},
+	{name: "c", detail: "change the pattern space to text"},
+	{name: "d", detail: "delete the pattern space and start the next cycle"},
+	{name: "D", detail: "delete the first line of the pattern space and restart the cycle"},
+	{name: "e", detail: "run a shell command", gnu: true},
+	{name: "F", detail: "print the input file name", gnu: true},
+	{name: "g", detail: "copy the hold space to the pattern space"},
+	{name: "G", detail: "append the hold space to the pattern space"},
+	{name: "h", detail: "copy the pattern space to the hold space"},
+	{name: "H", detail: "append the pattern space to the hold space"},
+	{name: "i", detail: "insert text before the line"},
+	{name: "l", detail: "print the pattern space unambiguously"},
+	{name: "n", detail: "print the pattern space and read the next line"},
+	{name: "N", detail: "append the next line to the pattern space"},
+	{name: "p", detail: "print the pattern space"},
+	{name: "P", detail: "print the first line of the pattern space"},
+	{name: "q", detail: "print the pattern space and quit"},
+	{name: "Q", detail: "quit without printing", gnu: true},
+	{name: "r", detail: "append the contents of a file at the end of the cycle"},
+	{name: "R", detail: "append a line of a file at the end of the cycle", gnu: true},
+	{name: "s", detail: "substitute a replacement for a regexp"},
+	{name: "t", detail: "branch if a substitution was made"},
+	{name: "T", detail: "branch if no substitution was made", gnu: true},
+	{name: "v", detail: "require GNU sed", gnu: true},
+	{name: "w", detail: "write the pattern space to a file"},
+	{name: "W", detail: "write the first line of the pattern space to a file", gnu: true},
+	{name: "x", detail: "exchange the pattern and hold spaces"},
+	{name: "y", detail: "transliterate characters"},
+	{name: "z", detail: "empty the pattern space", gnu: true},
+}
+
+var flagCompletions = []completion{
+	{name: "g", detail: "replace every match"},
+	{name: "p", detail: "print the pattern space if a replacement was made"},
+	{name: "w", detail: "write the pattern space to a file if a replacement was made"},
+	{name: "e", detail: "run the pattern space as a command if a replacement was made", gnu: true},
+	{name: "i", detail: "match regardless of case", gnu: true},
+	{name: "I", detail: "match regardless of case", gnu: true},
+	{name: "m", detail: "match ^ and $ at embedded newlines", gnu: true},
+	{name: "M", detail: "match ^ and $ at embedded newlines", gnu: true},
+}
+
+// complete returns the s flags after an s command, the labels after a
+// branch, and the commands anywhere else.
+func (s *Server) complete(p TextDocumentPositionParams) []CompletionItem {
+	text := s.docs[p.TextDocument.URI]
+	off := offsetAt(text, p.Position)
+	tokens := tokenize(text)
+	var prev *token
+	for i := range tokens {
+		if tokens[i].End <= off && tokens[i].Item.Type != lexer.ItemSpace {
+			prev = &tokens[i]
+		}
+	}
+
+	items := []CompletionItem{}
+	switch {
+	case prev != nil && prev.SFlags && prev.End == off:
+		for _, c := range flagCompletions {
+			if !c.gnu || s.opt.Dialect != gosed.POSIX {
+				items = append(items, CompletionItem{Label: c.name, Kind: KindProperty, Detail: c.detail})
+			}
+		}
+	case prev != nil && (prev.LabelRef || isBranch(prev)):
+		seen := make(map[string]bool)
+		for _, t := range tokens {
+			if t.LabelDef && !seen[t.Value] {
+				seen[t.Value] = true
+				items = append(items, CompletionItem{Label: t.Value, Kind: KindLabel})
+			}
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	default:
+		for _, c := range commandCompletions {
+			if !c.gnu || s.opt.Dialect != gosed.POSIX {
+				items = append(items, CompletionItem{Label: c.name, Kind: KindKeyword, Detail: c.detail})
+			}
+		}
+	}
+	return items
+}
+
+func isBranch(t *token) bool {
+	return t.Type == typeKeyword && (t.Value == "b" || t.Value == "t" || t.Value == "T")
+}
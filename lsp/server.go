@@ -0,0 +1,362 @@
+// Package lsp implements a Language Server Protocol server for sed
+// scripts. It reports compile errors and vet warnings as diagnostics and
+// provides semantic tokens, label definitions and references, hover text
+// explaining commands, formatting and completion.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	gosed "github.com/zkry/go-sed"
+	"github.com/zkry/go-sed/ast"
+	"github.com/zkry/go-sed/lexer"
+)
+
+// Server serves one client over a stream, such as standard input and
+// output. It handles one message at a time.
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	opt      gosed.Options
+	docs     map[string]string // The text of each open document by URI.
+	shutdown bool
+}
+
+// NewServer returns a server that reads messages from r and writes to w,
+// and compiles documents with opt.
+func NewServer(r io.Reader, w io.Writer, opt gosed.Options) *Server {
+	return &Server{in: bufio.NewReader(r), out: w, opt: opt, docs: make(map[string]string)}
+}
+
+// Run serves the client until it sends the exit notification or closes
+// the stream. It returns an error if that happens before the client asks
+// the server to shut down, or if the stream fails.
+func (s *Server) Run() error {
+	for {
+		msg, err := readMessage(s.in)
+		var rerr *responseError
+		switch {
+		case errors.As(err, &rerr):
+			if err := writeMessage(s.out, &message{ID: nullID(), Error: rerr}); err != nil {
+				return err
+			}
+			continue
+		case err == io.EOF && s.shutdown:
+			return nil
+		case err == io.EOF:
+			return errors.New("the client closed the connection without shutting down the server")
+		case err != nil:
+			return err
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("the client exited without shutting down the server")
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func nullID() *json.RawMessage {
+	id := json.RawMessage("null")
+	return &id
+}
+
+// handle answers a request, or acts on a notification.
+func (s *Server) handle(msg *message) error {
+	if msg.ID == nil {
+		return s.notify(msg.Method, msg.Params)
+	}
+	var result interface{}
+	var err error
+	if s.shutdown {
+		err = &responseError{Code: codeInvalidRequest, Message: "the server is shutting down"}
+	} else {
+		result, err = s.request(msg.Method, msg.Params)
+	}
+	resp := &message{ID: msg.ID}
+	if err != nil {
+		rerr, ok := err.(*responseError)
+		if !ok {
+			rerr = &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		resp.Error = rerr
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		return err
+	}
+	return writeMessage(s.out, resp)
+}
+
+func (s *Server) request(method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case "initialize":
+		return s.initialize(), nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/semanticTokens/full":
+		var p DocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		text := s.docs[p.TextDocument.URI]
+		return SemanticTokens{Data: semanticTokens(text, tokenize(text))}, nil
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		locs := s.labelLocations(p, true, false)
+		if len(locs) == 0 {
+			return nil, nil
+		}
+		return locs[0], nil
+	case "textDocument/references":
+		var p ReferenceParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return s.labelLocations(p.TextDocumentPositionParams, p.Context.IncludeDeclaration, true), nil
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return s.hover(p), nil
+	case "textDocument/formatting":
+		var p DocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return s.format(p.TextDocument.URI)
+	case "textDocument/completion":
+		var p TextDocumentPositionParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil, err
+		}
+		return s.complete(p), nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %q is not supported", method)}
+}
+
+func (s *Server) notify(method string, params json.RawMessage) error {
+	switch method {
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil
+		}
+		s.docs[p.TextDocument.URI] = p.TextDocument.Text
+		return s.publishDiagnostics(p.TextDocument.URI)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil || len(p.ContentChanges) == 0 {
+			return nil
+		}
+		// The server asks for the whole document on each change.
+		s.docs[p.TextDocument.URI] = p.ContentChanges[len(p.ContentChanges)-1].Text
+		return s.publishDiagnostics(p.TextDocument.URI)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := json.Unmarshal(params, &p); err != nil {
+			return nil
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return s.send("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	}
+	// Other notifications, such as initialized, need nothing done.
+	return nil
+}
+
+func (s *Server) send(method string, params interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, &message{Method: method, Params: data})
+}
+
+func (s *Server) initialize() interface{} {
+	return map[string]interface{}{
+		"capabilities": map[string]interface{}{
+			"textDocumentSync":           1, // The whole document on each change.
+			"hoverProvider":              true,
+			"definitionProvider":         true,
+			"referencesProvider":         true,
+			"documentFormattingProvider": true,
+			"completionProvider":         map[string]interface{}{},
+			"semanticTokensProvider": map[string]interface{}{
+				"legend": map[string]interface{}{"tokenTypes": tokenTypes, "tokenModifiers": []string{}},
+				"full":   true,
+			},
+		},
+		"serverInfo": map[string]string{"name": "gosed"},
+	}
+}
+
+// publishDiagnostics sends the compile errors of a document, or its vet
+// warnings when it compiles.
+func (s *Server) publishDiagnostics(uri string) error {
+	text := s.docs[uri]
+	diags := []Diagnostic{}
+	prg, errs := gosed.Compile(text, s.opt)
+	for _, e := range errs {
+		line, msg := 1, e
+		if _, err := fmt.Sscanf(e, "line %d:", &line); err == nil {
+			msg = strings.TrimSpace(e[strings.Index(e, ":")+1:])
+		}
+		diags = append(diags, Diagnostic{
+			Range:    lineRange(text, line-1),
+			Severity: SeverityError,
+			Source:   "gosed",
+			Message:  msg,
+		})
+	}
+	if prg != nil {
+		for _, w := range prg.Vet() {
+			diags = append(diags, Diagnostic{
+				Range:    lineRange(text, w.Line-1),
+				Severity: SeverityWarning,
+				Code:     w.Check,
+				Source:   "gosed vet",
+				Message:  w.Message,
+			})
+		}
+	}
+	return s.send("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+// labelLocations returns the locations of the definition of the label at
+// a position, if def, and of the branches to it, if refs.
+func (s *Server) labelLocations(p TextDocumentPositionParams, def, refs bool) []Location {
+	uri := p.TextDocument.URI
+	text := s.docs[uri]
+	tokens := tokenize(text)
+	t := labelAt(tokens, offsetAt(text, p.Position))
+	if t == nil {
+		return nil
+	}
+	locs := []Location{}
+	for _, other := range tokens {
+		if other.Value == t.Value && (def && other.LabelDef || refs && other.LabelRef) {
+			locs = append(locs, Location{URI: uri, Range: rangeOf(text, other.Start, other.End)})
+		}
+	}
+	return locs
+}
+
+// hover explains the command at a position of a document that compiles.
+func (s *Server) hover(p TextDocumentPositionParams) *Hover {
+	text := s.docs[p.TextDocument.URI]
+	prg, errs := gosed.Compile(text, s.opt)
+	if errs != nil {
+		return nil
+	}
+	tokens := tokenize(text)
+	start := commandAt(tokens, offsetAt(text, p.Position))
+	if start < 0 {
+		return nil
+	}
+	// The command is found by its place among the commands on its line.
+	line := positionAt(text, tokens[start].Start).Line
+	nth := 0
+	for i := 0; i < start; i++ {
+		if startsCommand(tokens[i]) && positionAt(text, tokens[i].Start).Line == line {
+			nth++
+		}
+	}
+	cmds := prg.Commands()
+	var found *ast.Command
+	ast.Walk(cmds, func(cmd *ast.Command) bool {
+		if cmd.Line == line+1 && found == nil {
+			if nth == 0 {
+				found = cmd
+			}
+			nth--
+		}
+		return true
+	})
+	if found == nil {
+		return nil
+	}
+	r := rangeOf(text, tokens[start].Start, tokens[start].End)
+	return &Hover{
+		Contents: MarkupContent{
+			Kind:  "markdown",
+			Value: "```sed\n" + found.String() + "\n```\n\n" + ast.ExplainCommand(cmds, found),
+		},
+		Range: &r,
+	}
+}
+
+// startsCommand reports whether t is the token that names a command.
+func startsCommand(t token) bool {
+	switch t.Item.Type {
+	case lexer.ItemCmd:
+		return t.Value != "v" // v is checked when parsing and is not a command.
+	case lexer.ItemColon, lexer.ItemLBrace:
+		return true
+	}
+	return false
+}
+
+// commandAt returns the index of the token naming the command that the
+// token at off belongs to, or -1.
+func commandAt(tokens []token, off int) int {
+	at := -1
+	for i, t := range tokens {
+		if t.Start <= off && off <= t.End {
+			at = i
+			break
+		}
+	}
+	if at < 0 || tokens[at].Type == typeComment {
+		return -1
+	}
+	if startsCommand(tokens[at]) {
+		return at
+	}
+	// The command of an argument is before it; that of an address after.
+	for i := at; i >= 0; i-- {
+		switch tokens[i].Item.Type {
+		case lexer.ItemCmd, lexer.ItemColon:
+			return i
+		}
+		if separatesCommands(tokens[i]) {
+			break
+		}
+	}
+	for i := at; i < len(tokens); i++ {
+		if startsCommand(tokens[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func separatesCommands(t token) bool {
+	switch t.Item.Type {
+	case lexer.ItemNewline, lexer.ItemSemicolon, lexer.ItemLBrace, lexer.ItemRBrace:
+		return true
+	}
+	return false
+}
+
+// format returns the edit that puts a document in canonical form, or no
+// edits when it is already or does not compile.
+func (s *Server) format(uri string) ([]TextEdit, error) {
+	text := s.docs[uri]
+	out, errs := gosed.Format(text, s.opt)
+	if errs != nil || out == text {
+		return []TextEdit{}, nil
+	}
+	return []TextEdit{{Range: rangeOf(text, 0, len(text)), NewText: out}}, nil
+}
@@ -0,0 +1,225 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	gosed "github.com/zkry/go-sed"
+)
+
+// testClient drives a server running in the same process, as an editor
+// would over standard input and output.
+type testClient struct {
+	t     *testing.T
+	w     io.WriteCloser
+	r     *bufio.Reader
+	id    int
+	notes []*message // Notifications read while waiting for a response.
+	done  chan error
+}
+
+func newTestClient(t *testing.T, opt gosed.Options) *testClient {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &testClient{t: t, w: inW, r: bufio.NewReader(outR), done: make(chan error, 1)}
+	go func() {
+		c.done <- NewServer(inR, outW, opt).Run()
+		outW.Close()
+	}()
+	return c
+}
+
+func (c *testClient) write(msg *message) {
+	if err := writeMessage(c.w, msg); err != nil {
+		c.t.Fatalf("writing %s: %v", msg.Method, err)
+	}
+}
+
+// call sends a request and decodes the result of its response into
+// result, or returns the error of the response.
+func (c *testClient) call(method string, params, result interface{}) *responseError {
+	c.id++
+	id := json.RawMessage(strings.TrimSpace(string(mustMarshal(c.t, c.id))))
+	c.write(&message{ID: &id, Method: method, Params: mustMarshal(c.t, params)})
+	for {
+		msg, err := readMessage(c.r)
+		if err != nil {
+			c.t.Fatalf("reading the response to %s: %v", method, err)
+		}
+		if msg.ID == nil {
+			c.notes = append(c.notes, msg)
+			continue
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatalf("decoding the result of %s: %v", method, err)
+		}
+		return nil
+	}
+}
+
+func (c *testClient) notify(method string, params interface{}) {
+	c.write(&message{Method: method, Params: mustMarshal(c.t, params)})
+}
+
+// diagnostics returns the next diagnostics the server publishes.
+func (c *testClient) diagnostics() PublishDiagnosticsParams {
+	var msg *message
+	if len(c.notes) > 0 {
+		msg, c.notes = c.notes[0], c.notes[1:]
+	} else {
+		var err error
+		if msg, err = readMessage(c.r); err != nil {
+			c.t.Fatalf("reading diagnostics: %v", err)
+		}
+	}
+	var p PublishDiagnosticsParams
+	if msg.Method != "textDocument/publishDiagnostics" || json.Unmarshal(msg.Params, &p) != nil {
+		c.t.Fatalf("expected diagnostics, got %s %s", msg.Method, msg.Params)
+	}
+	return p
+}
+
+func mustMarshal(t *testing.T, v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestServer(t *testing.T) {
+	const uri = "file:///join.sed"
+	doc := TextDocumentIdentifier{URI: uri}
+	at := func(line, char int) TextDocumentPositionParams {
+		return TextDocumentPositionParams{TextDocument: doc, Position: Position{Line: line, Character: char}}
+	}
+	c := newTestClient(t, gosed.Options{})
+
+	var init struct {
+		Capabilities struct {
+			HoverProvider          bool `json:"hoverProvider"`
+			SemanticTokensProvider struct {
+				Legend struct {
+					TokenTypes []string `json:"tokenTypes"`
+				} `json:"legend"`
+			} `json:"semanticTokensProvider"`
+		} `json:"capabilities"`
+	}
+	if err := c.call("initialize", map[string]interface{}{}, &init); err != nil {
+		t.Fatalf("initialize failed: %v", err)
+	}
+	if !init.Capabilities.HoverProvider || !reflect.DeepEqual(init.Capabilities.SemanticTokensProvider.Legend.TokenTypes, tokenTypes) {
+		t.Errorf("initialize returned capabilities %+v", init.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: uri, Text: "p\nk\n"}})
+	diags := c.diagnostics()
+	if len(diags.Diagnostics) == 0 || diags.Diagnostics[0].Severity != SeverityError || diags.Diagnostics[0].Range.Start.Line != 1 {
+		t.Errorf("expected an error on line 1, got %+v", diags)
+	}
+
+	text := ":top\n/x/ s/a/b/g\nt top\n$!N\np\nd\np\n"
+	c.notify("textDocument/didChange", map[string]interface{}{
+		"textDocument":   doc,
+		"contentChanges": []map[string]string{{"text": text}},
+	})
+	diags = c.diagnostics()
+	if len(diags.Diagnostics) != 1 || diags.Diagnostics[0].Code != "unreachable" || diags.Diagnostics[0].Range != lineRange(text, 6) {
+		t.Errorf("expected an unreachable warning on line 6, got %+v", diags)
+	}
+
+	var tokens SemanticTokens
+	if err := c.call("textDocument/semanticTokens/full", DocumentParams{TextDocument: doc}, &tokens); err != nil {
+		t.Fatal(err)
+	}
+	expected := []int{
+		0, 0, 1, typeKeyword, 0, 0, 1, 3, typeLabel, 0,
+		1, 1, 1, typeRegexp, 0, 0, 3, 1, typeKeyword, 0, 0, 2, 1, typeRegexp, 0, 0, 2, 1, typeString, 0, 0, 2, 1, typeModifier, 0,
+		1, 0, 1, typeKeyword, 0, 0, 2, 3, typeLabel, 0,
+		1, 0, 1, typeNumber, 0, 0, 1, 1, typeOperator, 0, 0, 1, 1, typeKeyword, 0,
+		1, 0, 1, typeKeyword, 0, 1, 0, 1, typeKeyword, 0, 1, 0, 1, typeKeyword, 0,
+	}
+	if !reflect.DeepEqual(tokens.Data, expected) {
+		t.Errorf("semantic tokens incorrect.\n  Got: %v\n  Expected: %v", tokens.Data, expected)
+	}
+
+	var def Location
+	if err := c.call("textDocument/definition", at(2, 3), &def); err != nil {
+		t.Fatal(err)
+	}
+	if def.Range != (Range{Start: Position{0, 1}, End: Position{0, 4}}) {
+		t.Errorf("definition of top is at %+v", def.Range)
+	}
+	var refs []Location
+	params := ReferenceParams{TextDocumentPositionParams: at(0, 4)}
+	params.Context.IncludeDeclaration = true
+	if err := c.call("textDocument/references", params, &refs); err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[1].Range != (Range{Start: Position{2, 2}, End: Position{2, 5}}) {
+		t.Errorf("references to top are %+v", refs)
+	}
+
+	for _, h := range []struct {
+		pos      TextDocumentPositionParams
+		expected string
+	}{
+		{at(3, 2), "```sed\n$! N\n```\n\nFor every line except the last, append a newline"},
+		{at(1, 1), "```sed\n/x/ s/a/b/g\n```\n\nFor lines matching /x/, replace every match of /a/"},
+	} {
+		var hover Hover
+		if err := c.call("textDocument/hover", h.pos, &hover); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(hover.Contents.Value, h.expected) {
+			t.Errorf("hover at %+v is %q, expected it to start with %q", h.pos.Position, hover.Contents.Value, h.expected)
+		}
+	}
+
+	var edits []TextEdit
+	if err := c.call("textDocument/formatting", DocumentParams{TextDocument: doc}, &edits); err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || edits[0].NewText != strings.Replace(text, "$!N", "$! N", 1) {
+		t.Errorf("formatting returned %+v", edits)
+	}
+
+	for _, comp := range []struct {
+		pos   TextDocumentPositionParams
+		first string
+		n     int
+	}{
+		{at(1, 11), "g", len(flagCompletions)},
+		{at(2, 2), "top", 1},
+		{at(7, 0), "{", len(commandCompletions)},
+	} {
+		var items []CompletionItem
+		if err := c.call("textDocument/completion", comp.pos, &items); err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != comp.n || items[0].Label != comp.first {
+			t.Errorf("completion at %+v returned %+v", comp.pos.Position, items)
+		}
+	}
+
+	if err := c.call("textDocument/rename", at(0, 1), nil); err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("an unsupported request returned %v", err)
+	}
+
+	var result interface{}
+	if err := c.call("shutdown", nil, &result); err != nil || result != nil {
+		t.Errorf("shutdown returned %v, %v", result, err)
+	}
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Errorf("the server stopped with %v", err)
+	}
+}
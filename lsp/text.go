@@ -0,0 +1,56 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// positionAt returns the position of the byte offset off in text.
+func positionAt(text string, off int) Position {
+	if off > len(text) {
+		off = len(text)
+	}
+	line := strings.Count(text[:off], "\n")
+	start := strings.LastIndex(text[:off], "\n") + 1
+	return Position{Line: line, Character: utf16Len(text[start:off])}
+}
+
+// offsetAt returns the byte offset in text of pos. Positions past the end
+// of a line are at its end.
+func offsetAt(text string, pos Position) int {
+	off := 0
+	for i := 0; i < pos.Line; i++ {
+		nl := strings.IndexByte(text[off:], '\n')
+		if nl < 0 {
+			return len(text)
+		}
+		off += nl + 1
+	}
+	for col := 0; col < pos.Character && off < len(text) && text[off] != '\n'; {
+		r, size := utf8.DecodeRuneInString(text[off:])
+		col += utf16.RuneLen(r)
+		off += size
+	}
+	return off
+}
+
+func rangeOf(text string, start, end int) Range {
+	return Range{Start: positionAt(text, start), End: positionAt(text, end)}
+}
+
+// lineRange returns the range of the zero-based line n of text, without
+// its newline.
+func lineRange(text string, n int) Range {
+	start := offsetAt(text, Position{Line: n})
+	end := start + strings.IndexByte(text[start:]+"\n", '\n')
+	return rangeOf(text, start, end)
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16.RuneLen(r)
+	}
+	return n
+}
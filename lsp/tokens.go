@@ -0,0 +1,108 @@
+package lsp
+
+import (
+	"strings"
+
+	gosed "github.com/zkry/go-sed"
+	"github.com/zkry/go-sed/lexer"
+)
+
+// The semantic token types the server reports, in the order of its
+// legend.
+var tokenTypes = []string{"keyword", "number", "regexp", "string", "operator", "comment", "label", "modifier"}
+
+const (
+	typeKeyword = iota
+	typeNumber
+	typeRegexp
+	typeString
+	typeOperator
+	typeComment
+	typeLabel
+	typeModifier
+	typeNone = -1
+)
+
+// token is a lexer item with what it is to an editor.
+type token struct {
+	lexer.Item
+	Type     int  // One of the token types, or typeNone.
+	LabelDef bool // The item is the label of a : command.
+	LabelRef bool // The item is the label of a b, t or T command.
+	SFlags   bool // The item is the last delimiter or a flag of an s command.
+}
+
+// tokenize returns the tokens of text.
+func tokenize(text string) []token {
+	var tokens []token
+	cmd, divs := "", 0
+	for _, item := range gosed.Info(text) {
+		t := token{Item: item, Type: typeNone}
+		switch item.Type {
+		case lexer.ItemComment:
+			t.Type = typeComment
+		case lexer.ItemCmd, lexer.ItemColon:
+			t.Type = typeKeyword
+			cmd, divs = item.Value, 0
+		case lexer.ItemLBrace, lexer.ItemRBrace, lexer.ItemSemicolon, lexer.ItemNewline:
+			cmd = ""
+		case lexer.ItemInt, lexer.ItemDollar:
+			t.Type = typeNumber
+		case lexer.ItemComma, lexer.ItemExpMark, lexer.ItemTilde, lexer.ItemPlus:
+			t.Type = typeOperator
+		case lexer.ItemRegexp:
+			t.Type = typeRegexp
+		case lexer.ItemReplacement, lexer.ItemLit, lexer.ItemIdent:
+			t.Type = typeString
+		case lexer.ItemFlags:
+			t.Type, t.SFlags = typeModifier, cmd == "s"
+		case lexer.ItemLabel:
+			t.Type, t.LabelDef, t.LabelRef = typeLabel, cmd == ":", cmd != ":"
+		case lexer.ItemDiv:
+			divs++
+			t.SFlags = cmd == "s" && divs == 3
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+// labelAt returns the label token at the byte offset off, including one
+// that ends there, or nil.
+func labelAt(tokens []token, off int) *token {
+	for i := range tokens {
+		t := &tokens[i]
+		if t.Start <= off && off <= t.End && (t.LabelDef || t.LabelRef) {
+			return t
+		}
+	}
+	return nil
+}
+
+// semanticTokens encodes the tokens of text with a type as the Language
+// Server Protocol's relative integer form. A token over several lines is
+// reported once for each line.
+func semanticTokens(text string, tokens []token) []int {
+	data := []int{}
+	prev := Position{}
+	for _, t := range tokens {
+		if t.Type == typeNone {
+			continue
+		}
+		start := t.Start
+		for _, part := range strings.SplitAfter(text[t.Start:t.End], "\n") {
+			segment := strings.TrimSuffix(part, "\n")
+			if segment != "" {
+				pos := positionAt(text, start)
+				deltaStart := pos.Character
+				if pos.Line == prev.Line {
+					deltaStart -= prev.Character
+				}
+				data = append(data, pos.Line-prev.Line, deltaStart, utf16Len(segment), t.Type, 0)
+				prev = pos
+			}
+			start += len(part)
+		}
+	}
+	return data
+}
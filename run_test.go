@@ -0,0 +1,251 @@
+package gosed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testProgram is a program in testdata with the options and inputs its
+// first line gives, as described for TestSed.
+type testProgram struct {
+	path   string
+	script string
+	opt    Options
+	flags  []string // The flags its first line gives sed.
+	inputs []string // The inputs named on its first line, if any.
+}
+
+// testScripts use what the programs in testdata don't. They are run
+// over every input.
+var testScripts = []testProgram{
+	{script: "2,4c\\\nchanged\n$!N;6,~4{=;l 12\n};0,/^t/s/t\\(.\\)/\\l&\\U\\1x\\Ey/2p"},
+	{script: "/regex/,+2!d;1~3{s/[aeiou]//gp\n}", opt: Options{SupressOutput: true}},
+	{script: "3~2a\\\nafter\n5i\\\nbefore\ns/ //2;T;s//_/g;//!b;y/abc/xyz/;7q"},
+	{script: "h;s/ .*//;G;x;$!d;x;l;8q", opt: Options{Dialect: BSD, LineLength: 30}},
+	{script: "n;H;$!d;x;l;z", opt: Options{NullData: true}},
+	{script: "$!{/^$/!c\\\nnonblank\n};F;=;N;P;D"},
+	{script: "/a/,/b/!{/c/,3c\\\nx\n};1~2!p;5!{4,$Q}"},
+	{script: "/a/i\\\nbefore\n$!N;4,5c\\"},
+	{script: "$a\\\nend\nN;N;P;D", opt: Options{Dialect: POSIX}},
+}
+
+// testPrograms returns the programs in testdata.
+func testPrograms(tb testing.TB) []testProgram {
+	paths, _ := filepath.Glob("testdata/programs/*.sed")
+	var programs []testProgram
+	for _, path := range paths {
+		script, err := ioutil.ReadFile(path)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		p := testProgram{path: path, script: string(script)}
+		for _, setting := range strings.Fields(strings.SplitN(p.script, "\n", 2)[0]) {
+			switch {
+			case setting == "-n":
+				p.opt.SupressOutput = true
+				p.flags = append(p.flags, setting)
+			case setting == "-E", setting == "-r":
+				p.opt.ExtendRegexp = true
+				p.flags = append(p.flags, setting)
+			case setting == "-z":
+				p.opt.NullData = true
+				p.flags = append(p.flags, setting)
+			case strings.HasSuffix(setting, ".txt"):
+				p.inputs = append(p.inputs, filepath.Join("testdata", "inputs", setting))
+			}
+		}
+		programs = append(programs, p)
+	}
+	return programs
+}
+
+// runMachine runs p over data one command at a time, as Run did before
+// the VM, and returns the output as Run does.
+func runMachine(p *Program, data string) (string, error) {
+	m := p.Machine(data)
+	for m.Step() {
+	}
+	delim := "\n"
+	if p.opt.NullData {
+		delim = "\x00"
+	}
+	return strings.TrimSuffix(m.Output(), delim), m.Err()
+}
+
+// TestRunMachine checks that the VM Run uses prints what stepping a
+// Machine through the program does, for each program in testdata over
+// each of its inputs and for scripts using what they don't.
+func TestRunMachine(t *testing.T) {
+	inputs, _ := filepath.Glob("testdata/inputs/*.txt")
+	var all bytes.Buffer
+	for _, input := range inputs {
+		data, err := ioutil.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all.Write(data)
+	}
+	limits := Limits{Commands: 100000}
+	programs := append([]testProgram{
+		{script: ":a;s/^.\\{1,78\\}$/ &/;ta", opt: Options{Limits: Limits{SpaceBytes: 40}}},
+		{script: "1!G;h;$!d", opt: Options{Limits: Limits{CommandsPerLine: 2}}},
+	}, testScripts...)
+	for _, p := range testPrograms(t) {
+		programs = append(programs, p)
+	}
+	for i, p := range programs {
+		if p.opt.Limits == (Limits{}) {
+			p.opt.Limits = limits
+		}
+		prg, errs := Compile(p.script, p.opt)
+		if errs != nil {
+			t.Errorf("Program [%d] %s did not compile: %v", i, p.path, errs)
+			continue
+		}
+		data := []string{all.String()}
+		for _, input := range p.inputs {
+			d, err := ioutil.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(data, string(d))
+		}
+		for _, d := range data {
+			if p.opt.NullData {
+				d = strings.Replace(d, "\n", "\x00", -1)
+			}
+			got, err := prg.Run([]byte(d))
+			expected, expectedErr := runMachine(prg, d)
+			if string(got) != expected || !reflect.DeepEqual(err, expectedErr) {
+				t.Errorf("Program [%d] %s runs incorrectly.\n  Got: %q, %v\n  Expected: %q, %v", i, p.path, got, err, expected, expectedErr)
+			}
+		}
+	}
+}
+
+// TestRunParallel checks that splitting the input between goroutines
+// prints what running it on one does, for each line independent program
+// in testdata, and for scripts using what they don't, over all the inputs
+// repeated to about 256KB. One long line stops the limited programs near
+// the end of their input.
+func TestRunParallel(t *testing.T) {
+	inputs, _ := filepath.Glob("testdata/inputs/*.txt")
+	var all bytes.Buffer
+	for _, input := range inputs {
+		data, err := ioutil.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all.Write(data)
+	}
+	data := strings.Repeat(all.String(), 256<<10/all.Len()+1)
+	long := data + strings.Repeat("x", 100) + "\n" + all.String()
+	programs := append([]testProgram{
+		{script: "/^$/!s/\\(.\\)\\(.\\)/\\2\\1/3g;=;l 20"},
+		{script: "2~5!{/[aeiou]\\{2\\}/c\\\nvowels\n};s/ /\\n/;P;D", opt: Options{NullData: true}},
+		{script: "s/.*/&&/", opt: Options{Limits: Limits{SpaceBytes: 150}}},
+		{script: ":a;s/x/y/;ta", opt: Options{Limits: Limits{CommandsPerLine: 120}}},
+	}, testScripts...)
+	for _, p := range testPrograms(t) {
+		programs = append(programs, p)
+	}
+	independent := 0
+	for i, p := range programs {
+		prg, errs := Compile(p.script, p.opt)
+		if errs != nil {
+			t.Errorf("Program [%d] %s did not compile: %v", i, p.path, errs)
+			continue
+		}
+		if !prg.LineIndependent() {
+			continue
+		}
+		independent++
+		d := data
+		if p.opt.Limits != (Limits{}) {
+			d = long
+		}
+		if p.opt.NullData {
+			d = strings.Replace(d, "\n", "\x00", -1)
+		}
+		expected, expectedErr := prg.Run([]byte(d))
+		prg.opt.Parallelism = 8
+		got, err := prg.Run([]byte(d))
+		if !bytes.Equal(got, expected) || !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("Program [%d] %s runs incorrectly in parallel.\n  Got: %d bytes, %v\n  Expected: %d bytes, %v", i, p.path, len(got), err, len(expected), expectedErr)
+		}
+	}
+	if independent < 10 {
+		t.Errorf("Only %d programs are line independent", independent)
+	}
+}
+
+// BenchmarkRun runs each program in testdata with the VM, over its
+// inputs repeated to about 16KB, and reports its allocations. Compare
+// with BenchmarkMachine.
+func BenchmarkRun(b *testing.B) {
+	benchmarkPrograms(b, func(p *Program, data string) {
+		p.Run([]byte(data))
+	})
+}
+
+// BenchmarkMachine runs each program in testdata as BenchmarkRun does,
+// one command at a time with a Machine.
+func BenchmarkMachine(b *testing.B) {
+	benchmarkPrograms(b, func(p *Program, data string) {
+		runMachine(p, data)
+	})
+}
+
+func benchmarkPrograms(b *testing.B, run func(p *Program, data string)) {
+	inputs, _ := filepath.Glob("testdata/inputs/*.txt")
+	for _, p := range testPrograms(b) {
+		if len(p.inputs) == 0 {
+			p.inputs = inputs
+		}
+		var input bytes.Buffer
+		for _, path := range p.inputs {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			input.Write(data)
+		}
+		data := strings.Repeat(input.String(), 16<<10/input.Len()+1)
+		name := strings.TrimSuffix(filepath.Base(p.path), ".sed")
+		b.Run(name, func(b *testing.B) {
+			// Leave out programs that take too long on repeated input.
+			p.opt.Limits = Limits{Commands: 10000000}
+			prg, errs := Compile(p.script, p.opt)
+			if errs != nil {
+				b.Fatalf("%s did not compile: %v", p.path, errs)
+			}
+			if _, err := prg.Run([]byte(data)); err != nil {
+				b.Skip(err)
+			}
+			prg.opt.Limits = Limits{}
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				run(prg, data)
+			}
+		})
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	prg := MustCompile("F", Options{})
+	out, _, err := prg.RunFiles([]File{
+		{Name: "a.txt", Data: []byte("1\n2")},
+		{Name: "empty.txt"},
+		{Name: "-", Data: []byte("3\n")},
+	})
+	expected := "a.txt\n1\na.txt\n2\n-\n3\n"
+	if err != nil || string(out) != expected {
+		t.Errorf("RunFiles incorrect.\n  Got: %q, %v\n  Expected: %q", out, err, expected)
+	}
+}
@@ -1,24 +1,70 @@
 package gosed
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 
 	"github.com/zkry/go-sed/ast"
 	"github.com/zkry/go-sed/lexer"
 )
 
+// Dialect selects which variant of sed a program is written for.
+type Dialect = ast.Dialect
+
+// Dialects of sed that Options.Dialect can select.
+const (
+	GNU   = ast.GNU   // GNU sed with all of its extensions.
+	POSIX = ast.POSIX // POSIX sed; GNU extensions are compile errors.
+	BSD   = ast.BSD   // FreeBSD and macOS sed.
+)
+
+// Limits bound the commands a run may execute and the size of its pattern
+// and hold spaces. A zero field means no limit.
+type Limits = ast.Limits
+
+// LimitError is returned by Run when a run hits one of its limits or its
+// context is done. It says which limit was hit, on which input line and
+// at which command.
+type LimitError = ast.LimitError
+
 type Options struct {
 	SupressOutput     bool // Prevents program from automatically outputing line.
 	AppendFile        bool // Makes the w command append to file.
 	ExtendRegexp      bool // Use extended version of regexp
+	NullData          bool // Separate lines with NUL characters instead of newlines.
+	Sandbox           bool // Reject programs that use the e, r or w commands.
+	Dialect           Dialect
+	LineLength        int // Wrap length for the l command; 0 is the default, negative never wraps.
 	PreviousLinesRead int
+	Trace             ast.Tracer      // Receives each step the program takes, as for --debug.
+	Context           context.Context // Stops Run when done.
+	Limits            Limits
+	// Parallelism is the most goroutines Run splits a long input between
+	// when the program is LineIndependent. Zero or one runs on one
+	// goroutine.
+	Parallelism int
 }
 
 func (opt *Options) baseRuntimeOptions() ast.RuntimeOptions {
 	return ast.RuntimeOptions{
-		AllowExec:  false,
-		AutoPrint:  !opt.SupressOutput,
-		AppendFile: opt.AppendFile,
+		AllowExec:   false,
+		AutoPrint:   !opt.SupressOutput,
+		AppendFile:  opt.AppendFile,
+		NullData:    opt.NullData,
+		Tracer:      opt.Trace,
+		LineLength:  opt.LineLength,
+		Dialect:     opt.Dialect,
+		Context:     opt.Context,
+		Limits:      opt.Limits,
+		Parallelism: opt.Parallelism,
+	}
+}
+
+func (opt *Options) parseOptions() ast.ParseOptions {
+	return ast.ParseOptions{
+		Dialect:        opt.Dialect,
+		ExtendedRegexp: opt.ExtendRegexp,
 	}
 }
 
@@ -35,9 +81,14 @@ type Program struct {
 // MustCompile takes a sed script and compiles it into a program.
 // Panics if errors are found in script.
 func MustCompile(program string, opt Options) *Program {
-	p := ast.New(program)
+	p := ast.NewWithOptions(program, opt.parseOptions())
 	prg := p.ParseProgram()
 	errs := p.Errors()
+	if len(errs) == 0 && opt.Sandbox {
+		if err := prg.CheckSandbox(); err != nil {
+			errs = ast.ErrorList{err.Error()}
+		}
+	}
 	if len(errs) > 0 {
 		panic("program could not compile: " + fmt.Sprintf("%v", errs))
 	}
@@ -47,24 +98,195 @@ func MustCompile(program string, opt Options) *Program {
 // Compile compiles a sed script and returns a program upon successfull
 // compilation. If unsuccessfull errors are returned.
 func Compile(program string, opt Options) (*Program, ast.ErrorList) {
-	p := ast.New(program)
+	p := ast.NewWithOptions(program, opt.parseOptions())
 	prg := p.ParseProgram()
 	errs := p.Errors()
+	if len(errs) == 0 && opt.Sandbox {
+		if err := prg.CheckSandbox(); err != nil {
+			errs = ast.ErrorList{err.Error()}
+		}
+	}
 	if len(errs) > 0 {
-		fmt.Println("Compile: length of error: ", len(p.Errors()))
 		return nil, errs
 	}
 	return &Program{p: prg, opt: opt}, nil
 }
 
-func (p *Program) Filter(data []byte) []byte {
+// Load returns the program encoded in data by MarshalBinary, without
+// parsing its script again, to run with opt. The program keeps the
+// ExtendRegexp it was compiled with, and Load returns an error if opt's
+// Dialect is not the one it was compiled for, if opt.Sandbox is set and
+// the program uses the e, r or w commands, or if data was encoded by
+// another version of gosed; see ast.Decode.
+func Load(data []byte, opt Options) (*Program, error) {
+	prg, popt, err := ast.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	if popt.Dialect != opt.Dialect {
+		return nil, fmt.Errorf("program was compiled for %v sed, not %v", popt.Dialect, opt.Dialect)
+	}
+	if opt.Sandbox {
+		if err := prg.CheckSandbox(); err != nil {
+			return nil, err
+		}
+	}
+	opt.ExtendRegexp = popt.ExtendedRegexp
+	return &Program{p: prg, opt: opt}, nil
+}
+
+// Format returns script in canonical form, with one command per line,
+// the contents of blocks indented, delimiters normalized to / and the
+// script's comments kept. It returns the script's errors if it does not
+// compile, and an error if the formatted script would not compile to the
+// same program.
+func Format(script string, opt Options) (string, ast.ErrorList) {
+	p := ast.NewWithOptions(script, opt.parseOptions())
+	prg := p.ParseProgram()
+	if errs := p.Errors(); len(errs) > 0 {
+		return "", errs
+	}
+	out := prg.Format()
+	p = ast.NewWithOptions(out, opt.parseOptions())
+	if reparsed := p.ParseProgram(); len(p.Errors()) > 0 || reparsed.String() != prg.String() {
+		return "", ast.ErrorList{"formatting would change the meaning of the script"}
+	}
+	return out, nil
+}
+
+// String returns the program in canonical form, one command per line.
+func (p *Program) String() string {
+	return p.p.String()
+}
+
+// Commands returns a read-only description of the program's commands,
+// for inspecting what a script does without running it.
+func (p *Program) Commands() []*ast.Command {
+	return p.p.Commands()
+}
+
+// Explain returns the program in canonical form with a comment before
+// each command describing in English what it does.
+func (p *Program) Explain() string {
+	return p.p.Explain()
+}
+
+// Vet checks the program for common mistakes, such as unreachable
+// commands and unused labels, and returns a warning for each.
+func (p *Program) Vet() []ast.Warning {
+	return p.p.Vet()
+}
+
+// Walk calls fn for each command of the program in order, visiting the
+// commands of a block after the block itself unless fn returns false.
+func (p *Program) Walk(fn func(*ast.Command) bool) {
+	ast.Walk(p.p.Commands(), fn)
+}
+
+// LineIndependent reports whether the output for each line of input
+// depends only on that line and its number, which lets Run split long
+// inputs between goroutines; see Options.Parallelism.
+func (p *Program) LineIndependent() bool {
+	return p.p.LineIndependent()
+}
+
+// FileIndependent reports whether running the program over one file can't
+// change what it does with another: it has no q or Q, which stop the
+// files after them, and doesn't read or write files or run commands.
+func (p *Program) FileIndependent() bool {
+	return p.p.FileIndependent()
+}
+
+// GenerateGo returns the source of a Go file in package pkg with a
+// function name(r io.Reader, w io.Writer) error that runs the program
+// with its options, for compiling a script ahead of time. See
+// ast.Program.GenerateGo for what can't be generated.
+func (p *Program) GenerateGo(pkg, name string) ([]byte, error) {
+	return p.p.GenerateGo(pkg, name, p.opt.baseRuntimeOptions())
+}
+
+// MarshalBinary encodes the compiled program, with the Dialect and
+// ExtendRegexp it was compiled with, for Load. Programs can be written
+// with encoding/gob too. The other options are not encoded.
+func (p *Program) MarshalBinary() ([]byte, error) {
+	return p.p.Encode(p.opt.parseOptions()), nil
+}
+
+// UnmarshalBinary sets p to the program encoded in data by MarshalBinary,
+// with the options it was compiled with and the others zero.
+func (p *Program) UnmarshalBinary(data []byte) error {
+	prg, popt, err := ast.Decode(data)
+	if err != nil {
+		return err
+	}
+	*p = Program{p: prg, opt: Options{Dialect: popt.Dialect, ExtendRegexp: popt.ExtendedRegexp}}
+	return nil
+}
+
+// Machine returns a machine that runs the program over data one command
+// at a time, for stepping through the program.
+func (p *Program) Machine(data string) *ast.Machine {
+	return p.p.NewMachine(data, p.opt.baseRuntimeOptions())
+}
+
+// Run runs the program over data and returns the output. If the run is
+// stopped by the options' Context or Limits, it returns the output so far
+// and a *LimitError.
+func (p *Program) Run(data []byte) ([]byte, error) {
+	return p.p.RunBytes(data, p.opt.baseRuntimeOptions())
+}
+
+// RunFile runs the program over the contents of a file as sed does: a
+// final line delimiter ends the last line instead of starting an empty
+// one, and is kept at the end of the output. quit reports whether a q or
+// Q command ended the run, after which sed reads no more files. Limits
+// and the Context stop it as they do Run.
+func (p *Program) RunFile(data []byte) (out []byte, quit bool, err error) {
+	return p.p.RunFile(data, p.opt.baseRuntimeOptions())
+}
+
+// File is one of the input files of RunFiles.
+type File struct {
+	Name string // The name the F command prints; "-" is the standard input.
+	Data []byte
+}
+
+// RunFiles runs the program over files one after another as if they were
+// one, as sed does without -s, and otherwise as RunFile does. A file that
+// doesn't end with a line delimiter gets one before the next file. F
+// prints the name of the file holding the current line.
+func (p *Program) RunFiles(files []File) (out []byte, quit bool, err error) {
 	ro := p.opt.baseRuntimeOptions()
-	return []byte(p.p.Run(string(data), ro))
+	delim := byte('\n')
+	if p.opt.NullData {
+		delim = 0
+	}
+	var data []byte
+	for _, f := range files {
+		if len(data) > 0 && data[len(data)-1] != delim {
+			data = append(data, delim)
+		}
+		data = append(data, f.Data...)
+		lines := bytes.Count(f.Data, []byte{delim})
+		if len(f.Data) > 0 && f.Data[len(f.Data)-1] != delim {
+			lines++
+		}
+		ro.Files = append(ro.Files, ast.InputFile{Name: f.Name, Lines: lines})
+	}
+	return p.p.RunFile(data, ro)
+}
+
+// Filter runs the program over data and returns the output. A run stopped
+// by a limit returns the output so far; use Run to see why it stopped.
+func (p *Program) Filter(data []byte) []byte {
+	out, _ := p.Run(data)
+	return out
 }
 
 func (p *Program) FilterString(data string) string {
 	ro := p.opt.baseRuntimeOptions()
-	return p.p.Run(data, ro)
+	out, _ := p.p.Run(data, ro)
+	return out
 }
 
 // FilterA performs a normal filter operation but does not reset the state
@@ -73,7 +295,8 @@ func (p *Program) FilterString(data string) string {
 func (p *Program) FilterA(data []byte) []byte {
 	ro := p.opt.baseRuntimeOptions()
 	ro.LineNoStart = p.s.linesRead + 1
-	res := []byte(p.p.Run(string(data), ro))
+	out, _ := p.p.Run(string(data), ro)
+	res := []byte(out)
 	p.s.linesRead += countLines(string(data)) // TODO: Think of more elegant way to do this.
 	return res
 }
@@ -84,7 +307,7 @@ func (p *Program) FilterA(data []byte) []byte {
 func (p *Program) FilterStringA(data string) string {
 	ro := p.opt.baseRuntimeOptions()
 	ro.LineNoStart = p.s.linesRead + 1
-	res := p.p.Run(data, ro)
+	res, _ := p.p.Run(data, ro)
 	p.s.linesRead += countLines(data) // TODO: Think of more elegant way to do this.
 	return res
 }
@@ -99,8 +322,10 @@ func countLines(d string) int {
 	return ct
 }
 
+// Info returns the tokens of program for syntax highlighting. They cover
+// the whole program, each with its offsets, line and column, and tell
+// regexps, replacements, flags, labels and comments apart; see
+// lexer.Tokens.
 func Info(program string) []lexer.Item {
-	p := ast.New(program)
-	prg := p.ParseProgram()
-	return prg.Tokens
+	return lexer.Tokens(program, lexer.Options{})
 }
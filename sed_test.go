@@ -2,9 +2,11 @@ package gosed
 
 import (
 	"bytes"
+	"encoding/gob"
 	"flag"
 	"io/ioutil"
-	"path"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -17,6 +19,7 @@ func TestInfo(t *testing.T) {
 		positions []int
 	}{
 		{"s/one/two/g", []int{1, 2, 5, 6, 9, 10, 11}},
+		{"/a\\/b/ s/\\//x/", []int{1, 5, 6, 7, 8, 9, 11, 12, 13, 14}},
 	}
 
 infoTests:
@@ -35,72 +38,135 @@ infoTests:
 	}
 }
 
-var update = flag.Bool("update", false, "update golden files")
+var (
+	update    = flag.Bool("update", false, "update golden files")
+	reference = flag.String("reference", "", "the GNU sed binary -update records golden files from")
+)
 
-// The -u flag may be used to generate the golden files from the gsed command. It should
-// also be noted that the first line on the sed script determins with what options and
-// against which files the sed program should be compared. Some of the test programs are
-// genaral file editing which can work with any input while some are specially designed for
-// certain files. The first items on the comment determins whith which flags the commadn
-// should be run (beginnign with a '-' character) while the last parameter determines the
-// specific file. If no files are mentiond, it will be run against all of the test files.
+// TestSed runs each program in testdata/programs over its inputs and
+// compares the output byte for byte with what GNU sed printed. Run
 //
-// There are three directories dealing with this test case:
+//   go test -run TestSed -update -reference /path/to/gnu/sed
 //
-//   programs/
-//   inputs/
-//   expected/
+// to record the golden files again from the given sed binary.
 //
-// Program contains the test programs. Tests by default are expected to compile sucessfully
-// and any compile error will fail the test. If the programs file name contains a _fail.sed
-// then it should be expected to fail.
+// The first line of a program may be a comment giving the flags it runs
+// with, each beginning with '-', and the files in testdata/inputs it is
+// meant for. A program that names no files is run over every input. The
+// script is always given to sed with -f, so a -f flag there changes
+// nothing.
 //
-// Unless otherwise stated in the programs first comment, all programs will be tested agains
-// all inputs. The expected will be generated into the expected folder with the name of the
-// file being the program name and the input name with a '_' dividor. Thus, reverse-lines.sed
-// ran with input input.txt will produce the file reverse-liens_input.txt.
+// The output of program.sed run over input.txt is kept in
+// testdata/expected/program_input.txt.
 func TestSed(t *testing.T) {
-	const programDir = "./testdata/programs"
-	const inputDir = "./testdata/inputs"
-	const expectDir = "./testdata/expected"
-
-	prgFiles, err := ioutil.ReadDir(programDir)
-	if err != nil {
-		panic(err)
+	const expectDir = "testdata/expected"
+	if *update && *reference == "" {
+		t.Fatal("-update needs the -reference sed to record golden files from")
 	}
-
-	for _, prgF := range prgFiles {
-		path := path.Join(programDir, prgF.Name())
-		prgData, err := ioutil.ReadFile(path)
-		if err != nil {
-			panic("could not open file: " + path)
+	allInputs, _ := filepath.Glob("testdata/inputs/*.txt")
+	for _, p := range testPrograms(t) {
+		// Leave out programs that don't finish, rather than hang the test.
+		p.opt.Limits = Limits{Commands: 10000000}
+		prg, errs := Compile(p.script, p.opt)
+		if len(errs) != 0 {
+			t.Errorf("Program %s did not compile.\n  %s", p.path, strings.Join(errs, "\n  "))
+			continue
 		}
-
-		// var inputFile string
-		opt := Options{}
-
-		// Get the options from the first line.
-		firstLine := bytes.Split(prgData, []byte("\n"))[0]
-		for _, setting := range bytes.Split(firstLine, []byte(" ")) {
-			switch s := string(setting); s {
-			case "#":
-			case "-n":
-				opt.SupressOutput = true
-			default:
-				if strings.HasSuffix(s, ".txt") {
-					// inputFile = s
+		inputs := p.inputs
+		if len(inputs) == 0 {
+			inputs = allInputs
+		}
+		for _, input := range inputs {
+			name := strings.TrimSuffix(filepath.Base(p.path), ".sed") + "_" + filepath.Base(input)
+			golden := filepath.Join(expectDir, name)
+			if *update {
+				args := append(append([]string{}, p.flags...), "-f", p.path, input)
+				out, err := exec.Command(*reference, args...).Output()
+				if err != nil {
+					t.Fatalf("%s %v failed: %v", *reference, args, err)
 				}
+				if err := ioutil.WriteFile(golden, out, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Errorf("Program %s has no golden file for %s; run with -update: %v", p.path, input, err)
+				continue
+			}
+			data, err := ioutil.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _, err := prg.RunFile(data)
+			if err != nil {
+				t.Errorf("Program %s over %s stopped: %v", p.path, input, err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Errorf("Program %s over %s incorrect.\n  Got: %q\n  Expected: %q", p.path, input, got, expected)
 			}
 		}
+	}
+}
 
-		// Attemp to compile the program, returning any errors if compilation failed.
-		_, errs := Compile(string(prgData), opt)
-		if len(errs) != 0 {
-			errDescBuff := bytes.Buffer{}
-			for _, errStr := range errs {
-				errDescBuff.WriteString("\n" + errStr)
-			}
-			t.Errorf("Program %s did not compile. %s", path, errDescBuff.String())
+func TestLoad(t *testing.T) {
+	prg := MustCompile("/(a|b)+/{s//<&>/g;h};$G", Options{ExtendRegexp: true, Dialect: POSIX})
+	var buff bytes.Buffer
+	if err := gob.NewEncoder(&buff).Encode(prg); err != nil {
+		t.Fatalf("gob couldn't encode the program: %v", err)
+	}
+	var decoded Program
+	if err := gob.NewDecoder(&buff).Decode(&decoded); err != nil {
+		t.Fatalf("gob couldn't decode the program: %v", err)
+	}
+	data, _ := prg.MarshalBinary()
+	loaded, err := Load(data, Options{Dialect: POSIX, SupressOutput: true})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	input := "ab\nc\nba"
+	for i, test := range []struct {
+		prg      *Program
+		expected string
+	}{
+		{&decoded, "<ab>\nc\n<ba>\n<ba>"},
+		{loaded, ""},
+	} {
+		if got := test.prg.FilterString(input); got != test.expected {
+			t.Errorf("Load [%d] incorrect.\n  Got: %q\n  Expected: %q", i, got, test.expected)
 		}
 	}
+	if got := loaded.String(); got != prg.String() {
+		t.Errorf("Loaded program is %q, expected %q", got, prg.String())
+	}
+
+	if _, err := Load(data, Options{}); err == nil {
+		t.Error("Load accepted a program compiled for another dialect")
+	}
+	data, _ = MustCompile("w out", Options{}).MarshalBinary()
+	if _, err := Load(data, Options{Sandbox: true}); err == nil {
+		t.Error("Load accepted a program that writes files in sandbox mode")
+	}
+}
+
+// FuzzLoad checks that Load rejects or runs whatever data it is given,
+// without panicking.
+func FuzzLoad(f *testing.F) {
+	for _, script := range []string{
+		"s/\\(a\\)\\(b\\)*/\\2\\U\\1/3g;2~3{h;x};$!N;P;D",
+		"0,/x/c\\\nchanged\n1~0=;3,+2l 5;4,~3!{:a;s/y/yy/;ta}",
+		"/\\(.\\)\\1/I,$ {y/ab/ba/;Q 2};$q",
+	} {
+		data, _ := MustCompile(script, Options{}).MarshalBinary()
+		f.Add(data)
+	}
+	opt := Options{Sandbox: true, Limits: Limits{Commands: 1000, SpaceBytes: 1 << 12}}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		prg, err := Load(data, opt)
+		if err != nil {
+			return
+		}
+		prg.Run([]byte("ab\naab\nxy\nbb"))
+	})
 }